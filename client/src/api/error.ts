@@ -1,8 +1,107 @@
+/**
+ * Machine-readable codes of the errors returned by the API.
+ * These mirror the codes registered in server/internal/routes/errors.go. Errors without a more
+ * specific code use the snake case of their HTTP status, such as "not_found".
+ */
+export const ErrorCode = {
+  ValidationFailed: "validation_failed",
+  InvalidCredentials: "invalid_credentials",
+  TooManyRequests: "too_many_requests",
+  UserNotFound: "user_not_found",
+  UserNotDeleted: "user_not_deleted",
+  CompanyNotFound: "company_not_found",
+  CompanyNotDeleted: "company_not_deleted",
+  RoleNotFound: "role_not_found",
+  RoleNotDeleted: "role_not_deleted",
+  PermissionNotFound: "permission_not_found",
+  SystemRoleNotDeletable: "system_role_not_deletable",
+  SystemRoleNotEditable: "system_role_not_editable",
+  DomainAlreadyExists: "domain_already_exists",
+  DomainNotFound: "domain_not_found",
+  DomainVerifiedByAnotherCompany: "domain_verified_by_another_company",
+  SamlConfigNotFound: "saml_config_not_found",
+  SettingsVersionConflict: "settings_version_conflict",
+  SettingsVersionNotFound: "settings_version_not_found",
+  ScimTokenNotFound: "scim_token_not_found",
+  PersonalAccessTokenNotFound: "personal_access_token_not_found",
+  MfaFactorNotFound: "mfa_factor_not_found",
+  MfaFactorAlreadyVerified: "mfa_factor_already_verified",
+  MfaCodeAlreadyUsed: "mfa_code_already_used",
+  RecoveryCodeInvalid: "recovery_code_invalid",
+  InvalidDomain: "invalid_domain",
+  UnknownDomain: "unknown_domain",
+  DomainNotVerified: "domain_not_verified",
+  UnsupportedOAuthProvider: "unsupported_oauth_provider",
+  InvalidIdpMetadata: "invalid_idp_metadata",
+  InvalidIdpCertificate: "invalid_idp_certificate",
+  MfaRequired: "mfa_required",
+  SsoRequired: "sso_required",
+  CompanyDeleted: "company_deleted",
+  InvalidConfirmationToken: "invalid_confirmation_token",
+  ConfirmationTokenExpired: "confirmation_token_expired",
+} as const;
+
+export type ErrorCode = (typeof ErrorCode)[keyof typeof ErrorCode];
+
+/** FieldError describes why a field of a request failed validation. */
+export interface FieldError {
+  field: string;
+  code: string;
+  message: string;
+}
+
+/** Problem is the RFC 7807 problem details body of every error response. */
+export interface Problem {
+  type: string;
+  title: string;
+  status: number;
+  detail?: string;
+  instance?: string;
+  code: string;
+  errors?: FieldError[];
+}
+
 class ApiError extends Error {
-  constructor(public status: number, public statusText: string, message?: string) {
+  constructor(
+    public status: number,
+    public statusText: string,
+    message?: string,
+    public code: string = "",
+    public fieldErrors: FieldError[] = [],
+  ) {
     super(message);
     this.name = "ApiError";
   }
+
+  /** fromResponse creates the error from a failed response, reading its problem details if present. */
+  static async fromResponse(resp: Response): Promise<ApiError> {
+    const fallback = `Request failed. Status: ${resp.status} statusText: ${resp.statusText}`;
+
+    let problem: Partial<Problem> = {};
+    try {
+      problem = await resp.json();
+    } catch {
+      // The body is not JSON, such as when a proxy in front of the API fails.
+    }
+
+    return new ApiError(
+      resp.status,
+      resp.statusText,
+      problem.detail || problem.title || fallback,
+      problem.code ?? "",
+      problem.errors ?? [],
+    );
+  }
+
+  /** is returns true if the error has the code. */
+  is(code: ErrorCode | string): boolean {
+    return this.code === code;
+  }
+
+  /** fieldError returns the validation error message of the field, if any. */
+  fieldError(field: string): string | undefined {
+    return this.fieldErrors.find((e) => e.field === field)?.message;
+  }
 }
 
 export default ApiError;
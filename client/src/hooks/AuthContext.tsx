@@ -1,5 +1,5 @@
 import { createContext, ReactNode, useEffect, useState } from "react";
-import ApiError from "../api/error.ts";
+import ApiError, { ErrorCode } from "../api/error.ts";
 
 const SESSION_STORE_KEY = "session";
 const IS_AUTHED_STORE_KEY = "authed";
@@ -97,12 +97,7 @@ export const AuthProvider = ({ children }: { children: ReactNode }) => {
     });
 
     if (!resp.ok) {
-      const errorBody = await resp.json();
-      const message = errorBody
-        ? errorBody.message
-        : `Error making post. Status: ${resp.status} statusText: ${resp.statusText}`;
-
-      throw new ApiError(resp.status, resp.statusText, message);
+      throw await ApiError.fromResponse(resp);
     }
 
     const responseData: TResp = await resp.json();
@@ -130,9 +125,10 @@ export const AuthProvider = ({ children }: { children: ReactNode }) => {
     try {
       return await post<SignupRequest, SignupResponse>(endpoint, data);
     } catch (error) {
-      if (error instanceof ApiError && (error.status === 400)) {
+      if (error instanceof ApiError && error.is(ErrorCode.ValidationFailed)) {
         throw new Error("Please ensure the form is complete.")
-      } else if (error instanceof ApiError && error.status === 500) {
+      } else if (error instanceof ApiError && error.status <= 500) {
+        // The detail of client errors, such as a domain that is already registered, is shown to the user.
         throw error;
       }
       throw new Error(genericError);
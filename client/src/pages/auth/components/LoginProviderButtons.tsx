@@ -2,22 +2,31 @@ import { Button } from "../../../components/ui/button";
 import MicrosoftLogo from "../../../assets/ms-logo.svg";
 import { ReactNode } from "react";
 
+const API_BASE_URL = import.meta.env.VITE_API_BASE_URL;
+
 function LoginProviderButtons() {
   return (
     <section className="flex flex-col gap-2">
-      <ProviderButton logo={MicrosoftLogo}>Microsoft</ProviderButton>
+      <ProviderButton provider="microsoft" logo={MicrosoftLogo}>
+        Microsoft
+      </ProviderButton>
     </section>
   );
 }
 
 interface ProviderButtonProps {
+  provider: string;
   logo: string;
   children: ReactNode;
 }
 
-function ProviderButton({ children, logo }: ProviderButtonProps) {
+function ProviderButton({ provider, children, logo }: ProviderButtonProps) {
+  const startLogin = () => {
+    window.location.href = `${API_BASE_URL}/auth/oauth/${provider}/start`;
+  };
+
   return (
-    <Button className="flex gap-4" size="lg">
+    <Button className="flex gap-4" size="lg" type="button" onClick={startLogin}>
       <img src={logo} alt="" aria-label="icon" />
       <span>{children}</span>
     </Button>
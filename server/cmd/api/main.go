@@ -1,27 +1,100 @@
 package main
 
 import (
+	"advancely/cmd/migrate/migrations"
 	"advancely/internal/application"
+	"advancely/internal/jobs"
+	"advancely/internal/lifecycle"
+	"advancely/internal/metrics"
 	"advancely/internal/routes"
+	"advancely/internal/tracing"
 	"advancely/pkg/migrator"
+	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"time"
 )
 
 func main() {
+	printConfig := flag.Bool("print-config", false, "print the config with secrets redacted and exit")
+	flag.Parse()
+
 	app := application.NewApp()
+	if *printConfig {
+		if err := app.Config.WriteRedacted(os.Stdout); err != nil {
+			app.Logger.Error("failed to print config", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	if err := migrateDatabase(app); err != nil {
 		slog.Error("failed to migrate database", "error", err)
 		os.Exit(1)
 	}
 
+	shutdownTracing, err := tracing.Setup(context.Background(), app.Config.Tracing, app.Config.Environment)
+	if err != nil {
+		app.Logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
 	app.Build()
 
 	router := routes.NewRouter(app)
-	router.Logger.Fatal(router.Start(app.Config.Host))
+
+	runner := jobs.NewRunner(app.Logger).
+		Add(jobs.NewCompanyPurger(app.Store.CompanyStore, router.AuthProvider, app.Logger).Job(app.Config.PurgeInterval)).
+		Add(jobs.NewRetentionPurger(
+			app.Store.UserStore,
+			app.Store.PermissionsStore,
+			router.AuthProvider,
+			app.Config.DeletionRetentionPeriod,
+			app.Logger).Job(app.Config.PurgeInterval))
+	runner.Start()
+
+	manager := lifecycle.NewManager(app.Logger, app.Config.ShutdownGracePeriod).
+		WithDrainDelay(app.Config.ShutdownDrainDelay).
+		OnDrain(router.Health.Drain).
+		AddServer(lifecycle.Server{
+			Name: "api",
+			Serve: func() error {
+				return router.Start(app.Config.Host)
+			},
+			Shutdown: router.Shutdown,
+		})
+	if app.Config.MetricsHost != "" {
+		// The metrics server is shut down after the API, so requests completing during shutdown are scraped.
+		manager.AddServer(lifecycle.HTTPServer("metrics", metricsServer(app, router.Metrics)))
+	}
+	// The jobs are stopped before the store they use is closed.
+	manager.
+		AddCloser("jobs", runner.Stop).
+		AddCloser("store", func(context.Context) error {
+			return app.Store.Close()
+		}).
+		AddCloser("tracing", shutdownTracing)
+
+	if err := manager.Run(context.Background()); err != nil {
+		app.Logger.Error("failed to shut down gracefully", "error", err)
+		os.Exit(1)
+	}
+}
+
+// metricsServer returns the server of the metrics on their own listen address, which is kept off the public API.
+func metricsServer(app *application.App, m *metrics.Metrics) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+
+	return &http.Server{
+		Addr:              app.Config.MetricsHost,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
 }
 
 func migrateDatabase(app *application.App) error {
@@ -49,6 +122,8 @@ func migrateDatabase(app *application.App) error {
 	}
 	defer db.Close()
 
-	m := migrator.NewPostgresMigrator(db, dbConfig.Name, migrator.DefaultMigrationPath).WithLogger(app.Logger)
+	m := migrator.NewPostgresMigrator(db, dbConfig.Name, migrator.FSSource(migrations.FS)).
+		WithLogger(app.Logger).
+		WithLock(migrator.DefaultLockKey, dbConfig.MigrationLockTimeout)
 	return m.Migrate(migrator.MigrationDirectionUp)
 }
@@ -1,23 +1,121 @@
 package main
 
 import (
+	"advancely/cmd/migrate/migrations"
 	"advancely/internal/application"
 	"advancely/pkg/migrator"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/joho/godotenv"
+	"io"
+	"io/fs"
 	"os"
+	"strconv"
+	"time"
+)
+
+const usage = `usage: migrate [flags] <command> [argument]
+
+commands:
+  status        show the version of the database and the pending migrations
+  up [N]        apply the next N migrations, or every pending migration
+  down N|all    revert the last N migrations, or every migration
+  goto V        migrate up or down to version V
+  force V       set the version to V without running migrations, after fixing a failed migration
+  create NAME   create an empty up and down migration in the migrations directory
+
+flags:
+`
 
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+var (
+	errUsage          = errors.New("invalid arguments")
+	errProductionDown = errors.New("refusing to revert migrations in production without -yes")
 )
 
 func init() {
-	if err := godotenv.Load(); err != nil {
+	// The config may also be set in the environment or the config file.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		panic(err)
 	}
 }
 
-func run(direction migrator.MigrationDirection, config application.AppConfig) error {
+type options struct {
+	dryRun bool
+	yes    bool
+	path   string
+	dir    string
+}
+
+func main() {
+	var opts options
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "print the SQL of the migrations that would run, without running them")
+	flags.BoolVar(&opts.yes, "yes", false, "confirm reverting migrations in production")
+	flags.StringVar(&opts.path, "path", "", "source URL of the migrations, such as file://cmd/migrate/migrations, instead of those embedded in the binary")
+	flags.StringVar(&opts.dir, "dir", "cmd/migrate/migrations", "directory new migrations are created in")
+	flags.Usage = func() {
+		_, _ = fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[1:])
+
+	if err := run(flags.Args(), opts, os.Stdout); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		if errors.Is(err, errUsage) {
+			flags.Usage()
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string, opts options, out io.Writer) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errUsage
+	}
+	command, arg := args[0], ""
+	if len(args) == 2 {
+		arg = args[1]
+	}
+
+	if command == "create" {
+		if arg == "" {
+			return fmt.Errorf("%w: create requires a name", errUsage)
+		}
+		paths, err := migrator.Create(opts.dir, arg, time.Now())
+		for _, path := range paths {
+			_, _ = fmt.Fprintf(out, "created %s\n", path)
+		}
+		return err
+	}
+
+	var target migrator.Target
+	var forceVersion int
+	switch command {
+	case "status":
+		if arg != "" {
+			return fmt.Errorf("%w: status takes no argument", errUsage)
+		}
+	case "force":
+		v, err := strconv.Atoi(arg)
+		if err != nil || v < migrator.NilVersion {
+			return fmt.Errorf("%w: force requires a version, or -1 for none", errUsage)
+		}
+		forceVersion = v
+	default:
+		t, err := parseTarget(command, arg)
+		if err != nil {
+			return err
+		}
+		target = t
+	}
+
+	config, err := application.LoadConfig(os.Getenv("CONFIG_FILE"), os.Getenv)
+	if err != nil {
+		return err
+	}
+
 	db, err := sql.Open("postgres", config.Database.URI)
 	if err != nil {
 		return fmt.Errorf("could not connect to database: %w", err)
@@ -27,22 +125,112 @@ func run(direction migrator.MigrationDirection, config application.AppConfig) er
 	}
 	defer db.Close()
 
-	m := migrator.NewPostgresMigrator(db, config.Database.Name, migrator.DefaultMigrationPath)
-	return m.Migrate(direction)
+	src := migrator.FSSource(migrations.FS)
+	if opts.path != "" {
+		src = migrator.PathSource(opts.path)
+	}
+	m := migrator.NewPostgresMigrator(db, config.Database.Name, src).
+		WithLock(migrator.DefaultLockKey, config.Database.MigrationLockTimeout)
+
+	switch command {
+	case "status":
+		return printStatus(m, out)
+	case "force":
+		if opts.dryRun {
+			_, err := fmt.Fprintf(out, "-- would force version %d\n", forceVersion)
+			return err
+		}
+		return m.Force(forceVersion)
+	}
+
+	steps, err := m.Plan(target)
+	if err != nil {
+		return err
+	}
+	if opts.dryRun {
+		return printPlan(steps, out)
+	}
+	if reverts(steps) && config.Environment.IsProduction() && !opts.yes {
+		return errProductionDown
+	}
+	return m.Run(target)
 }
 
-func main() {
-	if len(os.Args) != 2 {
-		_, _ = fmt.Fprintln(os.Stdout, "usage: migrate [direction: up|down]")
-		os.Exit(1)
+// parseTarget returns the target of the up, down or goto command.
+func parseTarget(command, arg string) (migrator.Target, error) {
+	switch command {
+	case "up":
+		if arg == "" {
+			return migrator.Up(0), nil
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return migrator.Target{}, fmt.Errorf("%w: up requires a positive number of migrations", errUsage)
+		}
+		return migrator.Up(n), nil
+	case "down":
+		// Reverting every migration drops every table, so it must be asked for explicitly.
+		if arg == "all" {
+			return migrator.Down(0), nil
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return migrator.Target{}, fmt.Errorf("%w: down requires a positive number of migrations or all", errUsage)
+		}
+		return migrator.Down(n), nil
+	case "goto":
+		v, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return migrator.Target{}, fmt.Errorf("%w: goto requires a version", errUsage)
+		}
+		return migrator.To(uint(v)), nil
+	default:
+		return migrator.Target{}, fmt.Errorf("%w: unknown command %q", errUsage, command)
+	}
+}
+
+func reverts(steps []migrator.Step) bool {
+	for _, step := range steps {
+		if step.Direction == migrator.MigrationDirectionDown {
+			return true
+		}
 	}
+	return false
+}
 
-	directionArg := os.Args[len(os.Args)-1]
-	direction := migrator.NewMigrationDirection(directionArg)
-	config := application.NewAppConfig(os.Getenv)
+func printStatus(m *migrator.PostgresMigrator, out io.Writer) error {
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
 
-	if err := run(direction, config); err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-		os.Exit(1)
+	version := "none"
+	if status.Version != migrator.NilVersion {
+		version = strconv.Itoa(status.Version)
+	}
+	if status.Dirty {
+		version += " (dirty: fix the failed migration, then force the version)"
+	}
+	_, _ = fmt.Fprintf(out, "version: %s\nlatest:  %d\n", version, status.Latest)
+	if status.Dirty {
+		return nil
+	}
+	_, _ = fmt.Fprintf(out, "pending: %d\n", len(status.Pending))
+	for _, step := range status.Pending {
+		_, _ = fmt.Fprintf(out, "  %s\n", step.Name())
+	}
+	return nil
+}
+
+func printPlan(steps []migrator.Step, out io.Writer) error {
+	if len(steps) == 0 {
+		_, err := fmt.Fprintln(out, "-- nothing to migrate")
+		return err
+	}
+	for _, step := range steps {
+		if _, err := fmt.Fprintf(out, "-- %s\n%s\n", step.Name(), step.SQL); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -0,0 +1,2 @@
+drop trigger if exists trg_set_updated_at_personal_access_tokens on security.personal_access_tokens;
+drop table if exists security.personal_access_tokens;
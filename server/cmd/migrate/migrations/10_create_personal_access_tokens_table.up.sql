@@ -0,0 +1,24 @@
+-- Personal access tokens allow users to authenticate machine-to-machine requests.
+-- Only a SHA-256 hash of the token is stored; the plaintext is shown to the user once on creation.
+create table if not exists security.personal_access_tokens (
+    id uuid primary key default uuid_generate_v4(),
+    user_id uuid not null references auth.users (id) on delete cascade,
+    company_id uuid not null references public.companies (id) on delete cascade,
+    name text not null,
+    token_hash text unique not null,
+    token_prefix text not null,
+    permissions text[] default null, -- if null, the token has all permissions of the user
+    expires_at timestamp not null,
+    last_used_at timestamp default null,
+    revoked_at timestamp default null,
+    created_at timestamp not null default now(),
+    updated_at timestamp default null
+);
+
+create index if not exists idx_personal_access_tokens_user_id
+    on security.personal_access_tokens (user_id);
+
+create or replace trigger trg_set_updated_at_personal_access_tokens
+    before update on security.personal_access_tokens
+    for each row
+execute function update_updated_at_timestamp();
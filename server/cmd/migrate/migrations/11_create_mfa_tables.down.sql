@@ -0,0 +1,7 @@
+drop trigger if exists trg_set_updated_at_company_security_settings on public.company_security_settings;
+drop table if exists public.company_security_settings;
+
+drop table if exists security.mfa_recovery_codes;
+
+drop trigger if exists trg_set_updated_at_mfa_factors on security.mfa_factors;
+drop table if exists security.mfa_factors;
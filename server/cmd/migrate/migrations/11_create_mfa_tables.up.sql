@@ -0,0 +1,40 @@
+-- TOTP factors used for multi-factor authentication.
+-- A user may have a single factor; it cannot be used to log in until it has been verified.
+create table if not exists security.mfa_factors (
+    id uuid primary key default uuid_generate_v4(),
+    user_id uuid unique not null references auth.users (id) on delete cascade,
+    secret text not null,
+    verified_at timestamp default null,
+    last_used_step bigint default null, -- the TOTP time step last used, to prevent code reuse
+    created_at timestamp not null default now(),
+    updated_at timestamp default null
+);
+
+create or replace trigger trg_set_updated_at_mfa_factors
+    before update on security.mfa_factors
+    for each row
+execute function update_updated_at_timestamp();
+
+-- Single use recovery codes allowing a user to log in without their authenticator.
+-- Only a SHA-256 hash of each code is stored.
+create table if not exists security.mfa_recovery_codes (
+    id serial primary key,
+    user_id uuid not null references auth.users (id) on delete cascade,
+    code_hash text not null,
+    used_at timestamp default null,
+    created_at timestamp not null default now(),
+
+    unique (user_id, code_hash)
+);
+
+create table if not exists public.company_security_settings (
+    company_id uuid primary key references public.companies (id) on delete cascade,
+    require_admin_mfa boolean not null default false,
+    created_at timestamp not null default now(),
+    updated_at timestamp default null
+);
+
+create or replace trigger trg_set_updated_at_company_security_settings
+    before update on public.company_security_settings
+    for each row
+execute function update_updated_at_timestamp();
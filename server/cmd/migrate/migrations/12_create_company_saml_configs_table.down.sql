@@ -0,0 +1,2 @@
+drop trigger if exists trg_set_updated_at_company_saml_configs on public.company_saml_configs;
+drop table if exists public.company_saml_configs;
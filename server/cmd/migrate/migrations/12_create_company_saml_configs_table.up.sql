@@ -0,0 +1,20 @@
+-- SAML identity provider configuration for companies using enterprise SSO.
+-- The attribute columns name the assertion attributes holding the user's details;
+-- if the email attribute is missing from an assertion, the NameID is used.
+create table if not exists public.company_saml_configs (
+    company_id uuid primary key references public.companies (id) on delete cascade,
+    idp_entity_id text not null,
+    idp_sso_url text not null,
+    idp_certificate text not null,
+    email_attribute text not null default 'email',
+    first_name_attribute text not null default 'firstName',
+    last_name_attribute text not null default 'lastName',
+    enforce_sso boolean not null default false, -- when true, users of the company cannot log in with a password
+    created_at timestamp not null default now(),
+    updated_at timestamp default null
+);
+
+create or replace trigger trg_set_updated_at_company_saml_configs
+    before update on public.company_saml_configs
+    for each row
+execute function update_updated_at_timestamp();
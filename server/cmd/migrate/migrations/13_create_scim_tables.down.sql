@@ -0,0 +1,6 @@
+drop trigger if exists trg_set_updated_at_scim_tokens on security.scim_tokens;
+drop table if exists security.scim_tokens;
+
+alter table public.profiles
+    drop column if exists deactivated_at,
+    drop column if exists external_id;
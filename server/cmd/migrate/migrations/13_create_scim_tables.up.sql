@@ -0,0 +1,21 @@
+-- SCIM provisioning identifies users by the ID assigned by the company's identity provider,
+-- and deactivates users rather than deleting them.
+alter table public.profiles
+    add column if not exists external_id text default null,
+    add column if not exists deactivated_at timestamp default null; -- deactivated users cannot log in
+
+-- SCIM tokens authenticate a company's identity provider when it provisions users and groups.
+-- A company has at most one token; only a SHA-256 hash of the token is stored.
+create table if not exists security.scim_tokens (
+    company_id uuid primary key references public.companies (id) on delete cascade,
+    token_hash text unique not null,
+    token_prefix text not null,
+    last_used_at timestamp default null,
+    created_at timestamp not null default now(),
+    updated_at timestamp default null
+);
+
+create or replace trigger trg_set_updated_at_scim_tokens
+    before update on security.scim_tokens
+    for each row
+execute function update_updated_at_timestamp();
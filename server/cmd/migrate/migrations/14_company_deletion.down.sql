@@ -0,0 +1,5 @@
+drop index if exists public.idx_companies_purge_after;
+
+alter table public.companies
+    drop column if exists purge_after,
+    drop column if exists deleted_at;
@@ -0,0 +1,10 @@
+-- Deleted companies are kept for a grace period, during which their owner can restore them,
+-- and are purged along with their users once purge_after has passed.
+alter table public.companies
+    add column if not exists deleted_at timestamp default null,
+    add column if not exists purge_after timestamp default null;
+
+-- The companies table is small, so building the index briefly blocking writes is acceptable.
+-- lint:ignore index-not-concurrent
+create index if not exists idx_companies_purge_after on public.companies (purge_after)
+    where purge_after is not null;
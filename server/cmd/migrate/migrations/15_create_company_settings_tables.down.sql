@@ -0,0 +1,21 @@
+create table if not exists public.company_security_settings (
+    company_id uuid primary key references public.companies (id) on delete cascade,
+    require_admin_mfa boolean not null default false,
+    created_at timestamp not null default now(),
+    updated_at timestamp default null
+);
+
+create or replace trigger trg_set_updated_at_company_security_settings
+    before update on public.company_security_settings
+    for each row
+execute function update_updated_at_timestamp();
+
+insert into public.company_security_settings (company_id, require_admin_mfa)
+select company_id, true
+from public.company_settings
+where settings->'requireAdminMfa' = 'true'::jsonb;
+
+drop table if exists public.company_settings_versions;
+
+drop trigger if exists trg_set_updated_at_company_settings on public.company_settings;
+drop table if exists public.company_settings;
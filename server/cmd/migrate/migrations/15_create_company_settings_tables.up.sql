@@ -0,0 +1,39 @@
+-- Settings of a company, as a JSON object keyed by setting. Settings missing from the object use
+-- the defaults of the registry in internal/model/settings, so new settings need no migration.
+create table if not exists public.company_settings (
+    company_id uuid primary key references public.companies (id) on delete cascade,
+    settings jsonb not null default '{}',
+    version integer not null default 0, -- incremented on every change
+    created_at timestamp not null default now(),
+    updated_at timestamp default null
+);
+
+create or replace trigger trg_set_updated_at_company_settings
+    before update on public.company_settings
+    for each row
+execute function update_updated_at_timestamp();
+
+-- Every version of a company's settings, so changes can be audited and rolled back.
+create table if not exists public.company_settings_versions (
+    company_id uuid not null references public.companies (id) on delete cascade,
+    version integer not null,
+    settings jsonb not null,
+    changed_by uuid references auth.users (id) on delete set null,
+    created_at timestamp not null default now(),
+
+    primary key (company_id, version)
+);
+
+-- Requiring admins to use MFA was saved in public.company_security_settings before the settings registry,
+-- where it has no version history. It is moved to the requireAdminMfa setting as the first version.
+insert into public.company_settings (company_id, settings, version)
+select company_id, jsonb_build_object('requireAdminMfa', true), 1
+from public.company_security_settings
+where require_admin_mfa;
+
+insert into public.company_settings_versions (company_id, version, settings)
+select company_id, version, settings
+from public.company_settings;
+
+drop trigger if exists trg_set_updated_at_company_security_settings on public.company_security_settings;
+drop table if exists public.company_security_settings;
@@ -0,0 +1,17 @@
+-- A profile can again belong to a single company, so users keep only their oldest membership.
+-- The roles of the other memberships are deleted along with them.
+delete from public.profiles p
+using public.profiles other
+where p.id = other.id
+  and (p.created_at, p.company_id) > (other.created_at, other.company_id);
+
+alter table security.user_roles
+    drop constraint if exists user_roles_profile_fkey,
+    drop constraint if exists user_roles_pkey,
+    add primary key (user_id, role_id),
+    drop column if exists company_id;
+
+alter table public.profiles
+    drop constraint if exists profiles_pkey,
+    add primary key (id),
+    alter column company_id drop not null;
@@ -0,0 +1,27 @@
+-- A profile is a user's membership of a company, so a user can belong to several companies.
+-- Profiles without a company could not be logged in with and are removed.
+delete from public.profiles where company_id is null;
+
+alter table public.profiles
+    drop constraint if exists profiles_pkey,
+    alter column company_id set not null,
+    add primary key (id, company_id);
+
+-- Roles are assigned per membership, as system roles such as Admin are shared by every company.
+alter table security.user_roles
+    add column if not exists company_id uuid references public.companies (id) on delete cascade;
+
+update security.user_roles ur
+set company_id = p.company_id
+from public.profiles p
+where p.id = ur.user_id;
+
+-- Roles of users without a profile did not grant them anything.
+delete from security.user_roles where company_id is null;
+
+alter table security.user_roles
+    alter column company_id set not null,
+    drop constraint if exists user_roles_pkey,
+    add primary key (user_id, company_id, role_id),
+    add constraint user_roles_profile_fkey foreign key (user_id, company_id)
+        references public.profiles (id, company_id) on delete cascade;
@@ -0,0 +1,9 @@
+-- Deleted profiles and roles would otherwise reappear.
+delete from public.profiles where deleted_at is not null;
+delete from security.roles where deleted_at is not null;
+
+alter table security.roles
+    drop column if exists deleted_at;
+
+alter table public.profiles
+    drop column if exists deleted_at;
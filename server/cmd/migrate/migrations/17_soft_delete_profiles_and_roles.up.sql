@@ -0,0 +1,7 @@
+-- Deleted profiles and roles are kept, along with their role assignments, so they can be restored
+-- until the retention period has passed and they are purged.
+alter table public.profiles
+    add column if not exists deleted_at timestamp default null;
+
+alter table security.roles
+    add column if not exists deleted_at timestamp default null;
@@ -0,0 +1,5 @@
+drop index if exists public.unique_verified_allowed_email_domain;
+
+alter table public.allowed_email_domains
+    drop column if exists verified_at,
+    drop column if exists verification_token;
@@ -0,0 +1,14 @@
+-- Companies prove they own an allowed email domain by publishing its verification token in a DNS TXT record.
+-- Only verified domains can be used for SSO or to join a company, and each can be verified by one company.
+alter table public.allowed_email_domains
+    add column if not exists verification_token text default null,
+    add column if not exists verified_at timestamp default null;
+
+update public.allowed_email_domains
+set verification_token = replace(gen_random_uuid()::text, '-', '')
+where verification_token is null;
+
+-- The allowed_email_domains table is small, so building the index briefly blocking writes is acceptable.
+-- lint:ignore index-not-concurrent
+create unique index if not exists unique_verified_allowed_email_domain on public.allowed_email_domains (lower(domain))
+    where verified_at is not null;
@@ -1 +1 @@
-drop table if exists companies;
\ No newline at end of file
+drop table if exists companies;
@@ -1,7 +1,7 @@
-alter table companies enable row level security;
+alter table public.companies enable row level security;
 
 create policy "Allow select for authenticated users from same company"
-on companies
+on public.companies
 for select
 using (
     exists (
@@ -12,7 +12,7 @@ using (
 );
 
 create policy "Allow update for admin users"
-on companies
+on public.companies
 for update
 using (
     exists (
@@ -24,7 +24,7 @@ using (
 );
 
 create policy "Allow delete for admin users"
-on companies
+on public.companies
 for delete
 using (
     exists (
@@ -1,4 +1,4 @@
-alter table profiles enable row level security;
+alter table public.profiles enable row level security;
 
 create policy "Allow select for authenticated users of the same company"
 on public.profiles
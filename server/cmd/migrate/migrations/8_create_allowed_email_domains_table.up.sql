@@ -1,4 +1,4 @@
-create table if not exists allowed_email_domains (
+create table if not exists public.allowed_email_domains (
     id serial primary key,
     company_id uuid references public.companies on delete cascade,
     domain text not null,
@@ -11,9 +11,9 @@ create or replace trigger trg_set_updated_at_allowed_email_domains
     for each row
 execute function update_updated_at_timestamp();
 
-alter table allowed_email_domains
+alter table public.allowed_email_domains
 drop constraint if exists unique_company_id_domain;
 
-alter table allowed_email_domains
+alter table public.allowed_email_domains
 add constraint unique_company_id_domain
-unique (company_id, domain);
\ No newline at end of file
+unique (company_id, domain);
@@ -28,4 +28,4 @@ using permissions_to_delete
 where security.permissions.id = permissions_to_delete.id;
 
 delete from security.permission_groups
-where name = 'Organization management';
\ No newline at end of file
+where name = 'Organization management';
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations of the database, so binaries can migrate it
+// without the source tree.
+package migrations
+
+import "embed"
+
+// FS contains the up and down migrations, named <version>_<name>.<up|down>.sql.
+//
+//go:embed *.sql
+var FS embed.FS
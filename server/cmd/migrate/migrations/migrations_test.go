@@ -0,0 +1,105 @@
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+
+	"advancely/cmd/migrate/migrations"
+	"advancely/internal/tests"
+	"advancely/pkg/migrator"
+
+	"github.com/stretchr/testify/require"
+)
+
+// snapshotSchemas are the schemas the migrations create objects in.
+var snapshotSchemas = []string{"public", "security"}
+
+func TestLint(t *testing.T) {
+	findings, err := migrator.Lint(migrations.FS)
+	require.NoError(t, err)
+	for _, finding := range findings {
+		t.Error(finding)
+	}
+}
+
+// TestRoundTrip applies every migration, reverts it and applies it again,
+// checking the down migration restores the schema and the up migration can be reapplied.
+func TestRoundTrip(t *testing.T) {
+	db, err := sql.Open("postgres", tests.DatabaseURL)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	tests.LockTestDatabase(t, db)
+
+	m := migrator.NewPostgresMigrator(db, tests.DatabaseName, migrator.FSSource(migrations.FS)).
+		WithLogger(tests.NewDefaultLogger())
+	require.NoError(t, m.Migrate(migrator.MigrationDirectionDown))
+	t.Cleanup(func() {
+		require.NoError(t, m.Migrate(migrator.MigrationDirectionDown))
+	})
+
+	steps, err := m.Plan(migrator.Up(0))
+	require.NoError(t, err)
+	require.NotEmpty(t, steps)
+
+	before := snapshot(t, db)
+	for _, step := range steps {
+		require.NoError(t, m.Run(migrator.Up(1)), step.Name())
+		after := snapshot(t, db)
+
+		require.NoError(t, m.Run(migrator.Down(1)), step.Name())
+		require.Equal(t, before, snapshot(t, db), "the down migration of %s does not restore the schema", step.Name())
+
+		require.NoError(t, m.Run(migrator.Up(1)), step.Name())
+		require.Equal(t, after, snapshot(t, db), "%s does not create the same schema when reapplied", step.Name())
+		before = after
+	}
+}
+
+// TestConcurrentMigrate migrates the database from several migrators at once, as replicas of the API
+// starting together do, checking they wait for each other instead of failing.
+func TestConcurrentMigrate(t *testing.T) {
+	db, err := sql.Open("postgres", tests.DatabaseURL)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	tests.LockTestDatabase(t, db)
+
+	newMigrator := func() *migrator.PostgresMigrator {
+		return migrator.NewPostgresMigrator(db, tests.DatabaseName, migrator.FSSource(migrations.FS)).
+			WithLogger(tests.NewDefaultLogger())
+	}
+	require.NoError(t, newMigrator().Migrate(migrator.MigrationDirectionDown))
+
+	const replicas = 4
+	errs := make([]error, replicas)
+	var wg sync.WaitGroup
+	for i := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = newMigrator().Migrate(migrator.MigrationDirectionUp)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	status, err := newMigrator().Status()
+	require.NoError(t, err)
+	require.False(t, status.Dirty)
+	require.Equal(t, int(status.Latest), status.Version)
+	require.Empty(t, status.Pending)
+}
+
+func snapshot(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	s, err := migrator.Snapshot(context.Background(), db, snapshotSchemas...)
+	require.NoError(t, err)
+	return s
+}
@@ -0,0 +1,207 @@
+// Package apierror writes every error returned by a handler as an RFC 7807 problem details response,
+// giving clients a stable machine-readable code for each error.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"advancely/internal/auth"
+	"advancely/internal/logging"
+	"advancely/internal/validation"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ContentType is the media type of problem details responses.
+const ContentType = "application/problem+json"
+
+// CodeValidationFailed is the code of requests that failed validation, which list the invalid fields.
+const CodeValidationFailed = "validation_failed"
+
+// Problem is an RFC 7807 problem details response.
+type Problem struct {
+	// Type is always "about:blank"; clients should use Code to identify the problem.
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail is a human-readable explanation of the problem, which may be shown to users.
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Code is a stable machine-readable code, such as "role_not_found".
+	Code   string                  `json:"code"`
+	Errors []validation.FieldError `json:"errors,omitempty"`
+}
+
+// StatusCode returns the code of problems with the status that have no more specific code,
+// such as "not_found" for 404 Not Found.
+func StatusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			return unicode.ToLower(r)
+		case r == ' ' || r == '-':
+			return '_'
+		}
+		return -1
+	}, text)
+}
+
+type registryEntry struct {
+	err    error
+	status int
+	code   string
+}
+
+// Registry maps sentinel errors to the status and code of their problem.
+type Registry struct {
+	entries []registryEntry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register maps the error, and any error wrapping it, to the status and code.
+// The status is only used when the error is returned without an *echo.HTTPError.
+func (r *Registry) Register(err error, status int, code string) *Registry {
+	r.entries = append(r.entries, registryEntry{err: err, status: status, code: code})
+	return r
+}
+
+// Lookup returns the status and code of the first registered error in the err tree.
+func (r *Registry) Lookup(err error) (int, string, bool) {
+	e, ok := r.lookup(err)
+	return e.status, e.code, ok
+}
+
+func (r *Registry) lookup(err error) (registryEntry, bool) {
+	for _, e := range r.entries {
+		if errors.Is(err, e.err) {
+			return e, true
+		}
+	}
+	return registryEntry{}, false
+}
+
+// Codes returns the codes of the registered errors.
+func (r *Registry) Codes() []string {
+	codes := make([]string, 0, len(r.entries))
+	for _, e := range r.entries {
+		codes = append(codes, e.code)
+	}
+	return codes
+}
+
+// Problem converts the error returned by a handler to a problem. Returns true if the error was unexpected,
+// which is the case for errors that are neither an *echo.HTTPError nor registered. The detail of registered
+// errors is the message of the sentinel error rather than of any error wrapping it, and the detail of other
+// errors is omitted for 5xx statuses, as both may leak internal details.
+func (r *Registry) Problem(err error) (Problem, bool) {
+	p := Problem{Type: "about:blank", Status: http.StatusInternalServerError}
+
+	var httpErr *echo.HTTPError
+	if !errors.As(err, &httpErr) {
+		e, ok := r.lookup(err)
+		if !ok {
+			p.Title = http.StatusText(p.Status)
+			p.Code = StatusCode(p.Status)
+			return p, true
+		}
+		p.Status, p.Code = e.status, e.code
+		p.Title = http.StatusText(p.Status)
+		p.Detail = sentence(e.err.Error())
+		return p, false
+	}
+
+	p.Status = httpErr.Code
+	p.Title = http.StatusText(p.Status)
+
+	// Handlers may return an HTTP error whose message is another error, such as a store sentinel error.
+	cause := error(httpErr)
+	message := httpErr.Message
+	for {
+		inner, ok := message.(*echo.HTTPError)
+		if !ok {
+			break
+		}
+		cause = errors.Join(cause, inner)
+		message = inner.Message
+	}
+	switch m := message.(type) {
+	case nil:
+	case string:
+		p.Detail = m
+	case error:
+		cause = errors.Join(cause, m)
+		if e, ok := r.lookup(m); ok {
+			p.Detail = sentence(e.err.Error())
+		} else if p.Status < http.StatusInternalServerError {
+			p.Detail = sentence(m.Error())
+		}
+	default:
+		p.Detail = fmt.Sprint(m)
+	}
+	if p.Detail == p.Title {
+		p.Detail = ""
+	}
+
+	if e, ok := r.lookup(cause); ok {
+		p.Code = e.code
+	} else if fieldErrs := validation.FieldErrors(cause); fieldErrs != nil {
+		p.Code = CodeValidationFailed
+		p.Errors = fieldErrs
+	} else if authErr, ok := auth.AsError(cause); ok && authErr.Code != "" {
+		p.Code = authErr.Code
+	} else {
+		p.Code = StatusCode(p.Status)
+	}
+	return p, false
+}
+
+// NewHTTPErrorHandler returns the echo error handler writing errors as problems.
+// Unexpected errors are logged, as they have not been handled by the handler returning them.
+func NewHTTPErrorHandler(registry *Registry, logger *slog.Logger) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if c.Response().Committed {
+			return
+		}
+
+		p, unexpected := registry.Problem(err)
+		if unexpected {
+			logging.FromContext(c.Request().Context(), logger).Error("unhandled error", "error", err, "method", c.Request().Method, "path", c.Request().URL.Path)
+		}
+		p.Instance = c.Request().URL.Path
+
+		if c.Request().Method == http.MethodHead {
+			err = c.NoContent(p.Status)
+		} else {
+			var b []byte
+			if b, err = json.Marshal(p); err == nil {
+				err = c.Blob(p.Status, ContentType, b)
+			}
+		}
+		if err != nil {
+			logging.FromContext(c.Request().Context(), logger).Error("failed to write error response", "error", err)
+		}
+	}
+}
+
+// sentence capitalises the first letter of an error message, such as "role not found".
+func sentence(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[size:]
+}
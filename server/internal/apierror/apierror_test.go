@@ -0,0 +1,215 @@
+package apierror_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"advancely/internal/apierror"
+	"advancely/internal/auth"
+	"advancely/internal/tests"
+	"advancely/internal/validation"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+var errWidgetNotFound = errors.New("widget not found")
+
+func newTestRegistry() *apierror.Registry {
+	return apierror.NewRegistry().Register(errWidgetNotFound, http.StatusNotFound, "widget_not_found")
+}
+
+// handleError runs the error handler for the error returned by a handler, returning the decoded problem.
+func handleError(t *testing.T, method string, err error) (*httptest.ResponseRecorder, apierror.Problem) {
+	t.Helper()
+	e := tests.NewEchoInstance()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(method, "/api/v1/widgets/1", nil), rec)
+	apierror.NewHTTPErrorHandler(newTestRegistry(), tests.NewDefaultLogger())(err, c)
+
+	var p apierror.Problem
+	if rec.Body.Len() > 0 {
+		require.Equal(t, apierror.ContentType, rec.Header().Get(echo.HeaderContentType))
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	}
+	return rec, p
+}
+
+func TestHTTPErrorHandler(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected apierror.Problem
+	}{
+		{
+			name: "HTTP error with message",
+			err:  echo.NewHTTPError(http.StatusForbidden, "Your company requires you to sign in with SSO"),
+			expected: apierror.Problem{
+				Status: http.StatusForbidden,
+				Title:  "Forbidden",
+				Detail: "Your company requires you to sign in with SSO",
+				Code:   "forbidden",
+			},
+		},
+		{
+			name: "HTTP error without message",
+			err:  echo.NewHTTPError(http.StatusInternalServerError),
+			expected: apierror.Problem{
+				Status: http.StatusInternalServerError,
+				Title:  "Internal Server Error",
+				Code:   "internal_server_error",
+			},
+		},
+		{
+			name: "registered error",
+			err:  fmt.Errorf("failed to get widget: %w", errWidgetNotFound),
+			expected: apierror.Problem{
+				Status: http.StatusNotFound,
+				Title:  "Not Found",
+				Detail: "Widget not found",
+				Code:   "widget_not_found",
+			},
+		},
+		{
+			name: "HTTP error with registered error message",
+			err:  echo.NewHTTPError(http.StatusBadRequest, fmt.Errorf("wrapped: %w", errWidgetNotFound)),
+			expected: apierror.Problem{
+				Status: http.StatusBadRequest,
+				Title:  "Bad Request",
+				Detail: "Widget not found",
+				Code:   "widget_not_found",
+			},
+		},
+		{
+			name: "HTTP error with registered internal error",
+			err:  echo.NewHTTPError(http.StatusNotFound, "No such widget").SetInternal(errWidgetNotFound),
+			expected: apierror.Problem{
+				Status: http.StatusNotFound,
+				Title:  "Not Found",
+				Detail: "No such widget",
+				Code:   "widget_not_found",
+			},
+		},
+		{
+			name: "HTTP error with auth error",
+			err: echo.NewHTTPError(http.StatusBadRequest, "Invalid login credentials").SetInternal(&auth.Error{
+				Status:  http.StatusBadRequest,
+				Code:    auth.ErrorCodeInvalidCredentials,
+				Message: "Invalid login credentials",
+			}),
+			expected: apierror.Problem{
+				Status: http.StatusBadRequest,
+				Title:  "Bad Request",
+				Detail: "Invalid login credentials",
+				Code:   auth.ErrorCodeInvalidCredentials,
+			},
+		},
+		{
+			name: "unexpected error",
+			err:  errors.New("pq: connection refused"),
+			expected: apierror.Problem{
+				Status: http.StatusInternalServerError,
+				Title:  "Internal Server Error",
+				Code:   "internal_server_error",
+			},
+		},
+		{
+			name: "HTTP error with unregistered error message and 5xx status",
+			err:  echo.NewHTTPError(http.StatusInternalServerError, errors.New("pq: connection refused")),
+			expected: apierror.Problem{
+				Status: http.StatusInternalServerError,
+				Title:  "Internal Server Error",
+				Code:   "internal_server_error",
+			},
+		},
+		{
+			name: "route not found",
+			err:  echo.ErrNotFound,
+			expected: apierror.Problem{
+				Status: http.StatusNotFound,
+				Title:  "Not Found",
+				Code:   "not_found",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, p := handleError(t, http.MethodGet, tc.err)
+			require.Equal(t, tc.expected.Status, rec.Code)
+
+			tc.expected.Type = "about:blank"
+			tc.expected.Instance = "/api/v1/widgets/1"
+			require.Equal(t, tc.expected, p)
+		})
+	}
+}
+
+func TestHTTPErrorHandlerValidationErrors(t *testing.T) {
+	type address struct {
+		City string `json:"city" validate:"required"`
+	}
+	type request struct {
+		Email    string   `json:"email" validate:"required,email"`
+		Password string   `json:"password" validate:"min=8"`
+		Role     string   `json:"role" validate:"oneof=admin member"`
+		Address  address  `json:"address"`
+		Tags     []string `json:"tags" validate:"max=2"`
+	}
+
+	e := tests.NewEchoInstance()
+	body := `{"email": "not-an-email", "password": "short", "role": "owner", "tags": ["a", "b", "c"]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/widgets/1", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c := e.NewContext(req, httptest.NewRecorder())
+
+	var r request
+	err := validation.BindAndValidate(c, &r)
+	require.NotNil(t, err)
+
+	rec, p := handleError(t, http.MethodPost, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, apierror.CodeValidationFailed, p.Code)
+	require.Equal(t, "The request is invalid", p.Detail)
+	require.Equal(t, []validation.FieldError{
+		{Field: "email", Code: "email", Message: "Must be a valid email address"},
+		{Field: "password", Code: "min", Message: "Must be at least 8 characters"},
+		{Field: "role", Code: "oneof", Message: "Must be one of: admin, member"},
+		{Field: "address.city", Code: "required", Message: "This field is required"},
+		{Field: "tags", Code: "max", Message: "Must be at most 2 items"},
+	}, p.Errors)
+}
+
+func TestHTTPErrorHandlerMalformedBody(t *testing.T) {
+	e := tests.NewEchoInstance()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/widgets/1", strings.NewReader(`{"email": `))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c := e.NewContext(req, httptest.NewRecorder())
+
+	var r struct {
+		Email string `json:"email"`
+	}
+	err := validation.BindAndValidate(c, &r)
+	require.NotNil(t, err)
+
+	_, p := handleError(t, http.MethodPost, err)
+	require.Equal(t, "bad_request", p.Code)
+	require.Equal(t, "The request body is malformed", p.Detail)
+	require.Empty(t, p.Errors)
+}
+
+func TestHTTPErrorHandlerHead(t *testing.T) {
+	rec, _ := handleError(t, http.MethodHead, echo.ErrNotFound)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Zero(t, rec.Body.Len())
+}
+
+func TestStatusCode(t *testing.T) {
+	require.Equal(t, "too_many_requests", apierror.StatusCode(http.StatusTooManyRequests))
+	require.Equal(t, "im_a_teapot", apierror.StatusCode(http.StatusTeapot))
+	require.Equal(t, "error", apierror.StatusCode(599))
+}
@@ -1,11 +1,14 @@
 package application
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"log/slog"
 	"os"
 
+	"advancely/internal/logging"
 	"advancely/internal/store"
 
 	"github.com/joho/godotenv"
@@ -20,20 +23,23 @@ type App struct {
 	Logger   *slog.Logger
 }
 
-// NewApp creates a new basic App instance.
+// NewApp creates a new basic App instance, loading the config from the environment and the optional
+// YAML file at CONFIG_FILE. Exits listing every problem if the config is invalid.
 // Before this can be used Build needs to be called to set up the application.
 func NewApp() *App {
-	if err := loadPossibleEnv(".env", "../.env", "/etc/secrets/.env"); err != nil {
+	// The .env file is optional as the config may be set in the environment or the config file,
+	// which are validated below.
+	if err := loadPossibleEnv(".env", "../.env", "/etc/secrets/.env"); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatal(fmt.Errorf("error loading .env file: %w", err))
 	}
 
-	config := NewAppConfig(os.Getenv)
+	config, err := LoadConfig(os.Getenv("CONFIG_FILE"), os.Getenv)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		AddSource:   true,
-		ReplaceAttr: nil,
-		Level:       config.LogLevel,
-	}))
+	logger := slog.New(logging.NewHandler(os.Stdout, config.LogFormat, config.LogLevel))
 
 	return &App{
 		Config: config,
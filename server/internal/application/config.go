@@ -1,8 +1,14 @@
 package application
 
 import (
+	"fmt"
 	"log/slog"
+	"net"
 	"strconv"
+	"strings"
+	"time"
+
+	"advancely/internal/logging"
 )
 
 type DatabaseConfig struct {
@@ -10,12 +16,118 @@ type DatabaseConfig struct {
 	Password      string
 	URI           string
 	AutoMigrateOn bool
+	// MigrationLockTimeout is how long to wait for another replica to finish migrating the database.
+	MigrationLockTimeout time.Duration
 }
 
 type SupabaseConfig struct {
 	URL               string
 	PublicKey         string
 	ServiceRoleSecret string
+	// JWTSecret is used to verify HS256 access tokens.
+	// If empty, access tokens are verified using the JWKS published by the auth server.
+	JWTSecret   string
+	JWTAudience string
+}
+
+// SAMLConfig contains the PEM encoded key pair used by the SAML service provider.
+// If unset, authentication requests are not signed and assertions cannot be encrypted.
+type SAMLConfig struct {
+	SPCertificate string
+	SPPrivateKey  string
+}
+
+// RateLimit allows Requests requests per Window. A limit with zero Requests is disabled.
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Enabled returns true if the limit applies.
+func (l RateLimit) Enabled() bool {
+	return l.Requests > 0 && l.Window > 0
+}
+
+// String returns the limit in the form parsed by ParseRateLimit.
+func (l RateLimit) String() string {
+	if !l.Enabled() {
+		return "0"
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Window)
+}
+
+// ParseRateLimit parses a limit in the form "requests/window", such as "10/1m".
+// The value "0" disables the limit.
+func ParseRateLimit(value string) (RateLimit, error) {
+	if value == "0" {
+		return RateLimit{}, nil
+	}
+	requests, window, ok := strings.Cut(value, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: expected requests/window", value)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: requests must be a positive integer", value)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: window must be a positive duration", value)
+	}
+	return RateLimit{Requests: n, Window: d}, nil
+}
+
+// RouteRateLimit limits the requests to a route by client IP and by the email in the request body.
+type RouteRateLimit struct {
+	PerIP    RateLimit
+	PerEmail RateLimit
+}
+
+// UserRateLimit limits the requests to a route by client IP and by the user the request is made for.
+type UserRateLimit struct {
+	PerIP   RateLimit
+	PerUser RateLimit
+}
+
+// LockoutConfig locks an email out of logging in after repeated failed attempts.
+// The lockout starts at BaseDuration once Threshold consecutive failures are reached,
+// doubling with every further failure up to MaxDuration.
+type LockoutConfig struct {
+	Threshold    int
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
+	// FailureWindow is how long failed attempts are remembered without a successful login.
+	FailureWindow time.Duration
+}
+
+type RateLimitConfig struct {
+	Login                RouteRateLimit
+	PasswordReset        RouteRateLimit
+	PasswordResetConfirm RouteRateLimit
+	Signup               RouteRateLimit
+	ConfirmEmail         RouteRateLimit
+	LoginLockout         LockoutConfig
+	// MFA limits the attempts at a second factor code, which are locked out per user by MFALockout.
+	MFA        UserRateLimit
+	MFALockout LockoutConfig
+}
+
+// Exporters of trace spans.
+const (
+	TracingExporterNone = "none"
+	TracingExporterOTLP = "otlp"
+)
+
+// TracingConfig configures the export of OpenTelemetry traces. Spans are not recorded with the "none" exporter.
+type TracingConfig struct {
+	Exporter string
+	// OTLPEndpoint is the URL of the OTLP/HTTP collector, such as http://localhost:4318.
+	// If empty, the OTEL_EXPORTER_OTLP_ENDPOINT environment variable or the exporter default is used.
+	OTLPEndpoint string
+	ServiceName  string
+	// SampleRatio is the fraction of traces started by the API that are sampled.
+	// Requests that are part of a sampled trace started by a client are always sampled.
+	SampleRatio float64
 }
 
 type ResendConfig struct {
@@ -25,22 +137,62 @@ type ResendConfig struct {
 type AppConfig struct {
 	Environment   Environment
 	LogLevel      slog.Level
+	LogFormat     logging.Format
 	Host          string
 	ClientBaseURL string
+	// APIBaseURL is the public URL of the API, including the /api/v1 prefix.
+	// It is used to build callback URLs given to external identity providers.
+	APIBaseURL    string
 	SessionSecret string
+	// MetricsHost is the listen address of the Prometheus metrics endpoint, which is disabled if empty.
+	// It should not be reachable from the public internet.
+	MetricsHost string
+	// TrustedProxies are the networks of the reverse proxies whose X-Forwarded-For header gives the client IP.
+	// If empty, the client IP is the address of the connection.
+	TrustedProxies []*net.IPNet
+	// ShutdownGracePeriod is how long in-flight requests have to complete once the API starts shutting down.
+	ShutdownGracePeriod time.Duration
+	// ShutdownDrainDelay is how long the API keeps serving requests after readiness starts failing.
+	ShutdownDrainDelay time.Duration
+	// CompanyDeletionGracePeriod is how long a deleted company can be restored by its owner before it is purged.
+	CompanyDeletionGracePeriod time.Duration
+	// DeletionRetentionPeriod is how long deleted users and roles can be restored before they are purged.
+	DeletionRetentionPeriod time.Duration
+	// PurgeInterval is how often deleted data past its grace period is purged.
+	PurgeInterval time.Duration
 
 	Database DatabaseConfig
 	Supabase SupabaseConfig
+	SAML     SAMLConfig
 	Resend   ResendConfig
+
+	RateLimit RateLimitConfig
+	Tracing   TracingConfig
+
+	// values are the values the config was read from, used to print it with secrets redacted.
+	values []configValue
 }
 
-func NewAppConfig(get func(string) string) AppConfig {
-	autoMigrateOn, _ := strconv.ParseBool(get("AUTO_MIGRATE_ON"))
+// Minimum lengths of the secrets used to sign sessions and access tokens.
+const (
+	MinSessionSecretLength = 32
+	MinJWTSecretLength     = 32
+)
+
+// NewAppConfig reads the config from get, which returns the value of a key such as DATABASE_URI,
+// or an empty string if it is unset. All invalid or missing values are returned in a single error.
+func NewAppConfig(get func(string) string) (AppConfig, error) {
+	r := newConfigReader(get, nil)
+	config := readAppConfig(r)
+	return config, r.err()
+}
 
-	envValue := get("ENVIRONMENT")
-	environment := NewEnvironment(envValue)
-	if environment == EnvironmentUnknown {
-		environment = EnvironmentProduction
+func readAppConfig(r *configReader) AppConfig {
+	environment := EnvironmentProduction
+	if value := r.string("ENVIRONMENT", EnvironmentProduction.String()); NewEnvironment(value) != EnvironmentUnknown {
+		environment = NewEnvironment(value)
+	} else {
+		r.problem("ENVIRONMENT", "must be %q or %q, got %q", EnvironmentDevelopment, EnvironmentProduction, value)
 	}
 
 	logLevel := slog.LevelInfo
@@ -48,26 +200,129 @@ func NewAppConfig(get func(string) string) AppConfig {
 		logLevel = slog.LevelDebug
 	}
 
+	logFormat, ok := logging.ParseFormat(r.string("LOG_FORMAT", string(logging.FormatText)))
+	if !ok {
+		r.problem("LOG_FORMAT", "must be %q or %q", logging.FormatText, logging.FormatJSON)
+	}
+
+	samlCertificate := r.string("SAML_SP_CERTIFICATE", "")
+	samlPrivateKey := r.secret("SAML_SP_PRIVATE_KEY", 0, false)
+	if (samlCertificate == "") != (samlPrivateKey == "") {
+		r.problem("SAML_SP_PRIVATE_KEY", "must be set together with SAML_SP_CERTIFICATE")
+	}
+
+	tracingExporter := r.string("TRACING_EXPORTER", TracingExporterNone)
+	if tracingExporter != TracingExporterNone && tracingExporter != TracingExporterOTLP {
+		r.problem("TRACING_EXPORTER", "must be %q or %q", TracingExporterNone, TracingExporterOTLP)
+	}
+	sampleRatio := r.float("TRACING_SAMPLE_RATIO", 1)
+	if sampleRatio < 0 || sampleRatio > 1 {
+		r.problem("TRACING_SAMPLE_RATIO", "must be between 0 and 1")
+	}
+
+	var trustedProxies []*net.IPNet
+	for _, value := range strings.Split(r.string("TRUSTED_PROXIES", ""), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			r.problem("TRUSTED_PROXIES", "invalid CIDR %q", value)
+			continue
+		}
+		trustedProxies = append(trustedProxies, network)
+	}
+
+	purgeInterval := r.duration("PURGE_INTERVAL", time.Hour)
+	if purgeInterval == 0 {
+		r.problem("PURGE_INTERVAL", "must be positive")
+	}
+
 	return AppConfig{
 		Environment:   environment,
 		LogLevel:      logLevel,
-		Host:          get("LISTEN_ADDRESS"),
-		ClientBaseURL: get("CLIENT_BASE_URL"),
-		SessionSecret: get("SESSION_SECRET"),
+		LogFormat:     logFormat,
+		Host:          r.string("LISTEN_ADDRESS", ":8080"),
+		ClientBaseURL: r.url("CLIENT_BASE_URL", true, "http", "https"),
+		APIBaseURL:    r.url("API_BASE_URL", true, "http", "https"),
+		SessionSecret: r.secret("SESSION_SECRET", MinSessionSecretLength, true),
+		MetricsHost:   r.string("METRICS_LISTEN_ADDRESS", ""),
+
+		TrustedProxies: trustedProxies,
+
+		ShutdownGracePeriod: r.duration("SHUTDOWN_GRACE_PERIOD", 10*time.Second),
+		ShutdownDrainDelay:  r.duration("SHUTDOWN_DRAIN_DELAY", 0),
+
+		CompanyDeletionGracePeriod: r.duration("COMPANY_DELETION_GRACE_PERIOD", 30*24*time.Hour),
+		DeletionRetentionPeriod:    r.duration("DELETION_RETENTION_PERIOD", 30*24*time.Hour),
+		PurgeInterval:              purgeInterval,
 
 		Database: DatabaseConfig{
-			Name:          get("DATABASE_NAME"),
-			Password:      get("DATABASE_PASSWORD"),
-			URI:           get("DATABASE_URI"),
-			AutoMigrateOn: autoMigrateOn,
+			Name:          r.required("DATABASE_NAME"),
+			Password:      r.secret("DATABASE_PASSWORD", 0, false),
+			URI:           r.url("DATABASE_URI", true, "postgres", "postgresql"),
+			AutoMigrateOn: r.bool("AUTO_MIGRATE_ON", false),
+
+			MigrationLockTimeout: r.duration("DATABASE_MIGRATION_LOCK_TIMEOUT", 5*time.Minute),
 		},
 		Supabase: SupabaseConfig{
-			URL:               get("SUPABASE_URL"),
-			PublicKey:         get("SUPABASE_PUBLIC_KEY"),
-			ServiceRoleSecret: get("SUPABASE_SERVICE_ROLE_SECRET"),
+			URL:               r.url("SUPABASE_URL", true, "http", "https"),
+			PublicKey:         r.required("SUPABASE_PUBLIC_KEY"),
+			ServiceRoleSecret: r.secret("SUPABASE_SERVICE_ROLE_SECRET", 0, true),
+			JWTSecret:         r.secret("SUPABASE_JWT_SECRET", MinJWTSecretLength, false),
+			JWTAudience:       r.string("SUPABASE_JWT_AUDIENCE", ""),
+		},
+		SAML: SAMLConfig{
+			SPCertificate: samlCertificate,
+			SPPrivateKey:  samlPrivateKey,
 		},
 		Resend: ResendConfig{
-			Key: get("RESEND_KEY"),
+			Key: r.secret("RESEND_KEY", 0, false),
 		},
+		RateLimit: RateLimitConfig{
+			Login: RouteRateLimit{
+				PerIP:    r.rateLimit("RATE_LIMIT_LOGIN_IP", RateLimit{Requests: 20, Window: time.Minute}),
+				PerEmail: r.rateLimit("RATE_LIMIT_LOGIN_EMAIL", RateLimit{Requests: 10, Window: time.Minute}),
+			},
+			PasswordReset: RouteRateLimit{
+				PerIP:    r.rateLimit("RATE_LIMIT_PASSWORD_RESET_IP", RateLimit{Requests: 10, Window: time.Hour}),
+				PerEmail: r.rateLimit("RATE_LIMIT_PASSWORD_RESET_EMAIL", RateLimit{Requests: 3, Window: time.Hour}),
+			},
+			PasswordResetConfirm: RouteRateLimit{
+				PerIP:    r.rateLimit("RATE_LIMIT_PASSWORD_RESET_CONFIRM_IP", RateLimit{Requests: 20, Window: 15 * time.Minute}),
+				PerEmail: r.rateLimit("RATE_LIMIT_PASSWORD_RESET_CONFIRM_EMAIL", RateLimit{Requests: 5, Window: 15 * time.Minute}),
+			},
+			Signup: RouteRateLimit{
+				PerIP:    r.rateLimit("RATE_LIMIT_SIGNUP_IP", RateLimit{Requests: 10, Window: time.Hour}),
+				PerEmail: r.rateLimit("RATE_LIMIT_SIGNUP_EMAIL", RateLimit{Requests: 3, Window: time.Hour}),
+			},
+			ConfirmEmail: RouteRateLimit{
+				PerIP: r.rateLimit("RATE_LIMIT_CONFIRM_EMAIL_IP", RateLimit{Requests: 20, Window: 15 * time.Minute}),
+			},
+			LoginLockout: LockoutConfig{
+				Threshold:     r.int("LOGIN_LOCKOUT_THRESHOLD", 5),
+				BaseDuration:  r.duration("LOGIN_LOCKOUT_BASE_DURATION", 30*time.Second),
+				MaxDuration:   r.duration("LOGIN_LOCKOUT_MAX_DURATION", time.Hour),
+				FailureWindow: r.duration("LOGIN_LOCKOUT_FAILURE_WINDOW", 24*time.Hour),
+			},
+			MFA: UserRateLimit{
+				PerIP:   r.rateLimit("RATE_LIMIT_MFA_IP", RateLimit{Requests: 30, Window: time.Minute}),
+				PerUser: r.rateLimit("RATE_LIMIT_MFA_USER", RateLimit{Requests: 5, Window: time.Minute}),
+			},
+			MFALockout: LockoutConfig{
+				Threshold:     r.int("MFA_LOCKOUT_THRESHOLD", 5),
+				BaseDuration:  r.duration("MFA_LOCKOUT_BASE_DURATION", time.Minute),
+				MaxDuration:   r.duration("MFA_LOCKOUT_MAX_DURATION", time.Hour),
+				FailureWindow: r.duration("MFA_LOCKOUT_FAILURE_WINDOW", 24*time.Hour),
+			},
+		},
+		Tracing: TracingConfig{
+			Exporter:     tracingExporter,
+			OTLPEndpoint: r.url("TRACING_OTLP_ENDPOINT", false, "http", "https"),
+			ServiceName:  r.string("TRACING_SERVICE_NAME", "advancely-api"),
+			SampleRatio:  sampleRatio,
+		},
+
+		values: r.values,
 	}
 }
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ConfirmationTokenTTL is how long a confirmation token can be used after it is issued.
+const ConfirmationTokenTTL = 10 * time.Minute
+
+var (
+	ErrInvalidConfirmationToken = errors.New("invalid confirmation token")
+	ErrConfirmationTokenExpired = errors.New("confirmation token has expired")
+)
+
+// NewConfirmationToken returns a token confirming that the user intends to perform the action on the subject,
+// such as deleting a company, which expires after ConfirmationTokenTTL. The token is signed with the secret,
+// so it does not need to be persisted.
+func NewConfirmationToken(secret, action string, subject, userID uuid.UUID, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(ConfirmationTokenTTL).Truncate(time.Second)
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expiry + "." + signConfirmation(secret, action, subject, userID, expiry), expiresAt
+}
+
+// VerifyConfirmationToken returns an error if the token was not issued for the action, subject and user,
+// or has expired.
+func VerifyConfirmationToken(secret, token, action string, subject, userID uuid.UUID, now time.Time) error {
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalidConfirmationToken
+	}
+	expected := signConfirmation(secret, action, subject, userID, expiry)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidConfirmationToken
+	}
+
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return ErrInvalidConfirmationToken
+	}
+	if now.Unix() > expiresAt {
+		return ErrConfirmationTokenExpired
+	}
+	return nil
+}
+
+func signConfirmation(secret, action string, subject, userID uuid.UUID, expiry string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%s:%s:%s:%s", action, subject, userID, expiry)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
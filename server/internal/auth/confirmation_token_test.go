@@ -0,0 +1,107 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"advancely/internal/auth"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyConfirmationToken(t *testing.T) {
+	const secret = "confirmation-token-secret-for-testing"
+	subject, userID := uuid.New(), uuid.New()
+	now := time.Now()
+	token, expiresAt := auth.NewConfirmationToken(secret, "delete-company", subject, userID, now)
+	require.WithinDuration(t, now.Add(auth.ConfirmationTokenTTL), expiresAt, time.Second)
+
+	testCases := []struct {
+		name        string
+		secret      string
+		token       string
+		action      string
+		subject     uuid.UUID
+		userID      uuid.UUID
+		now         time.Time
+		expectedErr error
+	}{
+		{
+			name:    "valid token",
+			secret:  secret,
+			token:   token,
+			action:  "delete-company",
+			subject: subject,
+			userID:  userID,
+			now:     now,
+		},
+		{
+			name:        "expired token",
+			secret:      secret,
+			token:       token,
+			action:      "delete-company",
+			subject:     subject,
+			userID:      userID,
+			now:         now.Add(auth.ConfirmationTokenTTL + time.Second),
+			expectedErr: auth.ErrConfirmationTokenExpired,
+		},
+		{
+			name:        "different action",
+			secret:      secret,
+			token:       token,
+			action:      "delete-user",
+			subject:     subject,
+			userID:      userID,
+			now:         now,
+			expectedErr: auth.ErrInvalidConfirmationToken,
+		},
+		{
+			name:        "different subject",
+			secret:      secret,
+			token:       token,
+			action:      "delete-company",
+			subject:     uuid.New(),
+			userID:      userID,
+			now:         now,
+			expectedErr: auth.ErrInvalidConfirmationToken,
+		},
+		{
+			name:        "different user",
+			secret:      secret,
+			token:       token,
+			action:      "delete-company",
+			subject:     subject,
+			userID:      uuid.New(),
+			now:         now,
+			expectedErr: auth.ErrInvalidConfirmationToken,
+		},
+		{
+			name:        "different secret",
+			secret:      "another-confirmation-token-secret",
+			token:       token,
+			action:      "delete-company",
+			subject:     subject,
+			userID:      userID,
+			now:         now,
+			expectedErr: auth.ErrInvalidConfirmationToken,
+		},
+		{
+			name:        "malformed token",
+			secret:      secret,
+			token:       "not-a-token",
+			action:      "delete-company",
+			subject:     subject,
+			userID:      userID,
+			now:         now,
+			expectedErr: auth.ErrInvalidConfirmationToken,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := auth.VerifyConfirmationToken(tc.secret, tc.token, tc.action, tc.subject, tc.userID, tc.now)
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	flowCookieValueKey = "flow"
+	// flowCookieMaxAge is how long, in seconds, the user has to complete a login with an external identity provider.
+	flowCookieMaxAge = 10 * 60
+)
+
+var ErrFlowNotFound = errors.New("login flow not found")
+
+// setFlowCookie saves the state of a login with an external identity provider in a short-lived cookie.
+// The value must be registered with gob.
+func setFlowCookie(c echo.Context, name, secret string, value any, options *sessions.Options) error {
+	store := sessions.NewCookieStore([]byte(secret))
+	store.Options = options
+
+	storeSession, err := store.Get(c.Request(), name)
+	if err != nil {
+		return fmt.Errorf("error getting %s cookie: %w", name, err)
+	}
+	storeSession.Values[flowCookieValueKey] = value
+	return storeSession.Save(c.Request(), c.Response())
+}
+
+// popFlowCookie returns the value saved by setFlowCookie and deletes the cookie,
+// so that each login flow can only be completed once.
+func popFlowCookie(c echo.Context, name, secret string) (any, error) {
+	store := sessions.NewCookieStore([]byte(secret))
+	storeSession, err := store.Get(c.Request(), name)
+	if err != nil {
+		return nil, ErrFlowNotFound
+	}
+
+	value, ok := storeSession.Values[flowCookieValueKey]
+	if !ok || value == nil {
+		return nil, ErrFlowNotFound
+	}
+
+	storeSession.Values[flowCookieValueKey] = nil
+	storeSession.Options.MaxAge = -1
+	if err := storeSession.Save(c.Request(), c.Response()); err != nil {
+		return nil, fmt.Errorf("error deleting %s cookie: %w", name, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksCacheTTL is how long fetched keys are trusted before the JWKS is fetched again.
+	jwksCacheTTL = 10 * time.Minute
+	// jwksMinRefreshInterval limits how often an unknown key ID, or a failure to fetch the JWKS, can trigger a refresh.
+	jwksMinRefreshInterval = 30 * time.Second
+	// jwksFetchTimeout is how long fetching the JWKS may take. The fetch is shared by every waiting
+	// request, so it is not cancelled along with the request that started it.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKSCache fetches and caches the public keys published by the auth server.
+// Keys are fetched by one request at a time, and the cached keys keep being served if fetching fails.
+type JWKSCache struct {
+	// TTL is how long fetched keys are trusted before the JWKS is fetched again.
+	TTL time.Duration
+	// MinRefreshInterval is the minimum time between two fetches of the JWKS.
+	MinRefreshInterval time.Duration
+
+	url        string
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// refreshing is closed when the refresh in progress completes, and is nil if there is none.
+	refreshing chan struct{}
+	refreshErr error
+}
+
+func NewJWKSCache(url string, httpClient *http.Client) *JWKSCache {
+	return &JWKSCache{
+		TTL:                jwksCacheTTL,
+		MinRefreshInterval: jwksMinRefreshInterval,
+		url:                url,
+		httpClient:         httpClient,
+		keys:               make(map[string]crypto.PublicKey),
+	}
+}
+
+// Key returns the public key with the given key ID.
+// The JWKS is fetched again if the cache has expired or the key ID is not known. A cached key is
+// returned if the JWKS cannot be fetched.
+func (c *JWKSCache) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	if ok && time.Since(c.fetchedAt) < c.TTL {
+		c.mu.Unlock()
+		return key, nil
+	}
+
+	done := c.refreshing
+	if done == nil {
+		if time.Since(c.attemptedAt) < c.MinRefreshInterval {
+			c.mu.Unlock()
+			if ok {
+				return key, nil
+			}
+			return nil, ErrUnsupportedKeyID
+		}
+		done = make(chan struct{})
+		c.refreshing = done
+		c.attemptedAt = time.Now()
+		go c.refresh(context.WithoutCancel(ctx), done)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if ok {
+			return key, nil
+		}
+		return nil, ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if c.refreshErr != nil {
+		return nil, c.refreshErr
+	}
+	return nil, ErrUnsupportedKeyID
+}
+
+// refresh replaces the cached keys with those currently published, then closes done.
+// The cached keys are kept if the JWKS cannot be fetched.
+func (c *JWKSCache) refresh(ctx context.Context, done chan struct{}) {
+	ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+	keys, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	if err == nil {
+		c.keys = keys
+		c.fetchedAt = time.Now()
+	}
+	c.refreshErr = err
+	c.refreshing = nil
+	c.mu.Unlock()
+	close(done)
+}
+
+// fetch returns the keys currently published by the auth server.
+func (c *JWKSCache) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: response status code %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(body.Keys))
+	for _, jwk := range body.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip keys we cannot use rather than failing for all keys.
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
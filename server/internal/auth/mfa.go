@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// MFAIssuer is the issuer shown in authenticator apps.
+	MFAIssuer = "Advancely"
+	// RecoveryCodeCount is the number of recovery codes generated when a factor is verified.
+	RecoveryCodeCount = 10
+)
+
+// recoveryCodeAlphabet excludes characters that are easily confused with one another.
+// It has 32 characters so that each random byte maps onto it without bias.
+const recoveryCodeAlphabet = "abcdefghjkmnopqrstuvwxyz23456789"
+
+// NewRecoveryCodes generates n single-use recovery codes in the form xxxxx-xxxxx.
+// The codes and their hashes are returned; only the hashes should be persisted.
+func NewRecoveryCodes(n int) (codes, hashes []string, err error) {
+	codes = make([]string, n)
+	hashes = make([]string, n)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		for j := range b {
+			b[j] = recoveryCodeAlphabet[int(b[j])%len(recoveryCodeAlphabet)]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the hex encoded SHA-256 hash of the recovery code.
+// The code is normalised first so that case and separators do not matter when it is entered.
+func HashRecoveryCode(code string) string {
+	normalised := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalised))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,37 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+
+	"advancely/internal/auth"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := auth.NewRecoveryCodes(auth.RecoveryCodeCount)
+	require.NoError(t, err)
+	require.Len(t, codes, auth.RecoveryCodeCount)
+	require.Len(t, hashes, auth.RecoveryCodeCount)
+
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		require.Len(t, code, 11)
+		require.Equal(t, auth.HashRecoveryCode(code), hashes[i])
+		require.False(t, seen[code], "expected recovery codes to be unique")
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCodeIgnoresFormatting(t *testing.T) {
+	codes, _, err := auth.NewRecoveryCodes(1)
+	require.NoError(t, err)
+	code := codes[0]
+
+	expected := auth.HashRecoveryCode(code)
+	require.Equal(t, expected, auth.HashRecoveryCode(strings.ToUpper(code)))
+	require.Equal(t, expected, auth.HashRecoveryCode(strings.ReplaceAll(code, "-", "")))
+	require.Equal(t, expected, auth.HashRecoveryCode(" "+strings.ReplaceAll(code, "-", " ")))
+	require.NotEqual(t, expected, auth.HashRecoveryCode("aaaaa-aaaaa"))
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"strings"
+
+	"advancely/internal/application"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo/v4"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+const OAuthFlowCookieStoreName = "oauth_flow"
+
+var ErrUnsupportedOAuthProvider = errors.New("unsupported OAuth provider")
+
+// oauthProviders maps the provider names used in the API to the providers known to the auth server.
+var oauthProviders = map[string]types.Provider{
+	"microsoft": types.ProviderAzure,
+	"google":    types.ProviderGoogle,
+}
+
+func init() {
+	gob.Register(&OAuthFlow{})
+}
+
+// ParseOAuthProvider returns the auth server provider for the given API provider name.
+func ParseOAuthProvider(name string) (types.Provider, error) {
+	p, ok := oauthProviders[strings.ToLower(name)]
+	if !ok {
+		return "", ErrUnsupportedOAuthProvider
+	}
+	return p, nil
+}
+
+// OAuthEmailVerified returns true if the user's email is confirmed and one of the OAuth providers has asserted that
+// it verified the email of the identity it logged the user in with. Some providers let users set an email they
+// do not own, so the email of an unverified identity cannot be trusted to decide which company the user belongs to.
+func OAuthEmailVerified(user types.User) bool {
+	if user.EmailConfirmedAt == nil {
+		return false
+	}
+	for _, identity := range user.Identities {
+		if !isOAuthProvider(identity.Provider) {
+			continue
+		}
+		email, _ := identity.IdentityData["email"].(string)
+		verified, _ := identity.IdentityData["email_verified"].(bool)
+		if verified && strings.EqualFold(email, user.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+func isOAuthProvider(name string) bool {
+	for _, p := range oauthProviders {
+		if string(p) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NewPKCE generates a PKCE code verifier and its S256 code challenge.
+func NewPKCE() (verifier, challenge string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(b)
+	return verifier, PKCEChallenge(verifier), nil
+}
+
+// PKCEChallenge returns the S256 code challenge for the code verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// OAuthFlow holds the state of an OAuth login between the start and callback requests.
+type OAuthFlow struct {
+	CodeVerifier string
+	// RedirectPath is the client path the user is sent to once logged in.
+	RedirectPath string
+}
+
+// SetCookie saves the flow in a short-lived cookie.
+// The cookie must use SameSite=Lax so that it is sent when the auth server redirects back to the callback.
+func (f *OAuthFlow) SetCookie(c echo.Context, secret string, env application.Environment) error {
+	return setFlowCookie(c, OAuthFlowCookieStoreName, secret, f, &sessions.Options{
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   env.IsProduction(),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   flowCookieMaxAge,
+	})
+}
+
+// PopOAuthFlowCookie returns the flow from the cookie and deletes the cookie, so that each flow can only be completed once.
+func PopOAuthFlowCookie(c echo.Context, secret string) (*OAuthFlow, error) {
+	value, err := popFlowCookie(c, OAuthFlowCookieStoreName, secret)
+	if err != nil {
+		return nil, err
+	}
+	flow, ok := value.(*OAuthFlow)
+	if !ok || flow.CodeVerifier == "" {
+		return nil, ErrFlowNotFound
+	}
+	return flow, nil
+}
@@ -0,0 +1,64 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"advancely/internal/auth"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+func TestOAuthEmailVerified(t *testing.T) {
+	now := time.Now()
+	identity := func(provider types.Provider, email string, verified bool) types.Identity {
+		return types.Identity{
+			Provider:     string(provider),
+			IdentityData: map[string]interface{}{"email": email, "email_verified": verified},
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		user     types.User
+		expected bool
+	}{
+		{
+			name: "verified by the provider",
+			user: types.User{Email: "user@company.com", EmailConfirmedAt: &now, Identities: []types.Identity{
+				identity(types.ProviderAzure, "User@Company.com", true),
+			}},
+			expected: true,
+		},
+		{
+			name: "not verified by the provider",
+			user: types.User{Email: "user@company.com", EmailConfirmedAt: &now, Identities: []types.Identity{
+				identity(types.ProviderAzure, "user@company.com", false),
+			}},
+		},
+		{
+			name: "provider verified another email",
+			user: types.User{Email: "user@company.com", EmailConfirmedAt: &now, Identities: []types.Identity{
+				identity(types.ProviderGoogle, "user@gmail.com", true),
+			}},
+		},
+		{
+			name: "only an email identity",
+			user: types.User{Email: "user@company.com", EmailConfirmedAt: &now, Identities: []types.Identity{
+				identity("email", "user@company.com", true),
+			}},
+		},
+		{
+			name: "email not confirmed",
+			user: types.User{Email: "user@company.com", Identities: []types.Identity{
+				identity(types.ProviderGoogle, "user@company.com", true),
+			}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, auth.OAuthEmailVerified(tc.user))
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package auth
+
+import "strings"
+
+// PersonalAccessTokenPrefix identifies a bearer token as a personal access token.
+const PersonalAccessTokenPrefix = "adv_"
+
+// NewPersonalAccessToken generates a random personal access token.
+func NewPersonalAccessToken() (token, hash, displayPrefix string, err error) {
+	return newToken(PersonalAccessTokenPrefix)
+}
+
+// IsPersonalAccessToken returns true if the bearer token has the personal access token prefix.
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"advancely/internal/model"
+	"advancely/internal/model/security"
+	"advancely/internal/model/settings"
+	"advancely/internal/store"
+
+	"github.com/google/uuid"
+)
+
+// ErrMFARequired is returned when an access token is used directly by a user who must complete
+// multi-factor authentication, which is only checked when logging in through the API.
+var ErrMFARequired = errors.New("multi-factor authentication is required, log in or use a personal access token")
+
+// ErrSSORequired is returned when an access token is used directly by a user whose company requires
+// them to log in through its SAML identity provider.
+var ErrSSORequired = errors.New("your company requires you to sign in with SSO")
+
+// ErrCompanyDeleted is returned when a session is used in a company that has been deleted and is waiting
+// to be purged. Only its owner can log in to it, to restore it.
+var ErrCompanyDeleted = errors.New("the company has been deleted")
+
+// SessionPolicy decides what a user must do before their session can be used. It is applied
+// both when logging in and when authenticating a Supabase access token sent as a bearer token.
+type SessionPolicy struct {
+	CompanyStore     store.CompanyStore
+	MFAStore         store.MFAStore
+	SettingsStore    store.CompanySettingsStore
+	PermissionsStore store.PermissionsStore
+}
+
+func NewSessionPolicy(s *store.PostgresStore) SessionPolicy {
+	return SessionPolicy{
+		CompanyStore:     s.CompanyStore,
+		MFAStore:         s.MFAStore,
+		SettingsStore:    s.CompanySettingsStore,
+		PermissionsStore: s.PermissionsStore,
+	}
+}
+
+// CompanyDeleted returns true if the company has been deleted and is waiting to be purged.
+func (p SessionPolicy) CompanyDeleted(ctx context.Context, companyID uuid.UUID) (bool, error) {
+	company, err := p.CompanyStore.Company(ctx, companyID)
+	if err != nil {
+		return false, err
+	}
+	return company.Deleted(), nil
+}
+
+// SSOEnforced returns true if the company requires its users to log in through its SAML identity provider.
+func (p SessionPolicy) SSOEnforced(ctx context.Context, companyID uuid.UUID) (bool, error) {
+	cfg, err := p.SettingsStore.SAMLConfig(ctx, companyID)
+	if err != nil {
+		if errors.Is(err, store.ErrSAMLConfigNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return cfg.EnforceSSO, nil
+}
+
+// MFAState returns the MFA state a new session for the user must start in.
+// Users with a verified factor must complete a challenge, and users of companies requiring MFA,
+// or admins of companies requiring admin MFA, must enroll a factor.
+// An empty state is returned if the session can be used immediately.
+func (p SessionPolicy) MFAState(ctx context.Context, user model.UserProfile) (string, error) {
+	factor, err := p.MFAStore.MFAFactor(ctx, user.ID)
+	if err == nil && factor.Verified() {
+		return MFAStateChallengeRequired, nil
+	}
+	if err != nil && !errors.Is(err, store.ErrMFAFactorNotFound) {
+		return "", err
+	}
+
+	companySettings, err := p.SettingsStore.Settings(ctx, user.CompanyID)
+	if err != nil {
+		return "", err
+	}
+	if settings.RequireMFA.Get(companySettings.Settings) {
+		return MFAStateEnrollmentRequired, nil
+	}
+	if !settings.RequireAdminMFA.Get(companySettings.Settings) {
+		return "", nil
+	}
+
+	roles, err := p.PermissionsStore.UserRoles(ctx, user.ID, user.CompanyID)
+	if err != nil {
+		return "", err
+	}
+	if roles.HasRole(security.RoleAdmin) {
+		return MFAStateEnrollmentRequired, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+const (
+	ErrorCodeInvalidCredentials string = "invalid_credentials"
+	ErrorCodeOTPExpired         string = "otp_expired"
+	ErrorCodeSamePassword       string = "same_password"
+	ErrorCodeUserNotFound       string = "user_not_found"
+)
+
+// Error represents an error returned by the auth server in response to a Provider call.
+type Error struct {
+	// Status is the HTTP status code returned by the auth server.
+	Status int
+	// Code is a machine-readable error code such as ErrorCodeInvalidCredentials.
+	Code    string
+	Message string
+}
+
+// Error returns the message of the error.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// AsError returns the *Error in the err tree and true, or nil and false if there is none.
+func AsError(err error) (*Error, bool) {
+	var authErr *Error
+	if errors.As(err, &authErr) {
+		return authErr, true
+	}
+	return nil, false
+}
+
+// Provider is the interface for the identity operations required by the API.
+// Errors returned by the auth server are surfaced as *Error.
+type Provider interface {
+	// SignInWithEmailPassword exchanges the given credentials for a new session.
+	SignInWithEmailPassword(ctx context.Context, email, password string) (*types.Session, error)
+	// SignUp registers a new user with the auth server.
+	SignUp(ctx context.Context, req types.SignupRequest) (*types.User, error)
+	// SendOTP sends a one-time password to the user, creating the user if requested.
+	SendOTP(ctx context.Context, req types.OTPRequest) error
+	// VerifyOTP verifies a one-time password, returning the session it grants.
+	VerifyOTP(ctx context.Context, req types.VerifyForUserRequest) (*types.Session, error)
+	// RefreshSession exchanges a refresh token for a new session.
+	RefreshSession(ctx context.Context, refreshToken string) (*types.Session, error)
+	// Logout revokes the session associated with the access token.
+	Logout(ctx context.Context, accessToken string) error
+	// ResetPasswordForEmail sends a password recovery email to the given address.
+	ResetPasswordForEmail(ctx context.Context, email, redirectTo string) error
+	// UpdatePassword sets a new password for the user owning the access token.
+	UpdatePassword(ctx context.Context, accessToken, password string) error
+	// GetUser returns the user owning the access token.
+	GetUser(ctx context.Context, accessToken string) (*types.User, error)
+	// OAuthURL returns the URL the user is sent to in order to log in with the OAuth provider.
+	// The auth server redirects back to redirectTo with a code for ExchangeCodeForSession.
+	OAuthURL(provider types.Provider, redirectTo, codeChallenge string) string
+	// ExchangeCodeForSession exchanges a PKCE authorization code and its code verifier for a session.
+	ExchangeCodeForSession(ctx context.Context, code, codeVerifier string) (*types.Session, error)
+	// SignInWithVerifiedEmail creates a session for the user with the email, creating the user if they do not exist.
+	// It must only be used once the user's identity has been verified by a trusted party, such as a SAML identity provider.
+	SignInWithVerifiedEmail(ctx context.Context, email string, metadata map[string]interface{}) (*types.Session, error)
+	// CreateUser creates a user with a confirmed email address without notifying them.
+	// It is used when users are provisioned by a company's identity provider, which they log in with.
+	CreateUser(ctx context.Context, email string, metadata map[string]interface{}) (*types.User, error)
+	// DeleteUser permanently deletes the user, ending their sessions.
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+	// SetUserBanned bans the user, or lifts their ban. Banned users cannot log in and their sessions
+	// can no longer be refreshed, revoking them.
+	SetUserBanned(ctx context.Context, id uuid.UUID, banned bool) error
+}
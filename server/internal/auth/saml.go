@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"advancely/internal/model"
+
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo/v4"
+)
+
+const SAMLFlowCookieStoreName = "saml_flow"
+
+var (
+	ErrInvalidIdPMetadata    = errors.New("invalid identity provider metadata")
+	ErrInvalidIdPCertificate = errors.New("invalid identity provider certificate")
+	ErrSAMLEmailMissing      = errors.New("SAML assertion does not contain an email address")
+)
+
+func init() {
+	gob.Register(&SAMLFlow{})
+}
+
+// SAMLKeyPair is the key and certificate used by the service provider to sign
+// authentication requests and decrypt assertions.
+type SAMLKeyPair struct {
+	Key         *rsa.PrivateKey
+	Certificate *x509.Certificate
+}
+
+// ParseSAMLKeyPair parses the PEM encoded certificate and RSA private key.
+// A nil key pair is returned if neither is provided, in which case requests are not signed
+// and identity providers cannot encrypt assertions.
+func ParseSAMLKeyPair(certificatePEM, keyPEM string) (*SAMLKeyPair, error) {
+	if certificatePEM == "" && keyPEM == "" {
+		return nil, nil
+	}
+	pair, err := tls.X509KeyPair([]byte(certificatePEM), []byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SAML key pair: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("SAML private key must be an RSA key")
+	}
+	certificate, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SAML certificate: %w", err)
+	}
+	return &SAMLKeyPair{Key: key, Certificate: certificate}, nil
+}
+
+// NewSAMLServiceProvider creates the service provider for a company's SAML configuration.
+// The metadata URL is used as the service provider entity ID.
+func NewSAMLServiceProvider(
+	cfg model.CompanySAMLConfig,
+	metadataURL, acsURL string,
+	keyPair *SAMLKeyPair,
+) (*saml.ServiceProvider, error) {
+	metadata, err := url.Parse(metadataURL)
+	if err != nil {
+		return nil, err
+	}
+	acs, err := url.Parse(acsURL)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := ParseIdPCertificate(cfg.IdPCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &saml.ServiceProvider{
+		MetadataURL:       *metadata,
+		AcsURL:            *acs,
+		IDPMetadata:       idpEntityDescriptor(cfg.IdPEntityID, cfg.IdPSSOURL, certificate),
+		AuthnNameIDFormat: saml.EmailAddressNameIDFormat,
+		AllowIDPInitiated: false,
+	}
+	if keyPair != nil {
+		sp.Key = keyPair.Key
+		sp.Certificate = keyPair.Certificate
+		sp.SignatureMethod = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	}
+	return sp, nil
+}
+
+// idpEntityDescriptor builds the identity provider metadata from the stored configuration.
+func idpEntityDescriptor(entityID, ssoURL string, certificate *x509.Certificate) *saml.EntityDescriptor {
+	return &saml.EntityDescriptor{
+		EntityID: entityID,
+		IDPSSODescriptors: []saml.IDPSSODescriptor{{
+			SSODescriptor: saml.SSODescriptor{
+				RoleDescriptor: saml.RoleDescriptor{
+					ProtocolSupportEnumeration: "urn:oasis:names:tc:SAML:2.0:protocol",
+					KeyDescriptors: []saml.KeyDescriptor{{
+						Use: "signing",
+						KeyInfo: saml.KeyInfo{
+							X509Data: saml.X509Data{
+								X509Certificates: []saml.X509Certificate{{
+									Data: base64.StdEncoding.EncodeToString(certificate.Raw),
+								}},
+							},
+						},
+					}},
+				},
+			},
+			SingleSignOnServices: []saml.Endpoint{{
+				Binding:  saml.HTTPRedirectBinding,
+				Location: ssoURL,
+			}},
+		}},
+	}
+}
+
+// ParseIdPCertificate parses an identity provider certificate that is either PEM encoded
+// or the base64 encoded DER found in metadata documents.
+func ParseIdPCertificate(certificate string) (*x509.Certificate, error) {
+	var der []byte
+	if block, _ := pem.Decode([]byte(certificate)); block != nil {
+		der = block.Bytes
+	} else {
+		var err error
+		der, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(certificate), ""))
+		if err != nil {
+			return nil, ErrInvalidIdPCertificate
+		}
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, ErrInvalidIdPCertificate
+	}
+	return cert, nil
+}
+
+// IdPMetadata contains the parts of the identity provider metadata stored for a company.
+type IdPMetadata struct {
+	EntityID string
+	SSOURL   string
+	// Certificate is the base64 encoded DER signing certificate.
+	Certificate string
+}
+
+// ParseIdPMetadata extracts the entity ID, HTTP-Redirect single sign-on URL and signing
+// certificate from an identity provider metadata document.
+func ParseIdPMetadata(data []byte) (IdPMetadata, error) {
+	var descriptor saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &descriptor); err != nil || len(descriptor.IDPSSODescriptors) == 0 {
+		// Some identity providers wrap the descriptor in an EntitiesDescriptor.
+		var entities saml.EntitiesDescriptor
+		if err := xml.Unmarshal(data, &entities); err != nil {
+			return IdPMetadata{}, ErrInvalidIdPMetadata
+		}
+		found := false
+		for _, d := range entities.EntityDescriptors {
+			if len(d.IDPSSODescriptors) > 0 {
+				descriptor, found = d, true
+				break
+			}
+		}
+		if !found {
+			return IdPMetadata{}, ErrInvalidIdPMetadata
+		}
+	}
+
+	metadata := IdPMetadata{EntityID: descriptor.EntityID}
+	for _, idp := range descriptor.IDPSSODescriptors {
+		for _, sso := range idp.SingleSignOnServices {
+			if sso.Binding == saml.HTTPRedirectBinding && metadata.SSOURL == "" {
+				metadata.SSOURL = sso.Location
+			}
+		}
+		for _, kd := range idp.KeyDescriptors {
+			if (kd.Use == "signing" || kd.Use == "") && len(kd.KeyInfo.X509Data.X509Certificates) > 0 && metadata.Certificate == "" {
+				metadata.Certificate = strings.Join(strings.Fields(kd.KeyInfo.X509Data.X509Certificates[0].Data), "")
+			}
+		}
+	}
+	if metadata.EntityID == "" || metadata.SSOURL == "" || metadata.Certificate == "" {
+		return IdPMetadata{}, ErrInvalidIdPMetadata
+	}
+	return metadata, nil
+}
+
+// SAMLIdentity is the user identified by a SAML assertion.
+type SAMLIdentity struct {
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+// SAMLIdentityFromAssertion reads the user's details from the assertion using the company's attribute mapping.
+// Attributes are matched on either their name or friendly name. If the email attribute is missing,
+// the NameID is used if it is an email address.
+func SAMLIdentityFromAssertion(assertion *saml.Assertion, cfg model.CompanySAMLConfig) (SAMLIdentity, error) {
+	identity := SAMLIdentity{
+		Email:     samlAttributeValue(assertion, cfg.EmailAttribute),
+		FirstName: samlAttributeValue(assertion, cfg.FirstNameAttribute),
+		LastName:  samlAttributeValue(assertion, cfg.LastNameAttribute),
+	}
+	if identity.Email == "" && assertion.Subject != nil && assertion.Subject.NameID != nil {
+		identity.Email = assertion.Subject.NameID.Value
+	}
+	identity.Email = strings.ToLower(strings.TrimSpace(identity.Email))
+	if !strings.Contains(identity.Email, "@") {
+		return SAMLIdentity{}, ErrSAMLEmailMissing
+	}
+	return identity, nil
+}
+
+func samlAttributeValue(assertion *saml.Assertion, name string) string {
+	if name == "" {
+		return ""
+	}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if (attr.Name == name || attr.FriendlyName == name) && len(attr.Values) > 0 {
+				return strings.TrimSpace(attr.Values[0].Value)
+			}
+		}
+	}
+	return ""
+}
+
+// SAMLFlow holds the state of a SAML login between the login and ACS requests.
+type SAMLFlow struct {
+	CompanyID uuid.UUID
+	// RequestID is the ID of the AuthnRequest the identity provider must respond to.
+	RequestID string
+	// RedirectPath is the client path the user is sent to once logged in.
+	RedirectPath string
+}
+
+// SetCookie saves the flow in a short-lived cookie.
+// The identity provider POSTs the response to the ACS from its own site,
+// so the cookie must use SameSite=None, which browsers only accept on Secure cookies.
+func (f *SAMLFlow) SetCookie(c echo.Context, secret string) error {
+	return setFlowCookie(c, SAMLFlowCookieStoreName, secret, f, &sessions.Options{
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+		MaxAge:   flowCookieMaxAge,
+	})
+}
+
+// PopSAMLFlowCookie returns the flow from the cookie and deletes the cookie, so that each flow can only be completed once.
+func PopSAMLFlowCookie(c echo.Context, secret string) (*SAMLFlow, error) {
+	value, err := popFlowCookie(c, SAMLFlowCookieStoreName, secret)
+	if err != nil {
+		return nil, err
+	}
+	flow, ok := value.(*SAMLFlow)
+	if !ok || flow.RequestID == "" {
+		return nil, ErrFlowNotFound
+	}
+	return flow, nil
+}
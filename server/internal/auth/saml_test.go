@@ -0,0 +1,92 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"advancely/internal/auth"
+	"advancely/internal/tests"
+
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testSAMLMetadataURL = "https://api.example.com/auth/saml/company/metadata"
+	testSAMLACSURL      = "https://api.example.com/auth/saml/company/acs"
+)
+
+func TestSAMLLoginRoundTrip(t *testing.T) {
+	idp := tests.NewFakeIdentityProvider(t)
+	idp.SetUser("Jane.Doe@Example.com", "Jane", "Doe")
+	cfg := idp.Config(uuid.New())
+
+	sp, err := auth.NewSAMLServiceProvider(cfg, testSAMLMetadataURL, testSAMLACSURL, nil)
+	require.NoError(t, err)
+	idp.RegisterServiceProvider(sp.Metadata())
+
+	req, err := sp.MakeAuthenticationRequest(
+		sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	require.NoError(t, err)
+	redirect, err := req.Redirect("", sp)
+	require.NoError(t, err)
+
+	form := idp.Login(t, redirect.String())
+
+	acs := httptest.NewRequest(http.MethodPost, testSAMLACSURL, strings.NewReader(form.Encode()))
+	acs.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	require.NoError(t, acs.ParseForm())
+
+	_, err = sp.ParseResponse(acs, []string{"unknown"})
+	require.Error(t, err, "responses to unknown requests must be rejected")
+
+	assertion, err := sp.ParseResponse(acs, []string{req.ID})
+	require.NoError(t, err)
+
+	identity, err := auth.SAMLIdentityFromAssertion(assertion, cfg)
+	require.NoError(t, err)
+	require.Equal(t, auth.SAMLIdentity{Email: "jane.doe@example.com", FirstName: "Jane", LastName: "Doe"}, identity)
+}
+
+func TestSAMLIdentityFromAssertionFallsBackToNameID(t *testing.T) {
+	cfg := tests.NewFakeIdentityProvider(t).Config(uuid.New())
+	assertion := &saml.Assertion{
+		Subject: &saml.Subject{NameID: &saml.NameID{Value: "user@example.com"}},
+		AttributeStatements: []saml.AttributeStatement{{
+			Attributes: []saml.Attribute{
+				{FriendlyName: "firstName", Values: []saml.AttributeValue{{Value: "Sam"}}},
+			},
+		}},
+	}
+
+	identity, err := auth.SAMLIdentityFromAssertion(assertion, cfg)
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", identity.Email)
+	require.Equal(t, "Sam", identity.FirstName)
+
+	assertion.Subject.NameID.Value = "not-an-email"
+	_, err = auth.SAMLIdentityFromAssertion(assertion, cfg)
+	require.ErrorIs(t, err, auth.ErrSAMLEmailMissing)
+}
+
+func TestParseIdPMetadata(t *testing.T) {
+	idp := tests.NewFakeIdentityProvider(t)
+	cfg := idp.Config(uuid.New())
+
+	metadata, err := auth.ParseIdPMetadata(idp.MetadataXML(t))
+	require.NoError(t, err)
+	require.Equal(t, cfg.IdPEntityID, metadata.EntityID)
+	require.Equal(t, cfg.IdPSSOURL, metadata.SSOURL)
+
+	certificate, err := auth.ParseIdPCertificate(metadata.Certificate)
+	require.NoError(t, err)
+	expected, err := auth.ParseIdPCertificate(cfg.IdPCertificate)
+	require.NoError(t, err)
+	require.True(t, expected.Equal(certificate))
+
+	_, err = auth.ParseIdPMetadata([]byte("<EntityDescriptor/>"))
+	require.ErrorIs(t, err, auth.ErrInvalidIdPMetadata)
+}
@@ -0,0 +1,9 @@
+package auth
+
+// SCIMTokenPrefix identifies a bearer token as a SCIM token.
+const SCIMTokenPrefix = "scim_"
+
+// NewSCIMToken generates a random token for a company's identity provider to authenticate SCIM requests.
+func NewSCIMToken() (token, hash, displayPrefix string, err error) {
+	return newToken(SCIMTokenPrefix)
+}
@@ -9,6 +9,7 @@ import (
 
 	"advancely/internal/application"
 	"advancely/internal/model"
+	"advancely/internal/model/security"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
@@ -19,6 +20,14 @@ import (
 const (
 	SessionCookieStoreName       = "session"
 	SessionCookieSessionValueKey = "session"
+
+	// TokenTypePersonalAccessToken is the TokenType of sessions authenticated with a personal access token.
+	TokenTypePersonalAccessToken = "personal_access_token"
+
+	// MFAStateChallengeRequired is the MFAState of a session awaiting a TOTP or recovery code.
+	MFAStateChallengeRequired = "mfa_required"
+	// MFAStateEnrollmentRequired is the MFAState of a session that must enroll a TOTP factor before use.
+	MFAStateEnrollmentRequired = "mfa_enrollment_required"
 )
 
 var ErrorCookieNotFound = errors.New("cookie not found")
@@ -62,6 +71,12 @@ type SessionCookie struct {
 	ExpiresAt    int64                 `json:"expires_at"`
 	Company      *SessionCookieCompany `json:"company"`
 	User         *SessionCookieUser    `json:"user"`
+	// Scope limits the permissions of the session; a nil Scope does not limit the session.
+	// Only sessions authenticated with a scoped personal access token have a Scope.
+	Scope []security.Permission `json:"-"`
+	// MFAState is set while the session is waiting for the user to complete multi-factor authentication.
+	// Sessions with an MFAState are not considered logged in.
+	MFAState string `json:"mfaState,omitempty"`
 }
 
 func (s *SessionCookie) SetCookie(c echo.Context, secret string, env application.Environment) error {
@@ -100,6 +115,29 @@ func (s *SessionCookie) Expired() bool {
 	return currentTime.After(expirationTime)
 }
 
+// IsPersonalAccessToken returns true if the session was authenticated with a personal access token.
+func (s *SessionCookie) IsPersonalAccessToken() bool {
+	return s.TokenType == TokenTypePersonalAccessToken
+}
+
+// MFAPending returns true if the user must complete multi-factor authentication before the session can be used.
+func (s *SessionCookie) MFAPending() bool {
+	return s.MFAState != ""
+}
+
+// InScope returns true if the session Scope allows the given permission.
+func (s *SessionCookie) InScope(permission security.Permission) bool {
+	if s.Scope == nil {
+		return true
+	}
+	for _, p := range s.Scope {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *SessionCookie) SetUser(u model.UserProfile) {
 	s.User = &SessionCookieUser{
 		ID:        u.ID,
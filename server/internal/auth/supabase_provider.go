@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"advancely/pkg/sbext"
+
+	"github.com/google/uuid"
+	"github.com/supabase-community/gotrue-go"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+// SupabaseProvider is a Provider backed by the Supabase auth server.
+type SupabaseProvider struct {
+	client *sbext.SupabaseExtended
+	// serviceRoleSecret authorizes calls to the admin API.
+	serviceRoleSecret string
+	// transport makes the requests to the auth server.
+	transport http.RoundTripper
+}
+
+func NewSupabaseProvider(client *sbext.SupabaseExtended, serviceRoleSecret string) *SupabaseProvider {
+	return &SupabaseProvider{
+		client:            client,
+		serviceRoleSecret: serviceRoleSecret,
+		transport:         http.DefaultTransport,
+	}
+}
+
+// WithTransport sets the transport of the requests to the auth server, such as one recording traces.
+func (p *SupabaseProvider) WithTransport(transport http.RoundTripper) *SupabaseProvider {
+	p.transport = transport
+	return p
+}
+
+// auth returns the auth client making its requests with the context.
+// The gotrue-go client creates requests without a context, so the context is set by the transport.
+func (p *SupabaseProvider) auth(ctx context.Context) gotrue.Client {
+	return p.client.Auth.WithClient(http.Client{Transport: contextTransport{ctx: ctx, base: p.transport}})
+}
+
+// contextTransport makes requests with its context rather than the context of the request.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+func (p *SupabaseProvider) SignInWithEmailPassword(ctx context.Context, email, password string) (*types.Session, error) {
+	resp, err := p.auth(ctx).SignInWithEmailPassword(email, password)
+	if err != nil {
+		return nil, wrapSupabaseError(err)
+	}
+	return &resp.Session, nil
+}
+
+func (p *SupabaseProvider) SignUp(ctx context.Context, req types.SignupRequest) (*types.User, error) {
+	resp, err := p.auth(ctx).Signup(req)
+	if err != nil {
+		return nil, wrapSupabaseError(err)
+	}
+	// Signup returns either different response depending on the autoconfirm setting.
+	// User if autoconfirm is off, Session if on.
+	if resp.User.ID == uuid.Nil {
+		return &resp.Session.User, nil
+	}
+	return &resp.User, nil
+}
+
+func (p *SupabaseProvider) SendOTP(ctx context.Context, req types.OTPRequest) error {
+	return wrapSupabaseError(p.auth(ctx).OTP(req))
+}
+
+func (p *SupabaseProvider) VerifyOTP(ctx context.Context, req types.VerifyForUserRequest) (*types.Session, error) {
+	resp, err := p.auth(ctx).VerifyForUser(req)
+	if err == nil {
+		return &resp.Session, nil
+	}
+
+	// The response from the above Supabase method seems to come in the error as JSON.
+	// First we attempt to parse a success response from the error.
+	if session, parseErr := sbext.ParseSessionFromErrJson(err); parseErr == nil {
+		return session, nil
+	}
+	return nil, wrapSupabaseError(err)
+}
+
+func (p *SupabaseProvider) RefreshSession(ctx context.Context, refreshToken string) (*types.Session, error) {
+	resp, err := p.auth(ctx).RefreshToken(refreshToken)
+	if err != nil {
+		return nil, wrapSupabaseError(err)
+	}
+	return &resp.Session, nil
+}
+
+func (p *SupabaseProvider) Logout(ctx context.Context, accessToken string) error {
+	return wrapSupabaseError(p.auth(ctx).WithToken(accessToken).Logout())
+}
+
+func (p *SupabaseProvider) ResetPasswordForEmail(ctx context.Context, email, redirectTo string) error {
+	return wrapSupabaseError(p.client.Extensions.ResetPasswordForEmail(ctx, email, redirectTo))
+}
+
+func (p *SupabaseProvider) UpdatePassword(ctx context.Context, accessToken, password string) error {
+	_, err := p.auth(ctx).WithToken(accessToken).UpdateUser(types.UpdateUserRequest{
+		Password: &password,
+	})
+	return wrapSupabaseError(err)
+}
+
+func (p *SupabaseProvider) GetUser(ctx context.Context, accessToken string) (*types.User, error) {
+	resp, err := p.auth(ctx).WithToken(accessToken).GetUser()
+	if err != nil {
+		return nil, wrapSupabaseError(err)
+	}
+	return &resp.User, nil
+}
+
+func (p *SupabaseProvider) OAuthURL(provider types.Provider, redirectTo, codeChallenge string) string {
+	// Azure only returns the user's email address if the email scope is requested.
+	scopes := ""
+	if provider == types.ProviderAzure {
+		scopes = "email"
+	}
+	return p.client.Extensions.AuthorizeURL(string(provider), scopes, redirectTo, codeChallenge)
+}
+
+func (p *SupabaseProvider) ExchangeCodeForSession(ctx context.Context, code, codeVerifier string) (*types.Session, error) {
+	resp, err := p.auth(ctx).Token(types.TokenRequest{
+		GrantType:    "pkce",
+		Code:         code,
+		CodeVerifier: codeVerifier,
+	})
+	if err != nil {
+		return nil, wrapSupabaseError(err)
+	}
+	return &resp.Session, nil
+}
+
+// SignInWithVerifiedEmail generates a magic link for the user with the admin API, which also creates the user
+// if they do not exist, and immediately verifies its one-time password to obtain a session.
+// No email is sent to the user.
+func (p *SupabaseProvider) SignInWithVerifiedEmail(
+	ctx context.Context,
+	email string,
+	metadata map[string]interface{},
+) (*types.Session, error) {
+	link, err := p.auth(ctx).WithToken(p.serviceRoleSecret).AdminGenerateLink(types.AdminGenerateLinkRequest{
+		Type:  types.LinkTypeMagicLink,
+		Email: email,
+		Data:  metadata,
+	})
+	if err != nil {
+		return nil, wrapSupabaseError(err)
+	}
+	return p.VerifyOTP(ctx, types.VerifyForUserRequest{
+		Type:  types.VerificationTypeMagiclink,
+		Email: email,
+		Token: link.EmailOTP,
+	})
+}
+
+func (p *SupabaseProvider) CreateUser(
+	ctx context.Context,
+	email string,
+	metadata map[string]interface{},
+) (*types.User, error) {
+	resp, err := p.auth(ctx).WithToken(p.serviceRoleSecret).AdminCreateUser(types.AdminCreateUserRequest{
+		Email:        email,
+		EmailConfirm: true,
+		UserMetadata: metadata,
+	})
+	if err != nil {
+		return nil, wrapSupabaseError(err)
+	}
+	return &resp.User, nil
+}
+
+func (p *SupabaseProvider) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	return wrapSupabaseError(p.auth(ctx).WithToken(p.serviceRoleSecret).AdminDeleteUser(types.AdminDeleteUserRequest{
+		UserID: id,
+	}))
+}
+
+// banDuration is how long banned users are banned for. Bans are lifted explicitly rather than expiring.
+const banDuration = 100 * 365 * 24 * time.Hour
+
+func (p *SupabaseProvider) SetUserBanned(ctx context.Context, id uuid.UUID, banned bool) error {
+	duration := types.BanDurationNone()
+	if banned {
+		duration = types.BanDurationTime(banDuration)
+	}
+	_, err := p.auth(ctx).WithToken(p.serviceRoleSecret).AdminUpdateUser(types.AdminUpdateUserRequest{
+		UserID:      id,
+		BanDuration: &duration,
+	})
+	return wrapSupabaseError(err)
+}
+
+// wrapSupabaseError converts errors returned from Supabase into an *Error where possible.
+// Errors that cannot be parsed are returned unchanged.
+func wrapSupabaseError(err error) error {
+	if err == nil {
+		return nil
+	}
+	sbErr, ok := sbext.NewError(err)
+	if !ok {
+		return err
+	}
+	return &Error{
+		Status:  sbErr.Code,
+		Code:    sbErr.ErrorCode,
+		Message: sbErr.Message,
+	}
+}
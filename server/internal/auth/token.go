@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// tokenDisplayLength is the number of leading characters of a token stored to help users identify it.
+const tokenDisplayLength = 12
+
+// newToken generates a random token starting with the prefix identifying its kind.
+// The token, its hash and a short display prefix are returned; only the hash and prefix should be persisted.
+func newToken(prefix string) (token, hash, displayPrefix string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	token = prefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), token[:tokenDisplayLength], nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of a generated token, which is how tokens are looked up.
+// Tokens are high entropy, so a fast hash is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"advancely/internal/application"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	jwksPath        = "/auth/v1/.well-known/jwks.json"
+	bearerPrefix    = "Bearer "
+	defaultAudience = "authenticated"
+)
+
+var (
+	ErrInvalidToken     = errors.New("invalid access token")
+	ErrNoBearerToken    = errors.New("no bearer token found")
+	ErrInvalidSubject   = errors.New("access token subject is not a valid user ID")
+	ErrUnsupportedKeyID = errors.New("access token key ID is not recognised")
+)
+
+// Claims represents the claims of a Supabase access token.
+type Claims struct {
+	jwt.RegisteredClaims
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	SessionID string `json:"session_id"`
+}
+
+// UserID returns the subject of the token parsed as a user ID.
+func (c *Claims) UserID() (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Subject)
+	if err != nil {
+		return uuid.Nil, ErrInvalidSubject
+	}
+	return id, nil
+}
+
+// Verifier validates access tokens issued by the auth server.
+type Verifier interface {
+	// Verify checks the signature, expiry, audience and subject of the token, returning its claims.
+	Verify(ctx context.Context, token string) (*Claims, error)
+}
+
+// NewVerifier creates a Verifier for Supabase access tokens.
+// If a JWT secret is configured, tokens are verified using HS256 with the secret,
+// otherwise the signing keys are fetched from the JWKS published by the auth server.
+func NewVerifier(config application.SupabaseConfig) Verifier {
+	audience := config.JWTAudience
+	if audience == "" {
+		audience = defaultAudience
+	}
+	if config.JWTSecret != "" {
+		return NewHMACVerifier([]byte(config.JWTSecret), audience)
+	}
+	return NewJWKSVerifier(NewJWKSCache(config.URL+jwksPath, http.DefaultClient), audience)
+}
+
+// HMACVerifier verifies HS256 tokens signed with a shared secret.
+type HMACVerifier struct {
+	secret   []byte
+	audience string
+}
+
+func NewHMACVerifier(secret []byte, audience string) *HMACVerifier {
+	return &HMACVerifier{
+		secret:   secret,
+		audience: audience,
+	}
+}
+
+func (v *HMACVerifier) Verify(_ context.Context, token string) (*Claims, error) {
+	return parseClaims(token, v.audience, []string{jwt.SigningMethodHS256.Alg()}, func(*jwt.Token) (any, error) {
+		return v.secret, nil
+	})
+}
+
+// JWKSVerifier verifies asymmetrically signed tokens using keys from a JWKS.
+type JWKSVerifier struct {
+	keys     *JWKSCache
+	audience string
+}
+
+func NewJWKSVerifier(keys *JWKSCache, audience string) *JWKSVerifier {
+	return &JWKSVerifier{
+		keys:     keys,
+		audience: audience,
+	}
+}
+
+func (v *JWKSVerifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	methods := []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}
+	return parseClaims(token, v.audience, methods, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.Key(ctx, kid)
+	})
+}
+
+// parseClaims parses and validates the token, ensuring the exp, aud and sub claims are present and valid.
+func parseClaims(token, audience string, methods []string, keyFunc jwt.Keyfunc) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, keyFunc,
+		jwt.WithValidMethods(methods),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if _, err := claims.UserID(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return &claims, nil
+}
+
+// BearerToken returns the token from an "Authorization: Bearer <token>" header on the request.
+func BearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return "", ErrNoBearerToken
+	}
+	return strings.TrimSpace(header[len(bearerPrefix):]), nil
+}
@@ -0,0 +1,251 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"advancely/internal/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "super-secret-jwt-token-with-at-least-32-characters"
+
+func newClaims(subject string, audience string, expiresAt time.Time) auth.Claims {
+	return auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Email: "user@email.com",
+	}
+}
+
+func signHS256(t *testing.T, claims jwt.Claims, secret string) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return token
+}
+
+func TestHMACVerifier(t *testing.T) {
+	userID := uuid.New()
+	inAnHour := time.Now().Add(time.Hour)
+
+	testCases := []struct {
+		name      string
+		token     string
+		expectErr bool
+	}{
+		{
+			name:      "valid token",
+			token:     signHS256(t, newClaims(userID.String(), "authenticated", inAnHour), testSecret),
+			expectErr: false,
+		},
+		{
+			name:      "expired token",
+			token:     signHS256(t, newClaims(userID.String(), "authenticated", time.Now().Add(-time.Hour)), testSecret),
+			expectErr: true,
+		},
+		{
+			name:      "wrong audience",
+			token:     signHS256(t, newClaims(userID.String(), "anon", inAnHour), testSecret),
+			expectErr: true,
+		},
+		{
+			name:      "missing subject",
+			token:     signHS256(t, newClaims("", "authenticated", inAnHour), testSecret),
+			expectErr: true,
+		},
+		{
+			name:      "subject is not a user ID",
+			token:     signHS256(t, newClaims("service_role", "authenticated", inAnHour), testSecret),
+			expectErr: true,
+		},
+		{
+			name: "missing expiry",
+			token: signHS256(t, auth.Claims{RegisteredClaims: jwt.RegisteredClaims{
+				Subject:  userID.String(),
+				Audience: jwt.ClaimStrings{"authenticated"},
+			}}, testSecret),
+			expectErr: true,
+		},
+		{
+			name:      "wrong secret",
+			token:     signHS256(t, newClaims(userID.String(), "authenticated", inAnHour), "another-secret"),
+			expectErr: true,
+		},
+		{
+			name:      "not a JWT",
+			token:     "not-a-jwt",
+			expectErr: true,
+		},
+	}
+
+	verifier := auth.NewHMACVerifier([]byte(testSecret), "authenticated")
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := verifier.Verify(context.Background(), tc.token)
+			if tc.expectErr {
+				require.ErrorIs(t, err, auth.ErrInvalidToken)
+				return
+			}
+			require.NoError(t, err)
+			id, err := claims.UserID()
+			require.NoError(t, err)
+			require.Equal(t, userID, id)
+		})
+	}
+}
+
+func TestJWKSVerifier(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": "key-1",
+				"alg": "ES256",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+				"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	sign := func(kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, newClaims(uuid.NewString(), "authenticated", time.Now().Add(time.Hour)))
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		require.NoError(t, err)
+		return s
+	}
+
+	verifier := auth.NewJWKSVerifier(auth.NewJWKSCache(server.URL, server.Client()), "authenticated")
+
+	_, err = verifier.Verify(context.Background(), sign("key-1"))
+	require.NoError(t, err)
+	_, err = verifier.Verify(context.Background(), sign("key-1"))
+	require.NoError(t, err)
+	require.Equal(t, 1, requests, "expected the JWKS to be cached")
+
+	_, err = verifier.Verify(context.Background(), sign("unknown-key"))
+	require.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	// An HS256 token must not be accepted by a verifier expecting asymmetric keys.
+	_, err = verifier.Verify(context.Background(), signHS256(t, newClaims(uuid.NewString(), "authenticated", time.Now().Add(time.Hour)), testSecret))
+	require.ErrorIs(t, err, auth.ErrInvalidToken)
+}
+
+func TestJWKSCacheServesCachedKeysWhenRefreshFails(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": "key-1",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+				"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	cache := auth.NewJWKSCache(server.URL, server.Client())
+	cache.TTL = 0
+	cache.MinRefreshInterval = 0
+	ctx := context.Background()
+
+	_, err = cache.Key(ctx, "key-1")
+	require.NoError(t, err)
+
+	failing.Store(true)
+	cached, err := cache.Key(ctx, "key-1")
+	require.NoError(t, err)
+	require.Equal(t, &key.PublicKey, cached)
+
+	_, err = cache.Key(ctx, "unknown-key")
+	require.Error(t, err)
+}
+
+func TestJWKSCacheFetchesOnceForConcurrentRequests(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []any{}})
+	}))
+	defer server.Close()
+
+	cache := auth.NewJWKSCache(server.URL, server.Client())
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.Key(context.Background(), "key-1")
+			require.ErrorIs(t, err, auth.ErrUnsupportedKeyID)
+		}()
+	}
+	require.Eventually(t, func() bool { return requests.Load() == 1 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	require.Equal(t, int32(1), requests.Load())
+}
+
+func TestBearerToken(t *testing.T) {
+	testCases := []struct {
+		name          string
+		header        string
+		expectedToken string
+		expectErr     bool
+	}{
+		{"bearer token", "Bearer abc.def.ghi", "abc.def.ghi", false},
+		{"lowercase scheme", "bearer abc.def.ghi", "abc.def.ghi", false},
+		{"no header", "", "", true},
+		{"basic auth", "Basic dXNlcjpwYXNz", "", true},
+		{"empty token", "Bearer ", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			token, err := auth.BearerToken(req)
+			if tc.expectErr {
+				require.ErrorIs(t, err, auth.ErrNoBearerToken)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedToken, token)
+		})
+	}
+}
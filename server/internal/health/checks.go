@@ -0,0 +1,49 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"advancely/pkg/migrator"
+)
+
+// DatabaseCheck checks the database can be reached.
+func DatabaseCheck(db *sql.DB) Check {
+	return Check{Name: "database", Check: db.PingContext}
+}
+
+// MigrationsCheck checks the database has been migrated to the version of the newest migration of the source,
+// which is read once. Requests may fail against a database missing migrations, such as while another instance
+// is still migrating it.
+func MigrationsCheck(db *sql.DB, src migrator.Source) Check {
+	latestVersion := sync.OnceValues(func() (uint, error) {
+		return migrator.LatestVersion(src)
+	})
+	return Check{
+		Name: "migrations",
+		Check: func(ctx context.Context) error {
+			expected, err := latestVersion()
+			if err != nil {
+				return err
+			}
+			version, dirty, err := migrator.DatabaseVersion(ctx, db)
+			if err != nil {
+				return err
+			}
+			if dirty {
+				return fmt.Errorf("database migration to version %d failed", version)
+			}
+			if version != int(expected) {
+				return fmt.Errorf("database is at version %d, expected %d", version, expected)
+			}
+			return nil
+		},
+	}
+}
+
+// AuthCheck checks the auth provider can be reached with ping.
+func AuthCheck(ping func(ctx context.Context) error) Check {
+	return Check{Name: "auth", Check: ping}
+}
@@ -0,0 +1,159 @@
+// Package health serves the liveness and readiness probes of the API.
+package health
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"advancely/internal/logging"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Statuses of the API and of its checks.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+	// StatusDraining is reported once the API has started shutting down, so no new requests are sent to it.
+	StatusDraining = "draining"
+	StatusFailed   = "failed"
+	StatusTimeout  = "timeout"
+)
+
+// Paths of the probes, which are served outside the /api/v1 prefix.
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// DefaultTimeout is the time each readiness check has to complete.
+const DefaultTimeout = 2 * time.Second
+
+// Check is a dependency the API needs to serve requests, such as the database.
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of a check.
+type CheckResult struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// Response is the body of the probe responses.
+type Response struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+// Checker runs the readiness checks of the API.
+type Checker struct {
+	Checks  []Check
+	Timeout time.Duration
+	Logger  *slog.Logger
+
+	draining atomic.Bool
+}
+
+func NewChecker(logger *slog.Logger, checks ...Check) *Checker {
+	return &Checker{
+		Checks:  checks,
+		Timeout: DefaultTimeout,
+		Logger:  logger,
+	}
+}
+
+// Drain marks the API as shutting down, failing readiness from then on.
+func (h *Checker) Drain() {
+	h.draining.Store(true)
+}
+
+// Draining returns true once Drain has been called.
+func (h *Checker) Draining() bool {
+	return h.draining.Load()
+}
+
+// Run runs the checks concurrently, returning their results in the order of the checks
+// and whether they all passed.
+func (h *Checker) Run(ctx context.Context) ([]CheckResult, bool) {
+	results := make([]CheckResult, len(h.Checks))
+	errs := make([]error, len(h.Checks))
+
+	var wg sync.WaitGroup
+	for i, check := range h.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, h.Timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Check(checkCtx)
+			results[i] = CheckResult{
+				Name:       check.Name,
+				Status:     StatusOK,
+				DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				results[i].Status = StatusFailed
+				if errors.Is(err, context.DeadlineExceeded) || errors.Is(checkCtx.Err(), context.DeadlineExceeded) {
+					results[i].Status = StatusTimeout
+				}
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	ok := true
+	for i, err := range errs {
+		if err != nil {
+			ok = false
+			// Errors are only logged, as they may contain internal details such as the address of the database.
+			logging.FromContext(ctx, h.Logger).Warn("readiness check failed", "check", results[i].Name, "error", err)
+		}
+	}
+	return results, ok
+}
+
+// MakeRoutes registers the probes on the group, which should not have a prefix.
+func (h *Checker) MakeRoutes(e *echo.Group) {
+	e.GET(LivenessPath, h.Liveness)
+	e.GET(ReadinessPath, h.Readiness)
+}
+
+// Liveness reports that the process is serving requests. It does not check dependencies,
+// so an unavailable database does not cause the API to be restarted.
+func (h *Checker) Liveness(c echo.Context) error {
+	return c.JSON(http.StatusOK, Response{Status: StatusOK})
+}
+
+// Readiness reports whether the API can serve requests, responding with 503 Service Unavailable
+// if any check fails or the API is draining.
+func (h *Checker) Readiness(c echo.Context) error {
+	if h.Draining() {
+		return c.JSON(http.StatusServiceUnavailable, Response{Status: StatusDraining})
+	}
+
+	results, ok := h.Run(c.Request().Context())
+	if !ok {
+		return c.JSON(http.StatusServiceUnavailable, Response{Status: StatusUnavailable, Checks: results})
+	}
+	return c.JSON(http.StatusOK, Response{Status: StatusOK, Checks: results})
+}
+
+// IsProbe returns true if the request is for one of the probes, which are excluded from access logs and traces.
+func IsProbe(c echo.Context) bool {
+	switch c.Path() {
+	case LivenessPath, ReadinessPath:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"advancely/internal/health"
+	"advancely/internal/tests"
+
+	"github.com/stretchr/testify/require"
+)
+
+func okCheck(name string) health.Check {
+	return health.Check{Name: name, Check: func(context.Context) error { return nil }}
+}
+
+// serveProbe serves a request for the probe at the path, returning the status and decoded body.
+func serveProbe(t *testing.T, checker *health.Checker, path string) (int, health.Response) {
+	t.Helper()
+	e := tests.NewEchoInstance()
+	checker.MakeRoutes(e.Group(""))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var resp health.Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return rec.Code, resp
+}
+
+func TestReadiness(t *testing.T) {
+	checker := health.NewChecker(tests.NewDefaultLogger(), okCheck("database"), okCheck("auth"))
+
+	status, resp := serveProbe(t, checker, health.ReadinessPath)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, health.StatusOK, resp.Status)
+	require.Len(t, resp.Checks, 2)
+	require.Equal(t, "database", resp.Checks[0].Name)
+	require.Equal(t, health.StatusOK, resp.Checks[0].Status)
+	require.Equal(t, "auth", resp.Checks[1].Name)
+}
+
+func TestReadinessFailedChecks(t *testing.T) {
+	checker := health.NewChecker(tests.NewDefaultLogger(),
+		okCheck("database"),
+		health.Check{Name: "migrations", Check: func(context.Context) error {
+			return errors.New("database is at version 12, expected 13")
+		}},
+		health.Check{Name: "auth", Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	)
+	checker.Timeout = 10 * time.Millisecond
+
+	status, resp := serveProbe(t, checker, health.ReadinessPath)
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, health.StatusUnavailable, resp.Status)
+	require.Equal(t, health.StatusOK, resp.Checks[0].Status)
+	require.Equal(t, health.StatusFailed, resp.Checks[1].Status)
+	require.Equal(t, health.StatusTimeout, resp.Checks[2].Status)
+	require.GreaterOrEqual(t, resp.Checks[2].DurationMs, float64(10))
+}
+
+func TestReadinessWhileDraining(t *testing.T) {
+	checker := health.NewChecker(tests.NewDefaultLogger(), okCheck("database"))
+	checker.Drain()
+
+	status, resp := serveProbe(t, checker, health.ReadinessPath)
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, health.StatusDraining, resp.Status)
+	require.Empty(t, resp.Checks)
+
+	// The process is still alive while it drains.
+	status, resp = serveProbe(t, checker, health.LivenessPath)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, health.StatusOK, resp.Status)
+}
@@ -0,0 +1,73 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"advancely/internal/auth"
+	"advancely/internal/model"
+	"advancely/internal/store"
+)
+
+// CompanyPurger permanently deletes the companies whose deletion grace period has ended,
+// along with the users in the auth provider who belong to no other company.
+type CompanyPurger struct {
+	CompanyStore store.CompanyStore
+	AuthProvider auth.Provider
+	Logger       *slog.Logger
+	// Now returns the current time, which the purge time of the companies is compared to.
+	Now func() time.Time
+}
+
+func NewCompanyPurger(companyStore store.CompanyStore, authProvider auth.Provider, logger *slog.Logger) *CompanyPurger {
+	return &CompanyPurger{
+		CompanyStore: companyStore,
+		AuthProvider: authProvider,
+		Logger:       logger,
+		Now:          time.Now,
+	}
+}
+
+// Job returns the job purging the companies at the interval.
+func (p *CompanyPurger) Job(interval time.Duration) Job {
+	return Job{
+		Name:     "purge-companies",
+		Interval: interval,
+		Run:      p.Purge,
+	}
+}
+
+// Purge deletes the companies due to be purged. A company whose users could not all be deleted is kept,
+// so it is purged again on the next run.
+func (p *CompanyPurger) Purge(ctx context.Context) error {
+	companies, err := p.CompanyStore.CompaniesToPurge(ctx, p.Now())
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, company := range companies {
+		if err := p.purge(ctx, company); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p.Logger.Info("purged company", "company_id", company.ID, "deleted_at", company.DeletedAt)
+	}
+	return errors.Join(errs...)
+}
+
+func (p *CompanyPurger) purge(ctx context.Context, company model.Company) error {
+	userIDs, err := p.CompanyStore.CompanyUserIDs(ctx, company.ID)
+	if err != nil {
+		return err
+	}
+	for _, id := range userIDs {
+		if err := deleteAuthUser(ctx, p.AuthProvider, id); err != nil {
+			return fmt.Errorf("failed to delete user %s of company %s: %w", id, company.ID, err)
+		}
+	}
+	return p.CompanyStore.DeleteCompany(ctx, company.ID)
+}
@@ -0,0 +1,59 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"advancely/internal/jobs"
+	"advancely/internal/store"
+	"advancely/internal/tests"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompanyPurgerPurgesCompaniesAfterGracePeriod(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	provider := tests.NewFakeAuthProvider().WithDatabase(db)
+	companyStore := store.NewPostgresCompanyStore(db)
+	userStore := store.NewPostgresUserStore(db)
+	ctx := context.Background()
+
+	owner := tests.CreateAuthUser(t, provider, db, "owner@purge.test")
+	purgedID := tests.CreateTestCompany(t, db, owner.ID)
+
+	other := tests.CreateAuthUser(t, provider, db, "other@purge.test")
+	keptID := tests.CreateTestCompany(t, db, other.ID)
+
+	// Users who are members of another company keep their account.
+	consultant := tests.CreateAuthUser(t, provider, db, "consultant@purge.test")
+	tests.AddAdminToCompany(t, db, consultant.ID, purgedID)
+	tests.AddAdminToCompany(t, db, consultant.ID, keptID)
+
+	now := time.Now()
+	_, err := companyStore.ScheduleCompanyDeletion(ctx, purgedID, now.Add(-time.Minute))
+	require.NoError(t, err)
+	// The grace period of the other company has not ended.
+	_, err = companyStore.ScheduleCompanyDeletion(ctx, keptID, now.Add(time.Hour))
+	require.NoError(t, err)
+
+	purger := jobs.NewCompanyPurger(companyStore, provider, tests.NewDefaultLogger())
+	purger.Now = func() time.Time { return now }
+	require.NoError(t, purger.Purge(ctx))
+
+	_, err = companyStore.Company(ctx, purgedID)
+	require.ErrorIs(t, err, store.ErrCompanyNotFound)
+	_, err = userStore.User(ctx, owner.ID, purgedID)
+	require.ErrorIs(t, err, store.ErrUserNotFound)
+	require.Equal(t, []uuid.UUID{owner.ID}, provider.DeletedUsers)
+	_, err = userStore.User(ctx, consultant.ID, keptID)
+	require.NoError(t, err)
+
+	kept, err := companyStore.Company(ctx, keptID)
+	require.NoError(t, err)
+	require.True(t, kept.Deleted())
+
+	// Purging again has nothing to do.
+	require.NoError(t, purger.Purge(ctx))
+}
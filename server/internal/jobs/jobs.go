@@ -0,0 +1,116 @@
+// Package jobs runs periodic background work of the API, such as purging deleted data.
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"advancely/internal/auth"
+
+	"github.com/google/uuid"
+)
+
+// Job is work run periodically in the background. Jobs run on every replica of the API,
+// so they must be safe to run concurrently.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Runner runs jobs at their interval until it is stopped. A job is first run when the runner starts.
+type Runner struct {
+	Logger *slog.Logger
+
+	jobs   []Job
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewRunner(logger *slog.Logger) *Runner {
+	return &Runner{
+		Logger: logger,
+	}
+}
+
+// Add adds a job started by Start.
+func (r *Runner) Add(job Job) *Runner {
+	r.jobs = append(r.jobs, job)
+	return r
+}
+
+// Start runs every job in its own goroutine until Stop is called.
+func (r *Runner) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	for _, job := range r.jobs {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.loop(ctx, job)
+		}()
+	}
+}
+
+// Stop cancels the running jobs and waits for them to return until the context is done.
+func (r *Runner) Stop(ctx context.Context) error {
+	if r.cancel == nil {
+		return nil
+	}
+	r.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Runner) loop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		r.run(ctx, job)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) run(ctx context.Context, job Job) {
+	logger := r.Logger.With("job", job.Name)
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		// Cancelled runs are retried by the next replica or start.
+		if ctx.Err() != nil {
+			logger.Info("job cancelled", "error", err)
+			return
+		}
+		logger.Error("job failed", "error", err, "duration", time.Since(start))
+		return
+	}
+	logger.Debug("job completed", "duration", time.Since(start))
+}
+
+// deleteAuthUser deletes the user from the auth provider. Users already deleted by a previous run,
+// or by another replica, are skipped.
+func deleteAuthUser(ctx context.Context, provider auth.Provider, id uuid.UUID) error {
+	if err := provider.DeleteUser(ctx, id); err != nil {
+		if authErr, ok := auth.AsError(err); ok && authErr.Status == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
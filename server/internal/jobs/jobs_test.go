@@ -0,0 +1,56 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"advancely/internal/jobs"
+	"advancely/internal/tests"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunnerRunsJobsUntilStopped(t *testing.T) {
+	var runs atomic.Int32
+	runner := jobs.NewRunner(tests.NewDefaultLogger()).Add(jobs.Job{
+		Name:     "count",
+		Interval: 10 * time.Millisecond,
+		Run: func(context.Context) error {
+			runs.Add(1)
+			return errors.New("failures do not stop the job")
+		},
+	})
+	runner.Start()
+
+	require.Eventually(t, func() bool { return runs.Load() >= 3 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, runner.Stop(context.Background()))
+
+	stopped := runs.Load()
+	time.Sleep(30 * time.Millisecond)
+	require.Equal(t, stopped, runs.Load())
+}
+
+func TestRunnerStopWaitsForRunningJob(t *testing.T) {
+	started := make(chan struct{})
+	runner := jobs.NewRunner(tests.NewDefaultLogger()).Add(jobs.Job{
+		Name:     "block",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			time.Sleep(20 * time.Millisecond)
+			return ctx.Err()
+		},
+	})
+	runner.Start()
+	<-started
+
+	// The job takes longer to return than the deadline of the first stop.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, runner.Stop(ctx), context.DeadlineExceeded)
+	require.NoError(t, runner.Stop(context.Background()))
+}
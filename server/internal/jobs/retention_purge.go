@@ -0,0 +1,87 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"advancely/internal/auth"
+	"advancely/internal/store"
+)
+
+// RetentionPurger permanently deletes the profiles and roles deleted longer ago than the retention period.
+// Users whose last profile is purged are deleted from the auth provider.
+type RetentionPurger struct {
+	UserStore        store.UserStore
+	PermissionsStore store.PermissionsStore
+	AuthProvider     auth.Provider
+	Logger           *slog.Logger
+	// Retention is how long deleted profiles and roles are kept, during which they can be restored.
+	Retention time.Duration
+	// Now returns the current time, which the deletion time of the profiles and roles is compared to.
+	Now func() time.Time
+}
+
+func NewRetentionPurger(
+	userStore store.UserStore,
+	permissionsStore store.PermissionsStore,
+	authProvider auth.Provider,
+	retention time.Duration,
+	logger *slog.Logger) *RetentionPurger {
+	return &RetentionPurger{
+		UserStore:        userStore,
+		PermissionsStore: permissionsStore,
+		AuthProvider:     authProvider,
+		Logger:           logger,
+		Retention:        retention,
+		Now:              time.Now,
+	}
+}
+
+// Job returns the job purging the deleted profiles and roles at the interval.
+func (p *RetentionPurger) Job(interval time.Duration) Job {
+	return Job{
+		Name:     "purge-deleted",
+		Interval: interval,
+		Run:      p.Purge,
+	}
+}
+
+// Purge deletes the profiles and roles past the retention period. A profile whose user could not
+// be deleted is kept, so it is purged again on the next run.
+func (p *RetentionPurger) Purge(ctx context.Context) error {
+	deletedBefore := p.Now().Add(-p.Retention)
+
+	profiles, err := p.UserStore.ProfilesToPurge(ctx, deletedBefore)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, profile := range profiles {
+		if err := p.purgeProfile(ctx, profile); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p.Logger.Info("purged profile", "user_id", profile.UserID, "company_id", profile.CompanyID, "deleted_at", profile.DeletedAt)
+	}
+
+	n, err := p.PermissionsStore.PurgeRoles(ctx, deletedBefore)
+	if err != nil {
+		errs = append(errs, err)
+	} else if n > 0 {
+		p.Logger.Info("purged roles", "count", n)
+	}
+	return errors.Join(errs...)
+}
+
+func (p *RetentionPurger) purgeProfile(ctx context.Context, profile store.ProfileToPurge) error {
+	if profile.LastProfile {
+		if err := deleteAuthUser(ctx, p.AuthProvider, profile.UserID); err != nil {
+			return fmt.Errorf("failed to delete user %s: %w", profile.UserID, err)
+		}
+	}
+	return p.UserStore.PurgeProfile(ctx, profile.UserID, profile.CompanyID)
+}
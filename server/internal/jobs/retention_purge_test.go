@@ -0,0 +1,79 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"advancely/internal/jobs"
+	"advancely/internal/model"
+	"advancely/internal/store"
+	"advancely/internal/tests"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionPurgerPurgesAfterRetentionPeriod(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	provider := tests.NewFakeAuthProvider().WithDatabase(db)
+	userStore := store.NewPostgresUserStore(db)
+	permissionsStore := store.NewPostgresPermissionsStore(db)
+	ctx := context.Background()
+
+	owner := tests.CreateAuthUser(t, provider, db, "owner@retention.test")
+	companyID := tests.CreateTestCompany(t, db, owner.ID)
+	other := tests.CreateAuthUser(t, provider, db, "other@retention.test")
+	otherCompanyID := tests.CreateTestCompany(t, db, other.ID)
+
+	member := tests.CreateAuthUser(t, provider, db, "member@retention.test")
+	tests.AddAdminToCompany(t, db, member.ID, companyID)
+	// Users who are members of another company keep their account.
+	consultant := tests.CreateAuthUser(t, provider, db, "consultant@retention.test")
+	tests.AddAdminToCompany(t, db, consultant.ID, companyID)
+	tests.AddAdminToCompany(t, db, consultant.ID, otherCompanyID)
+	// Users whose every profile is purged have their account deleted.
+	leaver := tests.CreateAuthUser(t, provider, db, "leaver@retention.test")
+	tests.AddAdminToCompany(t, db, leaver.ID, companyID)
+	tests.AddAdminToCompany(t, db, leaver.ID, otherCompanyID)
+	require.NoError(t, userStore.DeleteProfile(ctx, leaver.ID, otherCompanyID))
+	// Users deleted within the retention period are kept.
+	recent := tests.CreateAuthUser(t, provider, db, "recent@retention.test")
+	tests.AddAdminToCompany(t, db, recent.ID, companyID)
+
+	for _, id := range []uuid.UUID{member.ID, consultant.ID, leaver.ID, recent.ID} {
+		require.NoError(t, userStore.DeleteProfile(ctx, id, companyID))
+	}
+	purgedRole, err := permissionsStore.CreateRole(ctx, model.CreateRole{CompanyID: companyID, Name: "purged-role"})
+	require.NoError(t, err)
+	require.NoError(t, permissionsStore.DeleteRole(ctx, purgedRole.ID, companyID))
+	keptRole, err := permissionsStore.CreateRole(ctx, model.CreateRole{CompanyID: companyID, Name: "kept-role"})
+	require.NoError(t, err)
+	require.NoError(t, permissionsStore.DeleteRole(ctx, keptRole.ID, companyID))
+
+	_, err = db.Exec("update public.profiles set deleted_at = now() - interval '2 days' where id = any($1);",
+		pq.Array([]uuid.UUID{member.ID, consultant.ID, leaver.ID}))
+	require.NoError(t, err)
+	_, err = db.Exec("update security.roles set deleted_at = now() - interval '2 days' where id = $1;", purgedRole.ID)
+	require.NoError(t, err)
+
+	purger := jobs.NewRetentionPurger(userStore, permissionsStore, provider, 24*time.Hour, tests.NewDefaultLogger())
+	require.NoError(t, purger.Purge(ctx))
+
+	require.ElementsMatch(t, []uuid.UUID{member.ID, leaver.ID}, provider.DeletedUsers)
+	_, err = userStore.User(ctx, consultant.ID, otherCompanyID)
+	require.NoError(t, err)
+	_, err = userStore.RestoreProfile(ctx, consultant.ID, companyID)
+	require.ErrorIs(t, err, store.ErrUserNotFound)
+	_, err = userStore.RestoreProfile(ctx, recent.ID, companyID)
+	require.NoError(t, err)
+
+	_, err = permissionsStore.RestoreRole(ctx, purgedRole.ID, companyID)
+	require.ErrorIs(t, err, store.ErrRoleNotFound)
+	_, err = permissionsStore.RestoreRole(ctx, keptRole.ID, companyID)
+	require.NoError(t, err)
+
+	// Purging again has nothing to do.
+	require.NoError(t, purger.Purge(ctx))
+}
@@ -0,0 +1,152 @@
+// Package lifecycle runs the servers of the API until it receives a termination signal,
+// then shuts them down gracefully and releases the resources they use.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Server is a server run until shutdown, such as the API or the metrics endpoint.
+type Server struct {
+	Name string
+	// Serve blocks until the server fails or is shut down, in which case it returns http.ErrServerClosed.
+	Serve func() error
+	// Shutdown stops accepting connections and waits for in-flight requests until the context is done.
+	Shutdown func(ctx context.Context) error
+}
+
+// HTTPServer returns a Server running the standard library server.
+func HTTPServer(name string, server *http.Server) Server {
+	return Server{
+		Name:     name,
+		Serve:    server.ListenAndServe,
+		Shutdown: server.Shutdown,
+	}
+}
+
+type closer struct {
+	name  string
+	close func(ctx context.Context) error
+}
+
+// Manager starts the servers and, once the context is done or a termination signal is received, shuts them down:
+//
+//  1. The drain hooks are called, such as failing readiness so load balancers stop sending new requests.
+//  2. After DrainDelay, the servers are shut down in the order they were added, waiting up to GracePeriod
+//     for in-flight requests to complete.
+//  3. The closers are called in the order they were added, such as closing the database once no request uses it.
+type Manager struct {
+	Logger *slog.Logger
+	// GracePeriod is how long the servers have to shut down, shared by all servers.
+	GracePeriod time.Duration
+	// DrainDelay is how long to keep serving requests after the drain hooks are called,
+	// giving load balancers time to notice the API is no longer ready.
+	DrainDelay time.Duration
+	// Signals are the signals that start the shutdown.
+	Signals []os.Signal
+
+	servers []Server
+	drain   []func()
+	closers []closer
+}
+
+func NewManager(logger *slog.Logger, gracePeriod time.Duration) *Manager {
+	return &Manager{
+		Logger:      logger,
+		GracePeriod: gracePeriod,
+		Signals:     []os.Signal{os.Interrupt, syscall.SIGTERM},
+	}
+}
+
+// WithDrainDelay sets the time requests are still served after the drain hooks are called.
+func (m *Manager) WithDrainDelay(delay time.Duration) *Manager {
+	m.DrainDelay = delay
+	return m
+}
+
+// AddServer adds a server started by Run.
+func (m *Manager) AddServer(server Server) *Manager {
+	m.servers = append(m.servers, server)
+	return m
+}
+
+// OnDrain adds a hook called when shutdown starts, before the servers stop accepting connections.
+func (m *Manager) OnDrain(fn func()) *Manager {
+	m.drain = append(m.drain, fn)
+	return m
+}
+
+// AddCloser adds a resource released once the servers have shut down, such as a store or a background worker.
+func (m *Manager) AddCloser(name string, fn func(ctx context.Context) error) *Manager {
+	m.closers = append(m.closers, closer{name: name, close: fn})
+	return m
+}
+
+// Run starts the servers and blocks until they have been shut down and the closers have been called.
+// Shutdown starts when ctx is done, a signal is received or a server fails, in which case its error is returned.
+// A second signal received during shutdown terminates the process immediately.
+//
+// Errors shutting down are logged and joined in the returned error,
+// and the remaining servers and closers are still shut down.
+func (m *Manager) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, m.Signals...)
+	defer stop()
+
+	failed := make(chan error, len(m.servers))
+	for _, server := range m.servers {
+		go func() {
+			m.Logger.Info("starting server", "server", server.Name)
+			if err := server.Serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				failed <- fmt.Errorf("%s server failed: %w", server.Name, err)
+			}
+		}()
+	}
+
+	var errs []error
+	select {
+	case <-ctx.Done():
+		m.Logger.Info("shutting down", "grace_period", m.GracePeriod)
+	case err := <-failed:
+		m.Logger.Error("shutting down after server failure", "error", err)
+		errs = append(errs, err)
+	}
+	// Restore the default behavior of the signals, so a second signal terminates the process.
+	stop()
+
+	for _, fn := range m.drain {
+		fn()
+	}
+	if m.DrainDelay > 0 {
+		time.Sleep(m.DrainDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), m.GracePeriod)
+	defer cancel()
+	for _, server := range m.servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			m.Logger.Error("failed to shut down server", "server", server.Name, "error", err)
+			errs = append(errs, fmt.Errorf("failed to shut down %s server: %w", server.Name, err))
+		}
+	}
+
+	// Closers get their own deadline, so they still run if in-flight requests used the whole grace period.
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), m.GracePeriod)
+	defer cancelClose()
+	for _, c := range m.closers {
+		if err := c.close(closeCtx); err != nil {
+			m.Logger.Error("failed to close", "name", c.name, "error", err)
+			errs = append(errs, fmt.Errorf("failed to close %s: %w", c.name, err))
+		}
+	}
+
+	m.Logger.Info("shut down")
+	return errors.Join(errs...)
+}
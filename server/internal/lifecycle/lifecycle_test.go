@@ -0,0 +1,200 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"advancely/internal/lifecycle"
+	"advancely/internal/tests"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// slowServer is an echo server whose /slow route blocks until release is closed.
+type slowServer struct {
+	echo    *echo.Echo
+	url     string
+	started chan struct{}
+	release chan struct{}
+}
+
+func newSlowServer(t *testing.T) *slowServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &slowServer{
+		echo:    echo.New(),
+		url:     "http://" + listener.Addr().String(),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	s.echo.HideBanner = true
+	s.echo.HidePort = true
+	s.echo.Listener = listener
+	s.echo.GET("/slow", func(c echo.Context) error {
+		close(s.started)
+		<-s.release
+		return c.String(http.StatusOK, "done")
+	})
+	return s
+}
+
+func (s *slowServer) server() lifecycle.Server {
+	return lifecycle.Server{
+		Name: "api",
+		Serve: func() error {
+			return s.echo.Start("")
+		},
+		Shutdown: s.echo.Shutdown,
+	}
+}
+
+type response struct {
+	status int
+	body   string
+	err    error
+}
+
+// get requests the path in the background, sending the response once it completes.
+func get(url string) <-chan response {
+	responses := make(chan response, 1)
+	go func() {
+		res, err := http.Get(url)
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		responses <- response{status: res.StatusCode, body: string(body), err: err}
+	}()
+	return responses
+}
+
+func TestRunCompletesInFlightRequests(t *testing.T) {
+	s := newSlowServer(t)
+
+	var events []string
+	manager := lifecycle.NewManager(tests.NewDefaultLogger(), 5*time.Second).
+		AddServer(s.server()).
+		OnDrain(func() {
+			events = append(events, "drain")
+		}).
+		AddCloser("store", func(context.Context) error {
+			events = append(events, "store")
+			return nil
+		}).
+		AddCloser("worker", func(context.Context) error {
+			events = append(events, "worker")
+			return nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- manager.Run(ctx)
+	}()
+
+	responses := get(s.url + "/slow")
+	<-s.started
+	cancel()
+
+	// The request is still in flight, so the manager waits for it.
+	select {
+	case err := <-done:
+		t.Fatalf("manager returned with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(s.release)
+	res := <-responses
+	require.NoError(t, res.err)
+	require.Equal(t, http.StatusOK, res.status)
+	require.Equal(t, "done", res.body)
+
+	require.NoError(t, <-done)
+	require.Equal(t, []string{"drain", "store", "worker"}, events)
+
+	// New connections are refused once the server has shut down.
+	_, err := http.Get(s.url + "/slow")
+	require.Error(t, err)
+}
+
+func TestRunGracePeriodExceeded(t *testing.T) {
+	s := newSlowServer(t)
+	defer close(s.release)
+
+	closed := false
+	manager := lifecycle.NewManager(tests.NewDefaultLogger(), 50*time.Millisecond).
+		AddServer(s.server()).
+		AddCloser("store", func(context.Context) error {
+			closed = true
+			return nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- manager.Run(ctx)
+	}()
+
+	get(s.url + "/slow")
+	<-s.started
+	cancel()
+
+	err := <-done
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.True(t, closed, "closers run even if the grace period is exceeded")
+}
+
+func TestRunShutsDownOnSignal(t *testing.T) {
+	s := newSlowServer(t)
+	close(s.release)
+
+	drained := make(chan struct{})
+	manager := lifecycle.NewManager(tests.NewDefaultLogger(), time.Second).
+		AddServer(s.server()).
+		OnDrain(func() {
+			close(drained)
+		})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- manager.Run(context.Background())
+	}()
+
+	// The signals are handled once the server is serving requests.
+	res := <-get(s.url + "/slow")
+	require.NoError(t, res.err)
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+	<-drained
+	require.NoError(t, <-done)
+}
+
+func TestRunShutsDownWhenServerFails(t *testing.T) {
+	failure := errors.New("address already in use")
+	closed := false
+	manager := lifecycle.NewManager(tests.NewDefaultLogger(), time.Second).
+		AddServer(lifecycle.Server{
+			Name:     "api",
+			Serve:    func() error { return failure },
+			Shutdown: func(context.Context) error { return nil },
+		}).
+		AddCloser("store", func(context.Context) error {
+			closed = true
+			return nil
+		})
+
+	err := manager.Run(context.Background())
+	require.ErrorIs(t, err, failure)
+	require.True(t, closed)
+}
@@ -0,0 +1,66 @@
+// Package logging creates the application logger and carries request-scoped loggers in a context,
+// so that every log line written while handling a request can be tied back to it.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Format is the encoding of log records.
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// ParseFormat returns the format with the name, which is case-insensitive.
+// Returns false if the name is not a known format.
+func ParseFormat(name string) (Format, bool) {
+	switch f := Format(strings.ToLower(name)); f {
+	case FormatText, FormatJSON:
+		return f, true
+	}
+	return FormatText, false
+}
+
+// NewHandler returns a handler writing records at or above the level to w in the format.
+func NewHandler(w io.Writer, format Format, level slog.Leveler) slog.Handler {
+	opts := &slog.HandlerOptions{
+		AddSource: true,
+		Level:     level,
+	}
+	if format == FormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context carrying the logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by the context, or fallback if it carries none,
+// such as outside a request.
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// With adds the attributes to the logger carried by the context, returning the new context.
+// The context is returned unchanged if it carries no logger.
+func With(ctx context.Context, args ...any) context.Context {
+	logger, ok := ctx.Value(contextKey{}).(*slog.Logger)
+	if !ok {
+		return ctx
+	}
+	return NewContext(ctx, logger.With(args...))
+}
@@ -0,0 +1,186 @@
+// Package metrics collects the Prometheus metrics of the API, which are served on a separate listen address
+// so they are not exposed to the public.
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"advancely/internal/auth"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "advancely"
+
+// Outcomes of calls to external services.
+const (
+	OutcomeSuccess = "success"
+	// OutcomeRejected is the outcome of calls rejected by the service with a client error, such as invalid credentials.
+	OutcomeRejected = "rejected"
+	OutcomeError    = "error"
+)
+
+// Outcomes of permission checks.
+const (
+	PermissionAllowed = "allowed"
+	PermissionDenied  = "denied"
+	PermissionError   = "error"
+)
+
+// unmatchedRoute is the route label of requests not matching any route, which keeps the label bounded.
+const unmatchedRoute = "unmatched"
+
+// Metrics holds the collectors of the API. A nil *Metrics is valid and records nothing.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	httpRequestDuration     *prometheus.HistogramVec
+	externalRequestDuration *prometheus.HistogramVec
+	permissionChecks        *prometheus.CounterVec
+}
+
+// New creates the metrics and registers them, along with the Go runtime and process collectors, on a new registry.
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of HTTP requests by route template and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		externalRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "external_request_duration_seconds",
+			Help:      "Duration of calls to external services, such as the Supabase auth server.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"service", "operation", "outcome"}),
+		permissionChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "permission_checks_total",
+			Help:      "Permission checks by permission and outcome.",
+		}, []string{"permission", "outcome"}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequestDuration,
+		m.externalRequestDuration,
+		m.permissionChecks,
+	)
+	return m
+}
+
+// RegisterDB exports the connection pool stats of the database, such as the open and in use connections
+// and the number of connections waited for.
+func (m *Metrics) RegisterDB(name string, db *sql.DB) {
+	if m == nil {
+		return
+	}
+	m.Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler returns the handler serving the metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// Middleware observes the duration of requests by their route template rather than their path,
+// which would otherwise contain IDs.
+func (m *Metrics) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	if m == nil {
+		return next
+	}
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		route := c.Path()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		m.httpRequestDuration.
+			WithLabelValues(c.Request().Method, route, strconv.Itoa(responseStatus(c, err))).
+			Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// responseStatus returns the status of the response, which has not yet been written if the handler returned an error.
+func responseStatus(c echo.Context, err error) int {
+	if err == nil || c.Response().Committed {
+		return c.Response().Status
+	}
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// ObserveExternalCall records the duration of a call to the service started at start.
+func (m *Metrics) ObserveExternalCall(service, operation string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.externalRequestDuration.
+		WithLabelValues(service, operation, callOutcome(err)).
+		Observe(time.Since(start).Seconds())
+}
+
+// callOutcome classifies the error returned by a call to an external service.
+func callOutcome(err error) string {
+	if err == nil {
+		return OutcomeSuccess
+	}
+	if authErr, ok := auth.AsError(err); ok && authErr.Status >= 400 && authErr.Status < 500 {
+		return OutcomeRejected
+	}
+	return OutcomeError
+}
+
+// ObservePermissionCheck counts a check of the permission with the outcome.
+func (m *Metrics) ObservePermissionCheck(permission, outcome string) {
+	if m == nil {
+		return
+	}
+	m.permissionChecks.WithLabelValues(permission, outcome).Inc()
+}
+
+// InstrumentRoundTripper observes the duration of the requests made with next as calls to the service,
+// using the request path as the operation. It must only be used for clients calling a fixed set of paths.
+func (m *Metrics) InstrumentRoundTripper(service string, next http.RoundTripper) http.RoundTripper {
+	if m == nil {
+		return next
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+
+		outcome := OutcomeSuccess
+		switch {
+		case err != nil || resp.StatusCode >= 500:
+			outcome = OutcomeError
+		case resp.StatusCode >= 400:
+			outcome = OutcomeRejected
+		}
+		m.externalRequestDuration.
+			WithLabelValues(service, req.URL.Path, outcome).
+			Observe(time.Since(start).Seconds())
+		return resp, err
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
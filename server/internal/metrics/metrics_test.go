@@ -0,0 +1,119 @@
+package metrics_test
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"advancely/internal/metrics"
+	"advancely/internal/tests"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+// scrape returns the metrics served by the handler in the text exposition format.
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
+
+func TestMiddleware(t *testing.T) {
+	m := metrics.New()
+	e := tests.NewEchoInstance()
+	e.Use(m.Middleware)
+	e.GET("/users/:id", func(c echo.Context) error {
+		if c.Param("id") == "missing" {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/users/missing", "/unknown"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	body := scrape(t, m)
+	require.Contains(t, body, `advancely_http_request_duration_seconds_count{method="GET",route="/users/:id",status="204"} 2`)
+	require.Contains(t, body, `advancely_http_request_duration_seconds_count{method="GET",route="/users/:id",status="404"} 1`)
+	require.Contains(t, body, `advancely_http_request_duration_seconds_count{method="GET",route="unmatched",status="404"} 1`)
+	require.NotContains(t, body, `route="/users/1"`)
+}
+
+func TestInstrumentRoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth/v1/recover" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+	}))
+	defer server.Close()
+
+	m := metrics.New()
+	client := &http.Client{Transport: m.InstrumentRoundTripper(metrics.ServiceSupabase, http.DefaultTransport)}
+	for _, path := range []string{"/auth/v1/recover", "/auth/v1/user"} {
+		resp, err := client.Get(server.URL + path)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	body := scrape(t, m)
+	require.Contains(t, body, `advancely_external_request_duration_seconds_count{operation="/auth/v1/recover",outcome="rejected",service="supabase"} 1`)
+	require.Contains(t, body, `advancely_external_request_duration_seconds_count{operation="/auth/v1/user",outcome="success",service="supabase"} 1`)
+}
+
+func TestInstrumentProvider(t *testing.T) {
+	fake := tests.NewFakeAuthProvider()
+	fake.AddUser(types.User{ID: uuid.New(), Email: tests.DefaultUserEmail}, tests.DefaultUserPassword)
+
+	m := metrics.New()
+	provider := m.InstrumentProvider(fake)
+
+	ctx := context.Background()
+	_, err := provider.SignInWithEmailPassword(ctx, tests.DefaultUserEmail, tests.DefaultUserPassword)
+	require.NoError(t, err)
+	_, err = provider.SignInWithEmailPassword(ctx, tests.DefaultUserEmail, "wrong password")
+	require.Error(t, err)
+
+	body := scrape(t, m)
+	require.Contains(t, body, `advancely_external_request_duration_seconds_count{operation="SignInWithEmailPassword",outcome="success",service="auth_provider"} 1`)
+	require.Contains(t, body, `advancely_external_request_duration_seconds_count{operation="SignInWithEmailPassword",outcome="rejected",service="auth_provider"} 1`)
+}
+
+func TestPermissionChecksAndDB(t *testing.T) {
+	m := metrics.New()
+	m.ObservePermissionCheck("create:role", metrics.PermissionAllowed)
+	m.ObservePermissionCheck("create:role", metrics.PermissionDenied)
+	m.ObservePermissionCheck("create:role", metrics.PermissionDenied)
+
+	// Opening the database does not connect to it, which is enough to export the pool stats.
+	db, err := sql.Open("postgres", "postgres://localhost/advancely")
+	require.NoError(t, err)
+	defer db.Close()
+	m.RegisterDB("advancely", db)
+
+	body := scrape(t, m)
+	require.Contains(t, body, `advancely_permission_checks_total{outcome="allowed",permission="create:role"} 1`)
+	require.Contains(t, body, `advancely_permission_checks_total{outcome="denied",permission="create:role"} 2`)
+	for _, name := range []string{"go_sql_open_connections", "go_sql_in_use_connections", "go_sql_wait_count_total"} {
+		require.True(t, strings.Contains(body, name+`{db_name="advancely"}`), "missing %s", name)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *metrics.Metrics
+	provider := tests.NewFakeAuthProvider()
+	require.Same(t, provider, m.InstrumentProvider(provider))
+	require.Same(t, http.DefaultTransport, m.InstrumentRoundTripper(metrics.ServiceSupabase, http.DefaultTransport))
+	m.ObservePermissionCheck("create:role", metrics.PermissionAllowed)
+}
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"advancely/internal/auth"
+
+	"github.com/google/uuid"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+// Service labels of external calls.
+const (
+	ServiceAuthProvider = "auth_provider"
+	// ServiceSupabase is the service of requests made directly to Supabase rather than through the auth provider.
+	ServiceSupabase = "supabase"
+)
+
+// InstrumentedProvider is an auth.Provider observing the duration of every call made to the provider it wraps,
+// using the name of the method as the operation.
+type InstrumentedProvider struct {
+	auth.Provider
+	metrics *Metrics
+}
+
+// InstrumentProvider wraps the provider to observe its calls, returning it unchanged if m is nil.
+func (m *Metrics) InstrumentProvider(p auth.Provider) auth.Provider {
+	if m == nil {
+		return p
+	}
+	return &InstrumentedProvider{Provider: p, metrics: m}
+}
+
+func (p *InstrumentedProvider) observe(operation string, start time.Time, err error) {
+	p.metrics.ObserveExternalCall(ServiceAuthProvider, operation, start, err)
+}
+
+func (p *InstrumentedProvider) SignInWithEmailPassword(ctx context.Context, email, password string) (*types.Session, error) {
+	start := time.Now()
+	session, err := p.Provider.SignInWithEmailPassword(ctx, email, password)
+	p.observe("SignInWithEmailPassword", start, err)
+	return session, err
+}
+
+func (p *InstrumentedProvider) SignUp(ctx context.Context, req types.SignupRequest) (*types.User, error) {
+	start := time.Now()
+	user, err := p.Provider.SignUp(ctx, req)
+	p.observe("SignUp", start, err)
+	return user, err
+}
+
+func (p *InstrumentedProvider) SendOTP(ctx context.Context, req types.OTPRequest) error {
+	start := time.Now()
+	err := p.Provider.SendOTP(ctx, req)
+	p.observe("SendOTP", start, err)
+	return err
+}
+
+func (p *InstrumentedProvider) VerifyOTP(ctx context.Context, req types.VerifyForUserRequest) (*types.Session, error) {
+	start := time.Now()
+	session, err := p.Provider.VerifyOTP(ctx, req)
+	p.observe("VerifyOTP", start, err)
+	return session, err
+}
+
+func (p *InstrumentedProvider) RefreshSession(ctx context.Context, refreshToken string) (*types.Session, error) {
+	start := time.Now()
+	session, err := p.Provider.RefreshSession(ctx, refreshToken)
+	p.observe("RefreshSession", start, err)
+	return session, err
+}
+
+func (p *InstrumentedProvider) Logout(ctx context.Context, accessToken string) error {
+	start := time.Now()
+	err := p.Provider.Logout(ctx, accessToken)
+	p.observe("Logout", start, err)
+	return err
+}
+
+func (p *InstrumentedProvider) ResetPasswordForEmail(ctx context.Context, email, redirectTo string) error {
+	start := time.Now()
+	err := p.Provider.ResetPasswordForEmail(ctx, email, redirectTo)
+	p.observe("ResetPasswordForEmail", start, err)
+	return err
+}
+
+func (p *InstrumentedProvider) UpdatePassword(ctx context.Context, accessToken, password string) error {
+	start := time.Now()
+	err := p.Provider.UpdatePassword(ctx, accessToken, password)
+	p.observe("UpdatePassword", start, err)
+	return err
+}
+
+func (p *InstrumentedProvider) GetUser(ctx context.Context, accessToken string) (*types.User, error) {
+	start := time.Now()
+	user, err := p.Provider.GetUser(ctx, accessToken)
+	p.observe("GetUser", start, err)
+	return user, err
+}
+
+func (p *InstrumentedProvider) ExchangeCodeForSession(ctx context.Context, code, codeVerifier string) (*types.Session, error) {
+	start := time.Now()
+	session, err := p.Provider.ExchangeCodeForSession(ctx, code, codeVerifier)
+	p.observe("ExchangeCodeForSession", start, err)
+	return session, err
+}
+
+func (p *InstrumentedProvider) SignInWithVerifiedEmail(
+	ctx context.Context,
+	email string,
+	metadata map[string]interface{},
+) (*types.Session, error) {
+	start := time.Now()
+	session, err := p.Provider.SignInWithVerifiedEmail(ctx, email, metadata)
+	p.observe("SignInWithVerifiedEmail", start, err)
+	return session, err
+}
+
+func (p *InstrumentedProvider) CreateUser(
+	ctx context.Context,
+	email string,
+	metadata map[string]interface{},
+) (*types.User, error) {
+	start := time.Now()
+	user, err := p.Provider.CreateUser(ctx, email, metadata)
+	p.observe("CreateUser", start, err)
+	return user, err
+}
+
+func (p *InstrumentedProvider) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	start := time.Now()
+	err := p.Provider.DeleteUser(ctx, id)
+	p.observe("DeleteUser", start, err)
+	return err
+}
+
+func (p *InstrumentedProvider) SetUserBanned(ctx context.Context, id uuid.UUID, banned bool) error {
+	start := time.Now()
+	err := p.Provider.SetUserBanned(ctx, id, banned)
+	p.observe("SetUserBanned", start, err)
+	return err
+}
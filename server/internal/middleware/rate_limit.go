@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"advancely/internal/application"
+	"advancely/internal/auth"
+	"advancely/internal/logging"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxRateLimitBodySize is the largest request body read to find the email of a rate limited request.
+const maxRateLimitBodySize = 64 << 10
+
+// RateLimiter limits requests to sensitive routes by client IP and email,
+// and locks emails out of logging in after repeated failed attempts.
+type RateLimiter struct {
+	Store  RateLimitStore
+	Logger *slog.Logger
+	now    func() time.Time
+}
+
+func NewRateLimiter(store RateLimitStore, logger *slog.Logger) *RateLimiter {
+	return &RateLimiter{
+		Store:  store,
+		Logger: logger,
+		now:    time.Now,
+	}
+}
+
+// WithClock replaces the clock used to compute Retry-After headers, which is used in tests.
+func (l *RateLimiter) WithClock(now func() time.Time) *RateLimiter {
+	l.now = now
+	return l
+}
+
+// KeyFunc returns the key a request is limited by, such as the email in its body,
+// or an empty string if the request is not limited by key.
+type KeyFunc func(c echo.Context) string
+
+// Limit returns the middleware limiting requests to the route by client IP and by the email in the request body.
+// Requests over either limit are rejected with 429 Too Many Requests and a Retry-After header.
+// The route name identifies the counts of the route in the store.
+func (l *RateLimiter) Limit(route string, limit application.RouteRateLimit) echo.MiddlewareFunc {
+	return l.limit(route, limit.PerIP, "email", limit.PerEmail, requestEmail)
+}
+
+// LimitUser returns the middleware limiting requests to the route by client IP and by the ID of the user
+// the request is made for, as returned by userID.
+func (l *RateLimiter) LimitUser(route string, limit application.UserRateLimit, userID KeyFunc) echo.MiddlewareFunc {
+	return l.limit(route, limit.PerIP, "user", limit.PerUser, userID)
+}
+
+func (l *RateLimiter) limit(route string, perIP application.RateLimit, keyName string, perKey application.RateLimit, key KeyFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if perIP.Enabled() {
+				if err := l.hit(c, route+":ip:"+c.RealIP(), perIP); err != nil {
+					return err
+				}
+			}
+			if perKey.Enabled() {
+				if value := key(c); value != "" {
+					if err := l.hit(c, route+":"+keyName+":"+value, perKey); err != nil {
+						return err
+					}
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// Lockout returns the middleware locking the email in the request body out of the route after repeated
+// invalid credentials. Each failure past the threshold doubles the lockout, and a successful request
+// clears the failures.
+func (l *RateLimiter) Lockout(route string, cfg application.LockoutConfig) echo.MiddlewareFunc {
+	return l.lockout(route, cfg, requestEmail, isInvalidCredentials)
+}
+
+// LockoutUser returns the middleware locking the user returned by userID out of the route after repeated
+// failed attempts, which the route rejects with 401 Unauthorized. The lockout works like Lockout.
+func (l *RateLimiter) LockoutUser(route string, cfg application.LockoutConfig, userID KeyFunc) echo.MiddlewareFunc {
+	return l.lockout(route, cfg, userID, isUnauthorized)
+}
+
+func (l *RateLimiter) lockout(route string, cfg application.LockoutConfig, key KeyFunc, failed func(error) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			value := key(c)
+			if cfg.Threshold <= 0 || value == "" {
+				return next(c)
+			}
+
+			ctx := c.Request().Context()
+			logger := logging.FromContext(ctx, l.Logger)
+			lockKey := route + ":lockout:" + value
+			failuresKey := route + ":failures:" + value
+
+			until, err := l.Store.LockedUntil(ctx, lockKey)
+			if err != nil {
+				logger.Error("failed to get rate limit lockout", "error", err)
+			} else if !until.IsZero() {
+				return l.tooManyRequests(c, until)
+			}
+
+			err = next(c)
+			if failed(err) {
+				failures, _, storeErr := l.Store.Increment(ctx, failuresKey, cfg.FailureWindow)
+				if storeErr != nil {
+					logger.Error("failed to record failed attempt", "error", storeErr)
+				} else if failures >= cfg.Threshold {
+					if storeErr := l.Store.Lock(ctx, lockKey, lockoutDuration(cfg, failures)); storeErr != nil {
+						logger.Error("failed to lock out", "error", storeErr)
+					}
+				}
+			} else if err == nil {
+				if storeErr := l.Store.Reset(ctx, failuresKey); storeErr != nil {
+					logger.Error("failed to reset failed attempts", "error", storeErr)
+				}
+			}
+			return err
+		}
+	}
+}
+
+func isInvalidCredentials(err error) bool {
+	authErr, ok := auth.AsError(err)
+	return ok && authErr.Code == auth.ErrorCodeInvalidCredentials
+}
+
+func isUnauthorized(err error) bool {
+	var httpErr *echo.HTTPError
+	return errors.As(err, &httpErr) && httpErr.Code == http.StatusUnauthorized
+}
+
+// lockoutDuration returns the lockout after the number of consecutive failures, which is at least the threshold.
+func lockoutDuration(cfg application.LockoutConfig, failures int) time.Duration {
+	d := cfg.BaseDuration
+	for i := cfg.Threshold; i < failures && d < cfg.MaxDuration; i++ {
+		d *= 2
+	}
+	return min(d, cfg.MaxDuration)
+}
+
+// hit counts the request against the limit, returning an error if the limit is exceeded.
+// Requests are allowed if the store fails, so that an unavailable store does not prevent logging in.
+func (l *RateLimiter) hit(c echo.Context, key string, limit application.RateLimit) error {
+	count, resetAt, err := l.Store.Increment(c.Request().Context(), key, limit.Window)
+	if err != nil {
+		logging.FromContext(c.Request().Context(), l.Logger).Error("failed to count rate limited request", "error", err)
+		return nil
+	}
+	if count > limit.Requests {
+		return l.tooManyRequests(c, resetAt)
+	}
+	return nil
+}
+
+// ClientIPExtractor returns the extractor of the client IP of requests, which rate limits are keyed on.
+// The X-Forwarded-For header is only trusted from the proxies in the networks, so that clients cannot
+// spoof their IP to get a new rate limit. Without proxies, the IP is the address of the connection.
+func ClientIPExtractor(proxies []*net.IPNet) echo.IPExtractor {
+	if len(proxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, proxy := range proxies {
+		options = append(options, echo.TrustIPRange(proxy))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+func (l *RateLimiter) tooManyRequests(c echo.Context, until time.Time) error {
+	seconds := int(math.Ceil(until.Sub(l.now()).Seconds()))
+	c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(max(seconds, 1)))
+	return echo.NewHTTPError(http.StatusTooManyRequests, "Too many attempts, please try again later")
+}
+
+// requestEmail returns the lowercase email in the JSON or form body of the request, leaving the body
+// to be read by the handler. Returns an empty string if the body has no email.
+func requestEmail(c echo.Context) string {
+	req := c.Request()
+	if req.Body == nil {
+		return ""
+	}
+
+	if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationForm) {
+		return strings.ToLower(strings.TrimSpace(c.FormValue("email")))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxRateLimitBodySize+1))
+	req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+	if err != nil || len(body) > maxRateLimitBodySize {
+		return ""
+	}
+
+	var payload struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(payload.Email))
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimitStore counts requests and records lockouts for rate limit keys.
+// Keys are only valid within a single store, so separate instances of the API
+// must share a store for the limits to apply across them.
+type RateLimitStore interface {
+	// Increment counts a request for the key in the current window, starting a new window if none is active.
+	// Returns the number of requests in the window and the time the window ends.
+	Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+	// Reset clears the count of the key.
+	Reset(ctx context.Context, key string) error
+	// Lock locks the key for the duration, replacing any existing lock.
+	Lock(ctx context.Context, key string, d time.Duration) error
+	// LockedUntil returns the time the lock on the key ends, or the zero time if the key is not locked.
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+}
+
+// rateLimitSweepInterval is how often expired entries are removed from a MemoryRateLimitStore.
+const rateLimitSweepInterval = time.Minute
+
+type rateLimitWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+// MemoryRateLimitStore is a RateLimitStore keeping its counts in memory.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	windows   map[string]rateLimitWindow
+	locks     map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		windows: make(map[string]rateLimitWindow),
+		locks:   make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// WithClock replaces the clock of the store, which is used in tests.
+func (s *MemoryRateLimitStore) WithClock(now func() time.Time) *MemoryRateLimitStore {
+	s.now = now
+	return s
+}
+
+func (s *MemoryRateLimitStore) Increment(_ context.Context, key string, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	w, ok := s.windows[key]
+	if !ok || !now.Before(w.resetAt) {
+		w = rateLimitWindow{resetAt: now.Add(window)}
+	}
+	w.count++
+	s.windows[key] = w
+	return w.count, w.resetAt, nil
+}
+
+func (s *MemoryRateLimitStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.windows, key)
+	return nil
+}
+
+func (s *MemoryRateLimitStore) Lock(_ context.Context, key string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locks[key] = s.now().Add(d)
+	return nil
+}
+
+func (s *MemoryRateLimitStore) LockedUntil(_ context.Context, key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.locks[key]
+	if !ok || !s.now().Before(until) {
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+// sweep removes expired windows and locks. The caller must hold the lock.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, w := range s.windows {
+		if !now.Before(w.resetAt) {
+			delete(s.windows, key)
+		}
+	}
+	for key, until := range s.locks {
+		if !now.Before(until) {
+			delete(s.locks, key)
+		}
+	}
+}
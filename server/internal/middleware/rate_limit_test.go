@@ -0,0 +1,257 @@
+package middleware_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"advancely/internal/application"
+	"advancely/internal/auth"
+	mw "advancely/internal/middleware"
+	"advancely/internal/tests"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestRateLimiter() (*mw.RateLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	store := mw.NewMemoryRateLimitStore().WithClock(clock.Now)
+	return mw.NewRateLimiter(store, tests.NewDefaultLogger()).WithClock(clock.Now), clock
+}
+
+// serveLimited runs the handler behind the middleware for a login request from the IP.
+func serveLimited(t *testing.T, middleware echo.MiddlewareFunc, handler echo.HandlerFunc, ip, email string) (*httptest.ResponseRecorder, error) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"`+email+`","password":"secret"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.RemoteAddr = ip + ":1234"
+	rec := httptest.NewRecorder()
+	c := tests.NewEchoInstance().NewContext(req, rec)
+	return rec, middleware(handler)(c)
+}
+
+func okHandler(c echo.Context) error {
+	return c.NoContent(http.StatusNoContent)
+}
+
+func requireTooManyRequests(t *testing.T, rec *httptest.ResponseRecorder, err error, retryAfter string) {
+	t.Helper()
+	httpErr, ok := err.(*echo.HTTPError)
+	require.True(t, ok, "expected echo.HTTPError but got %v", err)
+	require.Equal(t, http.StatusTooManyRequests, httpErr.Code)
+	require.Equal(t, retryAfter, rec.Header().Get(echo.HeaderRetryAfter))
+}
+
+func TestRateLimiterLimitsPerIP(t *testing.T) {
+	limiter, clock := newTestRateLimiter()
+	limit := limiter.Limit("login", application.RouteRateLimit{
+		PerIP: application.RateLimit{Requests: 2, Window: time.Minute},
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := serveLimited(t, limit, okHandler, "10.0.0.1", "user@example.com")
+		require.NoError(t, err)
+	}
+	rec, err := serveLimited(t, limit, okHandler, "10.0.0.1", "user@example.com")
+	requireTooManyRequests(t, rec, err, "60")
+
+	_, err = serveLimited(t, limit, okHandler, "10.0.0.2", "user@example.com")
+	require.NoError(t, err, "other IPs are not limited")
+
+	clock.Advance(time.Minute)
+	_, err = serveLimited(t, limit, okHandler, "10.0.0.1", "user@example.com")
+	require.NoError(t, err, "the limit resets after the window")
+}
+
+func TestRateLimiterLimitsPerEmail(t *testing.T) {
+	limiter, _ := newTestRateLimiter()
+	limit := limiter.Limit("login", application.RouteRateLimit{
+		PerEmail: application.RateLimit{Requests: 1, Window: time.Hour},
+	})
+
+	// The handler must still be able to read the body after the middleware has read the email.
+	bodyHandler := func(c echo.Context) error {
+		body, err := io.ReadAll(c.Request().Body)
+		require.NoError(t, err)
+		require.Contains(t, string(body), `"password":"secret"`)
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	_, err := serveLimited(t, limit, bodyHandler, "10.0.0.1", "user@example.com")
+	require.NoError(t, err)
+	rec, err := serveLimited(t, limit, bodyHandler, "10.0.0.2", " USER@example.com")
+	requireTooManyRequests(t, rec, err, "3600")
+
+	_, err = serveLimited(t, limit, bodyHandler, "10.0.0.2", "other@example.com")
+	require.NoError(t, err)
+}
+
+func TestRateLimiterLockout(t *testing.T) {
+	limiter, clock := newTestRateLimiter()
+	lockout := limiter.Lockout("login", application.LockoutConfig{
+		Threshold:     2,
+		BaseDuration:  10 * time.Second,
+		MaxDuration:   25 * time.Second,
+		FailureWindow: time.Hour,
+	})
+
+	invalidCredentials := func(c echo.Context) error {
+		authErr := &auth.Error{Status: http.StatusBadRequest, Code: auth.ErrorCodeInvalidCredentials, Message: "Invalid login credentials"}
+		return echo.NewHTTPError(authErr.Status, authErr.Message).SetInternal(authErr)
+	}
+	fail := func() {
+		t.Helper()
+		_, err := serveLimited(t, lockout, invalidCredentials, "10.0.0.1", "user@example.com")
+		require.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code)
+	}
+
+	fail()
+	fail()
+	rec, err := serveLimited(t, lockout, okHandler, "10.0.0.2", "user@example.com")
+	requireTooManyRequests(t, rec, err, "10")
+
+	clock.Advance(10 * time.Second)
+	fail()
+	rec, err = serveLimited(t, lockout, okHandler, "10.0.0.1", "user@example.com")
+	requireTooManyRequests(t, rec, err, "20")
+
+	clock.Advance(20 * time.Second)
+	fail()
+	rec, err = serveLimited(t, lockout, okHandler, "10.0.0.1", "user@example.com")
+	requireTooManyRequests(t, rec, err, "25")
+
+	_, err = serveLimited(t, lockout, okHandler, "10.0.0.1", "other@example.com")
+	require.NoError(t, err, "other emails are not locked out")
+
+	// A successful login clears the failures, so the next failure does not lock the email out.
+	clock.Advance(25 * time.Second)
+	_, err = serveLimited(t, lockout, okHandler, "10.0.0.1", "user@example.com")
+	require.NoError(t, err)
+	fail()
+	_, err = serveLimited(t, lockout, okHandler, "10.0.0.1", "user@example.com")
+	require.NoError(t, err)
+}
+
+// serveFrom runs the handler behind the middleware for a request from the remote IP with the
+// client IP headers, extracting the client IP as the router does.
+func serveFrom(t *testing.T, extractor echo.IPExtractor, middleware echo.MiddlewareFunc, remoteIP string, headers map[string]string) (*httptest.ResponseRecorder, error) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req.RemoteAddr = remoteIP + ":1234"
+	e := tests.NewEchoInstance()
+	e.IPExtractor = extractor
+	rec := httptest.NewRecorder()
+	return rec, middleware(okHandler)(e.NewContext(req, rec))
+}
+
+func TestRateLimiterIgnoresSpoofedClientIP(t *testing.T) {
+	limiter, _ := newTestRateLimiter()
+	limit := limiter.Limit("login", application.RouteRateLimit{
+		PerIP: application.RateLimit{Requests: 1, Window: time.Minute},
+	})
+	extractor := mw.ClientIPExtractor(nil)
+
+	_, err := serveFrom(t, extractor, limit, "203.0.113.7", nil)
+	require.NoError(t, err)
+	for _, headers := range []map[string]string{
+		{echo.HeaderXForwardedFor: "198.51.100.1"},
+		{echo.HeaderXRealIP: "198.51.100.2"},
+	} {
+		rec, err := serveFrom(t, extractor, limit, "203.0.113.7", headers)
+		requireTooManyRequests(t, rec, err, "60")
+	}
+}
+
+func TestRateLimiterTrustsForwardedIPFromProxies(t *testing.T) {
+	limiter, _ := newTestRateLimiter()
+	limit := limiter.Limit("login", application.RouteRateLimit{
+		PerIP: application.RateLimit{Requests: 1, Window: time.Minute},
+	})
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	extractor := mw.ClientIPExtractor([]*net.IPNet{proxies})
+
+	_, err = serveFrom(t, extractor, limit, "10.0.0.1", map[string]string{echo.HeaderXForwardedFor: "198.51.100.1"})
+	require.NoError(t, err)
+	_, err = serveFrom(t, extractor, limit, "10.0.0.2", map[string]string{echo.HeaderXForwardedFor: "198.51.100.2"})
+	require.NoError(t, err, "clients behind the proxy are limited separately")
+
+	// A client forwarding through the proxy cannot add an IP before its own.
+	rec, err := serveFrom(t, extractor, limit, "10.0.0.1", map[string]string{echo.HeaderXForwardedFor: "192.0.2.9, 198.51.100.1"})
+	requireTooManyRequests(t, rec, err, "60")
+
+	_, err = serveFrom(t, extractor, limit, "203.0.113.7", nil)
+	require.NoError(t, err)
+	rec, err = serveFrom(t, extractor, limit, "203.0.113.7", map[string]string{echo.HeaderXForwardedFor: "198.51.100.3"})
+	requireTooManyRequests(t, rec, err, "60")
+}
+
+func TestRateLimiterLocksOutUser(t *testing.T) {
+	limiter, clock := newTestRateLimiter()
+	userID := func(c echo.Context) string {
+		return c.Request().Header.Get("X-User")
+	}
+	limit := limiter.LimitUser("mfa", application.UserRateLimit{
+		PerUser: application.RateLimit{Requests: 3, Window: time.Minute},
+	}, userID)
+	lockout := limiter.LockoutUser("mfa", application.LockoutConfig{
+		Threshold:     2,
+		BaseDuration:  time.Minute,
+		MaxDuration:   time.Hour,
+		FailureWindow: time.Hour,
+	}, userID)
+
+	invalidCode := func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid code")
+	}
+	serve := func(handler echo.HandlerFunc, ip, user string) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(http.MethodPost, "/auth/mfa/challenge", nil)
+		req.Header.Set("X-User", user)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		return rec, limit(lockout(handler))(tests.NewEchoInstance().NewContext(req, rec))
+	}
+
+	// Failures are counted per user, whichever IP they come from.
+	_, err := serve(invalidCode, "10.0.0.1", "user-1")
+	require.Equal(t, http.StatusUnauthorized, err.(*echo.HTTPError).Code)
+	_, err = serve(invalidCode, "10.0.0.2", "user-1")
+	require.Equal(t, http.StatusUnauthorized, err.(*echo.HTTPError).Code)
+	rec, err := serve(okHandler, "10.0.0.3", "user-1")
+	requireTooManyRequests(t, rec, err, "60")
+
+	_, err = serve(okHandler, "10.0.0.1", "user-2")
+	require.NoError(t, err, "other users are not locked out")
+
+	// The lockout has expired, but the user is over the limit of attempts in the window.
+	clock.Advance(time.Minute)
+	_, err = serve(okHandler, "10.0.0.1", "user-1")
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = serve(okHandler, "10.0.0.1", "user-1")
+		require.NoError(t, err)
+	}
+	rec, err = serve(okHandler, "10.0.0.1", "user-1")
+	requireTooManyRequests(t, rec, err, "60")
+}
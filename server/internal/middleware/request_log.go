@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"advancely/internal/auth"
+	"advancely/internal/logging"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxRequestIDLength is the length of the longest request ID accepted from a client or proxy.
+const maxRequestIDLength = 128
+
+// RequestLogger assigns every request an ID and writes a structured access log once it has been handled.
+type RequestLogger struct {
+	Logger *slog.Logger
+	// Skipper skips logging requests, such as health probes, which still get a request ID.
+	Skipper func(c echo.Context) bool
+	// Now returns the current time, used to measure the latency of requests.
+	Now func() time.Time
+}
+
+func NewRequestLogger(logger *slog.Logger) *RequestLogger {
+	return &RequestLogger{
+		Logger: logger,
+		Now:    time.Now,
+	}
+}
+
+// WithSkipper sets the function deciding which requests are not logged.
+func (l *RequestLogger) WithSkipper(skipper func(c echo.Context) bool) *RequestLogger {
+	l.Skipper = skipper
+	return l
+}
+
+// WithClock replaces the clock used to measure latency, which is used in tests.
+func (l *RequestLogger) WithClock(now func() time.Time) *RequestLogger {
+	l.Now = now
+	return l
+}
+
+// Handle propagates the X-Request-ID of the request, generating one if it is missing or invalid,
+// and sets it on the response. A logger with the request ID, and the trace ID if the request is traced,
+// is saved in the request context, and the access log is written with it after the request has been handled.
+//
+// Errors returned by later handlers are handled here so the access log records the status sent.
+func (l *RequestLogger) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := l.Now()
+		req := c.Request()
+
+		requestID := req.Header.Get(echo.HeaderXRequestID)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		req.Header.Set(echo.HeaderXRequestID, requestID)
+		c.Response().Header().Set(echo.HeaderXRequestID, requestID)
+
+		logger := l.Logger.With("request_id", requestID)
+		if span := trace.SpanContextFromContext(req.Context()); span.IsValid() {
+			logger = logger.With("trace_id", span.TraceID().String())
+		}
+		ctx := logging.NewContext(req.Context(), logger)
+		c.SetRequest(req.WithContext(ctx))
+
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+		if l.Skipper != nil && l.Skipper(c) {
+			return nil
+		}
+
+		// Later middleware may add attributes, such as the user ID, to the logger in the request context.
+		logger = logging.FromContext(c.Request().Context(), l.Logger)
+		status := c.Response().Status
+
+		attrs := []slog.Attr{
+			slog.String("method", req.Method),
+			slog.String("route", c.Path()),
+			slog.String("path", req.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("latency", l.Now().Sub(start)),
+			slog.Int64("bytes_out", c.Response().Size),
+			slog.String("remote_ip", c.RealIP()),
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(c.Request().Context(), level, "request", attrs...)
+		return nil
+	}
+}
+
+// validRequestID returns true if the ID is safe to propagate, being short and printable ASCII.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// logSession adds the IDs of the authenticated user and their company to the request logger.
+func logSession(c echo.Context, session *auth.SessionCookie) {
+	var args []any
+	if session.User != nil {
+		args = append(args, "user_id", session.User.ID.String())
+	}
+	if session.Company != nil {
+		args = append(args, "company_id", session.Company.ID.String())
+	}
+	if len(args) > 0 {
+		c.SetRequest(c.Request().WithContext(logging.With(c.Request().Context(), args...)))
+	}
+}
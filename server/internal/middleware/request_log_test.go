@@ -0,0 +1,140 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"advancely/internal/logging"
+	mw "advancely/internal/middleware"
+	"advancely/internal/tests"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRequestLogger returns a request logger writing JSON records to the returned buffer.
+// Every call of the clock advances it by 25ms.
+func newTestRequestLogger() (*mw.RequestLogger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	logger := slog.New(logging.NewHandler(&buf, logging.FormatJSON, slog.LevelDebug))
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	return mw.NewRequestLogger(logger).WithClock(func() time.Time {
+		now := clock.Now()
+		clock.Advance(25 * time.Millisecond)
+		return now
+	}), &buf
+}
+
+// readLogs decodes the JSON records written to the buffer.
+func readLogs(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func serveLogged(l *mw.RequestLogger, handler echo.HandlerFunc, requestID string) *httptest.ResponseRecorder {
+	e := tests.NewEchoInstance()
+	e.Use(l.Handle)
+	e.GET("/users/:id", handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	if requestID != "" {
+		req.Header.Set(echo.HeaderXRequestID, requestID)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRequestLoggerRequestID(t *testing.T) {
+	testCases := []struct {
+		name      string
+		requestID string
+		propagate bool
+	}{
+		{name: "propagates request ID", requestID: "req-123", propagate: true},
+		{name: "generates missing request ID"},
+		{name: "replaces request ID with spaces", requestID: "req 123"},
+		{name: "replaces request ID that is too long", requestID: strings.Repeat("a", 129)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, _ := newTestRequestLogger()
+			var handlerID string
+			rec := serveLogged(l, func(c echo.Context) error {
+				handlerID = c.Request().Header.Get(echo.HeaderXRequestID)
+				return c.NoContent(http.StatusNoContent)
+			}, tc.requestID)
+
+			requestID := rec.Header().Get(echo.HeaderXRequestID)
+			require.Equal(t, requestID, handlerID)
+			if tc.propagate {
+				require.Equal(t, tc.requestID, requestID)
+			} else {
+				_, err := uuid.Parse(requestID)
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRequestLoggerAccessLog(t *testing.T) {
+	l, buf := newTestRequestLogger()
+	userID, companyID := uuid.New(), uuid.New()
+
+	rec := serveLogged(l, func(c echo.Context) error {
+		// The user middleware adds the IDs of the authenticated user once it runs after the request logger.
+		c.SetRequest(c.Request().WithContext(logging.With(c.Request().Context(),
+			"user_id", userID.String(), "company_id", companyID.String())))
+
+		logging.FromContext(c.Request().Context(), nil).Info("handling request")
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}, "req-123")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	records := readLogs(t, buf)
+	require.Len(t, records, 2)
+
+	handlerLog := records[0]
+	require.Equal(t, "handling request", handlerLog["msg"])
+	require.Equal(t, "req-123", handlerLog["request_id"])
+	require.Equal(t, userID.String(), handlerLog["user_id"])
+
+	accessLog := records[1]
+	require.Equal(t, "request", accessLog["msg"])
+	require.Equal(t, "INFO", accessLog["level"])
+	require.Equal(t, "req-123", accessLog["request_id"])
+	require.Equal(t, http.MethodGet, accessLog["method"])
+	require.Equal(t, "/users/:id", accessLog["route"])
+	require.Equal(t, "/users/42", accessLog["path"])
+	require.EqualValues(t, http.StatusNotFound, accessLog["status"])
+	require.EqualValues(t, 25*time.Millisecond, accessLog["latency"])
+	require.Equal(t, userID.String(), accessLog["user_id"])
+	require.Equal(t, companyID.String(), accessLog["company_id"])
+	require.Contains(t, accessLog["error"], "User not found")
+}
+
+func TestRequestLoggerServerErrorLevel(t *testing.T) {
+	l, buf := newTestRequestLogger()
+	rec := serveLogged(l, func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}, "")
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	records := readLogs(t, buf)
+	require.Len(t, records, 1)
+	require.Equal(t, "ERROR", records[0]["level"])
+	require.NotContains(t, records[0], "user_id")
+}
@@ -3,46 +3,97 @@ package middleware
 import (
 	"advancely/internal/application"
 	"advancely/internal/auth"
+	"advancely/internal/logging"
+	"advancely/internal/model/security"
 	"advancely/internal/store"
+	"advancely/pkg/fn"
 	"context"
 	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"github.com/supabase-community/gotrue-go/types"
-	"github.com/supabase-community/supabase-go"
 	"log/slog"
 )
 
 var (
-	ErrorNoAccessToken  = errors.New("no access token found")
-	ErrorNoRefreshToken = errors.New("no refresh token found")
+	ErrorNoAccessToken   = errors.New("no access token found")
+	ErrorNoRefreshToken  = errors.New("no refresh token found")
+	ErrorTokenRevoked    = errors.New("personal access token has been revoked")
+	ErrorTokenExpired    = errors.New("personal access token has expired")
+	ErrorUserDeactivated = errors.New("user has been deactivated")
 )
 
 type UserMiddleware struct {
-	UserStore store.UserStore
-	Config    application.AppConfig
-	Logger    *slog.Logger
-	Supabase  *supabase.Client
+	UserStore    store.UserStore
+	TokenStore   store.PersonalAccessTokenStore
+	Config       application.AppConfig
+	Logger       *slog.Logger
+	AuthProvider auth.Provider
+	Verifier     auth.Verifier
+	Policy       auth.SessionPolicy
+	// AllowDeletedCompany returns true for the requests allowed in companies that have been deleted,
+	// which is restoring the company. Sessions in deleted companies are rejected on every other request.
+	AllowDeletedCompany func(c echo.Context) bool
 }
 
 func NewUserMiddleware(
 	config application.AppConfig,
-	supabaseClient *supabase.Client,
+	authProvider auth.Provider,
+	verifier auth.Verifier,
 	userStore store.UserStore,
+	tokenStore store.PersonalAccessTokenStore,
+	policy auth.SessionPolicy,
 	logger *slog.Logger,
 ) *UserMiddleware {
 	return &UserMiddleware{
-		Supabase:  supabaseClient,
-		Config:    config,
-		UserStore: userStore,
-		Logger:    logger,
+		AuthProvider: authProvider,
+		Verifier:     verifier,
+		Policy:       policy,
+		Config:       config,
+		UserStore:    userStore,
+		TokenStore:   tokenStore,
+		Logger:       logger,
 	}
 }
 
+// WithDeletedCompanyAllowed sets the function deciding which requests are allowed in deleted companies.
+func (m *UserMiddleware) WithDeletedCompanyAllowed(allow func(c echo.Context) bool) *UserMiddleware {
+	m.AllowDeletedCompany = allow
+	return m
+}
+
+// WithUserInContext authenticates the request using either an "Authorization: Bearer" header
+// or the session cookie, saving the session in the context if the access token is valid.
+// Bearer tokens may be either a Supabase access token or a personal access token.
+// Supabase access tokens of users whose company enforces SSO, or who must complete MFA, are rejected
+// with auth.ErrSSORequired or auth.ErrMFARequired, and bearer tokens used in a deleted company with
+// auth.ErrCompanyDeleted.
 func (m *UserMiddleware) WithUserInContext(next echo.HandlerFunc) echo.HandlerFunc {
-	logger := m.Logger.With("mw", "WithUserInContext")
 	return func(c echo.Context) error {
 		ctx := c.Request().Context()
+		logger := logging.FromContext(ctx, m.Logger).With("mw", "WithUserInContext")
+
+		if token, err := auth.BearerToken(c.Request()); err == nil {
+			var session *auth.SessionCookie
+			if auth.IsPersonalAccessToken(token) {
+				session, err = m.sessionFromPersonalAccessToken(ctx, token)
+			} else {
+				session, err = m.sessionFromBearerToken(ctx, token)
+			}
+			if err == nil {
+				err = m.rejectDeletedCompany(c, session)
+			}
+			if errors.Is(err, auth.ErrSSORequired) || errors.Is(err, auth.ErrMFARequired) || errors.Is(err, auth.ErrCompanyDeleted) {
+				return err
+			}
+			if err != nil {
+				logger.Debug("failed to authenticate bearer token", "error", err)
+				return next(c)
+			}
+			session.SaveInContext(c)
+			logSession(c, session)
+			return next(c)
+		}
 
 		session, err := auth.GetSessionFromCookie(c, m.Config.SessionSecret)
 		if err != nil {
@@ -50,28 +101,165 @@ func (m *UserMiddleware) WithUserInContext(next echo.HandlerFunc) echo.HandlerFu
 			return next(c)
 		}
 
+		// The user has not yet completed multi-factor authentication; only the MFA routes
+		// read the pending session from the cookie directly.
+		if session.MFAPending() {
+			return next(c)
+		}
+
+		// Suspended users keep a valid cookie until the access token expires, so their status
+		// is checked on every request rather than only when the session is refreshed.
+		if session.User != nil && session.Company != nil {
+			if user, err := m.UserStore.User(ctx, session.User.ID, session.Company.ID); err != nil || !user.Active() {
+				logger.Debug("rejected session of suspended or missing user", "error", err)
+				return next(c)
+			}
+			if err := m.rejectDeletedCompany(c, session); err != nil {
+				logger.Debug("rejected session of deleted company", "error", err)
+				return next(c)
+			}
+		}
+
 		if session.Expired() {
-			tokenResp, err := m.refreshSupabaseUser(ctx, session)
+			refreshed, err := m.refreshUser(ctx, session)
 			if err != nil {
-				logger.Debug("failed to refresh supabase user", "error", err)
+				logger.Debug("failed to refresh user", "error", err)
 				return next(c)
 			}
 
-			session = auth.NewSessionCookie(tokenResp.Session)
+			refreshedSession := auth.NewSessionCookie(*refreshed)
+			refreshedSession.User = session.User
+			refreshedSession.Company = session.Company
+			session = refreshedSession
+
 			if err := session.SetCookie(c, m.Config.SessionSecret, m.Config.Environment); err != nil {
 				logger.Error("failed to set cookie", "error", err)
 				return next(c)
 			}
 		}
 
+		claims, err := m.Verifier.Verify(ctx, session.AccessToken)
+		if err != nil {
+			logger.Debug("failed to verify session access token", "error", err)
+			return next(c)
+		}
+		if session.User == nil || claims.Subject != session.User.ID.String() {
+			logger.Debug("session access token subject does not match session user")
+			return next(c)
+		}
+
 		session.SaveInContext(c)
+		logSession(c, session)
 		return next(c)
 	}
 }
 
-// refreshSupabaseUser refreshes the user wit the refresh token, returning the new supabase.AuthenticatedDetails.
-// Returns an error if tokens are missing in the session or if there is an error refreshing via Supabase.
-func (m *UserMiddleware) refreshSupabaseUser(ctx context.Context, session *auth.SessionCookie) (*types.TokenResponse, error) {
+// rejectDeletedCompany returns auth.ErrCompanyDeleted if the company of the session has been deleted,
+// unless the request is allowed in deleted companies.
+func (m *UserMiddleware) rejectDeletedCompany(c echo.Context, session *auth.SessionCookie) error {
+	if m.AllowDeletedCompany != nil && m.AllowDeletedCompany(c) {
+		return nil
+	}
+	deleted, err := m.Policy.CompanyDeleted(c.Request().Context(), session.Company.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get company of session: %w", err)
+	}
+	if deleted {
+		return auth.ErrCompanyDeleted
+	}
+	return nil
+}
+
+// sessionFromBearerToken verifies the access token and builds a session for the user it was issued to,
+// in the company they log in to by default. Supabase issues the token without checking SSO or the
+// second factor, so it is only accepted for users who could log in with a password and no MFA.
+func (m *UserMiddleware) sessionFromBearerToken(ctx context.Context, token string) (*auth.SessionCookie, error) {
+	claims, err := m.Verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := m.UserStore.DefaultProfile(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user for bearer token: %w", err)
+	}
+	if !user.Active() {
+		return nil, ErrorUserDeactivated
+	}
+
+	ssoEnforced, err := m.Policy.SSOEnforced(ctx, user.CompanyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SAML configuration for bearer token: %w", err)
+	}
+	if ssoEnforced {
+		return nil, auth.ErrSSORequired
+	}
+
+	mfaState, err := m.Policy.MFAState(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA state for bearer token: %w", err)
+	}
+	if mfaState != "" {
+		return nil, auth.ErrMFARequired
+	}
+
+	session := &auth.SessionCookie{
+		AccessToken: token,
+		TokenType:   "bearer",
+		ExpiresAt:   claims.ExpiresAt.Unix(),
+		Company:     &auth.SessionCookieCompany{ID: user.CompanyID},
+	}
+	session.SetUser(user)
+	return session, nil
+}
+
+// sessionFromPersonalAccessToken looks up the personal access token and builds a session for its user.
+// The session Scope is set to the token permissions, if the token has been limited to a subset of permissions.
+func (m *UserMiddleware) sessionFromPersonalAccessToken(ctx context.Context, token string) (*auth.SessionCookie, error) {
+	pat, err := m.TokenStore.PersonalAccessTokenByHash(ctx, auth.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if pat.Revoked() {
+		return nil, ErrorTokenRevoked
+	}
+	if pat.Expired() {
+		return nil, ErrorTokenExpired
+	}
+
+	user, err := m.UserStore.User(ctx, pat.UserID, pat.CompanyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user for personal access token: %w", err)
+	}
+	if !user.Active() {
+		return nil, ErrorUserDeactivated
+	}
+
+	if err := m.TokenStore.TouchPersonalAccessToken(ctx, pat.ID); err != nil {
+		m.Logger.Error("failed to record personal access token use", "error", err)
+	}
+
+	session := &auth.SessionCookie{
+		TokenType: auth.TokenTypePersonalAccessToken,
+		ExpiresAt: pat.ExpiresAt.Unix(),
+		Company:   &auth.SessionCookieCompany{ID: pat.CompanyID},
+	}
+	session.SetUser(user)
+	if pat.Permissions != nil {
+		session.Scope = fn.Select(pat.Permissions, func(p string) security.Permission {
+			return security.Permission(p)
+		})
+	}
+	return session, nil
+}
+
+// refreshUser refreshes the user with the refresh token, returning the new session.
+// Returns an error if tokens are missing in the session or if there is an error refreshing via the auth provider.
+func (m *UserMiddleware) refreshUser(ctx context.Context, session *auth.SessionCookie) (*types.Session, error) {
 	if len(session.AccessToken) == 0 {
 		return nil, ErrorNoAccessToken
 	}
@@ -79,7 +267,7 @@ func (m *UserMiddleware) refreshSupabaseUser(ctx context.Context, session *auth.
 		return nil, ErrorNoRefreshToken
 	}
 
-	resp, err := m.Supabase.Auth.WithToken(session.AccessToken).RefreshToken(session.RefreshToken)
+	resp, err := m.AuthProvider.RefreshSession(ctx, session.RefreshToken)
 	if err != nil {
 		return nil, fmt.Errorf("failed to refresh user: %w", err)
 	}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// PersonalAccessToken represents the security.personal_access_tokens table.
+type PersonalAccessToken struct {
+	ID          uuid.UUID      `db:"id" json:"id"`
+	UserID      uuid.UUID      `db:"user_id" json:"userId"`
+	CompanyID   uuid.UUID      `db:"company_id" json:"companyId"`
+	Name        string         `db:"name" json:"name"`
+	TokenHash   string         `db:"token_hash" json:"-"`
+	TokenPrefix string         `db:"token_prefix" json:"tokenPrefix"`
+	Permissions pq.StringArray `db:"permissions" json:"permissions"`
+	ExpiresAt   time.Time      `db:"expires_at" json:"expiresAt"`
+	LastUsedAt  *time.Time     `db:"last_used_at" json:"lastUsedAt"`
+	RevokedAt   *time.Time     `db:"revoked_at" json:"revokedAt"`
+	CreatedAt   time.Time      `db:"created_at" json:"createdAt"`
+	UpdatedAt   *time.Time     `db:"updated_at" json:"updatedAt"`
+}
+
+// Expired returns true if the token ExpiresAt is in the past.
+func (t PersonalAccessToken) Expired() bool {
+	return time.Now().UTC().After(t.ExpiresAt)
+}
+
+// Revoked returns true if the token has been revoked.
+func (t PersonalAccessToken) Revoked() bool {
+	return t.RevokedAt != nil
+}
+
+// CreatePersonalAccessToken is a model used to create personal access tokens in the store.
+type CreatePersonalAccessToken struct {
+	UserID      uuid.UUID
+	CompanyID   uuid.UUID
+	Name        string
+	TokenHash   string
+	TokenPrefix string
+	// Permissions limits the token to a subset of the user's permissions.
+	// A nil slice grants all the user's permissions.
+	Permissions []string
+	ExpiresAt   time.Time
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"advancely/internal/model/settings"
 	"github.com/google/uuid"
 	"github.com/supabase-community/gotrue-go/types"
 	"time"
@@ -36,20 +37,116 @@ func (u *User) SupabaseUser() *types.User {
 
 // UserProfile represents a combination columns from the auth.users and public.profile tables
 type UserProfile struct {
-	ID        uuid.UUID  `db:"id" json:"id"`
-	CompanyID uuid.UUID  `db:"company_id" json:"companyId"`
-	FirstName string     `db:"first_name" json:"firstName"`
-	LastName  string     `db:"last_name" json:"lastName"`
-	Email     string     `db:"email" json:"email"`
-	IsAdmin   bool       `db:"is_admin" json:"-"`
+	ID        uuid.UUID `db:"id" json:"id"`
+	CompanyID uuid.UUID `db:"company_id" json:"companyId"`
+	FirstName string    `db:"first_name" json:"firstName"`
+	LastName  string    `db:"last_name" json:"lastName"`
+	Email     string    `db:"email" json:"email"`
+	IsAdmin   bool      `db:"is_admin" json:"-"`
+	// ExternalID is the ID of the user in the identity provider that provisioned them.
+	ExternalID    *string    `db:"external_id" json:"externalId,omitempty"`
+	DeactivatedAt *time.Time `db:"deactivated_at" json:"deactivatedAt,omitempty"`
+	// DeletedAt is set when the user is removed from the company, until they are restored or purged.
+	DeletedAt *time.Time `db:"deleted_at" json:"deletedAt,omitempty"`
 	CreatedAt time.Time  `db:"created_at" json:"createdAt"`
 	UpdatedAt *time.Time `db:"updated_at" json:"updatedAt"`
 }
 
+// Active returns true if the user has not been deactivated and may log in.
+func (u UserProfile) Active() bool {
+	return u.DeactivatedAt == nil
+}
+
+// Membership is a company the user is a member of, through their profile in the company.
+type Membership struct {
+	CompanyID     uuid.UUID  `db:"company_id" json:"companyId"`
+	CompanyName   string     `db:"company_name" json:"companyName"`
+	DeactivatedAt *time.Time `db:"deactivated_at" json:"deactivatedAt,omitempty"`
+	CreatedAt     time.Time  `db:"created_at" json:"createdAt"`
+}
+
+// Active returns true if the user has not been deactivated in the company.
+func (m Membership) Active() bool {
+	return m.DeactivatedAt == nil
+}
+
 type Company struct {
-	ID        uuid.UUID  `db:"id"`
-	Name      string     `db:"name"`
-	CreatorID uuid.UUID  `db:"creator_id"`
-	CreatedAt time.Time  `db:"created_at"`
-	UpdatedAt *time.Time `db:"updated_at"`
+	ID        uuid.UUID  `db:"id" json:"id"`
+	Name      string     `db:"name" json:"name"`
+	CreatorID uuid.UUID  `db:"creator_id" json:"creatorId"`
+	CreatedAt time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt *time.Time `db:"updated_at" json:"updatedAt"`
+	// DeletedAt is set when the owner deletes the company, which can be restored until PurgeAfter.
+	DeletedAt  *time.Time `db:"deleted_at" json:"deletedAt,omitempty"`
+	PurgeAfter *time.Time `db:"purge_after" json:"purgeAfter,omitempty"`
+}
+
+// OwnedBy returns true if the user owns the company. The owner is the user who created it.
+func (c Company) OwnedBy(userID uuid.UUID) bool {
+	return c.CreatorID != uuid.Nil && c.CreatorID == userID
+}
+
+// Deleted returns true if the company has been deleted and is waiting to be purged.
+func (c Company) Deleted() bool {
+	return c.DeletedAt != nil
+}
+
+// AllowedEmailDomain represents the public.allowed_email_domains table.
+type AllowedEmailDomain struct {
+	ID        int       `db:"id" json:"-"`
+	CompanyID uuid.UUID `db:"company_id" json:"-"`
+	Domain    string    `db:"domain" json:"domain"`
+	// VerificationToken is published by the company in a TXT record of the domain to prove it owns the domain.
+	VerificationToken string     `db:"verification_token" json:"verificationToken"`
+	VerifiedAt        *time.Time `db:"verified_at" json:"verifiedAt"`
+	CreatedAt         time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt         *time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+// Verified returns true if the company has proven it owns the domain.
+func (d AllowedEmailDomain) Verified() bool {
+	return d.VerifiedAt != nil
+}
+
+// CompanySettings represents the public.company_settings table.
+type CompanySettings struct {
+	CompanyID uuid.UUID       `db:"company_id" json:"-"`
+	Settings  settings.Values `db:"settings" json:"-"`
+	// Version is incremented by every change, starting from 0 for a company that has never saved its settings.
+	Version   int        `db:"version" json:"version"`
+	CreatedAt time.Time  `db:"created_at" json:"-"`
+	UpdatedAt *time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+// CompanySettingsVersion represents the public.company_settings_versions table.
+type CompanySettingsVersion struct {
+	CompanyID uuid.UUID       `db:"company_id" json:"-"`
+	Version   int             `db:"version" json:"version"`
+	Settings  settings.Values `db:"settings" json:"settings"`
+	ChangedBy *uuid.UUID      `db:"changed_by" json:"changedBy"`
+	CreatedAt time.Time       `db:"created_at" json:"createdAt"`
+}
+
+// CompanySAMLConfig represents the public.company_saml_configs table.
+type CompanySAMLConfig struct {
+	CompanyID          uuid.UUID  `db:"company_id" json:"-"`
+	IdPEntityID        string     `db:"idp_entity_id" json:"idpEntityId"`
+	IdPSSOURL          string     `db:"idp_sso_url" json:"idpSsoUrl"`
+	IdPCertificate     string     `db:"idp_certificate" json:"idpCertificate"`
+	EmailAttribute     string     `db:"email_attribute" json:"emailAttribute"`
+	FirstNameAttribute string     `db:"first_name_attribute" json:"firstNameAttribute"`
+	LastNameAttribute  string     `db:"last_name_attribute" json:"lastNameAttribute"`
+	EnforceSSO         bool       `db:"enforce_sso" json:"enforceSso"`
+	CreatedAt          time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt          *time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+// SCIMToken represents the security.scim_tokens table.
+type SCIMToken struct {
+	CompanyID   uuid.UUID  `db:"company_id" json:"-"`
+	TokenHash   string     `db:"token_hash" json:"-"`
+	TokenPrefix string     `db:"token_prefix" json:"tokenPrefix"`
+	LastUsedAt  *time.Time `db:"last_used_at" json:"lastUsedAt"`
+	CreatedAt   time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt   *time.Time `db:"updated_at" json:"updatedAt"`
 }
@@ -29,3 +29,13 @@ func (collection UserRoleCollection) HasPermission(name Permission) bool {
 	}
 	return false
 }
+
+// HasRole returns true if the user has the given role.
+func (collection UserRoleCollection) HasRole(role Role) bool {
+	for _, r := range collection.Roles {
+		if r.Role == role {
+			return true
+		}
+	}
+	return false
+}
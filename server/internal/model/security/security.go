@@ -26,10 +26,33 @@ const (
 	PermissionEditOrganizationSettings Permission = "edit-organization-settings"
 )
 
+// Permissions contains every permission known to the application.
+var Permissions = []Permission{
+	PermissionCreateUser,
+	PermissionEditUser,
+	PermissionDeleteUser,
+	PermissionCreateRole,
+	PermissionEditRole,
+	PermissionDeleteRole,
+	PermissionAssignUserRole,
+	PermissionRemoveUserRole,
+	PermissionEditOrganizationSettings,
+}
+
 func (p Permission) String() string {
 	return string(p)
 }
 
+// Valid returns true if the permission is known to the application.
+func (p Permission) Valid() bool {
+	for _, permission := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
 type Role string
 
 const (
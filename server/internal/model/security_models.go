@@ -1,6 +1,10 @@
 package model
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 // Role represents the security.roles table.
 type Role struct {
@@ -38,3 +42,19 @@ type RoleWithPermissions struct {
 	Role
 	Permissions []Permission `json:"permissions"`
 }
+
+// MFAFactor represents the security.mfa_factors table.
+type MFAFactor struct {
+	ID           uuid.UUID  `db:"id" json:"id"`
+	UserID       uuid.UUID  `db:"user_id" json:"userId"`
+	Secret       string     `db:"secret" json:"-"`
+	VerifiedAt   *time.Time `db:"verified_at" json:"verifiedAt"`
+	LastUsedStep *int64     `db:"last_used_step" json:"-"`
+	CreatedAt    time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt    *time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+// Verified returns true if the factor has been verified and can be used to log in.
+func (f MFAFactor) Verified() bool {
+	return f.VerifiedAt != nil
+}
@@ -0,0 +1,247 @@
+// Package settings is the registry of the settings a company can configure. Each setting has a Go type,
+// a default used until the company saves a value, and a validation applied to saved values.
+// The values saved by a company are stored as a single JSON object keyed by setting.
+package settings
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"advancely/internal/validation"
+)
+
+// Values are the settings saved by a company, keyed by setting. Settings without a value use their default.
+type Values map[string]json.RawMessage
+
+// Value encodes the values as a JSON object, so they can be stored in a jsonb column.
+func (v Values) Value() (driver.Value, error) {
+	if v == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]json.RawMessage(v))
+}
+
+// Scan decodes the values from a jsonb column.
+func (v *Values) Scan(src any) error {
+	var data []byte
+	switch src := src.(type) {
+	case []byte:
+		data = src
+	case string:
+		data = []byte(src)
+	case nil:
+		*v = Values{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into settings", src)
+	}
+	values := make(Values)
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
+	}
+	*v = values
+	return nil
+}
+
+// Setting is a setting of type T. Its value is read from the saved values with Get.
+type Setting[T any] struct {
+	Key     string
+	Default T
+	// Validate returns an error describing why the value is invalid, if it is.
+	Validate func(T) error
+}
+
+// Get returns the value of the setting, or its default if it has not been saved.
+func (s Setting[T]) Get(values Values) T {
+	raw, ok := values[s.Key]
+	if !ok {
+		return s.Default
+	}
+	value, err := s.decode(raw)
+	if err != nil {
+		// Saved values have been validated, but the validation may have since become stricter.
+		return s.Default
+	}
+	return value
+}
+
+func (s Setting[T]) decode(raw json.RawMessage) (T, error) {
+	var value T
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&value); err != nil {
+		return value, fmt.Errorf("must be %s", typeName(value))
+	}
+	if s.Validate != nil {
+		if err := s.Validate(value); err != nil {
+			return value, err
+		}
+	}
+	return value, nil
+}
+
+// normalize validates the raw value, returning it encoded as the setting's type.
+func (s Setting[T]) normalize(raw json.RawMessage) (json.RawMessage, error) {
+	value, err := s.decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+func (s Setting[T]) get(values Values) any {
+	return s.Get(values)
+}
+
+type definition interface {
+	normalize(raw json.RawMessage) (json.RawMessage, error)
+	get(values Values) any
+}
+
+var registry = make(map[string]definition)
+
+// register adds the setting to the registry. It panics if the key is already registered.
+func register[T any](s Setting[T]) Setting[T] {
+	if _, exists := registry[s.Key]; exists {
+		panic(fmt.Sprintf("setting %s is registered more than once", s.Key))
+	}
+	registry[s.Key] = s
+	return s
+}
+
+// Resolve returns the value of every registered setting, using the default of the settings without a value.
+// Saved values of settings that are no longer registered are omitted.
+func Resolve(values Values) map[string]any {
+	resolved := make(map[string]any, len(registry))
+	for key, def := range registry {
+		resolved[key] = def.get(values)
+	}
+	return resolved
+}
+
+// Apply returns the values with the changes applied. A null change resets the setting to its default.
+// All unknown settings and invalid values are returned as validation.Errors.
+func Apply(values Values, changes map[string]json.RawMessage) (Values, error) {
+	applied := make(Values, len(values)+len(changes))
+	for key, raw := range values {
+		applied[key] = raw
+	}
+
+	var errs validation.Errors
+	for _, key := range sortedKeys(changes) {
+		raw := changes[key]
+		def, ok := registry[key]
+		if !ok {
+			errs = append(errs, validation.FieldError{Field: key, Code: "unknown_setting", Message: "Is not a setting"})
+			continue
+		}
+		if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			delete(applied, key)
+			continue
+		}
+		normalized, err := def.normalize(raw)
+		if err != nil {
+			errs = append(errs, validation.FieldError{Field: key, Code: "invalid", Message: sentence(err.Error())})
+			continue
+		}
+		applied[key] = normalized
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return applied, nil
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func typeName(value any) string {
+	switch value.(type) {
+	case bool:
+		return "true or false"
+	case string:
+		return "a string"
+	case []int:
+		return "a list of integers"
+	default:
+		return fmt.Sprintf("a %T", value)
+	}
+}
+
+func sentence(s string) string {
+	if s == "" {
+		return s
+	}
+	return string(bytes.ToUpper([]byte(s[:1]))) + s[1:]
+}
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+func hexColor(s string) error {
+	if !hexColorPattern.MatchString(s) {
+		return errors.New("must be a hex colour such as #1d4ed8")
+	}
+	return nil
+}
+
+// maxDefaultRoles is the number of roles that can be assigned to new users by default.
+const maxDefaultRoles = 10
+
+func roleIDs(ids []int) error {
+	if len(ids) > maxDefaultRoles {
+		return fmt.Errorf("must have at most %d roles", maxDefaultRoles)
+	}
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if id <= 0 {
+			return errors.New("must only contain role IDs")
+		}
+		if seen[id] {
+			return fmt.Errorf("must not contain role %d more than once", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// The registered settings.
+var (
+	// DefaultRoleIDs are the roles assigned to users joining the company. Roles given explicitly
+	// when a user is invited replace the defaults.
+	DefaultRoleIDs = register(Setting[[]int]{
+		Key:      "defaultRoleIds",
+		Default:  []int{},
+		Validate: roleIDs,
+	})
+	// RequireMFA requires every user of the company to enroll in MFA before they can log in.
+	RequireMFA = register(Setting[bool]{
+		Key:     "requireMfa",
+		Default: false,
+	})
+	// RequireAdminMFA requires users with the Admin role to enroll in MFA before they can log in.
+	RequireAdminMFA = register(Setting[bool]{
+		Key:     "requireAdminMfa",
+		Default: false,
+	})
+	BrandPrimaryColor = register(Setting[string]{
+		Key:      "brandPrimaryColor",
+		Default:  "#1d4ed8",
+		Validate: hexColor,
+	})
+	BrandAccentColor = register(Setting[string]{
+		Key:      "brandAccentColor",
+		Default:  "#f59e0b",
+		Validate: hexColor,
+	})
+)
@@ -0,0 +1,65 @@
+package settings_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"advancely/internal/model/settings"
+	"advancely/internal/validation"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetReturnsDefaults(t *testing.T) {
+	values := settings.Values{}
+	require.Equal(t, []int{}, settings.DefaultRoleIDs.Get(values))
+	require.False(t, settings.RequireMFA.Get(values))
+	require.Equal(t, "#1d4ed8", settings.BrandPrimaryColor.Get(values))
+
+	// Values that are no longer valid fall back to the default.
+	values = settings.Values{settings.BrandPrimaryColor.Key: json.RawMessage(`"blue"`)}
+	require.Equal(t, "#1d4ed8", settings.BrandPrimaryColor.Get(values))
+}
+
+func TestApply(t *testing.T) {
+	values, err := settings.Apply(settings.Values{}, map[string]json.RawMessage{
+		"requireMfa":       json.RawMessage(`true`),
+		"brandAccentColor": json.RawMessage(`"#000000"`),
+		"defaultRoleIds":   json.RawMessage(`[1, 2]`),
+	})
+	require.NoError(t, err)
+	require.True(t, settings.RequireMFA.Get(values))
+	require.Equal(t, "#000000", settings.BrandAccentColor.Get(values))
+	require.Equal(t, []int{1, 2}, settings.DefaultRoleIDs.Get(values))
+
+	resolved := settings.Resolve(values)
+	require.Equal(t, true, resolved["requireMfa"])
+	require.Equal(t, "#1d4ed8", resolved["brandPrimaryColor"])
+
+	// A null value resets the setting to its default, leaving the other values as they are.
+	values, err = settings.Apply(values, map[string]json.RawMessage{"requireMfa": json.RawMessage(`null`)})
+	require.NoError(t, err)
+	require.NotContains(t, values, "requireMfa")
+	require.Equal(t, "#000000", settings.BrandAccentColor.Get(values))
+}
+
+func TestApplyInvalidValues(t *testing.T) {
+	_, err := settings.Apply(settings.Values{}, map[string]json.RawMessage{
+		"theme":             json.RawMessage(`"dark"`),
+		"requireMfa":        json.RawMessage(`"yes"`),
+		"requireAdminMfa":   json.RawMessage(`1`),
+		"brandAccentColor":  json.RawMessage(`"orange"`),
+		"defaultRoleIds":    json.RawMessage(`[1, 1]`),
+		"brandPrimaryColor": json.RawMessage(`"#000000"`),
+	})
+
+	var errs validation.Errors
+	require.ErrorAs(t, err, &errs)
+	require.Equal(t, validation.Errors{
+		{Field: "brandAccentColor", Code: "invalid", Message: "Must be a hex colour such as #1d4ed8"},
+		{Field: "defaultRoleIds", Code: "invalid", Message: "Must not contain role 1 more than once"},
+		{Field: "requireAdminMfa", Code: "invalid", Message: "Must be true or false"},
+		{Field: "requireMfa", Code: "invalid", Message: "Must be true or false"},
+		{Field: "theme", Code: "unknown_setting", Message: "Is not a setting"},
+	}, errs)
+}
@@ -9,61 +9,84 @@ import (
 
 	"advancely/internal/application"
 	"advancely/internal/auth"
+	mw "advancely/internal/middleware"
 	"advancely/internal/model"
 	"advancely/internal/model/security"
 	"advancely/internal/store"
 	"advancely/internal/validation"
-	"advancely/pkg/sbext"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/supabase-community/gotrue-go/types"
-	"github.com/supabase-community/supabase-go"
 )
 
 func NewAuthHandler(
-	supabaseClient *supabase.Client,
+	authProvider auth.Provider,
 	s *store.PostgresStore,
 	config application.AppConfig,
+	rateLimiter *mw.RateLimiter,
 	logger *slog.Logger,
 ) AuthHandler {
 	return AuthHandler{
-		Supabase:         sbext.NewSupabaseExtended(supabaseClient, config.Supabase),
+		AuthProvider:     authProvider,
 		UserStore:        s.UserStore,
 		CompanyStore:     s.CompanyStore,
 		PermissionsStore: s.PermissionsStore,
+		MFAStore:         s.MFAStore,
+		SettingsStore:    s.CompanySettingsStore,
 		Config:           config,
+		RateLimiter:      rateLimiter,
 		Logger:           logger,
 	}
 }
 
 type AuthHandler struct {
-	Supabase         *sbext.SupabaseExtended
+	AuthProvider     auth.Provider
 	UserStore        store.UserStore
 	CompanyStore     store.CompanyStore
 	PermissionsStore store.PermissionsStore
+	MFAStore         store.MFAStore
+	SettingsStore    store.CompanySettingsStore
 	Config           application.AppConfig
+	RateLimiter      *mw.RateLimiter
 	Logger           *slog.Logger
 }
 
 func (h AuthHandler) MakeRoutes(e *echo.Group) {
+	limits := h.Config.RateLimit
+
 	group := e.Group("/auth")
-	group.POST("/login", h.HandleLogin())
-	group.POST("/signup", h.handleSignup())
+	group.POST("/login", h.HandleLogin(),
+		h.RateLimiter.Limit("login", limits.Login),
+		h.RateLimiter.Lockout("login", limits.LoginLockout),
+	)
+	group.POST("/signup", h.handleSignup(),
+		h.RateLimiter.Limit("signup", limits.Signup),
+	)
 	group.POST("/logout", h.handleLogout())
-	group.POST("/confirm-email", h.handleVerifyEmailVerificationComplete())
-	group.POST("/reset-password", h.HandleTriggerPasswordReset())
-	group.POST("/reset-password/confirm", h.handleConfirmPasswordReset())
+	group.GET("/companies", h.HandleListCompanies())
+	group.POST("/switch-company", h.HandleSwitchCompany())
+	group.POST("/confirm-email", h.handleVerifyEmailVerificationComplete(),
+		h.RateLimiter.Limit("confirm-email", limits.ConfirmEmail),
+	)
+	group.POST("/reset-password", h.HandleTriggerPasswordReset(),
+		h.RateLimiter.Limit("reset-password", limits.PasswordReset),
+	)
+	group.POST("/reset-password/confirm", h.HandleConfirmPasswordReset(),
+		h.RateLimiter.Limit("reset-password-confirm", limits.PasswordResetConfirm),
+	)
+	group.GET("/oauth/:provider/start", h.HandleOAuthStart())
+	group.GET("/oauth/callback", h.HandleOAuthCallback())
 }
 
 func (h AuthHandler) handleLogout() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		user := auth.CurrentUser(c)
-		if err := h.Supabase.Auth.WithToken(user.AccessToken).Logout(); err != nil {
-			h.Logger.Error("Error logging out", "error", err)
+		if err := h.AuthProvider.Logout(c.Request().Context(), user.AccessToken); err != nil {
+			requestLogger(c, h.Logger).Error("Error logging out", "error", err)
 		}
 		if err := auth.DeleteSessionCookie(c, h.Config.SessionSecret); err != nil {
-			h.Logger.Error("Error deleting session cookie", "error", err)
+			requestLogger(c, h.Logger).Error("Error deleting session cookie", "error", err)
 		}
 		return c.NoContent(http.StatusNoContent)
 	}
@@ -74,55 +97,116 @@ type LoginRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
+// LoginMFAResponse is returned in place of the session when the user must complete multi-factor authentication.
+// The pending session is stored in the cookie and is upgraded by the /auth/mfa routes.
+type LoginMFAResponse struct {
+	Status string `json:"status"`
+}
+
 func (h AuthHandler) HandleLogin() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		var req LoginRequest
 		if err := validation.BindAndValidate(c, &req); err != nil {
-			h.Logger.Error("failed binding/validating login request", "error", err)
-			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			return err
 		}
 
-		token, err := h.Supabase.Auth.SignInWithEmailPassword(req.Email, req.Password)
+		token, err := h.AuthProvider.SignInWithEmailPassword(c.Request().Context(), req.Email, req.Password)
 		if err != nil {
-			if sbErr, isSbErr := sbext.NewError(err); isSbErr {
-				return echo.NewHTTPError(sbErr.Code, sbErr.Message)
+			// The auth error is kept so the lockout middleware can count invalid credentials.
+			if authErr, ok := auth.AsError(err); ok {
+				return echo.NewHTTPError(authErr.Status, authErr.Message).SetInternal(authErr)
 			}
-			h.Logger.Error("failed signing in with supabase", "error", err)
+			requestLogger(c, h.Logger).Error("failed signing in with auth provider", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 
-		session := auth.NewSessionCookie(token.Session)
+		session := auth.NewSessionCookie(*token)
 
-		user, err := h.UserStore.User(token.User.ID)
+		user, err := h.UserStore.DefaultProfile(ctx, token.User.ID)
 		if err != nil {
 			if errors.Is(err, store.ErrUserNotFound) {
 				return echo.NewHTTPError(http.StatusBadRequest, store.ErrUserNotFound)
 			}
-			h.Logger.Error("failed getting user from store", "error", err)
+			requestLogger(c, h.Logger).Error("failed getting user from store", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
+		if !user.Active() {
+			if err := h.AuthProvider.Logout(c.Request().Context(), token.AccessToken); err != nil {
+				requestLogger(c, h.Logger).Error("failed to revoke session of deactivated user", "error", err)
+			}
+			return echo.NewHTTPError(http.StatusForbidden, "Your account has been deactivated")
+		}
 
-		company, err := h.CompanyStore.Company(user.CompanyID)
+		company, err := h.CompanyStore.Company(ctx, user.CompanyID)
 		if err != nil {
 			if errors.Is(err, store.ErrCompanyNotFound) {
 				return echo.NewHTTPError(http.StatusBadRequest, store.ErrCompanyNotFound)
 			}
-			h.Logger.Error("failed getting company from store", "error", err)
+			requestLogger(c, h.Logger).Error("failed getting company from store", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
+		// The owner of a deleted company can still log in to restore it.
+		if company.Deleted() && !company.OwnedBy(user.ID) {
+			if err := h.AuthProvider.Logout(c.Request().Context(), token.AccessToken); err != nil {
+				requestLogger(c, h.Logger).Error("failed to revoke session of user of deleted company", "error", err)
+			}
+			return auth.ErrCompanyDeleted
+		}
+
+		ssoEnforced, err := h.ssoEnforced(c.Request().Context(), user.CompanyID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed getting SAML configuration", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		if ssoEnforced {
+			if err := h.AuthProvider.Logout(c.Request().Context(), token.AccessToken); err != nil {
+				requestLogger(c, h.Logger).Error("failed to revoke session of user required to use SSO", "error", err)
+			}
+			return echo.NewHTTPError(http.StatusForbidden, "Your company requires you to sign in with SSO")
+		}
 
 		session.SetUser(user)
 		session.SetCompany(company)
 
+		mfaState, err := h.mfaStateForUser(c.Request().Context(), user)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed determining MFA state", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		session.MFAState = mfaState
+
 		if err := session.SetCookie(c, h.Config.SessionSecret, h.Config.Environment); err != nil {
-			h.Logger.Error("failed setting session cookie", "error", err)
+			requestLogger(c, h.Logger).Error("failed setting session cookie", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 
+		if session.MFAPending() {
+			return c.JSON(http.StatusOK, LoginMFAResponse{Status: mfaState})
+		}
 		return c.JSON(http.StatusOK, session)
 	}
 }
 
+// ssoEnforced returns true if the company requires its users to log in through its SAML identity provider.
+func (h AuthHandler) ssoEnforced(ctx context.Context, companyID uuid.UUID) (bool, error) {
+	return h.sessionPolicy().SSOEnforced(ctx, companyID)
+}
+
+// mfaStateForUser returns the MFA state a new session for the user must start in, as decided by the session policy.
+func (h AuthHandler) mfaStateForUser(ctx context.Context, user model.UserProfile) (string, error) {
+	return h.sessionPolicy().MFAState(ctx, user)
+}
+
+func (h AuthHandler) sessionPolicy() auth.SessionPolicy {
+	return auth.SessionPolicy{
+		CompanyStore:     h.CompanyStore,
+		MFAStore:         h.MFAStore,
+		SettingsStore:    h.SettingsStore,
+		PermissionsStore: h.PermissionsStore,
+	}
+}
+
 // handleSignup signs the user up via Supabase and adds records to the companies and profiles tables.
 // This function handles instances where the auth.user, company and profile rows may already exist
 // due to any previous errored runs.
@@ -143,48 +227,45 @@ func (h AuthHandler) handleSignup() echo.HandlerFunc {
 		CompanyName   string `json:"companyName"`
 	}
 
-	// getOrSignupSupabaseUser is an idempotent function for signing up with Supabase auth.
-	// If the user already exists, the supabase.User is returned, otherwise signup is completed.
-	getOrSignupSupabaseUser := func(ctx context.Context, form formParams) (*types.User, error) {
-		existingUser, err := h.UserStore.BaseUserByEmail(form.UserEmail)
+	// getOrSignupUser is an idempotent function for signing up with the auth provider.
+	// If the user already exists, the types.User is returned, otherwise signup is completed.
+	getOrSignupUser := func(ctx context.Context, form formParams) (*types.User, error) {
+		existingUser, err := h.UserStore.BaseUserByEmail(ctx, form.UserEmail)
 		if errors.Is(err, store.ErrUserNotFound) {
-			resp, err := h.Supabase.Auth.Signup(types.SignupRequest{
+			return h.AuthProvider.SignUp(ctx, types.SignupRequest{
 				Email:    form.UserEmail,
 				Password: form.Password,
 				Data: map[string]interface{}{
 					"company_name": form.CompanyName,
 				},
 			})
-			// Signup returns either different response depending on the autoconfirm setting.
-			// User if autoconfirm is off, Session if on.
-			return &resp.User, err
 		}
 		return existingUser.SupabaseUser(), err
 	}
 
-	getOrCreateCompany := func(company model.Company) (model.Company, error) {
-		existingCompany, err := h.CompanyStore.CompanyByCreator(company.CreatorID)
+	getOrCreateCompany := func(ctx context.Context, company model.Company) (model.Company, error) {
+		existingCompany, err := h.CompanyStore.CompanyByCreator(ctx, company.CreatorID)
 		if err == nil {
 			return existingCompany, nil
 		}
 		if !errors.Is(err, store.ErrCompanyNotFound) {
 			return model.Company{}, err
 		}
-		if err := h.CompanyStore.CreateCompany(&company); err != nil {
+		if err := h.CompanyStore.CreateCompany(ctx, &company); err != nil {
 			return model.Company{}, err
 		}
 		return company, nil
 	}
 
-	getOrCreateUserProfile := func(user store.CreateProfileRequest) (model.UserProfile, error) {
-		existingUser, err := h.UserStore.User(user.UserID)
+	getOrCreateUserProfile := func(ctx context.Context, user store.CreateProfileRequest) (model.UserProfile, error) {
+		existingUser, err := h.UserStore.User(ctx, user.UserID, user.CompanyID)
 		if err == nil {
 			return existingUser, nil
 		}
 		if !errors.Is(err, store.ErrUserNotFound) {
 			return model.UserProfile{}, err
 		}
-		return h.UserStore.CreateProfile(user)
+		return h.UserStore.CreateProfile(ctx, user)
 	}
 
 	return func(c echo.Context) error {
@@ -192,36 +273,36 @@ func (h AuthHandler) handleSignup() echo.HandlerFunc {
 
 		var form formParams
 		if err := validation.BindAndValidate(c, &form); err != nil {
-			h.Logger.Error("failed to bind/validate signup request", "error", err)
+			requestLogger(c, h.Logger).Error("failed to bind/validate signup request", "error", err)
 			return err
 		}
 
-		user, err := getOrSignupSupabaseUser(ctx, form)
+		user, err := getOrSignupUser(ctx, form)
 		if err != nil {
-			h.Logger.Error("failed to sign up in supabase", "error", err)
+			requestLogger(c, h.Logger).Error("failed to sign up with auth provider", "error", err)
 			msg := fmt.Sprintf("Failed to create user with email address %s.", form.UserEmail)
 			return echo.NewHTTPError(500, msg)
 		}
 		if err != nil {
-			h.Logger.Error("failed to parse user ID", "error", err)
+			requestLogger(c, h.Logger).Error("failed to parse user ID", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError, "Invalid user ID.")
 		}
 
 		// CreateCompany the company
 
-		company, err := getOrCreateCompany(model.Company{
+		company, err := getOrCreateCompany(ctx, model.Company{
 			Name:      form.CompanyName,
 			CreatorID: user.ID,
 		})
 		if err != nil {
-			h.Logger.Error("failed to create company", "error", err)
+			requestLogger(c, h.Logger).Error("failed to create company", "error", err)
 			msg := fmt.Sprintf("Failed to create company with name %s.", form.CompanyName)
 			return echo.NewHTTPError(http.StatusInternalServerError, msg)
 		}
 
 		// CreateCompany the initial admin user profile
 
-		profile, err := getOrCreateUserProfile(store.CreateProfileRequest{
+		profile, err := getOrCreateUserProfile(ctx, store.CreateProfileRequest{
 			UserID:    user.ID,
 			CompanyID: company.ID,
 			FirstName: form.UserFirstName,
@@ -229,15 +310,15 @@ func (h AuthHandler) handleSignup() echo.HandlerFunc {
 			IsAdmin:   true,
 		})
 		if err != nil {
-			h.Logger.Error("failed to create user profile", "error", err)
+			requestLogger(c, h.Logger).Error("failed to create user profile", "error", err)
 			return echo.NewHTTPError(500, "Failed to create your user profile")
 		}
 
 		// Assign the Admin role to the user
 
-		err = h.PermissionsStore.AssignSystemRoleToUser(security.RoleAdmin, user.ID, company.ID)
+		err = h.PermissionsStore.AssignSystemRoleToUser(ctx, security.RoleAdmin, user.ID, company.ID)
 		if err != nil {
-			h.Logger.Error("failed to add admin role to user", "error", err)
+			requestLogger(c, h.Logger).Error("failed to add admin role to user", "error", err)
 			return echo.NewHTTPError(500, "Failed to assign appropriate permissions to your user")
 		}
 
@@ -259,13 +340,13 @@ func (h AuthHandler) handleVerifyEmailVerificationComplete() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var req Request
 		if err := validation.BindAndValidate(c, &req); err != nil {
-			h.Logger.Error("failed to bind/validate verification request", "error", err)
+			requestLogger(c, h.Logger).Error("failed to bind/validate verification request", "error", err)
 			return err
 		}
 
-		user, err := h.Supabase.Auth.WithToken(req.Token).GetUser()
+		user, err := h.AuthProvider.GetUser(c.Request().Context(), req.Token)
 		if err != nil || user == nil || user.ID == uuid.Nil {
-			h.Logger.Error("failed to verify user", "error", err)
+			requestLogger(c, h.Logger).Error("failed to verify user", "error", err)
 			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
 		}
 
@@ -290,8 +371,8 @@ func (h AuthHandler) HandleTriggerPasswordReset() echo.HandlerFunc {
 		}
 
 		redirect := h.Config.ClientBaseURL + "/auth/password-reset"
-		if err := h.Supabase.Extensions.ResetPasswordForEmail(ctx, req.Email, redirect); err != nil {
-			h.Logger.Error("failed to trigger password reset", "error", err)
+		if err := h.AuthProvider.ResetPasswordForEmail(ctx, req.Email, redirect); err != nil {
+			requestLogger(c, h.Logger).Error("failed to trigger password reset", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 		return c.NoContent(http.StatusNoContent)
@@ -308,29 +389,28 @@ type ConfirmPasswordResetRequest struct {
 	NewPassword string `json:"password"`
 }
 
-func (h AuthHandler) handleConfirmPasswordReset() echo.HandlerFunc {
+func (h AuthHandler) HandleConfirmPasswordReset() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
 		var req ConfirmPasswordResetRequest
 		if err := validation.BindAndValidate(c, &req); err != nil {
 			return err
 		}
 
 		// redirectTo seems to serve no purpose here, but is required.
-		_, err := h.Supabase.Auth.VerifyForUser(types.VerifyForUserRequest{
+		session, err := h.AuthProvider.VerifyOTP(ctx, types.VerifyForUserRequest{
 			Type:       "recovery",
 			Token:      req.OTP,
 			Email:      req.Email,
 			RedirectTo: h.Config.ClientBaseURL + "/auth/password-reset",
 		})
-
-		// The response from the above Supabase method seems to come in the error as JSON.
-		// First we attempt to parse a success response from the error.
-		session, jsonParseErr := sbext.ParseSessionFromErrJson(err)
-		if jsonParseErr != nil && err != nil {
-			sbErr, isSbErr := sbext.NewError(err)
-			if isSbErr && sbErr.ErrorCode == sbext.ErrorCodeOTPExpired {
+		if err != nil {
+			authErr, ok := auth.AsError(err)
+			if ok && authErr.Code == auth.ErrorCodeOTPExpired {
 				return echo.NewHTTPError(http.StatusUnauthorized, "OTP is invalid or has expired")
 			}
+			requestLogger(c, h.Logger).Error("failed to verify OTP", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 		if session == nil || session.AccessToken == "" {
@@ -339,19 +419,16 @@ func (h AuthHandler) handleConfirmPasswordReset() echo.HandlerFunc {
 
 		cookie := auth.NewSessionCookie(*session)
 		if err := cookie.SetCookie(c, h.Config.SessionSecret, h.Config.Environment); err != nil {
-			h.Logger.Error("failed to set session cookie", "error", err)
+			requestLogger(c, h.Logger).Error("failed to set session cookie", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 
-		_, err = h.Supabase.Auth.WithToken(session.AccessToken).UpdateUser(types.UpdateUserRequest{
-			Password: &req.NewPassword,
-		})
-		if err != nil {
-			sbErr, isSbErr := sbext.NewError(err)
-			if isSbErr && sbErr.ErrorCode == sbext.ErrorCodeSamePassword {
-				return echo.NewHTTPError(http.StatusBadRequest, sbErr.Message)
+		if err := h.AuthProvider.UpdatePassword(ctx, session.AccessToken, req.NewPassword); err != nil {
+			authErr, ok := auth.AsError(err)
+			if ok && authErr.Code == auth.ErrorCodeSamePassword {
+				return echo.NewHTTPError(http.StatusBadRequest, authErr.Message)
 			}
-			h.Logger.Error("failed to update user password", "error", err)
+			requestLogger(c, h.Logger).Error("failed to update user password", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 
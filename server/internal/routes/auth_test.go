@@ -14,12 +14,14 @@ import (
 	"testing"
 )
 
-func newTestAuthHandler(t *testing.T, db *sqlx.DB) routes.AuthHandler {
+func newTestAuthHandler(db *sqlx.DB, authProvider auth.Provider) routes.AuthHandler {
 	return routes.AuthHandler{
-		Supabase:         tests.NewTestSupabaseExtended(t),
+		AuthProvider:     authProvider,
 		UserStore:        store.NewPostgresUserStore(db),
 		CompanyStore:     store.NewPostgresCompanyStore(db),
 		PermissionsStore: store.NewPostgresPermissionsStore(db),
+		MFAStore:         store.NewPostgresMFAStore(db),
+		SettingsStore:    store.NewPostgresCompanySettingsStore(db),
 		Config: application.AppConfig{
 			SessionSecret: "session.secret",
 		},
@@ -29,15 +31,15 @@ func newTestAuthHandler(t *testing.T, db *sqlx.DB) routes.AuthHandler {
 
 func TestAuthLogin(t *testing.T) {
 	db := tests.SetUpTestDatabase(t)
-	sb := tests.NewTestSupabaseClient(t)
-	user := tests.SignUpAdminUser(t, sb, db)
+	authProvider := tests.NewFakeAuthProvider()
+	user := tests.SignUpAdminUser(t, authProvider, db)
 
 	payload := map[string]string{
 		"email":    user.Email,
 		"password": tests.DefaultUserPassword,
 	}
 	c, rec := tests.NewRequestRecorder(t, http.MethodPost, "/login", payload)
-	handler := newTestAuthHandler(t, db)
+	handler := newTestAuthHandler(db, authProvider)
 	err := handler.HandleLogin()(c)
 	require.NoError(t, err)
 	require.NotNil(t, rec)
@@ -74,12 +76,11 @@ func TestAuthLoginWithInvalidRequest(t *testing.T) {
 		},
 	}
 
-	db := tests.SetUpTestDatabase(t)
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			c, _ := tests.NewRequestRecorder(t, http.MethodPost, "/login", tc.payload)
 
-			handler := newTestAuthHandler(t, db)
+			handler := newTestAuthHandler(nil, tests.NewFakeAuthProvider())
 			err := handler.HandleLogin()(c)
 			if err != nil {
 				c.Error(err)
@@ -91,13 +92,12 @@ func TestAuthLoginWithInvalidRequest(t *testing.T) {
 }
 
 func TestAuthLoginWhenUserDoesNotExist(t *testing.T) {
-	db := tests.SetUpTestDatabase(t)
 	payload := map[string]string{
 		"email":    "unknown-user@domain.com",
 		"password": "password",
 	}
 	c, _ := tests.NewRequestRecorder(t, http.MethodPost, "/login", payload)
-	handler := newTestAuthHandler(t, db)
+	handler := newTestAuthHandler(nil, tests.NewFakeAuthProvider())
 	err := handler.HandleLogin()(c)
 
 	assertHTTPError(t, err, http.StatusBadRequest, "Invalid login credentials")
@@ -105,34 +105,30 @@ func TestAuthLoginWhenUserDoesNotExist(t *testing.T) {
 
 func TestAuthLoginWhenCompanyAndProfileDoesNotExist(t *testing.T) {
 	db := tests.SetUpTestDatabase(t)
-	sb := tests.NewTestSupabaseClient(t)
-	user := tests.CreateAdminUser(t, sb, db)
+	authProvider := tests.NewFakeAuthProvider()
+	user := tests.CreateAdminUser(t, authProvider, db)
 
 	payload := map[string]string{
 		"email":    user.Email,
 		"password": tests.DefaultUserPassword,
 	}
 	c, _ := tests.NewRequestRecorder(t, http.MethodPost, "/login", payload)
-	handler := newTestAuthHandler(t, db)
+	handler := newTestAuthHandler(db, authProvider)
 	err := handler.HandleLogin()(c)
 
 	assertHTTPError(t, err, http.StatusBadRequest, store.ErrUserNotFound)
 }
 
 func TestTriggerPasswordReset(t *testing.T) {
-	db := tests.SetUpTestDatabase(t)
-	sb := tests.NewTestSupabaseClient(t)
-	user := tests.SignUpAdminUser(t, sb, db)
-
 	testCases := []struct {
-		name                  string
-		email                 string
-		expectedStatus        int
-		expectDatabaseUpdated bool
+		name                 string
+		email                string
+		expectedStatus       int
+		expectResetRequested bool
 	}{
 		{
 			"success",
-			user.Email,
+			tests.DefaultUserEmail,
 			http.StatusNoContent,
 			true,
 		},
@@ -146,21 +142,71 @@ func TestTriggerPasswordReset(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
+			authProvider := tests.NewFakeAuthProvider()
+			authProvider.AddUser(tests.NewAuthUser(tests.DefaultUserEmail), tests.DefaultUserPassword)
+
 			payload := map[string]string{"email": tc.email}
 			c, rec := tests.NewRequestRecorder(t, http.MethodPost, "/auth/password-reset", payload)
 
-			handler := newTestAuthHandler(t, db)
+			handler := newTestAuthHandler(nil, authProvider)
 			err := handler.HandleTriggerPasswordReset()(c)
 			require.NoError(t, err)
 			require.Equal(t, tc.expectedStatus, rec.Code)
 
-			if tc.expectDatabaseUpdated {
-				var recoveryToken string
-				stmt := "select recovery_token from auth.users where id = $1;"
-				err = db.Get(&recoveryToken, stmt, user.ID)
-				require.NoError(t, err)
-				require.NotEmpty(t, recoveryToken)
+			if tc.expectResetRequested {
+				require.Equal(t, []string{tc.email}, authProvider.PasswordResets)
+			} else {
+				require.Empty(t, authProvider.PasswordResets)
+			}
+		})
+	}
+}
+
+func TestConfirmPasswordReset(t *testing.T) {
+	testCases := []struct {
+		name           string
+		otp            string
+		password       string
+		expectedStatus int
+	}{
+		{
+			name:           "success",
+			otp:            "123456",
+			password:       "new-password",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "invalid OTP",
+			otp:            "654321",
+			password:       "new-password",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "same password",
+			otp:            "123456",
+			password:       tests.DefaultUserPassword,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			authProvider := tests.NewFakeAuthProvider()
+			authProvider.AddUser(tests.NewAuthUser(tests.DefaultUserEmail), tests.DefaultUserPassword)
+			authProvider.SetOTP(tests.DefaultUserEmail, "123456")
+
+			payload := routes.ConfirmPasswordResetRequest{
+				OneTimePassword: routes.OneTimePassword{OTP: tc.otp},
+				Email:           tests.DefaultUserEmail,
+				NewPassword:     tc.password,
+			}
+			c, rec := tests.NewRequestRecorder(t, http.MethodPost, "/auth/reset-password/confirm", payload)
+
+			handler := newTestAuthHandler(nil, authProvider)
+			if err := handler.HandleConfirmPasswordReset()(c); err != nil {
+				c.Error(err)
 			}
+			require.Equal(t, tc.expectedStatus, rec.Code)
 		})
 	}
 }
@@ -1,36 +1,229 @@
 package routes
 
 import (
+	"advancely/internal/application"
 	"advancely/internal/auth"
+	"advancely/internal/model"
 	"advancely/internal/model/security"
 	"advancely/internal/store"
 	"advancely/internal/validation"
 	"errors"
 	"github.com/labstack/echo/v4"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
 func NewCompaniesHandler(
 	s *store.PostgresStore,
+	config application.AppConfig,
 	logger *slog.Logger,
 	ensurePermissionFn EnsurePermissionFn) CompaniesHandler {
 	return CompaniesHandler{
+		CompanyStore:         s.CompanyStore,
 		CompanySettingsStore: s.CompanySettingsStore,
+		PermissionsStore:     s.PermissionsStore,
+		SCIMTokenStore:       s.SCIMTokenStore,
+		LookupTXT:            net.DefaultResolver.LookupTXT,
+		Config:               config,
 		Logger:               logger,
 		EnsurePermission:     ensurePermissionFn,
 	}
 }
 
 type CompaniesHandler struct {
+	CompanyStore         store.CompanyStore
 	CompanySettingsStore store.CompanySettingsStore
-	Logger               *slog.Logger
-	EnsurePermission     EnsurePermissionFn
+	PermissionsStore     store.PermissionsStore
+	SCIMTokenStore       store.SCIMTokenStore
+	// LookupTXT looks up the TXT records used to verify that the company owns its allowed email domains.
+	LookupTXT        validation.LookupTXTFn
+	Config           application.AppConfig
+	Logger           *slog.Logger
+	EnsurePermission EnsurePermissionFn
 }
 
 func (h CompaniesHandler) MakeRoutes(e *echo.Group) {
-	group := e.Group("/company/settings")
+	company := e.Group("/company")
+	company.GET("", h.HandleGetCompany())
+	company.PUT("", h.HandleUpdateCompany())
+	company.POST("/deletion-token", h.HandleCreateDeletionToken())
+	company.DELETE("", h.HandleDeleteCompany())
+	company.POST("/restore", h.HandleRestoreCompany())
+
+	group := company.Group("/settings")
+	group.GET("", h.HandleGetSettings())
+	group.PATCH("", h.HandleUpdateSettings())
+	group.GET("/versions", h.HandleGetSettingsVersions())
+	group.POST("/versions/:version/rollback", h.HandleRollbackSettings())
+	group.GET("/domain", h.HandleGetAllowedDomains())
 	group.POST("/domain", h.HandleAddAllowedDomain())
+	group.POST("/domain/:domain/verify", h.HandleVerifyAllowedDomain())
+	group.GET("/saml", h.HandleGetSAMLConfig())
+	group.PUT("/saml", h.HandleSaveSAMLConfig())
+	group.DELETE("/saml", h.HandleDeleteSAMLConfig())
+	group.GET("/scim-token", h.HandleGetSCIMToken())
+	group.POST("/scim-token", h.HandleCreateSCIMToken())
+	group.DELETE("/scim-token", h.HandleDeleteSCIMToken())
+}
+
+// confirmActionDeleteCompany is the action of the confirmation tokens required to delete a company.
+const confirmActionDeleteCompany = "delete-company"
+
+func (h CompaniesHandler) HandleGetCompany() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+		company, err := h.CompanyStore.Company(c.Request().Context(), user.Company.ID)
+		if err != nil {
+			if errors.Is(err, store.ErrCompanyNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
+			}
+			requestLogger(c, h.Logger).Error("failed to get company", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, company)
+	}
+}
+
+type UpdateCompanyRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+func (h CompaniesHandler) HandleUpdateCompany() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+
+		var req UpdateCompanyRequest
+		if err := validation.BindAndValidate(c, &req); err != nil {
+			return err
+		}
+
+		company := model.Company{ID: user.Company.ID, Name: strings.TrimSpace(req.Name)}
+		if company.Name == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "The company name cannot be blank")
+		}
+		if err := h.CompanyStore.UpdateCompany(c.Request().Context(), &company); err != nil {
+			if errors.Is(err, store.ErrCompanyNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
+			}
+			requestLogger(c, h.Logger).Error("failed to update company", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, company)
+	}
+}
+
+// ownedCompany returns the company of the current user, or a 403 error if the user does not own it.
+func (h CompaniesHandler) ownedCompany(c echo.Context) (model.Company, error) {
+	user := auth.CurrentUser(c)
+	company, err := h.CompanyStore.Company(c.Request().Context(), user.Company.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrCompanyNotFound) {
+			return model.Company{}, echo.NewHTTPError(http.StatusNotFound, err)
+		}
+		requestLogger(c, h.Logger).Error("failed to get company", "error", err)
+		return model.Company{}, echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	if !company.OwnedBy(user.User.ID) {
+		return model.Company{}, echo.NewHTTPError(http.StatusForbidden, "Only the owner of the company can delete or restore it")
+	}
+	return company, nil
+}
+
+// DeletionTokenResponse contains the token confirming the deletion of the company, which must be sent
+// to DELETE /company before it expires.
+type DeletionTokenResponse struct {
+	ConfirmationToken string    `json:"confirmationToken"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+}
+
+// HandleCreateDeletionToken issues the token confirming the owner intends to delete the company,
+// so that the company cannot be deleted by a single request.
+func (h CompaniesHandler) HandleCreateDeletionToken() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		company, err := h.ownedCompany(c)
+		if err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+		token, expiresAt := auth.NewConfirmationToken(h.Config.SessionSecret, confirmActionDeleteCompany, company.ID, user.User.ID, time.Now())
+		return c.JSON(http.StatusCreated, DeletionTokenResponse{ConfirmationToken: token, ExpiresAt: expiresAt})
+	}
+}
+
+type DeleteCompanyRequest struct {
+	ConfirmationToken string `json:"confirmationToken" validate:"required"`
+}
+
+// HandleDeleteCompany deletes the company once the owner has confirmed it with a token from
+// HandleCreateDeletionToken. The company can be restored until the grace period ends,
+// after which it is purged along with its users.
+func (h CompaniesHandler) HandleDeleteCompany() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		company, err := h.ownedCompany(c)
+		if err != nil {
+			return err
+		}
+
+		var req DeleteCompanyRequest
+		if err := validation.BindAndValidate(c, &req); err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+		now := time.Now()
+		if err := auth.VerifyConfirmationToken(
+			h.Config.SessionSecret, req.ConfirmationToken, confirmActionDeleteCompany, company.ID, user.User.ID, now,
+		); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		deleted, err := h.CompanyStore.ScheduleCompanyDeletion(c.Request().Context(), company.ID, now.Add(h.Config.CompanyDeletionGracePeriod))
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to delete company", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		requestLogger(c, h.Logger).Info("company deleted", "company_id", deleted.ID, "purge_after", deleted.PurgeAfter)
+		return c.JSON(http.StatusAccepted, deleted)
+	}
+}
+
+// IsRestoreCompany returns true for requests restoring the company, which is the only request
+// the owner of a deleted company can make.
+func IsRestoreCompany(c echo.Context) bool {
+	return c.Request().Method == http.MethodPost && c.Path() == "/api/v1/company/restore"
+}
+
+// HandleRestoreCompany cancels the deletion of the company during its grace period.
+func (h CompaniesHandler) HandleRestoreCompany() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		company, err := h.ownedCompany(c)
+		if err != nil {
+			return err
+		}
+
+		restored, err := h.CompanyStore.RestoreCompany(c.Request().Context(), company.ID)
+		if err != nil {
+			if errors.Is(err, store.ErrCompanyNotDeleted) {
+				return echo.NewHTTPError(http.StatusConflict, err)
+			}
+			requestLogger(c, h.Logger).Error("failed to restore company", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, restored)
+	}
 }
 
 type AddAllowedDomainRequest struct {
@@ -49,7 +242,7 @@ func (h CompaniesHandler) HandleAddAllowedDomain() echo.HandlerFunc {
 
 		var req AddAllowedDomainRequest
 		if err := validation.BindAndValidate(c, &req); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err)
+			return err
 		}
 
 		if err := validation.ValidateDomain(req.Domain); err != nil {
@@ -58,12 +251,290 @@ func (h CompaniesHandler) HandleAddAllowedDomain() echo.HandlerFunc {
 			}
 		}
 
-		if err := h.CompanySettingsStore.AddAllowedEmailDomain(ctx, user.Company.ID, req.Domain); err != nil {
+		domain, err := h.CompanySettingsStore.AddAllowedEmailDomain(ctx, user.Company.ID, req.Domain)
+		if err != nil {
 			if errors.Is(err, store.ErrDomainAlreadyExists) {
 				return echo.NewHTTPError(http.StatusConflict, err)
 			}
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
-		return c.NoContent(http.StatusCreated)
+		return c.JSON(http.StatusCreated, newAllowedDomainResponse(domain))
+	}
+}
+
+// AllowedDomainResponse is an allowed email domain along with the TXT record the company must add to
+// the domain's DNS to verify that it owns the domain.
+type AllowedDomainResponse struct {
+	model.AllowedEmailDomain
+	VerificationRecord string `json:"verificationRecord"`
+}
+
+func newAllowedDomainResponse(domain model.AllowedEmailDomain) AllowedDomainResponse {
+	return AllowedDomainResponse{
+		AllowedEmailDomain: domain,
+		VerificationRecord: validation.DomainVerificationPrefix + domain.VerificationToken,
+	}
+}
+
+func (h CompaniesHandler) HandleGetAllowedDomains() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+		domains, err := h.CompanySettingsStore.AllowedEmailDomains(c.Request().Context(), user.Company.ID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to get allowed email domains", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		resp := make([]AllowedDomainResponse, len(domains))
+		for i, domain := range domains {
+			resp[i] = newAllowedDomainResponse(domain)
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+// HandleVerifyAllowedDomain verifies that the company owns the allowed email domain, by looking up the TXT record
+// with its verification token. Only verified domains can be used to log in with SSO, and each domain can only
+// be verified by one company.
+func (h CompaniesHandler) HandleVerifyAllowedDomain() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		user := auth.CurrentUser(c)
+
+		domain, err := h.CompanySettingsStore.AllowedEmailDomain(ctx, user.Company.ID, c.Param("domain"))
+		if err != nil {
+			if errors.Is(err, store.ErrDomainNotFound) {
+				return err
+			}
+			requestLogger(c, h.Logger).Error("failed to get allowed email domain", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		if domain.Verified() {
+			return c.JSON(http.StatusOK, newAllowedDomainResponse(domain))
+		}
+
+		if err := validation.VerifyDomainOwnership(ctx, h.LookupTXT, domain.Domain, domain.VerificationToken); err != nil {
+			if errors.Is(err, validation.ErrDomainNotVerified) {
+				return err
+			}
+			requestLogger(c, h.Logger).Error("failed to verify domain ownership", "error", err)
+			return echo.NewHTTPError(http.StatusBadGateway, "Failed to look up the DNS records of the domain")
+		}
+
+		domain, err = h.CompanySettingsStore.VerifyAllowedEmailDomain(ctx, user.Company.ID, domain.Domain)
+		if err != nil {
+			if errors.Is(err, store.ErrDomainVerifiedByAnotherCompany) {
+				return err
+			}
+			requestLogger(c, h.Logger).Error("failed to verify allowed email domain", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, newAllowedDomainResponse(domain))
+	}
+}
+
+// SAMLConfigResponse is the company's SAML configuration along with the service provider
+// URLs the company's administrator needs to configure their identity provider.
+type SAMLConfigResponse struct {
+	model.CompanySAMLConfig
+	SPMetadataURL string `json:"spMetadataUrl"`
+	SPACSURL      string `json:"spAcsUrl"`
+}
+
+func (h CompaniesHandler) samlConfigResponse(cfg model.CompanySAMLConfig) SAMLConfigResponse {
+	return SAMLConfigResponse{
+		CompanySAMLConfig: cfg,
+		SPMetadataURL:     SAMLMetadataURL(h.Config, cfg.CompanyID),
+		SPACSURL:          SAMLACSURL(h.Config, cfg.CompanyID),
+	}
+}
+
+func (h CompaniesHandler) HandleGetSAMLConfig() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+		cfg, err := h.CompanySettingsStore.SAMLConfig(c.Request().Context(), user.Company.ID)
+		if err != nil {
+			if errors.Is(err, store.ErrSAMLConfigNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
+			}
+			requestLogger(c, h.Logger).Error("failed to get SAML configuration", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, h.samlConfigResponse(cfg))
+	}
+}
+
+// SaveSAMLConfigRequest configures the company's identity provider, either from its metadata
+// document or from the entity ID, SSO URL and certificate entered individually.
+type SaveSAMLConfigRequest struct {
+	MetadataXML        string `json:"metadataXml"`
+	IdPEntityID        string `json:"idpEntityId" validate:"required_without=MetadataXML"`
+	IdPSSOURL          string `json:"idpSsoUrl" validate:"required_without=MetadataXML"`
+	IdPCertificate     string `json:"idpCertificate" validate:"required_without=MetadataXML"`
+	EmailAttribute     string `json:"emailAttribute"`
+	FirstNameAttribute string `json:"firstNameAttribute"`
+	LastNameAttribute  string `json:"lastNameAttribute"`
+	// EnforceSSO disables password and social logins for the company's users.
+	EnforceSSO bool `json:"enforceSso"`
+}
+
+func (h CompaniesHandler) HandleSaveSAMLConfig() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+
+		var req SaveSAMLConfigRequest
+		if err := validation.BindAndValidate(c, &req); err != nil {
+			return err
+		}
+
+		cfg := model.CompanySAMLConfig{
+			CompanyID:          user.Company.ID,
+			IdPEntityID:        req.IdPEntityID,
+			IdPSSOURL:          req.IdPSSOURL,
+			IdPCertificate:     req.IdPCertificate,
+			EmailAttribute:     valueOrDefault(req.EmailAttribute, "email"),
+			FirstNameAttribute: valueOrDefault(req.FirstNameAttribute, "firstName"),
+			LastNameAttribute:  valueOrDefault(req.LastNameAttribute, "lastName"),
+			EnforceSSO:         req.EnforceSSO,
+		}
+		if req.MetadataXML != "" {
+			metadata, err := auth.ParseIdPMetadata([]byte(req.MetadataXML))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err)
+			}
+			cfg.IdPEntityID = metadata.EntityID
+			cfg.IdPSSOURL = metadata.SSOURL
+			cfg.IdPCertificate = metadata.Certificate
+		}
+
+		if u, err := url.Parse(cfg.IdPSSOURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "The identity provider SSO URL must be an https URL")
+		}
+		if _, err := auth.ParseIdPCertificate(cfg.IdPCertificate); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		if err := h.CompanySettingsStore.SaveSAMLConfig(c.Request().Context(), &cfg); err != nil {
+			requestLogger(c, h.Logger).Error("failed to save SAML configuration", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, h.samlConfigResponse(cfg))
+	}
+}
+
+func (h CompaniesHandler) HandleDeleteSAMLConfig() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+		if err := h.CompanySettingsStore.DeleteSAMLConfig(c.Request().Context(), user.Company.ID); err != nil {
+			if errors.Is(err, store.ErrSAMLConfigNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
+			}
+			requestLogger(c, h.Logger).Error("failed to delete SAML configuration", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// SCIMTokenResponse describes the company's SCIM token and the base URL to configure in the identity provider.
+// The token itself is only returned when it is created.
+type SCIMTokenResponse struct {
+	model.SCIMToken
+	Token       string `json:"token,omitempty"`
+	SCIMBaseURL string `json:"scimBaseUrl"`
+}
+
+func (h CompaniesHandler) HandleGetSCIMToken() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+		token, err := h.SCIMTokenStore.SCIMToken(c.Request().Context(), user.Company.ID)
+		if err != nil {
+			if errors.Is(err, store.ErrSCIMTokenNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
+			}
+			requestLogger(c, h.Logger).Error("failed to get SCIM token", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, SCIMTokenResponse{SCIMToken: token, SCIMBaseURL: SCIMBaseURL(h.Config)})
+	}
+}
+
+// HandleCreateSCIMToken creates the company's SCIM token, replacing any existing token.
+func (h CompaniesHandler) HandleCreateSCIMToken() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+		plaintext, hash, prefix, err := auth.NewSCIMToken()
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to generate SCIM token", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		token := model.SCIMToken{
+			CompanyID:   user.Company.ID,
+			TokenHash:   hash,
+			TokenPrefix: prefix,
+		}
+		if err := h.SCIMTokenStore.SaveSCIMToken(c.Request().Context(), &token); err != nil {
+			requestLogger(c, h.Logger).Error("failed to save SCIM token", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusCreated, SCIMTokenResponse{
+			SCIMToken:   token,
+			Token:       plaintext,
+			SCIMBaseURL: SCIMBaseURL(h.Config),
+		})
+	}
+}
+
+func (h CompaniesHandler) HandleDeleteSCIMToken() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+		if err := h.SCIMTokenStore.DeleteSCIMToken(c.Request().Context(), user.Company.ID); err != nil {
+			if errors.Is(err, store.ErrSCIMTokenNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
+			}
+			requestLogger(c, h.Logger).Error("failed to delete SCIM token", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func valueOrDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
 	}
+	return value
 }
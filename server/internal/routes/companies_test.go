@@ -1,24 +1,40 @@
 package routes_test
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"advancely/internal/application"
+	"advancely/internal/auth"
+	mw "advancely/internal/middleware"
+	"advancely/internal/model"
 	"advancely/internal/routes"
 	"advancely/internal/store"
 	"advancely/internal/tests"
+	"advancely/internal/validation"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/require"
 )
 
 func newTestCompaniesHandler(db *sqlx.DB) routes.CompaniesHandler {
 	return routes.CompaniesHandler{
+		CompanyStore:         store.NewPostgresCompanyStore(db),
 		CompanySettingsStore: store.NewPostgresCompanySettingsStore(db),
-		Logger:               tests.NewDefaultLogger(),
-		EnsurePermission:     routes.EnsurePermissionsFnFactory(store.NewPostgresPermissionsStore(db)),
+		PermissionsStore:     store.NewPostgresPermissionsStore(db),
+		Config: application.AppConfig{
+			SessionSecret:              "companies-handler-session-secret-for-testing",
+			CompanyDeletionGracePeriod: 30 * 24 * time.Hour,
+		},
+		Logger:           tests.NewDefaultLogger(),
+		EnsurePermission: routes.EnsurePermissionsFnFactory(store.NewPostgresPermissionsStore(db)),
 	}
 }
 
@@ -76,3 +92,298 @@ func TestAdminUserCanCreateNewAllowedDomain(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleVerifyAllowedDomain(t *testing.T) {
+	db, user, companyId := setUpTestAdminUserAndCompany(t)
+	records := map[string][]string{}
+	handler := newTestCompaniesHandler(db)
+	handler.LookupTXT = func(_ context.Context, name string) ([]string, error) {
+		return records[name], nil
+	}
+
+	c, rec := tests.NewRequestRecorder(t, http.MethodPost, "/company/settings/domain", routes.AddAllowedDomainRequest{
+		Domain:              "verified-company.com",
+		AllowUnknownDomains: true,
+	})
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	require.NoError(t, handler.HandleAddAllowedDomain()(c))
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var added routes.AllowedDomainResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &added))
+	require.NotEmpty(t, added.VerificationToken)
+	require.Equal(t, validation.DomainVerificationPrefix+added.VerificationToken, added.VerificationRecord)
+	require.False(t, added.Verified())
+
+	verify := func(companyID uuid.UUID) (*httptest.ResponseRecorder, error) {
+		c, rec := tests.NewRequestRecorder(t, http.MethodPost, "/company/settings/domain/verified-company.com/verify", nil)
+		tests.SaveSessionInContext(c, user.ID, companyID)
+		c.SetParamNames("domain")
+		c.SetParamValues("verified-company.com")
+		return rec, handler.HandleVerifyAllowedDomain()(c)
+	}
+
+	records["verified-company.com"] = []string{"v=spf1 -all", validation.DomainVerificationPrefix + "not-the-token"}
+	_, err := verify(companyId)
+	require.ErrorIs(t, err, validation.ErrDomainNotVerified)
+
+	records["verified-company.com"] = append(records["verified-company.com"], added.VerificationRecord)
+	rec, err = verify(companyId)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var verified routes.AllowedDomainResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &verified))
+	require.True(t, verified.Verified())
+
+	// Another company cannot verify the domain, even with its own token published.
+	otherCompanyId := tests.CreateTestCompany(t, db, user.ID)
+	other, err := handler.CompanySettingsStore.AddAllowedEmailDomain(context.Background(), otherCompanyId, "verified-company.com")
+	require.NoError(t, err)
+	records["verified-company.com"] = append(records["verified-company.com"], validation.DomainVerificationPrefix+other.VerificationToken)
+	_, err = verify(otherCompanyId)
+	require.ErrorIs(t, err, store.ErrDomainVerifiedByAnotherCompany)
+}
+
+func TestHandleUpdateCompany(t *testing.T) {
+	db, user, companyId := setUpTestAdminUserAndCompany(t)
+	handler := newTestCompaniesHandler(db)
+
+	c, rec := tests.NewRequestRecorder(t, http.MethodPut, "/company", routes.UpdateCompanyRequest{Name: " Renamed "})
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	require.NoError(t, handler.HandleUpdateCompany()(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	c, rec = tests.NewRequestRecorder(t, http.MethodGet, "/company", nil)
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	require.NoError(t, handler.HandleGetCompany()(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var company model.Company
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &company))
+	require.Equal(t, companyId, company.ID)
+	require.Equal(t, "Renamed", company.Name)
+	require.Nil(t, company.DeletedAt)
+}
+
+func TestDeletedCompanyRejectsSessions(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	ctx := context.Background()
+
+	owner := tests.CreateAdminUser(t, authProvider, db)
+	companyId := tests.CreateTestCompany(t, db, owner.ID)
+	member := tests.CreateAuthUser(t, authProvider, db, "member@advancelyexample.com")
+	tests.AddAdminToCompany(t, db, member.ID, companyId)
+	consultant := tests.CreateAuthUser(t, authProvider, db, "consultant@advancelyexample.com")
+	otherCompanyId := tests.CreateTestCompany(t, db, consultant.ID)
+	tests.AddAdminToCompany(t, db, consultant.ID, companyId)
+
+	tokenStore := store.NewPostgresPersonalAccessTokenStore(db)
+	token, hash, prefix, err := auth.NewPersonalAccessToken()
+	require.NoError(t, err)
+	_, err = tokenStore.CreatePersonalAccessToken(ctx, model.CreatePersonalAccessToken{
+		UserID:      member.ID,
+		CompanyID:   companyId,
+		Name:        "ci",
+		TokenHash:   hash,
+		TokenPrefix: prefix,
+		ExpiresAt:   time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	_, err = store.NewPostgresCompanyStore(db).ScheduleCompanyDeletion(ctx, companyId, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	// Only the owner can log in to the deleted company, and members cannot switch to it.
+	authHandler := newTestAuthHandler(db, authProvider)
+	login := func(email string) error {
+		c, _ := tests.NewRequestRecorder(t, http.MethodPost, "/auth/login", map[string]string{
+			"email":    email,
+			"password": tests.DefaultUserPassword,
+		})
+		return authHandler.HandleLogin()(c)
+	}
+	require.ErrorIs(t, login(member.Email), auth.ErrCompanyDeleted)
+	require.NoError(t, login(owner.Email))
+
+	c, _ := tests.NewRequestRecorder(t, http.MethodPost, "/auth/switch-company", routes.SwitchCompanyRequest{CompanyID: companyId})
+	tests.SaveSessionInContext(c, consultant.ID, otherCompanyId)
+	require.ErrorIs(t, authHandler.HandleSwitchCompany()(c), auth.ErrCompanyDeleted)
+
+	// Sessions of the deleted company are rejected, except for restoring it.
+	tokens, err := authProvider.SignInWithEmailPassword(ctx, owner.Email, tests.DefaultUserPassword)
+	require.NoError(t, err)
+	profile, err := store.NewPostgresUserStore(db).User(ctx, owner.ID, companyId)
+	require.NoError(t, err)
+	session := auth.NewSessionCookie(*tokens)
+	session.SetUser(profile)
+	session.Company = &auth.SessionCookieCompany{ID: companyId}
+
+	userMw := mw.NewUserMiddleware(
+		application.AppConfig{SessionSecret: testSessionSecret},
+		authProvider,
+		auth.NewHMACVerifier([]byte(tests.FakeJWTSecret), "authenticated"),
+		store.NewPostgresUserStore(db),
+		tokenStore,
+		newTestSessionPolicy(db),
+		tests.NewDefaultLogger(),
+	).WithDeletedCompanyAllowed(routes.IsRestoreCompany)
+	authenticate := func(method, path string, authenticate func(c echo.Context)) (bool, error) {
+		c, _ := tests.NewRequestRecorder(t, method, path, nil)
+		c.SetPath(path)
+		authenticate(c)
+		var loggedIn bool
+		err := userMw.WithUserInContext(func(c echo.Context) error {
+			loggedIn = auth.CurrentUser(c).LoggedIn
+			return nil
+		})(c)
+		return loggedIn, err
+	}
+	withCookie := func(c echo.Context) {
+		tests.AddSessionCookie(t, c, session, testSessionSecret)
+	}
+	withToken := func(c echo.Context) {
+		c.Request().Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+
+	loggedIn, err := authenticate(http.MethodGet, "/api/v1/company", withCookie)
+	require.NoError(t, err)
+	require.False(t, loggedIn)
+
+	loggedIn, err = authenticate(http.MethodPost, "/api/v1/company/restore", withCookie)
+	require.NoError(t, err)
+	require.True(t, loggedIn)
+
+	_, err = authenticate(http.MethodGet, "/api/v1/user", withToken)
+	require.ErrorIs(t, err, auth.ErrCompanyDeleted)
+}
+
+func TestHandleDeleteCompanyRequiresOwner(t *testing.T) {
+	db, _, companyId := setUpTestAdminUserAndCompany(t)
+	handler := newTestCompaniesHandler(db)
+
+	c, _ := tests.NewRequestRecorder(t, http.MethodPost, "/company/deletion-token", nil)
+	tests.SaveSessionInContext(c, uuid.New(), companyId)
+	err := handler.HandleCreateDeletionToken()(c)
+	assertHTTPError(t, err, http.StatusForbidden, "")
+}
+
+func TestHandleDeleteAndRestoreCompany(t *testing.T) {
+	db, user, companyId := setUpTestAdminUserAndCompany(t)
+	handler := newTestCompaniesHandler(db)
+
+	// A token is required to delete the company.
+	c, _ := tests.NewRequestRecorder(t, http.MethodDelete, "/company", routes.DeleteCompanyRequest{ConfirmationToken: "1.invalid"})
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	assertHTTPError(t, handler.HandleDeleteCompany()(c), http.StatusBadRequest, "")
+
+	c, rec := tests.NewRequestRecorder(t, http.MethodPost, "/company/deletion-token", nil)
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	require.NoError(t, handler.HandleCreateDeletionToken()(c))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var token routes.DeletionTokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &token))
+
+	c, rec = tests.NewRequestRecorder(t, http.MethodDelete, "/company", routes.DeleteCompanyRequest{ConfirmationToken: token.ConfirmationToken})
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	require.NoError(t, handler.HandleDeleteCompany()(c))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var deleted model.Company
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &deleted))
+	require.NotNil(t, deleted.DeletedAt)
+	require.NotNil(t, deleted.PurgeAfter)
+	require.WithinDuration(t, time.Now().Add(30*24*time.Hour), *deleted.PurgeAfter, time.Minute)
+
+	c, rec = tests.NewRequestRecorder(t, http.MethodPost, "/company/restore", nil)
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	require.NoError(t, handler.HandleRestoreCompany()(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var restored model.Company
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &restored))
+	require.Nil(t, restored.DeletedAt)
+	require.Nil(t, restored.PurgeAfter)
+
+	// The company is no longer waiting to be purged.
+	c, _ = tests.NewRequestRecorder(t, http.MethodPost, "/company/restore", nil)
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	assertHTTPError(t, handler.HandleRestoreCompany()(c), http.StatusConflict, "")
+}
+
+func TestHandleUpdateAndRollbackSettings(t *testing.T) {
+	db, user, companyId := setUpTestAdminUserAndCompany(t)
+	handler := newTestCompaniesHandler(db)
+
+	update := func(req routes.UpdateSettingsRequest) (routes.CompanySettingsResponse, error) {
+		c, rec := tests.NewRequestRecorder(t, http.MethodPatch, "/company/settings", req)
+		tests.SaveSessionInContext(c, user.ID, companyId)
+		var res routes.CompanySettingsResponse
+		if err := handler.HandleUpdateSettings()(c); err != nil {
+			return res, err
+		}
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res, nil
+	}
+
+	c, rec := tests.NewRequestRecorder(t, http.MethodGet, "/company/settings", nil)
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	require.NoError(t, handler.HandleGetSettings()(c))
+	var current routes.CompanySettingsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &current))
+	require.Equal(t, 0, current.Version)
+	require.Equal(t, false, current.Settings["requireMfa"])
+
+	updated, err := update(routes.UpdateSettingsRequest{
+		Version:  &current.Version,
+		Settings: map[string]json.RawMessage{"requireMfa": json.RawMessage(`true`)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, updated.Version)
+	require.Equal(t, true, updated.Settings["requireMfa"])
+
+	// Changes made to an outdated version are rejected.
+	_, err = update(routes.UpdateSettingsRequest{
+		Version:  &current.Version,
+		Settings: map[string]json.RawMessage{"brandPrimaryColor": json.RawMessage(`"#000000"`)},
+	})
+	assertHTTPError(t, err, http.StatusConflict, "")
+
+	_, err = update(routes.UpdateSettingsRequest{
+		Settings: map[string]json.RawMessage{"defaultRoleIds": json.RawMessage(`[2147483647]`)},
+	})
+	assertHTTPError(t, err, http.StatusBadRequest, "")
+
+	updated, err = update(routes.UpdateSettingsRequest{
+		Settings: map[string]json.RawMessage{"brandPrimaryColor": json.RawMessage(`"#000000"`)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, updated.Version)
+	require.Equal(t, "#000000", updated.Settings["brandPrimaryColor"])
+
+	c, rec = tests.NewRequestRecorder(t, http.MethodGet, "/company/settings/versions", nil)
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	require.NoError(t, handler.HandleGetSettingsVersions()(c))
+	var versions []model.CompanySettingsVersion
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &versions))
+	require.Len(t, versions, 2)
+	require.Equal(t, 2, versions[0].Version)
+
+	c, rec = tests.NewRequestRecorder(t, http.MethodPost, "/company/settings/versions/1/rollback", nil)
+	c.SetParamNames("version")
+	c.SetParamValues("1")
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	require.NoError(t, handler.HandleRollbackSettings()(c))
+	var rolledBack routes.CompanySettingsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rolledBack))
+	require.Equal(t, 3, rolledBack.Version)
+	require.Equal(t, true, rolledBack.Settings["requireMfa"])
+	require.Equal(t, "#1d4ed8", rolledBack.Settings["brandPrimaryColor"])
+
+	c, _ = tests.NewRequestRecorder(t, http.MethodPost, "/company/settings/versions/42/rollback", nil)
+	c.SetParamNames("version")
+	c.SetParamValues("42")
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	assertHTTPError(t, handler.HandleRollbackSettings()(c), http.StatusNotFound, "")
+}
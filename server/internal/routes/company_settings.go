@@ -0,0 +1,197 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"advancely/internal/auth"
+	"advancely/internal/model"
+	"advancely/internal/model/security"
+	"advancely/internal/model/settings"
+	"advancely/internal/store"
+	"advancely/internal/validation"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// CompanySettingsResponse contains the value of every setting, using the default of those the company
+// has not saved, and the version of the settings changes are made to.
+type CompanySettingsResponse struct {
+	Version   int            `json:"version"`
+	Settings  map[string]any `json:"settings"`
+	UpdatedAt *time.Time     `json:"updatedAt"`
+}
+
+func newCompanySettingsResponse(cs model.CompanySettings) CompanySettingsResponse {
+	return CompanySettingsResponse{
+		Version:   cs.Version,
+		Settings:  settings.Resolve(cs.Settings),
+		UpdatedAt: cs.UpdatedAt,
+	}
+}
+
+func (h CompaniesHandler) HandleGetSettings() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+		cs, err := h.CompanySettingsStore.Settings(c.Request().Context(), user.Company.ID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to get company settings", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, newCompanySettingsResponse(cs))
+	}
+}
+
+// UpdateSettingsRequest changes some of the company's settings. A null value resets a setting to its default.
+type UpdateSettingsRequest struct {
+	// Version is the version the changes were made to. If set, the changes are rejected
+	// if the settings have been changed since.
+	Version  *int                       `json:"version"`
+	Settings map[string]json.RawMessage `json:"settings" validate:"required"`
+}
+
+func (h CompaniesHandler) HandleUpdateSettings() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		user := auth.CurrentUser(c)
+
+		var req UpdateSettingsRequest
+		if err := validation.BindAndValidate(c, &req); err != nil {
+			return err
+		}
+
+		current, err := h.CompanySettingsStore.Settings(ctx, user.Company.ID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to get company settings", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		values, err := settings.Apply(current.Settings, req.Settings)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "The settings are invalid").SetInternal(err)
+		}
+		return h.saveSettings(c, values, req.Version)
+	}
+}
+
+func (h CompaniesHandler) HandleGetSettingsVersions() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		user := auth.CurrentUser(c)
+		versions, err := h.CompanySettingsStore.SettingsVersions(c.Request().Context(), user.Company.ID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to list company settings versions", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, versions)
+	}
+}
+
+// HandleRollbackSettings restores the settings of a previous version, which are saved as a new version.
+func (h CompaniesHandler) HandleRollbackSettings() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.EnsurePermission(c, security.PermissionEditOrganizationSettings); err != nil {
+			return err
+		}
+
+		version, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Version could not be parsed")
+		}
+
+		user := auth.CurrentUser(c)
+		previous, err := h.CompanySettingsStore.SettingsVersion(c.Request().Context(), user.Company.ID, version)
+		if err != nil {
+			if errors.Is(err, store.ErrSettingsVersionNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
+			}
+			requestLogger(c, h.Logger).Error("failed to get company settings version", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		// The previous values are validated again, as the registry may have changed since they were saved.
+		values, err := settings.Apply(settings.Values{}, previous.Settings)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusConflict, "The settings of the version are no longer valid").SetInternal(err)
+		}
+		return h.saveSettings(c, values, nil)
+	}
+}
+
+// saveSettings saves the settings of the current user's company as a new version, once the roles
+// they refer to have been checked, and writes them in the response.
+func (h CompaniesHandler) saveSettings(c echo.Context, values settings.Values, baseVersion *int) error {
+	ctx := c.Request().Context()
+	user := auth.CurrentUser(c)
+
+	if err := h.validateDefaultRoles(ctx, user.Company.ID, values); err != nil {
+		var fieldErrs validation.Errors
+		if errors.As(err, &fieldErrs) {
+			return echo.NewHTTPError(http.StatusBadRequest, "The settings are invalid").SetInternal(err)
+		}
+		requestLogger(c, h.Logger).Error("failed to get default roles", "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	saved, err := h.CompanySettingsStore.SaveSettings(ctx, user.Company.ID, values, baseVersion, user.User.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrSettingsVersionConflict) {
+			return echo.NewHTTPError(http.StatusConflict, err)
+		}
+		requestLogger(c, h.Logger).Error("failed to save company settings", "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, newCompanySettingsResponse(saved))
+}
+
+// validateDefaultRoles returns validation.Errors if the default roles are not roles of the company.
+func (h CompaniesHandler) validateDefaultRoles(ctx context.Context, companyID uuid.UUID, values settings.Values) error {
+	return validateRoleIDs(ctx, h.PermissionsStore, companyID, settings.DefaultRoleIDs.Key, settings.DefaultRoleIDs.Get(values))
+}
+
+// validateRoleIDs returns validation.Errors for the field if any of the roles is neither a role
+// of the company nor a system role.
+func validateRoleIDs(ctx context.Context, s store.PermissionsStore, companyID uuid.UUID, field string, ids []int) error {
+	var errs validation.Errors
+	for _, id := range ids {
+		if _, err := s.Role(ctx, id, &companyID); err != nil {
+			if !errors.Is(err, store.ErrRoleNotFound) {
+				return err
+			}
+			errs = append(errs, validation.FieldError{
+				Field:   field,
+				Code:    "role_not_found",
+				Message: fmt.Sprintf("Role %d does not exist", id),
+			})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// defaultRoleIDs returns the roles the company assigns to users joining it.
+func defaultRoleIDs(ctx context.Context, s store.CompanySettingsStore, companyID uuid.UUID) ([]int, error) {
+	cs, err := s.Settings(ctx, companyID)
+	if err != nil {
+		return nil, err
+	}
+	return settings.DefaultRoleIDs.Get(cs.Settings), nil
+}
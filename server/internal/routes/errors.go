@@ -0,0 +1,48 @@
+package routes
+
+import (
+	"net/http"
+
+	"advancely/internal/apierror"
+	"advancely/internal/auth"
+	"advancely/internal/store"
+	"advancely/internal/validation"
+)
+
+// NewErrorRegistry returns the codes of the sentinel errors handlers may return.
+// The codes are part of the API and are mirrored by ErrorCode in the client's api/error.ts.
+func NewErrorRegistry() *apierror.Registry {
+	return apierror.NewRegistry().
+		Register(store.ErrUserNotFound, http.StatusNotFound, "user_not_found").
+		Register(store.ErrUserNotDeleted, http.StatusConflict, "user_not_deleted").
+		Register(store.ErrCompanyNotFound, http.StatusNotFound, "company_not_found").
+		Register(store.ErrCompanyNotDeleted, http.StatusConflict, "company_not_deleted").
+		Register(store.ErrRoleNotFound, http.StatusNotFound, "role_not_found").
+		Register(store.ErrRoleNotDeleted, http.StatusConflict, "role_not_deleted").
+		Register(store.ErrPermissionNotFount, http.StatusNotFound, "permission_not_found").
+		Register(store.ErrCannotDeleteSystemRole, http.StatusForbidden, "system_role_not_deletable").
+		Register(store.ErrCannotUpdateSystemRole, http.StatusForbidden, "system_role_not_editable").
+		Register(store.ErrDomainAlreadyExists, http.StatusConflict, "domain_already_exists").
+		Register(store.ErrDomainNotFound, http.StatusNotFound, "domain_not_found").
+		Register(store.ErrDomainVerifiedByAnotherCompany, http.StatusConflict, "domain_verified_by_another_company").
+		Register(store.ErrSAMLConfigNotFound, http.StatusNotFound, "saml_config_not_found").
+		Register(store.ErrSettingsVersionConflict, http.StatusConflict, "settings_version_conflict").
+		Register(store.ErrSettingsVersionNotFound, http.StatusNotFound, "settings_version_not_found").
+		Register(store.ErrSCIMTokenNotFound, http.StatusNotFound, "scim_token_not_found").
+		Register(store.ErrPersonalAccessTokenNotFound, http.StatusNotFound, "personal_access_token_not_found").
+		Register(store.ErrMFAFactorNotFound, http.StatusNotFound, "mfa_factor_not_found").
+		Register(store.ErrMFAFactorAlreadyVerified, http.StatusConflict, "mfa_factor_already_verified").
+		Register(store.ErrMFACodeAlreadyUsed, http.StatusUnauthorized, "mfa_code_already_used").
+		Register(store.ErrRecoveryCodeInvalid, http.StatusUnauthorized, "recovery_code_invalid").
+		Register(validation.ErrInvalidDomain, http.StatusBadRequest, "invalid_domain").
+		Register(validation.ErrUnknownDomain, http.StatusBadRequest, "unknown_domain").
+		Register(validation.ErrDomainNotVerified, http.StatusUnprocessableEntity, "domain_not_verified").
+		Register(auth.ErrUnsupportedOAuthProvider, http.StatusNotFound, "unsupported_oauth_provider").
+		Register(auth.ErrInvalidIdPMetadata, http.StatusBadRequest, "invalid_idp_metadata").
+		Register(auth.ErrInvalidIdPCertificate, http.StatusBadRequest, "invalid_idp_certificate").
+		Register(auth.ErrMFARequired, http.StatusForbidden, "mfa_required").
+		Register(auth.ErrSSORequired, http.StatusForbidden, "sso_required").
+		Register(auth.ErrCompanyDeleted, http.StatusForbidden, "company_deleted").
+		Register(auth.ErrInvalidConfirmationToken, http.StatusBadRequest, "invalid_confirmation_token").
+		Register(auth.ErrConfirmationTokenExpired, http.StatusBadRequest, "confirmation_token_expired")
+}
@@ -0,0 +1,27 @@
+package routes_test
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"advancely/internal/routes"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestErrorRegistryCodesInClient ensures every registered error code can be handled by the client.
+func TestErrorRegistryCodesInClient(t *testing.T) {
+	source, err := os.ReadFile("../../../client/src/api/error.ts")
+	if os.IsNotExist(err) {
+		t.Skip("client source not found")
+	}
+	require.NoError(t, err)
+
+	seen := make(map[string]bool)
+	for _, code := range routes.NewErrorRegistry().Codes() {
+		require.False(t, seen[code], "code %s is registered more than once", code)
+		seen[code] = true
+		require.Contains(t, string(source), strconv.Quote(code), "ErrorCode in api/error.ts is missing %s", code)
+	}
+}
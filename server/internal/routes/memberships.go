@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"advancely/internal/auth"
+	"advancely/internal/model"
+	"advancely/internal/store"
+	"advancely/internal/validation"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// MembershipResponse is a company the user is a member of. Current is true for the company of the session.
+type MembershipResponse struct {
+	model.Membership
+	Current bool `json:"current"`
+}
+
+// HandleListCompanies returns the companies the user is a member of.
+func (h AuthHandler) HandleListCompanies() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		session := auth.CurrentUser(c)
+		if !session.LoggedIn {
+			return echo.NewHTTPError(http.StatusUnauthorized)
+		}
+
+		memberships, err := h.UserStore.Memberships(c.Request().Context(), session.User.ID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to list memberships", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		res := make([]MembershipResponse, 0, len(memberships))
+		for _, m := range memberships {
+			res = append(res, MembershipResponse{Membership: m, Current: m.CompanyID == session.Company.ID})
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+type SwitchCompanyRequest struct {
+	CompanyID uuid.UUID `json:"companyId" validate:"required"`
+}
+
+// HandleSwitchCompany changes the company of the session to another company the user is a member of.
+// Deleted companies can only be switched to by their owner, to restore them.
+// Companies requiring SSO must be logged in to through their identity provider, and the session waits
+// for the user to complete MFA as it would when logging in to the company.
+func (h AuthHandler) HandleSwitchCompany() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		current := auth.CurrentUser(c)
+		if !current.LoggedIn {
+			return echo.NewHTTPError(http.StatusUnauthorized)
+		}
+		if current.IsPersonalAccessToken() {
+			return echo.NewHTTPError(http.StatusForbidden, "personal access tokens cannot be used to switch company")
+		}
+
+		var req SwitchCompanyRequest
+		if err := validation.BindAndValidate(c, &req); err != nil {
+			return err
+		}
+
+		user, err := h.UserStore.User(ctx, current.User.ID, req.CompanyID)
+		if err != nil {
+			if errors.Is(err, store.ErrUserNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, store.ErrCompanyNotFound)
+			}
+			requestLogger(c, h.Logger).Error("failed getting user from store", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		if !user.Active() {
+			return echo.NewHTTPError(http.StatusForbidden, "Your account has been deactivated in this company")
+		}
+
+		company, err := h.CompanyStore.Company(ctx, user.CompanyID)
+		if err != nil {
+			if errors.Is(err, store.ErrCompanyNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, store.ErrCompanyNotFound)
+			}
+			requestLogger(c, h.Logger).Error("failed getting company from store", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		if company.Deleted() && !company.OwnedBy(user.ID) {
+			return auth.ErrCompanyDeleted
+		}
+
+		ssoEnforced, err := h.ssoEnforced(ctx, company.ID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed getting SAML configuration", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		if ssoEnforced {
+			return echo.NewHTTPError(http.StatusForbidden, "This company requires you to sign in with SSO")
+		}
+
+		session := current.SessionCookie
+		session.SetUser(user)
+		session.SetCompany(company)
+
+		// Sessions created through SSO have not completed a local MFA challenge,
+		// so it is required again to switch to a company requiring it.
+		mfaState, err := h.mfaStateForUser(ctx, user)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed determining MFA state", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		session.MFAState = mfaState
+
+		if err := session.SetCookie(c, h.Config.SessionSecret, h.Config.Environment); err != nil {
+			requestLogger(c, h.Logger).Error("failed setting session cookie", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		if session.MFAPending() {
+			return c.JSON(http.StatusOK, LoginMFAResponse{Status: mfaState})
+		}
+		return c.JSON(http.StatusOK, session)
+	}
+}
@@ -0,0 +1,96 @@
+package routes_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"advancely/internal/auth"
+	"advancely/internal/routes"
+	"advancely/internal/store"
+	"advancely/internal/tests"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListAndSwitchCompanies(t *testing.T) {
+	db, user, companyId := setUpTestAdminUserAndCompany(t)
+	handler := newTestAuthHandler(db, tests.NewFakeAuthProvider())
+
+	owner := tests.CreateAuthUser(t, tests.NewFakeAuthProvider(), db, "owner@other-company.com")
+	otherCompanyId := tests.CreateTestCompany(t, db, owner.ID)
+	tests.AddAdminToCompany(t, db, user.ID, otherCompanyId)
+
+	c, rec := tests.NewRequestRecorder(t, http.MethodGet, "/auth/companies", nil)
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	require.NoError(t, handler.HandleListCompanies()(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var memberships []routes.MembershipResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &memberships))
+	require.Len(t, memberships, 2)
+	require.Equal(t, companyId, memberships[0].CompanyID)
+	require.True(t, memberships[0].Current)
+	require.Equal(t, otherCompanyId, memberships[1].CompanyID)
+	require.False(t, memberships[1].Current)
+
+	c, rec = tests.NewRequestRecorder(t, http.MethodPost, "/auth/switch-company", routes.SwitchCompanyRequest{CompanyID: otherCompanyId})
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	require.NoError(t, handler.HandleSwitchCompany()(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotEmpty(t, rec.Header().Get("Set-Cookie"))
+
+	var session auth.SessionCookie
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &session))
+	require.Equal(t, otherCompanyId, session.Company.ID)
+
+	// Users cannot switch to companies they are not a member of.
+	c, _ = tests.NewRequestRecorder(t, http.MethodPost, "/auth/switch-company", routes.SwitchCompanyRequest{CompanyID: uuid.New()})
+	tests.SaveSessionInContext(c, user.ID, companyId)
+	assertHTTPError(t, handler.HandleSwitchCompany()(c), http.StatusNotFound, "")
+}
+
+func TestSwitchCompanyRequiresLogin(t *testing.T) {
+	handler := newTestAuthHandler(nil, tests.NewFakeAuthProvider())
+
+	c, _ := tests.NewRequestRecorder(t, http.MethodPost, "/auth/switch-company", routes.SwitchCompanyRequest{CompanyID: uuid.New()})
+	assertHTTPError(t, handler.HandleSwitchCompany()(c), http.StatusUnauthorized, "")
+}
+
+func TestSwitchCompanyFromSAMLSessionRequiresMFA(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	idp, companyID := setUpSAMLCompany(t, db, authProvider, false)
+	idp.SetUser("sso.user@company-email.com", "Sso", "User")
+	acsRec := loginWithSAML(t, newTestSAMLHandler(db, authProvider), idp, companyID)
+
+	userID := authProvider.NewSession("sso.user@company-email.com").User.ID
+	owner := tests.CreateAuthUser(t, authProvider, db, "owner@other-company.com")
+	otherCompanyID := tests.CreateTestCompany(t, db, owner.ID)
+	tests.AddAdminToCompany(t, db, userID, otherCompanyID)
+
+	ctx := context.Background()
+	mfaStore := store.NewPostgresMFAStore(db)
+	_, err := mfaStore.CreateMFAFactor(ctx, userID, "secret")
+	require.NoError(t, err)
+	require.NoError(t, mfaStore.VerifyMFAFactor(ctx, userID, 1))
+
+	// The SSO session has not completed a TOTP challenge, so switching requires one.
+	c, rec := tests.NewRequestRecorder(t, http.MethodPost, "/auth/switch-company", routes.SwitchCompanyRequest{CompanyID: otherCompanyID})
+	for _, cookie := range acsRec.Result().Cookies() {
+		c.Request().AddCookie(cookie)
+	}
+	session, err := auth.GetSessionFromCookie(c, testSessionSecret)
+	require.NoError(t, err)
+	require.False(t, session.MFAPending())
+	session.SaveInContext(c)
+
+	require.NoError(t, newTestAuthHandler(db, authProvider).HandleSwitchCompany()(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var res routes.LoginMFAResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Equal(t, auth.MFAStateChallengeRequired, res.Status)
+}
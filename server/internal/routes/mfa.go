@@ -0,0 +1,367 @@
+package routes
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"advancely/internal/application"
+	"advancely/internal/auth"
+	mw "advancely/internal/middleware"
+	"advancely/internal/model/security"
+	"advancely/internal/model/settings"
+	"advancely/internal/store"
+	"advancely/internal/validation"
+	"advancely/pkg/totp"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func NewMFAHandler(
+	s *store.PostgresStore,
+	config application.AppConfig,
+	rateLimiter *mw.RateLimiter,
+	logger *slog.Logger,
+) MFAHandler {
+	return MFAHandler{
+		MFAStore:         s.MFAStore,
+		SettingsStore:    s.CompanySettingsStore,
+		PermissionsStore: s.PermissionsStore,
+		Config:           config,
+		RateLimiter:      rateLimiter,
+		Logger:           logger,
+	}
+}
+
+type MFAHandler struct {
+	MFAStore         store.MFAStore
+	SettingsStore    store.CompanySettingsStore
+	PermissionsStore store.PermissionsStore
+	Config           application.AppConfig
+	RateLimiter      *mw.RateLimiter
+	Logger           *slog.Logger
+}
+
+func (h MFAHandler) MakeRoutes(e *echo.Group) {
+	// The routes checking a code share the limits and lockout of the user, so that codes cannot be guessed
+	// by spreading attempts across routes.
+	limits := []echo.MiddlewareFunc{
+		h.RateLimiter.LimitUser("mfa", h.Config.RateLimit.MFA, h.limitedUserID),
+		h.RateLimiter.LockoutUser("mfa", h.Config.RateLimit.MFALockout, h.limitedUserID),
+	}
+
+	group := e.Group("/auth/mfa")
+	group.POST("/enroll", h.HandleEnroll())
+	group.POST("/verify", h.HandleVerify(), limits...)
+	group.POST("/challenge", h.HandleChallenge(), limits...)
+	group.POST("/recovery-codes", h.HandleRegenerateRecoveryCodes(), limits...)
+	group.DELETE("", h.HandleUnenroll(), limits...)
+}
+
+// limitedUserID returns the ID of the user whose code is checked by the request, which is the user of the
+// logged-in session or of the session waiting on MFA in the cookie.
+func (h MFAHandler) limitedUserID(c echo.Context) string {
+	if current := auth.CurrentUser(c); current.LoggedIn {
+		return current.User.ID.String()
+	}
+	session, err := auth.GetSessionFromCookie(c, h.Config.SessionSecret)
+	if err != nil || session.User == nil {
+		return ""
+	}
+	return session.User.ID.String()
+}
+
+// mfaSession returns the session of the user managing their factor. This is either the logged-in
+// session or, for admins who must enroll before logging in, the pending session in the cookie.
+// Sessions authenticated with a personal access token cannot manage factors.
+func (h MFAHandler) mfaSession(c echo.Context, allowEnrollmentPending bool) (*auth.SessionCookie, *echo.HTTPError) {
+	current := auth.CurrentUser(c)
+	if current.LoggedIn {
+		if current.IsPersonalAccessToken() {
+			return nil, echo.NewHTTPError(http.StatusForbidden, "personal access tokens cannot be used to manage MFA")
+		}
+		return &current.SessionCookie, nil
+	}
+	if !allowEnrollmentPending {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	return h.pendingSession(c, auth.MFAStateEnrollmentRequired)
+}
+
+// pendingSession returns the session in the cookie if it is waiting on the given MFA state.
+func (h MFAHandler) pendingSession(c echo.Context, state string) (*auth.SessionCookie, *echo.HTTPError) {
+	session, err := auth.GetSessionFromCookie(c, h.Config.SessionSecret)
+	if err != nil || session.MFAState != state || session.User == nil || session.Expired() {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	return session, nil
+}
+
+// completeMFA clears the pending MFA state of the session and saves it in the cookie.
+func (h MFAHandler) completeMFA(c echo.Context, session *auth.SessionCookie) error {
+	session.MFAState = ""
+	return session.SetCookie(c, h.Config.SessionSecret, h.Config.Environment)
+}
+
+// validateCode validates the TOTP code against the user's verified factor,
+// recording its time step so that the same code cannot be used twice.
+func (h MFAHandler) validateCode(c echo.Context, userID uuid.UUID, code string) *echo.HTTPError {
+	ctx := c.Request().Context()
+
+	factor, err := h.MFAStore.MFAFactor(ctx, userID)
+	if err != nil {
+		if errors.Is(err, store.ErrMFAFactorNotFound) {
+			return echo.NewHTTPError(http.StatusBadRequest, "MFA is not enabled")
+		}
+		requestLogger(c, h.Logger).Error("failed to get MFA factor", "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	if !factor.Verified() {
+		return echo.NewHTTPError(http.StatusBadRequest, "MFA is not enabled")
+	}
+
+	step, err := totp.Validate(factor.Secret, code, time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid code")
+	}
+	if err := h.MFAStore.UseMFAFactorStep(ctx, userID, step); err != nil {
+		if errors.Is(err, store.ErrMFACodeAlreadyUsed) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid code")
+		}
+		requestLogger(c, h.Logger).Error("failed to record MFA code use", "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// newRecoveryCodes replaces the user's recovery codes, returning the new codes.
+func (h MFAHandler) newRecoveryCodes(c echo.Context, userID uuid.UUID) ([]string, error) {
+	codes, hashes, err := auth.NewRecoveryCodes(auth.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.MFAStore.ReplaceRecoveryCodes(c.Request().Context(), userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+type EnrollMFAResponse struct {
+	FactorID uuid.UUID `json:"factorId"`
+	Secret   string    `json:"secret"`
+	URI      string    `json:"uri"`
+}
+
+// HandleEnroll creates an unverified TOTP factor for the user.
+// The factor must be verified with a code from the authenticator app before it is used at login.
+func (h MFAHandler) HandleEnroll() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		session, httpErr := h.mfaSession(c, true)
+		if httpErr != nil {
+			return httpErr
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to generate TOTP secret", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		factor, err := h.MFAStore.CreateMFAFactor(c.Request().Context(), session.User.ID, secret)
+		if err != nil {
+			if errors.Is(err, store.ErrMFAFactorAlreadyVerified) {
+				return echo.NewHTTPError(http.StatusConflict, "MFA is already enabled")
+			}
+			requestLogger(c, h.Logger).Error("failed to create MFA factor", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		return c.JSON(http.StatusCreated, EnrollMFAResponse{
+			FactorID: factor.ID,
+			Secret:   secret,
+			URI:      totp.URI(auth.MFAIssuer, session.User.Email, secret),
+		})
+	}
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type VerifyMFAResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+	// Session is included if verifying the factor completed a pending login.
+	Session *auth.SessionCookie `json:"session,omitempty"`
+}
+
+// HandleVerify verifies the user's pending factor with a code from their authenticator app,
+// returning the recovery codes. A session pending enrollment is upgraded to a logged-in session.
+func (h MFAHandler) HandleVerify() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		session, httpErr := h.mfaSession(c, true)
+		if httpErr != nil {
+			return httpErr
+		}
+
+		var req MFACodeRequest
+		if err := validation.BindAndValidate(c, &req); err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		factor, err := h.MFAStore.MFAFactor(ctx, session.User.ID)
+		if err != nil {
+			if errors.Is(err, store.ErrMFAFactorNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, "No MFA enrollment found")
+			}
+			requestLogger(c, h.Logger).Error("failed to get MFA factor", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		if factor.Verified() {
+			return echo.NewHTTPError(http.StatusConflict, "MFA is already enabled")
+		}
+
+		step, err := totp.Validate(factor.Secret, req.Code, time.Now())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid code")
+		}
+		if err := h.MFAStore.VerifyMFAFactor(ctx, session.User.ID, step); err != nil {
+			requestLogger(c, h.Logger).Error("failed to verify MFA factor", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		codes, err := h.newRecoveryCodes(c, session.User.ID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to create recovery codes", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		resp := VerifyMFAResponse{RecoveryCodes: codes}
+		if session.MFAPending() {
+			if err := h.completeMFA(c, session); err != nil {
+				requestLogger(c, h.Logger).Error("failed to set session cookie", "error", err)
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
+			resp.Session = session
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+// ChallengeMFARequest requires either a TOTP code or one of the user's recovery codes.
+type ChallengeMFARequest struct {
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code"`
+}
+
+// HandleChallenge completes a login for a user with MFA enabled, upgrading the pending session.
+func (h MFAHandler) HandleChallenge() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		session, httpErr := h.pendingSession(c, auth.MFAStateChallengeRequired)
+		if httpErr != nil {
+			return httpErr
+		}
+
+		var req ChallengeMFARequest
+		if err := validation.BindAndValidate(c, &req); err != nil {
+			return err
+		}
+
+		if req.Code != "" {
+			if httpErr := h.validateCode(c, session.User.ID, req.Code); httpErr != nil {
+				return httpErr
+			}
+		} else {
+			hash := auth.HashRecoveryCode(req.RecoveryCode)
+			if err := h.MFAStore.UseRecoveryCode(c.Request().Context(), session.User.ID, hash); err != nil {
+				if errors.Is(err, store.ErrRecoveryCodeInvalid) {
+					return echo.NewHTTPError(http.StatusUnauthorized, "Invalid recovery code")
+				}
+				requestLogger(c, h.Logger).Error("failed to use recovery code", "error", err)
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
+		}
+
+		if err := h.completeMFA(c, session); err != nil {
+			requestLogger(c, h.Logger).Error("failed to set session cookie", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, session)
+	}
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// HandleRegenerateRecoveryCodes replaces the user's recovery codes, invalidating any unused codes.
+func (h MFAHandler) HandleRegenerateRecoveryCodes() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		session, httpErr := h.mfaSession(c, false)
+		if httpErr != nil {
+			return httpErr
+		}
+
+		var req MFACodeRequest
+		if err := validation.BindAndValidate(c, &req); err != nil {
+			return err
+		}
+		if httpErr := h.validateCode(c, session.User.ID, req.Code); httpErr != nil {
+			return httpErr
+		}
+
+		codes, err := h.newRecoveryCodes(c, session.User.ID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to create recovery codes", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// HandleUnenroll removes the user's factor and recovery codes.
+// Users cannot remove their factor if their company requires MFA, nor can admins if it requires admins to use MFA.
+func (h MFAHandler) HandleUnenroll() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		session, httpErr := h.mfaSession(c, false)
+		if httpErr != nil {
+			return httpErr
+		}
+
+		var req MFACodeRequest
+		if err := validation.BindAndValidate(c, &req); err != nil {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		companySettings, err := h.SettingsStore.Settings(ctx, session.Company.ID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to get company settings", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		if settings.RequireMFA.Get(companySettings.Settings) {
+			return echo.NewHTTPError(http.StatusForbidden, "MFA is required by your company")
+		}
+		if settings.RequireAdminMFA.Get(companySettings.Settings) {
+			roles, err := h.PermissionsStore.UserRoles(ctx, session.User.ID, session.Company.ID)
+			if err != nil {
+				requestLogger(c, h.Logger).Error("failed to get user roles", "error", err)
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
+			if roles.HasRole(security.RoleAdmin) {
+				return echo.NewHTTPError(http.StatusForbidden, "MFA is required for admins")
+			}
+		}
+
+		if httpErr := h.validateCode(c, session.User.ID, req.Code); httpErr != nil {
+			return httpErr
+		}
+		if err := h.MFAStore.DeleteMFAFactor(ctx, session.User.ID); err != nil {
+			requestLogger(c, h.Logger).Error("failed to delete MFA factor", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
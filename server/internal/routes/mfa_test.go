@@ -0,0 +1,227 @@
+package routes_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"advancely/internal/application"
+	"advancely/internal/auth"
+	mw "advancely/internal/middleware"
+	"advancely/internal/model/settings"
+	"advancely/internal/routes"
+	"advancely/internal/store"
+	"advancely/internal/tests"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+const testSessionSecret = "session.secret"
+
+// newTestMFAHandler creates an MFAHandler without stores, for tests that are rejected before the store is used.
+func newTestMFAHandler() routes.MFAHandler {
+	return routes.MFAHandler{
+		Config: application.AppConfig{
+			SessionSecret: testSessionSecret,
+		},
+		Logger: tests.NewDefaultLogger(),
+	}
+}
+
+func newPendingSession(state string) *auth.SessionCookie {
+	return &auth.SessionCookie{
+		AccessToken: "access-token",
+		ExpiresAt:   time.Now().Add(time.Hour).Unix(),
+		User:        &auth.SessionCookieUser{ID: uuid.New(), Email: "user@email.com"},
+		Company:     &auth.SessionCookieCompany{ID: uuid.New()},
+		MFAState:    state,
+	}
+}
+
+func TestMFAChallengeRejectedRequests(t *testing.T) {
+	testCases := []struct {
+		name           string
+		payload        map[string]string
+		session        *auth.SessionCookie
+		expectedStatus int
+	}{
+		{
+			name:           "no pending session",
+			payload:        map[string]string{"code": "123456"},
+			session:        nil,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "session pending enrollment",
+			payload:        map[string]string{"code": "123456"},
+			session:        newPendingSession(auth.MFAStateEnrollmentRequired),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "session without pending MFA",
+			payload:        map[string]string{"code": "123456"},
+			session:        newPendingSession(""),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "no code or recovery code",
+			payload:        map[string]string{},
+			session:        newPendingSession(auth.MFAStateChallengeRequired),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "code is not six digits",
+			payload:        map[string]string{"code": "12ab"},
+			session:        newPendingSession(auth.MFAStateChallengeRequired),
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := tests.NewRequestRecorder(t, http.MethodPost, "/auth/mfa/challenge", tc.payload)
+			if tc.session != nil {
+				tests.AddSessionCookie(t, c, tc.session, testSessionSecret)
+			}
+
+			err := newTestMFAHandler().HandleChallenge()(c)
+			assertHTTPError(t, err, tc.expectedStatus, "")
+		})
+	}
+}
+
+func TestMFAManagementRejectedRequests(t *testing.T) {
+	patSession := func(c echo.Context) {
+		session := auth.SessionCookie{
+			TokenType: auth.TokenTypePersonalAccessToken,
+			User:      &auth.SessionCookieUser{ID: uuid.New()},
+			Company:   &auth.SessionCookieCompany{ID: uuid.New()},
+		}
+		session.SaveInContext(c)
+	}
+	loggedIn := func(c echo.Context) {
+		tests.SaveSessionInContext(c, uuid.New(), uuid.New())
+	}
+
+	handler := newTestMFAHandler()
+	testCases := []struct {
+		name           string
+		handler        echo.HandlerFunc
+		payload        map[string]string
+		saveSession    func(c echo.Context)
+		expectedStatus int
+	}{
+		{
+			name:           "enroll when not logged in",
+			handler:        handler.HandleEnroll(),
+			saveSession:    func(c echo.Context) {},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "enroll with a personal access token",
+			handler:        handler.HandleEnroll(),
+			saveSession:    patSession,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "verify without a code",
+			handler:        handler.HandleVerify(),
+			payload:        map[string]string{},
+			saveSession:    loggedIn,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "regenerate recovery codes when not logged in",
+			handler:        handler.HandleRegenerateRecoveryCodes(),
+			payload:        map[string]string{"code": "123456"},
+			saveSession:    func(c echo.Context) {},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "regenerate recovery codes with a personal access token",
+			handler:        handler.HandleRegenerateRecoveryCodes(),
+			payload:        map[string]string{"code": "123456"},
+			saveSession:    patSession,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unenroll with an invalid code",
+			handler:        handler.HandleUnenroll(),
+			payload:        map[string]string{"code": "1234567"},
+			saveSession:    loggedIn,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unenroll when not logged in",
+			handler:        handler.HandleUnenroll(),
+			payload:        map[string]string{"code": "123456"},
+			saveSession:    func(c echo.Context) {},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := tests.NewRequestRecorder(t, http.MethodPost, "/auth/mfa", tc.payload)
+			tc.saveSession(c)
+
+			err := tc.handler(c)
+			assertHTTPError(t, err, tc.expectedStatus, "")
+		})
+	}
+}
+
+func TestBearerTokenRejectedWhenMFARequired(t *testing.T) {
+	db, user, companyId := setUpTestAdminUserAndCompany(t)
+	policy := newTestSessionPolicy(db)
+	userMw := mw.NewUserMiddleware(
+		application.AppConfig{SessionSecret: testSessionSecret},
+		tests.NewFakeAuthProvider(),
+		auth.NewHMACVerifier([]byte(tests.FakeJWTSecret), "authenticated"),
+		store.NewPostgresUserStore(db),
+		store.NewPostgresPersonalAccessTokenStore(db),
+		policy,
+		tests.NewDefaultLogger(),
+	)
+	token := tests.NewAccessToken(user.ID.String(), user.Email, time.Now().Add(time.Hour))
+	authenticate := func() (bool, error) {
+		c, _ := tests.NewRequestRecorder(t, http.MethodGet, "/", nil)
+		c.Request().Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		var loggedIn bool
+		err := userMw.WithUserInContext(func(c echo.Context) error {
+			loggedIn = auth.CurrentUser(c).LoggedIn
+			return nil
+		})(c)
+		return loggedIn, err
+	}
+
+	loggedIn, err := authenticate()
+	require.NoError(t, err)
+	require.True(t, loggedIn)
+
+	// The access token is issued before the second factor is checked, so it cannot be used once the user has a factor.
+	ctx := context.Background()
+	_, err = policy.MFAStore.CreateMFAFactor(ctx, user.ID, "secret")
+	require.NoError(t, err)
+	require.NoError(t, policy.MFAStore.VerifyMFAFactor(ctx, user.ID, 1))
+
+	loggedIn, err = authenticate()
+	require.ErrorIs(t, err, auth.ErrMFARequired)
+	require.False(t, loggedIn)
+
+	// Companies requiring admins to use MFA reject the tokens of admins without a factor.
+	require.NoError(t, policy.MFAStore.DeleteMFAFactor(ctx, user.ID))
+	values, err := settings.Apply(settings.Values{}, map[string]json.RawMessage{
+		settings.RequireAdminMFA.Key: json.RawMessage("true"),
+	})
+	require.NoError(t, err)
+	_, err = policy.SettingsStore.SaveSettings(ctx, companyId, values, nil, user.ID)
+	require.NoError(t, err)
+
+	_, err = authenticate()
+	require.ErrorIs(t, err, auth.ErrMFARequired)
+}
@@ -0,0 +1,209 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"advancely/internal/auth"
+	"advancely/internal/model"
+	"advancely/internal/store"
+
+	"github.com/labstack/echo/v4"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+const (
+	oauthCallbackPath        = "/auth/oauth/callback"
+	oauthDefaultRedirectPath = "/dashboard"
+	clientLoginPath          = "/login"
+	clientSignupPath         = "/signup"
+)
+
+// HandleOAuthStart begins a PKCE login with the OAuth provider, redirecting the user to the auth server.
+// The code verifier is kept in a short-lived cookie until the auth server redirects back to the callback.
+func (h AuthHandler) HandleOAuthStart() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		provider, err := auth.ParseOAuthProvider(c.Param("provider"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Unsupported provider").SetInternal(err)
+		}
+
+		verifier, challenge, err := auth.NewPKCE()
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to generate PKCE parameters", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		flow := auth.OAuthFlow{
+			CodeVerifier: verifier,
+			RedirectPath: sanitizeRedirectPath(c.QueryParam("redirect")),
+		}
+		if err := flow.SetCookie(c, h.Config.SessionSecret, h.Config.Environment); err != nil {
+			requestLogger(c, h.Logger).Error("failed to set OAuth flow cookie", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		redirectTo := h.Config.APIBaseURL + oauthCallbackPath
+		return c.Redirect(http.StatusFound, h.AuthProvider.OAuthURL(provider, redirectTo, challenge))
+	}
+}
+
+// HandleOAuthCallback completes the PKCE login, exchanging the code for a session.
+// Users without a profile are added to the company allowing their email domain. If no single
+// company allows the domain, the user is sent to the signup page to create their company.
+// As the user arrives here from the auth server, errors are reported by redirecting to the client login page.
+func (h AuthHandler) HandleOAuthCallback() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		if errCode := c.QueryParam("error"); errCode != "" {
+			requestLogger(c, h.Logger).Info("OAuth login failed", "error", errCode, "description", c.QueryParam("error_description"))
+			return h.redirectToClient(c, clientLoginPath, "error", "oauth_failed")
+		}
+
+		flow, err := auth.PopOAuthFlowCookie(c, h.Config.SessionSecret)
+		if err != nil {
+			requestLogger(c, h.Logger).Debug("failed to get OAuth flow", "error", err)
+			return h.redirectToClient(c, clientLoginPath, "error", "oauth_expired")
+		}
+
+		code := c.QueryParam("code")
+		if code == "" {
+			return h.redirectToClient(c, clientLoginPath, "error", "oauth_failed")
+		}
+
+		token, err := h.AuthProvider.ExchangeCodeForSession(ctx, code, flow.CodeVerifier)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to exchange OAuth code for session", "error", err)
+			return h.redirectToClient(c, clientLoginPath, "error", "oauth_failed")
+		}
+
+		user, err := h.UserStore.DefaultProfile(ctx, token.User.ID)
+		if errors.Is(err, store.ErrUserNotFound) {
+			var joined bool
+			user, joined, err = h.joinCompanyByEmailDomain(ctx, token.User)
+			if err == nil && !joined {
+				return h.redirectToClient(c, clientSignupPath, "email", token.User.Email)
+			}
+			if errors.Is(err, store.ErrProfileExists) {
+				return h.redirectToClient(c, clientLoginPath, "error", "account_deleted")
+			}
+		}
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to get user for OAuth login", "error", err)
+			return h.redirectToClient(c, clientLoginPath, "error", "oauth_failed")
+		}
+		if !user.Active() {
+			if err := h.AuthProvider.Logout(ctx, token.AccessToken); err != nil {
+				requestLogger(c, h.Logger).Error("failed to revoke session of deactivated user", "error", err)
+			}
+			return h.redirectToClient(c, clientLoginPath, "error", "account_deactivated")
+		}
+
+		ssoEnforced, err := h.ssoEnforced(ctx, user.CompanyID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed getting SAML configuration", "error", err)
+			return h.redirectToClient(c, clientLoginPath, "error", "oauth_failed")
+		}
+		if ssoEnforced {
+			if err := h.AuthProvider.Logout(ctx, token.AccessToken); err != nil {
+				requestLogger(c, h.Logger).Error("failed to revoke session of user required to use SSO", "error", err)
+			}
+			return h.redirectToClient(c, clientLoginPath, "error", "sso_required")
+		}
+
+		company, err := h.CompanyStore.Company(ctx, user.CompanyID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed getting company from store", "error", err)
+			return h.redirectToClient(c, clientLoginPath, "error", "oauth_failed")
+		}
+
+		session := auth.NewSessionCookie(*token)
+		session.SetUser(user)
+		session.SetCompany(company)
+
+		mfaState, err := h.mfaStateForUser(ctx, user)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed determining MFA state", "error", err)
+			return h.redirectToClient(c, clientLoginPath, "error", "oauth_failed")
+		}
+		session.MFAState = mfaState
+
+		if err := session.SetCookie(c, h.Config.SessionSecret, h.Config.Environment); err != nil {
+			requestLogger(c, h.Logger).Error("failed setting session cookie", "error", err)
+			return h.redirectToClient(c, clientLoginPath, "error", "oauth_failed")
+		}
+
+		if session.MFAPending() {
+			return h.redirectToClient(c, clientLoginPath, "status", mfaState)
+		}
+		return c.Redirect(http.StatusFound, h.Config.ClientBaseURL+flow.RedirectPath)
+	}
+}
+
+// joinCompanyByEmailDomain creates a profile for the user, with the default roles, in the company that has verified
+// their email domain.
+// False is returned if the OAuth provider has not verified the user's email, or no company has verified the domain.
+func (h AuthHandler) joinCompanyByEmailDomain(ctx context.Context, user types.User) (model.UserProfile, bool, error) {
+	at := strings.LastIndex(user.Email, "@")
+	if at == -1 || !auth.OAuthEmailVerified(user) {
+		return model.UserProfile{}, false, nil
+	}
+
+	companyID, err := h.SettingsStore.CompanyIDByVerifiedEmailDomain(ctx, user.Email[at+1:])
+	if errors.Is(err, store.ErrDomainNotFound) {
+		return model.UserProfile{}, false, nil
+	}
+	if err != nil {
+		return model.UserProfile{}, false, err
+	}
+
+	roleIDs, err := defaultRoleIDs(ctx, h.SettingsStore, companyID)
+	if err != nil {
+		return model.UserProfile{}, false, err
+	}
+
+	firstName, lastName := namesFromMetadata(user.UserMetadata)
+	profile, err := h.UserStore.CreateProfile(ctx, store.CreateProfileRequest{
+		UserID:    user.ID,
+		CompanyID: companyID,
+		FirstName: firstName,
+		LastName:  lastName,
+		RoleIDs:   roleIDs,
+	})
+	if err != nil {
+		return model.UserProfile{}, false, err
+	}
+	return profile, true, nil
+}
+
+// redirectToClient redirects to the given client path with a single query parameter.
+func (h AuthHandler) redirectToClient(c echo.Context, path, key, value string) error {
+	q := url.Values{}
+	q.Set(key, value)
+	return c.Redirect(http.StatusFound, h.Config.ClientBaseURL+path+"?"+q.Encode())
+}
+
+// namesFromMetadata returns the user's first and last name from the metadata provided by the identity provider.
+func namesFromMetadata(metadata map[string]interface{}) (string, string) {
+	for _, key := range []string{"full_name", "name"} {
+		name, ok := metadata[key].(string)
+		if !ok || strings.TrimSpace(name) == "" {
+			continue
+		}
+		first, last, _ := strings.Cut(strings.TrimSpace(name), " ")
+		return first, strings.TrimSpace(last)
+	}
+	return "", ""
+}
+
+// sanitizeRedirectPath ensures the redirect is a path on the client, preventing open redirects.
+func sanitizeRedirectPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.Contains(path, "\\") {
+		return oauthDefaultRedirectPath
+	}
+	return path
+}
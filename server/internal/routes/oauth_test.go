@@ -0,0 +1,235 @@
+package routes_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"advancely/internal/auth"
+	"advancely/internal/routes"
+	"advancely/internal/store"
+	"advancely/internal/tests"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+const (
+	testClientBaseURL = "http://client.test"
+	testAPIBaseURL    = "http://api.test/api/v1"
+)
+
+func newTestOAuthHandler(handler routes.AuthHandler) routes.AuthHandler {
+	handler.Config.ClientBaseURL = testClientBaseURL
+	handler.Config.APIBaseURL = testAPIBaseURL
+	return handler
+}
+
+// startOAuth runs the start handler, returning the redirect URL and the cookies set on the response.
+func startOAuth(t *testing.T, handler routes.AuthHandler, provider, redirect string) (*url.URL, []*http.Cookie) {
+	target := "/auth/oauth/" + provider + "/start"
+	if redirect != "" {
+		target += "?redirect=" + url.QueryEscape(redirect)
+	}
+	c, rec := tests.NewRequestRecorder(t, http.MethodGet, target, nil)
+	c.SetParamNames("provider")
+	c.SetParamValues(provider)
+
+	err := handler.HandleOAuthStart()(c)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	require.NoError(t, err)
+	return location, rec.Result().Cookies()
+}
+
+func callbackOAuth(t *testing.T, handler routes.AuthHandler, query url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	c, rec := tests.NewRequestRecorder(t, http.MethodGet, "/auth/oauth/callback?"+query.Encode(), nil)
+	for _, cookie := range cookies {
+		c.Request().AddCookie(cookie)
+	}
+
+	err := handler.HandleOAuthCallback()(c)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, rec.Code)
+	return rec
+}
+
+func TestOAuthStart(t *testing.T) {
+	handler := newTestOAuthHandler(newTestAuthHandler(nil, tests.NewFakeAuthProvider()))
+
+	location, cookies := startOAuth(t, handler, "microsoft", "")
+	require.Equal(t, "azure", location.Query().Get("provider"))
+	require.Equal(t, testAPIBaseURL+"/auth/oauth/callback", location.Query().Get("redirect_to"))
+	require.NotEmpty(t, location.Query().Get("code_challenge"))
+
+	require.Len(t, cookies, 1)
+	require.Equal(t, auth.OAuthFlowCookieStoreName, cookies[0].Name)
+	require.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+}
+
+func TestOAuthStartWithUnsupportedProvider(t *testing.T) {
+	handler := newTestOAuthHandler(newTestAuthHandler(nil, tests.NewFakeAuthProvider()))
+
+	c, _ := tests.NewRequestRecorder(t, http.MethodGet, "/auth/oauth/github/start", nil)
+	c.SetParamNames("provider")
+	c.SetParamValues("github")
+
+	err := handler.HandleOAuthStart()(c)
+	assertHTTPError(t, err, http.StatusNotFound, "Unsupported provider")
+}
+
+func TestOAuthCallbackFailures(t *testing.T) {
+	authProvider := tests.NewFakeAuthProvider()
+	authProvider.AddUser(tests.NewAuthUser(tests.DefaultUserEmail), tests.DefaultUserPassword)
+	handler := newTestOAuthHandler(newTestAuthHandler(nil, authProvider))
+
+	_, cookies := startOAuth(t, handler, "google", "")
+
+	testCases := []struct {
+		name          string
+		query         url.Values
+		cookies       []*http.Cookie
+		expectedError string
+	}{
+		{
+			name:          "error from the auth server",
+			query:         url.Values{"error": {"access_denied"}},
+			cookies:       cookies,
+			expectedError: "oauth_failed",
+		},
+		{
+			name:          "no flow cookie",
+			query:         url.Values{"code": {"code"}},
+			cookies:       nil,
+			expectedError: "oauth_expired",
+		},
+		{
+			name:          "no code",
+			query:         url.Values{},
+			cookies:       cookies,
+			expectedError: "oauth_failed",
+		},
+		{
+			name: "code issued for another code challenge",
+			query: url.Values{"code": {
+				authProvider.AddOAuthCode(tests.DefaultUserEmail, auth.PKCEChallenge("another-verifier")),
+			}},
+			cookies:       cookies,
+			expectedError: "oauth_failed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := callbackOAuth(t, handler, tc.query, tc.cookies)
+
+			location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+			require.NoError(t, err)
+			require.Equal(t, "/login", location.Path)
+			require.Equal(t, tc.expectedError, location.Query().Get("error"))
+		})
+	}
+}
+
+// addOAuthUser registers a user who logs in with the Azure identity, which asserts whether it verified their email.
+func addOAuthUser(t *testing.T, authProvider *tests.FakeAuthProvider, db *sqlx.DB, email string, emailVerified bool) types.User {
+	user := tests.CreateAuthUser(t, authProvider, db, email)
+	user.UserMetadata = map[string]interface{}{"full_name": "New Starter"}
+	user.Identities = []types.Identity{{
+		UserID:       user.ID,
+		Provider:     string(types.ProviderAzure),
+		IdentityData: map[string]interface{}{"email": email, "email_verified": emailVerified},
+	}}
+	authProvider.AddUser(user, "")
+	return user
+}
+
+func TestOAuthLoginJoinsCompanyByAllowedEmailDomain(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider()
+	admin := tests.SignUpAdminUser(t, authProvider, db)
+
+	ctx := context.Background()
+	adminProfile, err := store.NewPostgresUserStore(db).DefaultProfile(ctx, admin.ID)
+	require.NoError(t, err)
+	settingsStore := store.NewPostgresCompanySettingsStore(db)
+	_, err = settingsStore.AddAllowedEmailDomain(ctx, adminProfile.CompanyID, "company-email.com")
+	require.NoError(t, err)
+	_, err = settingsStore.VerifyAllowedEmailDomain(ctx, adminProfile.CompanyID, "company-email.com")
+	require.NoError(t, err)
+
+	newUser := addOAuthUser(t, authProvider, db, "new.starter@company-email.com", true)
+
+	handler := newTestOAuthHandler(newTestAuthHandler(db, authProvider))
+	location, cookies := startOAuth(t, handler, "microsoft", "/dashboard/users")
+	code := authProvider.AddOAuthCode(newUser.Email, location.Query().Get("code_challenge"))
+
+	rec := callbackOAuth(t, handler, url.Values{"code": {code}}, cookies)
+	require.Equal(t, testClientBaseURL+"/dashboard/users", rec.Header().Get(echo.HeaderLocation))
+
+	profile, err := store.NewPostgresUserStore(db).DefaultProfile(ctx, newUser.ID)
+	require.NoError(t, err)
+	require.Equal(t, adminProfile.CompanyID, profile.CompanyID)
+	require.Equal(t, "New", profile.FirstName)
+	require.Equal(t, "Starter", profile.LastName)
+}
+
+func TestOAuthLoginDoesNotJoinCompanyWithoutVerifiedEmailAndDomain(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider()
+	admin := tests.SignUpAdminUser(t, authProvider, db)
+
+	ctx := context.Background()
+	adminProfile, err := store.NewPostgresUserStore(db).DefaultProfile(ctx, admin.ID)
+	require.NoError(t, err)
+	settingsStore := store.NewPostgresCompanySettingsStore(db)
+	_, err = settingsStore.AddAllowedEmailDomain(ctx, adminProfile.CompanyID, "company-email.com")
+	require.NoError(t, err)
+	_, err = settingsStore.VerifyAllowedEmailDomain(ctx, adminProfile.CompanyID, "company-email.com")
+	require.NoError(t, err)
+	_, err = settingsStore.AddAllowedEmailDomain(ctx, adminProfile.CompanyID, "unverified-email.com")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		email         string
+		emailVerified bool
+	}{
+		{name: "email not verified by the provider", email: "attacker@company-email.com", emailVerified: false},
+		{name: "domain not verified by the company", email: "new.starter@unverified-email.com", emailVerified: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := addOAuthUser(t, authProvider, db, tc.email, tc.emailVerified)
+
+			handler := newTestOAuthHandler(newTestAuthHandler(db, authProvider))
+			location, cookies := startOAuth(t, handler, "microsoft", "")
+			code := authProvider.AddOAuthCode(user.Email, location.Query().Get("code_challenge"))
+
+			rec := callbackOAuth(t, handler, url.Values{"code": {code}}, cookies)
+			require.Equal(t, testClientBaseURL+"/signup?"+url.Values{"email": {tc.email}}.Encode(), rec.Header().Get(echo.HeaderLocation))
+
+			_, err := store.NewPostgresUserStore(db).DefaultProfile(ctx, user.ID)
+			require.ErrorIs(t, err, store.ErrUserNotFound)
+		})
+	}
+}
+
+func TestOAuthLoginWithUnknownDomainRedirectsToSignup(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider()
+	user := tests.CreateAuthUser(t, authProvider, db, "founder@new-company.com")
+
+	handler := newTestOAuthHandler(newTestAuthHandler(db, authProvider))
+	location, cookies := startOAuth(t, handler, "google", "")
+	code := authProvider.AddOAuthCode(user.Email, location.Query().Get("code_challenge"))
+
+	rec := callbackOAuth(t, handler, url.Values{"code": {code}}, cookies)
+	require.Equal(t, testClientBaseURL+"/signup?email=founder%40new-company.com", rec.Header().Get(echo.HeaderLocation))
+}
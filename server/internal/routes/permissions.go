@@ -49,7 +49,8 @@ func (h PermissionsHandler) MakeRoutes(e *echo.Group) {
 	roleGroup.GET("", h.handleListRolesWithPermissions())
 	roleGroup.POST("", h.HandleCreateRole())
 	roleGroup.PUT("/:roleId", h.handleUpdateRole())
-	roleGroup.DELETE("/:roleId", h.handleDeleteRole())
+	roleGroup.DELETE("/:roleId", h.HandleDeleteRole())
+	roleGroup.POST("/:roleId/restore", h.HandleRestoreRole())
 
 	permissionGroup := group.Group("/role/:roleId/permission")
 	permissionGroup.POST("/:permissionId", h.handleAssignPermissionToRole())
@@ -62,6 +63,7 @@ func (h PermissionsHandler) MakeRoutes(e *echo.Group) {
 
 func (h PermissionsHandler) handleGetRoleWithPermissions() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		session := auth.CurrentUser(c)
 
 		roleId, err := strconv.Atoi(c.Param("roleId"))
@@ -69,10 +71,10 @@ func (h PermissionsHandler) handleGetRoleWithPermissions() echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusBadRequest, "Role ID could not be parsed")
 		}
 
-		role, err := h.PermissionsStore.Role(roleId, &session.Company.ID)
+		role, err := h.PermissionsStore.Role(ctx, roleId, &session.Company.ID)
 		if err != nil {
 			if errors.Is(err, store.ErrRoleNotFound) {
-				return echo.NewHTTPError(http.StatusNotFound, err.Error())
+				return echo.NewHTTPError(http.StatusNotFound, err)
 			}
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
@@ -82,10 +84,11 @@ func (h PermissionsHandler) handleGetRoleWithPermissions() echo.HandlerFunc {
 
 func (h PermissionsHandler) handleListRolesWithPermissions() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		session := auth.CurrentUser(c)
-		roles, err := h.PermissionsStore.Roles(session.Company.ID)
+		roles, err := h.PermissionsStore.Roles(ctx, session.Company.ID)
 		if err != nil {
-			h.Logger.Error("failed to list roles", "error", err)
+			requestLogger(c, h.Logger).Error("failed to list roles", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 		return c.JSON(http.StatusOK, roles)
@@ -99,6 +102,7 @@ type CreateRoleRequest struct {
 
 func (h PermissionsHandler) HandleCreateRole() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		session := auth.CurrentUser(c)
 		if err := h.EnsurePermission(c, security.PermissionCreateRole); err != nil {
 			return err
@@ -119,7 +123,7 @@ func (h PermissionsHandler) HandleCreateRole() echo.HandlerFunc {
 
 		// Create the role
 
-		createdRole, err := h.PermissionsStore.CreateRole(role)
+		createdRole, err := h.PermissionsStore.CreateRole(ctx, role)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
@@ -134,6 +138,7 @@ func (h PermissionsHandler) handleUpdateRole() echo.HandlerFunc {
 	}
 
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		session := auth.CurrentUser(c)
 		if err := h.EnsurePermission(c, security.PermissionEditRole); err != nil {
 			return err
@@ -141,14 +146,14 @@ func (h PermissionsHandler) handleUpdateRole() echo.HandlerFunc {
 
 		roleId, err := strconv.Atoi(c.Param("roleId"))
 		if err != nil {
-			h.Logger.Error("error parsing roleId param", "error", err)
+			requestLogger(c, h.Logger).Error("error parsing roleId param", "error", err)
 			return echo.NewHTTPError(http.StatusBadRequest, "could not determine the role ID")
 		}
 
-		role, err := h.PermissionsStore.Role(roleId, &session.Company.ID)
+		role, err := h.PermissionsStore.Role(ctx, roleId, &session.Company.ID)
 		if err != nil {
 			if errors.Is(err, store.ErrRoleNotFound) {
-				return echo.NewHTTPError(http.StatusNotFound, err.Error())
+				return echo.NewHTTPError(http.StatusNotFound, err)
 			}
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
@@ -159,7 +164,7 @@ func (h PermissionsHandler) handleUpdateRole() echo.HandlerFunc {
 
 		var request Request
 		if err := validation.BindAndValidate(c, &request); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			return err
 		}
 
 		update := model.Role{
@@ -170,15 +175,16 @@ func (h PermissionsHandler) handleUpdateRole() echo.HandlerFunc {
 			IsSystemRole: role.IsSystemRole,
 		}
 
-		if err := h.PermissionsStore.UpdateRole(&update); err != nil {
+		if err := h.PermissionsStore.UpdateRole(ctx, &update); err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 		return c.NoContent(http.StatusNoContent)
 	}
 }
 
-func (h PermissionsHandler) handleDeleteRole() echo.HandlerFunc {
+func (h PermissionsHandler) HandleDeleteRole() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		session := auth.CurrentUser(c)
 		if err := h.EnsurePermission(c, security.PermissionDeleteRole); err != nil {
 			return err
@@ -194,15 +200,53 @@ func (h PermissionsHandler) handleDeleteRole() echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusBadRequest, "role id is invalid")
 		}
 
-		if err := h.PermissionsStore.DeleteRole(roleId, session.Company.ID); err != nil {
+		if err := h.PermissionsStore.DeleteRole(ctx, roleId, session.Company.ID); err != nil {
+			if errors.Is(err, store.ErrRoleNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
+			}
+			if errors.Is(err, store.ErrCannotDeleteSystemRole) {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+			}
+			requestLogger(c, h.Logger).Error("error deleting role", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 		return c.NoContent(http.StatusNoContent)
 	}
 }
 
+// HandleRestoreRole restores a deleted role before it is purged. The role is assigned again
+// to the users it was assigned to when it was deleted.
+func (h PermissionsHandler) HandleRestoreRole() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		session := auth.CurrentUser(c)
+		if err := h.EnsurePermission(c, security.PermissionDeleteRole); err != nil {
+			return err
+		}
+
+		roleID, err := strconv.Atoi(c.Param("roleId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "role id is invalid")
+		}
+
+		role, err := h.PermissionsStore.RestoreRole(ctx, roleID, session.Company.ID)
+		if err != nil {
+			if errors.Is(err, store.ErrRoleNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
+			}
+			if errors.Is(err, store.ErrRoleNotDeleted) {
+				return echo.NewHTTPError(http.StatusConflict, err)
+			}
+			requestLogger(c, h.Logger).Error("error restoring role", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, role)
+	}
+}
+
 func (h PermissionsHandler) handleAssignPermissionToRole() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		session := auth.CurrentUser(c)
 		if err := h.EnsurePermission(c, security.PermissionEditRole); err != nil {
 			return err
@@ -214,14 +258,14 @@ func (h PermissionsHandler) handleAssignPermissionToRole() echo.HandlerFunc {
 			return echo.NewHTTPError(400, "role or permission ID not valid")
 		}
 
-		if err := h.PermissionsStore.AssignPermissionToRole(roleID, permissionId, session.Company.ID); err != nil {
+		if err := h.PermissionsStore.AssignPermissionToRole(ctx, roleID, permissionId, session.Company.ID); err != nil {
 			if errors.Is(err, store.ErrCannotUpdateSystemRole) {
-				return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
 			}
 			if errs.IsOne(err, store.ErrRoleNotFound, store.ErrPermissionNotFount) {
-				return echo.NewHTTPError(http.StatusNotFound, err.Error())
+				return echo.NewHTTPError(http.StatusNotFound, err)
 			}
-			h.Logger.Error("error assigning permission to role", "error", err)
+			requestLogger(c, h.Logger).Error("error assigning permission to role", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 		return c.NoContent(http.StatusCreated)
@@ -230,6 +274,7 @@ func (h PermissionsHandler) handleAssignPermissionToRole() echo.HandlerFunc {
 
 func (h PermissionsHandler) handleRemovePermissionFromRole() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		session := auth.CurrentUser(c)
 		if err := h.EnsurePermission(c, security.PermissionEditRole); err != nil {
 			return err
@@ -241,14 +286,14 @@ func (h PermissionsHandler) handleRemovePermissionFromRole() echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusBadRequest, "role or permission ID not valid")
 		}
 
-		if err := h.PermissionsStore.RemovePermissionFromRole(roleID, permissionId, session.Company.ID); err != nil {
+		if err := h.PermissionsStore.RemovePermissionFromRole(ctx, roleID, permissionId, session.Company.ID); err != nil {
 			if errors.Is(err, store.ErrRoleNotFound) {
-				return echo.NewHTTPError(http.StatusNotFound, err.Error())
+				return echo.NewHTTPError(http.StatusNotFound, err)
 			}
 			if errs.IsOne(err, store.ErrCannotUpdateSystemRole, store.ErrCannotUpdateSystemRole) {
-				return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
 			}
-			h.Logger.Error("error removing permission from role", "error", err)
+			requestLogger(c, h.Logger).Error("error removing permission from role", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 		return c.NoContent(http.StatusNoContent)
@@ -257,6 +302,7 @@ func (h PermissionsHandler) handleRemovePermissionFromRole() echo.HandlerFunc {
 
 func (h PermissionsHandler) handleAssignRoleToUser() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		session := auth.CurrentUser(c)
 		if err := h.EnsurePermission(c, security.PermissionAssignUserRole); err != nil {
 			return err
@@ -272,11 +318,11 @@ func (h PermissionsHandler) handleAssignRoleToUser() echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusBadRequest, "user ID not valid")
 		}
 
-		if err := h.PermissionsStore.AssignRoleToUser(roleID, userID, session.Company.ID); err != nil {
-			if errors.Is(err, store.ErrRoleNotFound) {
-				return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		if err := h.PermissionsStore.AssignRoleToUser(ctx, roleID, userID, session.Company.ID); err != nil {
+			if errors.Is(err, store.ErrRoleNotFound) || errors.Is(err, store.ErrUserNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
 			}
-			h.Logger.Error("error assigning role to user", "error", err)
+			requestLogger(c, h.Logger).Error("error assigning role to user", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 		return c.NoContent(http.StatusCreated)
@@ -285,6 +331,7 @@ func (h PermissionsHandler) handleAssignRoleToUser() echo.HandlerFunc {
 
 func (h PermissionsHandler) handleRemoveRoleFromUser() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		session := auth.CurrentUser(c)
 		if err := h.EnsurePermission(c, security.PermissionRemoveUserRole); err != nil {
 			return err
@@ -300,8 +347,8 @@ func (h PermissionsHandler) handleRemoveRoleFromUser() echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusBadRequest, "user ID not valid")
 		}
 
-		if err := h.PermissionsStore.RemoveRoleFromUser(roleID, userID, session.Company.ID); err != nil {
-			h.Logger.Error("error removing role from user", "error", err)
+		if err := h.PermissionsStore.RemoveRoleFromUser(ctx, roleID, userID, session.Company.ID); err != nil {
+			requestLogger(c, h.Logger).Error("error removing role from user", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 		return c.NoContent(http.StatusNoContent)
@@ -1,15 +1,21 @@
 package routes_test
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"advancely/internal/application"
+	"advancely/internal/model"
+	"advancely/internal/model/security"
 	"advancely/internal/routes"
 	"advancely/internal/store"
 	"advancely/internal/tests"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/require"
 )
 
@@ -67,3 +73,45 @@ func TestHandleCreateRoleWithIncorrectPermission(t *testing.T) {
 	require.Error(t, err)
 	require.Equal(t, http.StatusForbidden, rec.Code)
 }
+
+func TestHandleDeleteAndRestoreRole(t *testing.T) {
+	db, user, companyId := setUpTestAdminUserAndCompany(t)
+	handler := newPermissionsHandler(db, tests.NewFakeRoleFetcher(security.PermissionDeleteRole))
+	ctx := context.Background()
+
+	role, err := handler.PermissionsStore.CreateRole(ctx, model.CreateRole{CompanyID: companyId, Name: "deleted-role"})
+	require.NoError(t, err)
+	require.NoError(t, handler.PermissionsStore.AssignRoleToUser(ctx, role.ID, user.ID, companyId))
+
+	call := func(h echo.HandlerFunc, method string, roleID int) (*httptest.ResponseRecorder, error) {
+		c, rec := tests.NewRequestRecorder(t, method, "/auth/permissions/role/"+strconv.Itoa(roleID), nil)
+		tests.SaveSessionInContext(c, user.ID, companyId)
+		c.SetParamNames("roleId")
+		c.SetParamValues(strconv.Itoa(roleID))
+		return rec, h(c)
+	}
+
+	rec, err := call(handler.HandleDeleteRole(), http.MethodDelete, role.ID)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	_, err = handler.PermissionsStore.Role(ctx, role.ID, &companyId)
+	require.ErrorIs(t, err, store.ErrRoleNotFound)
+	roles, err := handler.PermissionsStore.Roles(ctx, companyId)
+	require.NoError(t, err)
+	for _, r := range roles {
+		require.NotEqual(t, role.ID, r.ID)
+	}
+
+	rec, err = call(handler.HandleRestoreRole(), http.MethodPost, role.ID)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// The role is assigned again to the users it was assigned to.
+	userIDs, err := handler.PermissionsStore.RoleUserIDs(ctx, role.ID, companyId)
+	require.NoError(t, err)
+	require.Equal(t, []uuid.UUID{user.ID}, userIDs)
+
+	_, err = call(handler.HandleRestoreRole(), http.MethodPost, role.ID)
+	assertHTTPError(t, err, http.StatusConflict, "")
+}
@@ -1,17 +1,27 @@
 package routes
 
 import (
+	"log/slog"
 	"net/http"
 
+	"advancely/cmd/migrate/migrations"
+	"advancely/internal/apierror"
 	"advancely/internal/application"
 	"advancely/internal/auth"
+	"advancely/internal/health"
+	"advancely/internal/logging"
+	"advancely/internal/metrics"
 	mw "advancely/internal/middleware"
 	"advancely/internal/model/security"
 	"advancely/internal/store"
+	"advancely/internal/tracing"
 	"advancely/internal/validation"
+	"advancely/pkg/migrator"
+	"advancely/pkg/sbext"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 )
 
 // RouteMaker is the interface used to specify handlers must implement the MakeRoutes method.
@@ -21,18 +31,48 @@ type RouteMaker interface {
 
 type Router struct {
 	*echo.Echo
-	RoleFetcher store.RoleFetcher
+	RoleFetcher  store.RoleFetcher
+	AuthProvider auth.Provider
+	// RateLimitStore holds the rate limit counts. It is in memory by default,
+	// so limits apply per instance of the API.
+	RateLimitStore mw.RateLimitStore
+	// Metrics is served separately from the router on the metrics listen address.
+	Metrics *metrics.Metrics
+	// Health serves the liveness and readiness probes.
+	Health *health.Checker
 }
 
 func NewRouter(app *application.App) *Router {
+	m := metrics.New()
+	m.RegisterDB(app.Config.Database.Name, app.Store.DB.DB)
+
+	supabaseClient := sbext.NewSupabaseExtended(app.Supabase, app.Config.Supabase).WithHTTPClient(&http.Client{
+		Transport: tracing.NewTransport(m.InstrumentRoundTripper(metrics.ServiceSupabase, http.DefaultTransport)),
+	})
+
 	r := &Router{
 		Echo:        echo.New(),
 		RoleFetcher: app.Store.PermissionsStore,
+		AuthProvider: m.InstrumentProvider(
+			auth.NewSupabaseProvider(supabaseClient, app.Config.Supabase.ServiceRoleSecret).
+				WithTransport(tracing.NewTransport(http.DefaultTransport)),
+		),
+		RateLimitStore: mw.NewMemoryRateLimitStore(),
+		Metrics:        m,
+		Health: health.NewChecker(app.Logger,
+			health.DatabaseCheck(app.Store.DB.DB),
+			health.MigrationsCheck(app.Store.DB.DB, migrator.FSSource(migrations.FS)),
+			health.AuthCheck(supabaseClient.Extensions.AuthHealth),
+		),
 	}
 
+	r.IPExtractor = mw.ClientIPExtractor(app.Config.TrustedProxies)
 	r.Validator = validation.NewCustomValidator()
+	r.HTTPErrorHandler = apierror.NewHTTPErrorHandler(NewErrorRegistry(), app.Logger)
 	r.configureMiddleware(app)
 
+	r.Health.MakeRoutes(r.Group(""))
+
 	baseGroup := r.Group("/api/v1")
 	for _, h := range r.getRouteHandlers(app) {
 		h.MakeRoutes(baseGroup)
@@ -46,29 +86,62 @@ type EnsurePermissionFn = func(c echo.Context, permission security.Permission) *
 func EnsurePermissionsFnFactory(fetcher store.RoleFetcher) EnsurePermissionFn {
 	return func(c echo.Context, permission security.Permission) *echo.HTTPError {
 		session := auth.CurrentUser(c)
-		roles, err := fetcher.UserRoles(session.User.ID)
+		roles, err := fetcher.UserRoles(c.Request().Context(), session.User.ID, session.Company.ID)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
-		if !roles.HasPermission(permission) {
+		// Sessions authenticated with a scoped personal access token are limited to
+		// the intersection of the token scope and the permissions of the user's roles.
+		if !roles.HasPermission(permission) || !session.InScope(permission) {
 			return echo.NewHTTPError(http.StatusForbidden)
 		}
 		return nil
 	}
 }
 
+// requestLogger returns the logger of the request, which records its ID and the authenticated user.
+// Returns fallback if the request has no logger, such as in tests not using the full middleware chain.
+func requestLogger(c echo.Context, fallback *slog.Logger) *slog.Logger {
+	return logging.FromContext(c.Request().Context(), fallback)
+}
+
+// instrumentPermissionChecks counts the outcomes of the permission checks made with ensurePermission.
+func instrumentPermissionChecks(ensurePermission EnsurePermissionFn, m *metrics.Metrics) EnsurePermissionFn {
+	return func(c echo.Context, permission security.Permission) *echo.HTTPError {
+		err := ensurePermission(c, permission)
+		switch {
+		case err == nil:
+			m.ObservePermissionCheck(string(permission), metrics.PermissionAllowed)
+		case err.Code == http.StatusForbidden:
+			m.ObservePermissionCheck(string(permission), metrics.PermissionDenied)
+		default:
+			m.ObservePermissionCheck(string(permission), metrics.PermissionError)
+		}
+		return err
+	}
+}
+
 func (r *Router) getRouteHandlers(app *application.App) []RouteMaker {
-	ensurePermissionFn := EnsurePermissionsFnFactory(r.RoleFetcher)
+	ensurePermissionFn := instrumentPermissionChecks(EnsurePermissionsFnFactory(r.RoleFetcher), r.Metrics)
+	rateLimiter := mw.NewRateLimiter(r.RateLimitStore, app.Logger)
 
 	return []RouteMaker{
-		NewAuthHandler(app.Supabase, app.Store, app.Config, app.Logger),
+		NewAuthHandler(r.AuthProvider, app.Store, app.Config, rateLimiter, app.Logger),
+		NewMFAHandler(app.Store, app.Config, rateLimiter, app.Logger),
+		NewSAMLHandler(r.AuthProvider, app.Store, app.Config, app.Logger),
+		NewSCIMHandler(r.AuthProvider, app.Store, app.Config, app.Logger),
 		NewPermissionsHandler(app.Store, app.Config, app.Logger, ensurePermissionFn),
-		NewCompaniesHandler(app.Store, app.Logger, ensurePermissionFn),
-		NewUsersHandler(app.Store, app.Supabase, ensurePermissionFn, app.Logger),
+		NewCompaniesHandler(app.Store, app.Config, app.Logger, ensurePermissionFn),
+		NewUsersHandler(app.Store, r.AuthProvider, ensurePermissionFn, app.Logger),
+		NewPersonalAccessTokensHandler(app.Store, ensurePermissionFn, app.Logger),
 	}
 }
 
 func (r *Router) configureMiddleware(app *application.App) {
+	r.Use(otelecho.Middleware(app.Config.Tracing.ServiceName, otelecho.WithSkipper(health.IsProbe)))
+	r.Use(mw.NewRequestLogger(app.Logger).WithSkipper(health.IsProbe).Handle)
+	r.Use(r.Metrics.Middleware)
+
 	// In production, the client is served from the api.
 	// In development, the client must be served separately.
 	if app.Config.Environment.IsProduction() {
@@ -91,11 +164,14 @@ func (r *Router) configureMiddleware(app *application.App) {
 	}
 	r.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins:     allowOrigins,
-		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
+		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, echo.HeaderXRequestID},
 		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		ExposeHeaders:    []string{echo.HeaderRetryAfter, echo.HeaderXRequestID},
 		AllowCredentials: true,
 	}))
 
-	userMw := mw.NewUserMiddleware(app.Config, app.Supabase, app.Store.UserStore, app.Logger)
+	verifier := auth.NewVerifier(app.Config.Supabase)
+	userMw := mw.NewUserMiddleware(app.Config, r.AuthProvider, verifier, app.Store.UserStore, app.Store.PersonalAccessTokenStore, auth.NewSessionPolicy(app.Store), app.Logger).
+		WithDeletedCompanyAllowed(IsRestoreCompany)
 	r.Use(userMw.WithUserInContext)
 }
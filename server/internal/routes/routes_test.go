@@ -5,6 +5,8 @@ import (
 	"github.com/stretchr/testify/require"
 	"testing"
 
+	"advancely/internal/auth"
+	"advancely/internal/store"
 	"advancely/internal/tests"
 
 	"github.com/google/uuid"
@@ -14,12 +16,20 @@ import (
 
 func setUpTestAdminUserAndCompany(t *testing.T) (*sqlx.DB, types.User, uuid.UUID) {
 	db := tests.SetUpTestDatabase(t)
-	sb := tests.NewTestSupabaseClient(t)
-	user := tests.CreateAdminUser(t, sb, db)
+	user := tests.CreateAdminUser(t, tests.NewFakeAuthProvider(), db)
 	companyId := tests.CreateTestCompany(t, db, user.ID)
 	return db, user, companyId
 }
 
+func newTestSessionPolicy(db *sqlx.DB) auth.SessionPolicy {
+	return auth.SessionPolicy{
+		CompanyStore:     store.NewPostgresCompanyStore(db),
+		MFAStore:         store.NewPostgresMFAStore(db),
+		SettingsStore:    store.NewPostgresCompanySettingsStore(db),
+		PermissionsStore: store.NewPostgresPermissionsStore(db),
+	}
+}
+
 func assertHTTPError(t *testing.T, err error, expectedCode int, expectedMessage interface{}) {
 	require.Error(t, err)
 	httpErr, ok := err.(*echo.HTTPError)
@@ -0,0 +1,336 @@
+package routes
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"advancely/internal/application"
+	"advancely/internal/auth"
+	"advancely/internal/model"
+	"advancely/internal/store"
+
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func NewSAMLHandler(
+	authProvider auth.Provider,
+	s *store.PostgresStore,
+	config application.AppConfig,
+	logger *slog.Logger,
+) SAMLHandler {
+	keyPair, err := auth.ParseSAMLKeyPair(config.SAML.SPCertificate, config.SAML.SPPrivateKey)
+	if err != nil {
+		logger.Error("failed to parse SAML key pair; authentication requests will not be signed", "error", err)
+	}
+
+	return SAMLHandler{
+		AuthProvider:  authProvider,
+		UserStore:     s.UserStore,
+		CompanyStore:  s.CompanyStore,
+		SettingsStore: s.CompanySettingsStore,
+		KeyPair:       keyPair,
+		Config:        config,
+		Logger:        logger,
+	}
+}
+
+type SAMLHandler struct {
+	AuthProvider  auth.Provider
+	UserStore     store.UserStore
+	CompanyStore  store.CompanyStore
+	SettingsStore store.CompanySettingsStore
+	KeyPair       *auth.SAMLKeyPair
+	Config        application.AppConfig
+	Logger        *slog.Logger
+}
+
+func (h SAMLHandler) MakeRoutes(e *echo.Group) {
+	group := e.Group("/auth/saml")
+	group.GET("/login", h.HandleDiscoverLogin())
+	group.GET("/:companyId/metadata", h.HandleMetadata())
+	group.GET("/:companyId/login", h.HandleLogin())
+	group.POST("/:companyId/acs", h.HandleACS())
+}
+
+// SAMLMetadataURL returns the URL of the service provider metadata for the company, which is also the SP entity ID.
+func SAMLMetadataURL(config application.AppConfig, companyID uuid.UUID) string {
+	return config.APIBaseURL + "/auth/saml/" + companyID.String() + "/metadata"
+}
+
+// SAMLACSURL returns the URL of the assertion consumer service for the company.
+func SAMLACSURL(config application.AppConfig, companyID uuid.UUID) string {
+	return config.APIBaseURL + "/auth/saml/" + companyID.String() + "/acs"
+}
+
+// serviceProvider returns the SAML service provider for the company in the companyId path parameter.
+func (h SAMLHandler) serviceProvider(c echo.Context) (*saml.ServiceProvider, model.CompanySAMLConfig, *echo.HTTPError) {
+	companyID, err := uuid.Parse(c.Param("companyId"))
+	if err != nil {
+		return nil, model.CompanySAMLConfig{}, echo.NewHTTPError(http.StatusNotFound)
+	}
+
+	cfg, err := h.SettingsStore.SAMLConfig(c.Request().Context(), companyID)
+	if err != nil {
+		if errors.Is(err, store.ErrSAMLConfigNotFound) {
+			return nil, model.CompanySAMLConfig{}, echo.NewHTTPError(http.StatusNotFound, "SSO is not configured for this company")
+		}
+		requestLogger(c, h.Logger).Error("failed to get SAML configuration", "error", err)
+		return nil, model.CompanySAMLConfig{}, echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	sp, err := auth.NewSAMLServiceProvider(cfg, SAMLMetadataURL(h.Config, companyID), SAMLACSURL(h.Config, companyID), h.KeyPair)
+	if err != nil {
+		requestLogger(c, h.Logger).Error("failed to create SAML service provider", "error", err)
+		return nil, model.CompanySAMLConfig{}, echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return sp, cfg, nil
+}
+
+// HandleMetadata returns the service provider metadata to be configured in the company's identity provider.
+func (h SAMLHandler) HandleMetadata() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sp, _, httpErr := h.serviceProvider(c)
+		if httpErr != nil {
+			return httpErr
+		}
+
+		b, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to marshal SAML metadata", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.Blob(http.StatusOK, "application/samlmetadata+xml", b)
+	}
+}
+
+// HandleDiscoverLogin starts a SAML login for the company that has verified the domain of the email query parameter.
+func (h SAMLHandler) HandleDiscoverLogin() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		email := c.QueryParam("email")
+		at := strings.LastIndex(email, "@")
+		if at == -1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "A valid email address is required")
+		}
+
+		companyID, err := h.SettingsStore.CompanyIDByVerifiedEmailDomain(ctx, email[at+1:])
+		if errors.Is(err, store.ErrDomainNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "SSO is not configured for this email domain")
+		}
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to get company by email domain", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		if _, err := h.SettingsStore.SAMLConfig(ctx, companyID); err != nil {
+			if errors.Is(err, store.ErrSAMLConfigNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, "SSO is not configured for this email domain")
+			}
+			requestLogger(c, h.Logger).Error("failed to get SAML configuration", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		target := h.Config.APIBaseURL + "/auth/saml/" + companyID.String() + "/login"
+		if redirect := c.QueryParam("redirect"); redirect != "" {
+			target += "?" + url.Values{"redirect": {redirect}}.Encode()
+		}
+		return c.Redirect(http.StatusFound, target)
+	}
+}
+
+// HandleLogin redirects the user to the company's identity provider with a SAML authentication request.
+func (h SAMLHandler) HandleLogin() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sp, cfg, httpErr := h.serviceProvider(c)
+		if httpErr != nil {
+			return httpErr
+		}
+
+		req, err := sp.MakeAuthenticationRequest(
+			sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to create SAML authentication request", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		redirect, err := req.Redirect("", sp)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to create SAML redirect", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		flow := auth.SAMLFlow{
+			CompanyID:    cfg.CompanyID,
+			RequestID:    req.ID,
+			RedirectPath: sanitizeRedirectPath(c.QueryParam("redirect")),
+		}
+		if err := flow.SetCookie(c, h.Config.SessionSecret); err != nil {
+			requestLogger(c, h.Logger).Error("failed to set SAML flow cookie", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.Redirect(http.StatusFound, redirect.String())
+	}
+}
+
+// HandleACS consumes the identity provider's response, logging the user in.
+// The email domain must have been verified by the company, and users without an account are provisioned
+// into the company. Existing accounts can only be logged in to if they are linked to the company.
+// Sessions created through SSO do not require local MFA; the identity provider is responsible for enforcing it.
+func (h SAMLHandler) HandleACS() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		sp, cfg, httpErr := h.serviceProvider(c)
+		if httpErr != nil {
+			return httpErr
+		}
+
+		flow, err := auth.PopSAMLFlowCookie(c, h.Config.SessionSecret)
+		if err != nil || flow.CompanyID != cfg.CompanyID {
+			requestLogger(c, h.Logger).Debug("failed to get SAML flow", "error", err)
+			return h.redirectToLogin(c, "sso_expired")
+		}
+
+		// ParseResponse reads the form without parsing it.
+		if err := c.Request().ParseForm(); err != nil {
+			return h.redirectToLogin(c, "sso_failed")
+		}
+		assertion, err := sp.ParseResponse(c.Request(), []string{flow.RequestID})
+		if err != nil {
+			var invalidErr *saml.InvalidResponseError
+			if errors.As(err, &invalidErr) {
+				err = invalidErr.PrivateErr
+			}
+			requestLogger(c, h.Logger).Info("invalid SAML response", "error", err)
+			return h.redirectToLogin(c, "sso_failed")
+		}
+
+		identity, err := auth.SAMLIdentityFromAssertion(assertion, cfg)
+		if err != nil {
+			requestLogger(c, h.Logger).Info("invalid SAML assertion", "error", err)
+			return h.redirectToLogin(c, "sso_failed")
+		}
+
+		allowed, err := emailDomainVerified(ctx, h.SettingsStore, cfg.CompanyID, identity.Email)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to check email domain", "error", err)
+			return h.redirectToLogin(c, "sso_failed")
+		}
+		if !allowed {
+			requestLogger(c, h.Logger).Info("SAML identity email domain is not verified by the company", "email", identity.Email)
+			return h.redirectToLogin(c, "sso_domain_not_allowed")
+		}
+
+		linked, err := h.accountLinked(ctx, cfg.CompanyID, identity.Email)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to check SAML account", "error", err)
+			return h.redirectToLogin(c, "sso_failed")
+		}
+		if !linked {
+			requestLogger(c, h.Logger).Info("SAML identity belongs to an account not linked to the company", "email", identity.Email)
+			return h.redirectToLogin(c, "sso_account_not_linked")
+		}
+
+		token, err := h.AuthProvider.SignInWithVerifiedEmail(ctx, identity.Email, map[string]interface{}{
+			"full_name": strings.TrimSpace(identity.FirstName + " " + identity.LastName),
+		})
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to sign in SAML user", "error", err)
+			return h.redirectToLogin(c, "sso_failed")
+		}
+
+		user, err := h.UserStore.User(ctx, token.User.ID, cfg.CompanyID)
+		if errors.Is(err, store.ErrUserNotFound) {
+			user, err = h.provisionUser(ctx, token.User.ID, cfg.CompanyID, identity)
+		}
+		if errors.Is(err, store.ErrProfileExists) {
+			return h.redirectToLogin(c, "account_deleted")
+		}
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to get or provision SAML user", "error", err)
+			return h.redirectToLogin(c, "sso_failed")
+		}
+		if !user.Active() {
+			if err := h.AuthProvider.Logout(ctx, token.AccessToken); err != nil {
+				requestLogger(c, h.Logger).Error("failed to revoke session of deactivated user", "error", err)
+			}
+			return h.redirectToLogin(c, "account_deactivated")
+		}
+
+		company, err := h.CompanyStore.Company(ctx, cfg.CompanyID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed getting company from store", "error", err)
+			return h.redirectToLogin(c, "sso_failed")
+		}
+
+		session := auth.NewSessionCookie(*token)
+		session.SetUser(user)
+		session.SetCompany(company)
+		if err := session.SetCookie(c, h.Config.SessionSecret, h.Config.Environment); err != nil {
+			requestLogger(c, h.Logger).Error("failed setting session cookie", "error", err)
+			return h.redirectToLogin(c, "sso_failed")
+		}
+		return c.Redirect(http.StatusFound, h.Config.ClientBaseURL+flow.RedirectPath)
+	}
+}
+
+// emailDomainVerified returns true if the company has verified it owns the domain of the email.
+// This prevents a company's identity provider from asserting, or provisioning, identities belonging
+// to other companies.
+func emailDomainVerified(ctx context.Context, s store.CompanySettingsStore, companyID uuid.UUID, email string) (bool, error) {
+	domain := email[strings.LastIndex(email, "@")+1:]
+	ownerID, err := s.CompanyIDByVerifiedEmailDomain(ctx, domain)
+	if errors.Is(err, store.ErrDomainNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return ownerID == companyID, nil
+}
+
+// accountLinked returns true if the identity provider of the company may log in to the account with the email.
+// New accounts are provisioned into the company, but existing accounts must already have a profile in it,
+// added by an admin inviting them or through SCIM. Otherwise the identity provider could log in to the
+// account of anyone with an email on the domain, along with their profiles in other companies.
+func (h SAMLHandler) accountLinked(ctx context.Context, companyID uuid.UUID, email string) (bool, error) {
+	account, err := h.UserStore.BaseUserByEmail(ctx, email)
+	if errors.Is(err, store.ErrUserNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = h.UserStore.User(ctx, account.ID, companyID)
+	if errors.Is(err, store.ErrUserNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// provisionUser creates the profile of a user logging in for the first time, with the company's default roles.
+func (h SAMLHandler) provisionUser(ctx context.Context, userID, companyID uuid.UUID, identity auth.SAMLIdentity) (model.UserProfile, error) {
+	roleIDs, err := defaultRoleIDs(ctx, h.SettingsStore, companyID)
+	if err != nil {
+		return model.UserProfile{}, err
+	}
+	return h.UserStore.CreateProfile(ctx, store.CreateProfileRequest{
+		UserID:    userID,
+		CompanyID: companyID,
+		FirstName: identity.FirstName,
+		LastName:  identity.LastName,
+		RoleIDs:   roleIDs,
+	})
+}
+
+func (h SAMLHandler) redirectToLogin(c echo.Context, errorCode string) error {
+	return c.Redirect(http.StatusFound, h.Config.ClientBaseURL+clientLoginPath+"?error="+errorCode)
+}
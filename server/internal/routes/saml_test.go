@@ -0,0 +1,237 @@
+package routes_test
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"advancely/internal/application"
+	"advancely/internal/auth"
+	mw "advancely/internal/middleware"
+	"advancely/internal/routes"
+	"advancely/internal/store"
+	"advancely/internal/tests"
+
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSAMLHandler(db *sqlx.DB, authProvider *tests.FakeAuthProvider) routes.SAMLHandler {
+	return routes.SAMLHandler{
+		AuthProvider:  authProvider,
+		UserStore:     store.NewPostgresUserStore(db),
+		CompanyStore:  store.NewPostgresCompanyStore(db),
+		SettingsStore: store.NewPostgresCompanySettingsStore(db),
+		Config: application.AppConfig{
+			SessionSecret: "session.secret",
+			ClientBaseURL: testClientBaseURL,
+			APIBaseURL:    testAPIBaseURL,
+		},
+		Logger: tests.NewDefaultLogger(),
+	}
+}
+
+// setUpSAMLCompany configures the identity provider for the company of a new admin user,
+// allowing the company-email.com domain.
+func setUpSAMLCompany(t *testing.T, db *sqlx.DB, authProvider *tests.FakeAuthProvider, enforceSSO bool) (*tests.FakeIdentityProvider, uuid.UUID) {
+	admin := tests.SignUpAdminUser(t, authProvider, db)
+	profile, err := store.NewPostgresUserStore(db).DefaultProfile(context.Background(), admin.ID)
+	require.NoError(t, err)
+
+	settingsStore := store.NewPostgresCompanySettingsStore(db)
+	_, err = settingsStore.AddAllowedEmailDomain(context.Background(), profile.CompanyID, "company-email.com")
+	require.NoError(t, err)
+	_, err = settingsStore.VerifyAllowedEmailDomain(context.Background(), profile.CompanyID, "company-email.com")
+	require.NoError(t, err)
+
+	idp := tests.NewFakeIdentityProvider(t)
+	cfg := idp.Config(profile.CompanyID)
+	cfg.EnforceSSO = enforceSSO
+	require.NoError(t, settingsStore.SaveSAMLConfig(context.Background(), &cfg))
+	return idp, profile.CompanyID
+}
+
+// loginWithSAML runs the SAML login and ACS handlers for the company, returning the ACS response.
+func loginWithSAML(t *testing.T, handler routes.SAMLHandler, idp *tests.FakeIdentityProvider, companyID uuid.UUID) *httptest.ResponseRecorder {
+	c, rec := tests.NewRequestRecorder(t, http.MethodGet, "/auth/saml/"+companyID.String()+"/login", nil)
+	c.SetParamNames("companyId")
+	c.SetParamValues(companyID.String())
+	require.NoError(t, handler.HandleLogin()(c))
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	metadata := &saml.EntityDescriptor{}
+	c, metadataRec := tests.NewRequestRecorder(t, http.MethodGet, "/auth/saml/"+companyID.String()+"/metadata", nil)
+	c.SetParamNames("companyId")
+	c.SetParamValues(companyID.String())
+	require.NoError(t, handler.HandleMetadata()(c))
+	require.NoError(t, xml.Unmarshal(metadataRec.Body.Bytes(), metadata))
+	idp.RegisterServiceProvider(metadata)
+
+	form := idp.Login(t, rec.Header().Get(echo.HeaderLocation))
+
+	e := tests.NewEchoInstance()
+	req := httptest.NewRequest(http.MethodPost, "/auth/saml/"+companyID.String()+"/acs", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	acsRec := httptest.NewRecorder()
+	c = e.NewContext(req, acsRec)
+	c.SetParamNames("companyId")
+	c.SetParamValues(companyID.String())
+	require.NoError(t, handler.HandleACS()(c))
+	require.Equal(t, http.StatusFound, acsRec.Code)
+	return acsRec
+}
+
+func TestSAMLLoginProvisionsUser(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	idp, companyID := setUpSAMLCompany(t, db, authProvider, false)
+	idp.SetUser("sso.user@company-email.com", "Sso", "User")
+
+	rec := loginWithSAML(t, newTestSAMLHandler(db, authProvider), idp, companyID)
+	require.Equal(t, testClientBaseURL+"/dashboard", rec.Header().Get(echo.HeaderLocation))
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, auth.SessionCookieStoreName, cookies[0].Name)
+
+	userID := authProvider.NewSession("sso.user@company-email.com").User.ID
+	profile, err := store.NewPostgresUserStore(db).DefaultProfile(context.Background(), userID)
+	require.NoError(t, err)
+	require.Equal(t, companyID, profile.CompanyID)
+	require.Equal(t, "Sso", profile.FirstName)
+	require.Equal(t, "User", profile.LastName)
+}
+
+func TestSAMLLoginRejectsDomainNotAllowedByCompany(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	idp, companyID := setUpSAMLCompany(t, db, authProvider, false)
+	idp.SetUser("someone@other-company.com", "Some", "One")
+
+	rec := loginWithSAML(t, newTestSAMLHandler(db, authProvider), idp, companyID)
+
+	location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	require.NoError(t, err)
+	require.Equal(t, "sso_domain_not_allowed", location.Query().Get("error"))
+}
+
+func TestSAMLLoginRejectsDomainNotVerifiedByCompany(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	setUpSAMLCompany(t, db, authProvider, false)
+	member := tests.CreateAuthUser(t, authProvider, db, "member@company-email.com")
+
+	// Another company can allow the domain, but cannot verify it or use it to log in to the domain's accounts.
+	ctx := context.Background()
+	settingsStore := store.NewPostgresCompanySettingsStore(db)
+	attacker := tests.CreateAuthUser(t, authProvider, db, "admin@attacker-email.com")
+	attackerCompanyID := tests.CreateTestCompany(t, db, attacker.ID)
+	_, err := settingsStore.AddAllowedEmailDomain(ctx, attackerCompanyID, "company-email.com")
+	require.NoError(t, err)
+	_, err = settingsStore.VerifyAllowedEmailDomain(ctx, attackerCompanyID, "company-email.com")
+	require.ErrorIs(t, err, store.ErrDomainVerifiedByAnotherCompany)
+
+	idp := tests.NewFakeIdentityProvider(t)
+	cfg := idp.Config(attackerCompanyID)
+	require.NoError(t, settingsStore.SaveSAMLConfig(ctx, &cfg))
+	idp.SetUser(member.Email, "Member", "User")
+
+	rec := loginWithSAML(t, newTestSAMLHandler(db, authProvider), idp, attackerCompanyID)
+
+	location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	require.NoError(t, err)
+	require.Equal(t, "sso_domain_not_allowed", location.Query().Get("error"))
+	_, err = store.NewPostgresUserStore(db).User(ctx, member.ID, attackerCompanyID)
+	require.ErrorIs(t, err, store.ErrUserNotFound)
+}
+
+func TestSAMLLoginRejectsAccountNotLinkedToCompany(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	idp, companyID := setUpSAMLCompany(t, db, authProvider, false)
+	handler := newTestSAMLHandler(db, authProvider)
+
+	// The user signed up with their own company before their employer set up SSO.
+	user := tests.CreateAuthUser(t, authProvider, db, "founder@company-email.com")
+	tests.CreateTestCompany(t, db, user.ID)
+	idp.SetUser(user.Email, "Founder", "User")
+
+	rec := loginWithSAML(t, handler, idp, companyID)
+	location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	require.NoError(t, err)
+	require.Equal(t, "sso_account_not_linked", location.Query().Get("error"))
+	require.Empty(t, rec.Result().Cookies())
+
+	// Once an admin adds the account to the company, it can log in with SSO.
+	_, err = store.NewPostgresUserStore(db).CreateProfile(context.Background(), store.CreateProfileRequest{
+		UserID:    user.ID,
+		CompanyID: companyID,
+	})
+	require.NoError(t, err)
+
+	rec = loginWithSAML(t, handler, idp, companyID)
+	require.Equal(t, testClientBaseURL+"/dashboard", rec.Header().Get(echo.HeaderLocation))
+}
+
+func TestPasswordLoginRejectedWhenSSOEnforced(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider()
+	_, companyID := setUpSAMLCompany(t, db, authProvider, true)
+
+	user := tests.CreateAuthUser(t, authProvider, db, "member@company-email.com")
+	authProvider.AddUser(user, tests.DefaultUserPassword)
+	_, err := store.NewPostgresUserStore(db).CreateProfile(context.Background(), store.CreateProfileRequest{
+		UserID:    user.ID,
+		CompanyID: companyID,
+	})
+	require.NoError(t, err)
+
+	payload := map[string]string{
+		"email":    user.Email,
+		"password": tests.DefaultUserPassword,
+	}
+	c, _ := tests.NewRequestRecorder(t, http.MethodPost, "/login", payload)
+	err = newTestAuthHandler(db, authProvider).HandleLogin()(c)
+	assertHTTPError(t, err, http.StatusForbidden, "Your company requires you to sign in with SSO")
+}
+
+func TestBearerTokenRejectedWhenSSOEnforced(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider()
+	_, companyID := setUpSAMLCompany(t, db, authProvider, true)
+
+	user := tests.CreateAuthUser(t, authProvider, db, "member@company-email.com")
+	_, err := store.NewPostgresUserStore(db).CreateProfile(context.Background(), store.CreateProfileRequest{
+		UserID:    user.ID,
+		CompanyID: companyID,
+	})
+	require.NoError(t, err)
+
+	userMw := mw.NewUserMiddleware(
+		application.AppConfig{SessionSecret: testSessionSecret},
+		authProvider,
+		auth.NewHMACVerifier([]byte(tests.FakeJWTSecret), "authenticated"),
+		store.NewPostgresUserStore(db),
+		store.NewPostgresPersonalAccessTokenStore(db),
+		newTestSessionPolicy(db),
+		tests.NewDefaultLogger(),
+	)
+	c, _ := tests.NewRequestRecorder(t, http.MethodGet, "/", nil)
+	c.Request().Header.Set(echo.HeaderAuthorization, "Bearer "+tests.NewAccessToken(user.ID.String(), user.Email, time.Now().Add(time.Hour)))
+	err = userMw.WithUserInContext(func(c echo.Context) error {
+		require.Fail(t, "the request must be rejected before the handler")
+		return nil
+	})(c)
+	require.ErrorIs(t, err, auth.ErrSSORequired)
+}
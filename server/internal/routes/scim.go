@@ -0,0 +1,703 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"advancely/internal/application"
+	"advancely/internal/auth"
+	"advancely/internal/model"
+	"advancely/internal/scim"
+	"advancely/internal/store"
+	"advancely/pkg/errs"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// scimCompanyIDKey is the context key of the ID of the company authenticated by its SCIM token.
+const scimCompanyIDKey = "scim_company_id"
+
+// scimGroupDescription is the description of roles created by SCIM provisioning.
+const scimGroupDescription = "Provisioned by your identity provider"
+
+func NewSCIMHandler(
+	authProvider auth.Provider,
+	s *store.PostgresStore,
+	config application.AppConfig,
+	logger *slog.Logger,
+) SCIMHandler {
+	return SCIMHandler{
+		AuthProvider:     authProvider,
+		UserStore:        s.UserStore,
+		CompanyStore:     s.CompanyStore,
+		PermissionsStore: s.PermissionsStore,
+		SettingsStore:    s.CompanySettingsStore,
+		TokenStore:       s.SCIMTokenStore,
+		Config:           config,
+		Logger:           logger,
+	}
+}
+
+// SCIMHandler implements the SCIM 2.0 endpoints used by a company's identity provider to provision
+// its users and groups. Groups are mapped to the company's custom roles, and group members to the
+// users assigned those roles. Requests are authenticated with the company's SCIM token.
+type SCIMHandler struct {
+	AuthProvider     auth.Provider
+	UserStore        store.UserStore
+	CompanyStore     store.CompanyStore
+	PermissionsStore store.PermissionsStore
+	SettingsStore    store.CompanySettingsStore
+	TokenStore       store.SCIMTokenStore
+	Config           application.AppConfig
+	Logger           *slog.Logger
+}
+
+func (h SCIMHandler) MakeRoutes(e *echo.Group) {
+	group := e.Group("/scim/v2", h.Authenticate)
+	group.GET("/ServiceProviderConfig", h.HandleServiceProviderConfig())
+	group.GET("/ResourceTypes", h.HandleResourceTypes())
+	group.GET("/Schemas", h.HandleSchemas())
+
+	group.GET("/Users", h.HandleListUsers())
+	group.POST("/Users", h.HandleCreateUser())
+	group.GET("/Users/:id", h.HandleGetUser())
+	group.PUT("/Users/:id", h.HandleReplaceUser())
+	group.PATCH("/Users/:id", h.HandlePatchUser())
+	group.DELETE("/Users/:id", h.HandleDeleteUser())
+
+	group.GET("/Groups", h.HandleListGroups())
+	group.POST("/Groups", h.HandleCreateGroup())
+	group.GET("/Groups/:id", h.HandleGetGroup())
+	group.PUT("/Groups/:id", h.HandleReplaceGroup())
+	group.PATCH("/Groups/:id", h.HandlePatchGroup())
+	group.DELETE("/Groups/:id", h.HandleDeleteGroup())
+}
+
+// SCIMBaseURL returns the base URL of the SCIM endpoints, which is configured in the identity provider.
+func SCIMBaseURL(config application.AppConfig) string {
+	return config.APIBaseURL + "/scim/v2"
+}
+
+// Authenticate is the middleware authenticating SCIM requests with the company's SCIM token.
+// Tokens of companies that have been deleted are rejected.
+func (h SCIMHandler) Authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		bearer, err := auth.BearerToken(c.Request())
+		if err != nil || !strings.HasPrefix(bearer, auth.SCIMTokenPrefix) {
+			return writeSCIMError(c, scim.NewError(http.StatusUnauthorized, "", "A SCIM token is required"))
+		}
+
+		token, err := h.TokenStore.SCIMTokenByHash(ctx, auth.HashToken(bearer))
+		if err != nil {
+			if !errors.Is(err, store.ErrSCIMTokenNotFound) {
+				requestLogger(c, h.Logger).Error("failed to get SCIM token", "error", err)
+			}
+			return writeSCIMError(c, scim.NewError(http.StatusUnauthorized, "", "The SCIM token is not valid"))
+		}
+
+		company, err := h.CompanyStore.Company(ctx, token.CompanyID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to get company of SCIM token", "error", err)
+			return writeSCIMError(c, scim.NewError(http.StatusInternalServerError, "", ""))
+		}
+		if company.Deleted() {
+			return writeSCIMError(c, scim.NewError(http.StatusUnauthorized, "", "The company has been deleted"))
+		}
+		if err := h.TokenStore.TouchSCIMToken(ctx, token.CompanyID); err != nil {
+			requestLogger(c, h.Logger).Error("failed to record SCIM token use", "error", err)
+		}
+
+		c.Set(scimCompanyIDKey, token.CompanyID)
+		return next(c)
+	}
+}
+
+func scimCompanyID(c echo.Context) uuid.UUID {
+	return c.Get(scimCompanyIDKey).(uuid.UUID)
+}
+
+func (h SCIMHandler) HandleServiceProviderConfig() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return writeSCIM(c, http.StatusOK, scim.NewServiceProviderConfig())
+	}
+}
+
+func (h SCIMHandler) HandleResourceTypes() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return writeSCIM(c, http.StatusOK, scim.NewListResponse(scim.ResourceTypes(), 1, -1))
+	}
+}
+
+func (h SCIMHandler) HandleSchemas() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return writeSCIM(c, http.StatusOK, scim.NewListResponse([]scim.Schema{scim.UserSchema, scim.GroupSchema}, 1, -1))
+	}
+}
+
+func (h SCIMHandler) HandleListUsers() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		users, err := h.UserStore.Users(ctx, scimCompanyID(c))
+		if err != nil {
+			return h.handleError(c, "failed to list users", err)
+		}
+
+		resources := make([]scim.User, 0, len(users))
+		for _, u := range users {
+			resources = append(resources, h.scimUser(u))
+		}
+		return h.writeListResponse(c, resources)
+	}
+}
+
+func (h SCIMHandler) HandleGetUser() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := h.companyUser(c, c.Param("id"))
+		if err != nil {
+			return h.handleError(c, "failed to get user", err)
+		}
+		return writeSCIM(c, http.StatusOK, h.scimUser(user))
+	}
+}
+
+// HandleCreateUser provisions a user in the company. The user is created in the auth server without
+// being notified, as they are expected to log in through the company's identity provider.
+// The domain of the user's email must have been verified by the company.
+func (h SCIMHandler) HandleCreateUser() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		companyID := scimCompanyID(c)
+
+		var req scim.User
+		if err := bindSCIM(c, &req); err != nil {
+			return writeSCIMError(c, err)
+		}
+		email := strings.ToLower(strings.TrimSpace(req.UserName))
+		if !strings.Contains(email, "@") {
+			return writeSCIMError(c, scim.NewError(http.StatusBadRequest, scim.ErrorTypeInvalidValue, "userName must be an email address"))
+		}
+		verified, err := emailDomainVerified(ctx, h.SettingsStore, companyID, email)
+		if err != nil {
+			return h.handleError(c, "failed to check email domain", err)
+		}
+		if !verified {
+			return writeSCIMError(c, scim.NewError(http.StatusBadRequest, scim.ErrorTypeInvalidValue, "userName must belong to an email domain verified by the company"))
+		}
+
+		userID, err := h.authUserID(ctx, companyID, email, req)
+		if err != nil {
+			return h.handleError(c, "failed to create auth user", err)
+		}
+
+		roleIDs, err := defaultRoleIDs(ctx, h.SettingsStore, companyID)
+		if err != nil {
+			return h.handleError(c, "failed to get default roles", err)
+		}
+
+		firstName, lastName := scimUserNames(req)
+		_, err = h.UserStore.CreateProfile(ctx, store.CreateProfileRequest{
+			UserID:     userID,
+			CompanyID:  companyID,
+			FirstName:  firstName,
+			LastName:   lastName,
+			ExternalID: optionalString(req.ExternalID),
+			RoleIDs:    roleIDs,
+		})
+		if err != nil {
+			return h.handleError(c, "failed to create profile", err)
+		}
+		if req.Active != nil && !*req.Active {
+			if err := setUserActive(ctx, h.UserStore, h.AuthProvider, userID, companyID, false); err != nil {
+				return h.handleError(c, "failed to deactivate user", err)
+			}
+		}
+
+		user, err := h.UserStore.User(ctx, userID, companyID)
+		if err != nil {
+			return h.handleError(c, "failed to get created user", err)
+		}
+		resource := h.scimUser(user)
+		c.Response().Header().Set(echo.HeaderLocation, resource.Meta.Location)
+		return writeSCIM(c, http.StatusCreated, resource)
+	}
+}
+
+// authUserID returns the ID of the auth user with the email, creating the user if they do not exist.
+// Users who are members of other companies are added to the company, but a uniqueness error is returned
+// if the user is already a member of it.
+func (h SCIMHandler) authUserID(ctx context.Context, companyID uuid.UUID, email string, req scim.User) (uuid.UUID, error) {
+	existing, err := h.UserStore.BaseUserByEmail(ctx, email)
+	if err == nil {
+		if _, err := h.UserStore.User(ctx, existing.ID, companyID); !errors.Is(err, store.ErrUserNotFound) {
+			if err != nil {
+				return uuid.Nil, err
+			}
+			return uuid.Nil, scim.NewError(http.StatusConflict, scim.ErrorTypeUniqueness, "A user with the userName already exists")
+		}
+		return existing.ID, nil
+	}
+	if !errors.Is(err, store.ErrUserNotFound) {
+		return uuid.Nil, err
+	}
+
+	firstName, lastName := scimUserNames(req)
+	user, err := h.AuthProvider.CreateUser(ctx, email, map[string]interface{}{
+		"full_name": strings.TrimSpace(firstName + " " + lastName),
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return user.ID, nil
+}
+
+func (h SCIMHandler) HandleReplaceUser() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := h.companyUser(c, c.Param("id"))
+		if err != nil {
+			return h.handleError(c, "failed to get user", err)
+		}
+
+		var req scim.User
+		if err := bindSCIM(c, &req); err != nil {
+			return writeSCIMError(c, err)
+		}
+		return h.saveUser(c, user, req)
+	}
+}
+
+func (h SCIMHandler) HandlePatchUser() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := h.companyUser(c, c.Param("id"))
+		if err != nil {
+			return h.handleError(c, "failed to get user", err)
+		}
+
+		var req scim.PatchRequest
+		if err := bindSCIM(c, &req); err != nil {
+			return writeSCIMError(c, err)
+		}
+		if err := req.Validate(); err != nil {
+			return writeSCIMError(c, err)
+		}
+
+		resource := h.scimUser(user)
+		if err := scim.ApplyUserPatch(&resource, req.Operations); err != nil {
+			return writeSCIMError(c, err)
+		}
+		return h.saveUser(c, user, resource)
+	}
+}
+
+// saveUser updates the user from the resource, writing the updated resource to the response.
+// Users are deactivated rather than deleted when their active attribute is false.
+func (h SCIMHandler) saveUser(c echo.Context, user model.UserProfile, resource scim.User) error {
+	ctx := c.Request().Context()
+
+	if !strings.EqualFold(strings.TrimSpace(resource.UserName), user.Email) {
+		return writeSCIMError(c, scim.NewError(http.StatusBadRequest, scim.ErrorTypeMutability, "userName cannot be changed"))
+	}
+
+	user.FirstName, user.LastName = scimUserNames(resource)
+	user.ExternalID = optionalString(resource.ExternalID)
+	if err := h.UserStore.UpdateUser(ctx, &user); err != nil {
+		return h.handleError(c, "failed to update user", err)
+	}
+
+	active := resource.Active == nil || *resource.Active
+	if active != user.Active() {
+		if err := setUserActive(ctx, h.UserStore, h.AuthProvider, user.ID, user.CompanyID, active); err != nil {
+			return h.handleError(c, "failed to set user active", err)
+		}
+	}
+
+	updated, err := h.UserStore.User(ctx, user.ID, user.CompanyID)
+	if err != nil {
+		return h.handleError(c, "failed to get updated user", err)
+	}
+	return writeSCIM(c, http.StatusOK, h.scimUser(updated))
+}
+
+// HandleDeleteUser removes the user from the company. They can be restored by an administrator
+// until the retention period has passed, after which their account is deleted if they belong to no other company.
+func (h SCIMHandler) HandleDeleteUser() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		user, err := h.companyUser(c, c.Param("id"))
+		if err != nil {
+			return h.handleError(c, "failed to get user", err)
+		}
+		if err := h.UserStore.DeleteProfile(ctx, user.ID, user.CompanyID); err != nil {
+			return h.handleError(c, "failed to delete user", err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func (h SCIMHandler) HandleListGroups() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		companyID := scimCompanyID(c)
+		roles, err := h.PermissionsStore.Roles(ctx, companyID)
+		if err != nil {
+			return h.handleError(c, "failed to list roles", err)
+		}
+
+		// Identity providers typically exclude members when searching for a group by name.
+		excludeMembers := strings.Contains(strings.ToLower(c.QueryParam("excludedAttributes")), "members")
+
+		resources := []scim.Group{}
+		for _, r := range roles {
+			if r.IsSystemRole {
+				continue
+			}
+			var memberIDs []uuid.UUID
+			if !excludeMembers {
+				memberIDs, err = h.PermissionsStore.RoleUserIDs(c.Request().Context(), r.ID, companyID)
+				if err != nil {
+					return h.handleError(c, "failed to list role users", err)
+				}
+			}
+			resources = append(resources, h.scimGroup(r.Role, memberIDs))
+		}
+		return h.writeListResponse(c, resources)
+	}
+}
+
+func (h SCIMHandler) HandleGetGroup() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		group, _, err := h.companyGroup(c, c.Param("id"))
+		if err != nil {
+			return h.handleError(c, "failed to get group", err)
+		}
+		return writeSCIM(c, http.StatusOK, group)
+	}
+}
+
+func (h SCIMHandler) HandleCreateGroup() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		companyID := scimCompanyID(c)
+
+		var req scim.Group
+		if err := bindSCIM(c, &req); err != nil {
+			return writeSCIMError(c, err)
+		}
+		if strings.TrimSpace(req.DisplayName) == "" {
+			return writeSCIMError(c, scim.NewError(http.StatusBadRequest, scim.ErrorTypeInvalidValue, "displayName is required"))
+		}
+		memberIDs, err := h.memberUserIDs(ctx, companyID, req.Members)
+		if err != nil {
+			return writeSCIMError(c, err)
+		}
+
+		role, err := h.PermissionsStore.CreateRole(ctx, model.CreateRole{
+			CompanyID:   companyID,
+			Name:        strings.TrimSpace(req.DisplayName),
+			Description: scimGroupDescription,
+		})
+		if err != nil {
+			return h.handleError(c, "failed to create role", err)
+		}
+		if err := h.setRoleUsers(c.Request().Context(), role.ID, companyID, memberIDs); err != nil {
+			return h.handleError(c, "failed to set role users", err)
+		}
+
+		group, _, err := h.companyGroup(c, strconv.Itoa(role.ID))
+		if err != nil {
+			return h.handleError(c, "failed to get created group", err)
+		}
+		c.Response().Header().Set(echo.HeaderLocation, group.Meta.Location)
+		return writeSCIM(c, http.StatusCreated, group)
+	}
+}
+
+func (h SCIMHandler) HandleReplaceGroup() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		_, role, err := h.companyGroup(c, c.Param("id"))
+		if err != nil {
+			return h.handleError(c, "failed to get group", err)
+		}
+
+		var req scim.Group
+		if err := bindSCIM(c, &req); err != nil {
+			return writeSCIMError(c, err)
+		}
+		return h.saveGroup(c, role, req)
+	}
+}
+
+func (h SCIMHandler) HandlePatchGroup() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		group, role, err := h.companyGroup(c, c.Param("id"))
+		if err != nil {
+			return h.handleError(c, "failed to get group", err)
+		}
+
+		var req scim.PatchRequest
+		if err := bindSCIM(c, &req); err != nil {
+			return writeSCIMError(c, err)
+		}
+		if err := req.Validate(); err != nil {
+			return writeSCIMError(c, err)
+		}
+		if err := scim.ApplyGroupPatch(&group, req.Operations); err != nil {
+			return writeSCIMError(c, err)
+		}
+		return h.saveGroup(c, role, group)
+	}
+}
+
+// saveGroup renames the role and replaces its users with the members of the group,
+// writing the updated resource to the response.
+func (h SCIMHandler) saveGroup(c echo.Context, role model.Role, group scim.Group) error {
+	companyID := scimCompanyID(c)
+
+	name := strings.TrimSpace(group.DisplayName)
+	if name == "" {
+		return writeSCIMError(c, scim.NewError(http.StatusBadRequest, scim.ErrorTypeInvalidValue, "displayName is required"))
+	}
+	memberIDs, err := h.memberUserIDs(c.Request().Context(), companyID, group.Members)
+	if err != nil {
+		return writeSCIMError(c, err)
+	}
+
+	if name != role.Name {
+		role.Name = name
+		if err := h.PermissionsStore.UpdateRole(c.Request().Context(), &role); err != nil {
+			return h.handleError(c, "failed to update role", err)
+		}
+	}
+	if err := h.setRoleUsers(c.Request().Context(), role.ID, companyID, memberIDs); err != nil {
+		return h.handleError(c, "failed to set role users", err)
+	}
+
+	updated, _, err := h.companyGroup(c, strconv.Itoa(role.ID))
+	if err != nil {
+		return h.handleError(c, "failed to get updated group", err)
+	}
+	return writeSCIM(c, http.StatusOK, updated)
+}
+
+func (h SCIMHandler) HandleDeleteGroup() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		_, role, err := h.companyGroup(c, c.Param("id"))
+		if err != nil {
+			return h.handleError(c, "failed to get group", err)
+		}
+		if err := h.PermissionsStore.DeleteRole(ctx, role.ID, scimCompanyID(c)); err != nil {
+			return h.handleError(c, "failed to delete role", err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// companyUser returns the user with the ID if they belong to the authenticated company.
+func (h SCIMHandler) companyUser(c echo.Context, id string) (model.UserProfile, error) {
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		return model.UserProfile{}, store.ErrUserNotFound
+	}
+	return h.UserStore.User(c.Request().Context(), userID, scimCompanyID(c))
+}
+
+// companyGroup returns the group for the custom role with the ID. System roles cannot be managed through SCIM.
+func (h SCIMHandler) companyGroup(c echo.Context, id string) (scim.Group, model.Role, error) {
+	companyID := scimCompanyID(c)
+	roleID, err := strconv.Atoi(id)
+	if err != nil {
+		return scim.Group{}, model.Role{}, store.ErrRoleNotFound
+	}
+	role, err := h.PermissionsStore.Role(c.Request().Context(), roleID, &companyID)
+	if err != nil {
+		return scim.Group{}, model.Role{}, err
+	}
+	if role.IsSystemRole {
+		return scim.Group{}, model.Role{}, store.ErrRoleNotFound
+	}
+	memberIDs, err := h.PermissionsStore.RoleUserIDs(c.Request().Context(), role.ID, companyID)
+	if err != nil {
+		return scim.Group{}, model.Role{}, err
+	}
+	return h.scimGroup(role.Role, memberIDs), role.Role, nil
+}
+
+// memberUserIDs returns the user IDs of the members, which must all be users of the company.
+func (h SCIMHandler) memberUserIDs(ctx context.Context, companyID uuid.UUID, members []scim.Member) ([]uuid.UUID, error) {
+	userIDs := make([]uuid.UUID, 0, len(members))
+	for _, m := range members {
+		unknown := scim.NewError(http.StatusBadRequest, scim.ErrorTypeInvalidValue, "Unknown member "+m.Value)
+		userID, err := uuid.Parse(m.Value)
+		if err != nil {
+			return nil, unknown
+		}
+		if _, err := h.UserStore.User(ctx, userID, companyID); err != nil {
+			if errors.Is(err, store.ErrUserNotFound) {
+				return nil, unknown
+			}
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, nil
+}
+
+// setRoleUsers assigns the role to the users, removing it from any other users.
+func (h SCIMHandler) setRoleUsers(ctx context.Context, roleID int, companyID uuid.UUID, userIDs []uuid.UUID) error {
+	current, err := h.PermissionsStore.RoleUserIDs(ctx, roleID, companyID)
+	if err != nil {
+		return err
+	}
+
+	desired := make(map[uuid.UUID]bool, len(userIDs))
+	for _, id := range userIDs {
+		desired[id] = true
+	}
+	for _, id := range current {
+		if desired[id] {
+			delete(desired, id)
+			continue
+		}
+		if err := h.PermissionsStore.RemoveRoleFromUser(ctx, roleID, id, companyID); err != nil {
+			return err
+		}
+	}
+	for _, id := range userIDs {
+		if !desired[id] {
+			continue
+		}
+		if err := h.PermissionsStore.AssignRoleToUser(ctx, roleID, id, companyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h SCIMHandler) scimUser(u model.UserProfile) scim.User {
+	return scim.NewUser(u, SCIMBaseURL(h.Config)+"/Users/"+u.ID.String())
+}
+
+func (h SCIMHandler) scimGroup(r model.Role, memberIDs []uuid.UUID) scim.Group {
+	ids := make([]string, 0, len(memberIDs))
+	for _, id := range memberIDs {
+		ids = append(ids, id.String())
+	}
+	baseURL := SCIMBaseURL(h.Config)
+	return scim.NewGroup(r, ids, baseURL+"/Groups/"+strconv.Itoa(r.ID), baseURL+"/Users")
+}
+
+// writeListResponse filters and paginates the resources according to the filter, startIndex and count parameters.
+func (h SCIMHandler) writeListResponse(c echo.Context, resources any) error {
+	startIndex, count := 1, scim.MaxResults
+	if v, err := strconv.Atoi(c.QueryParam("startIndex")); err == nil {
+		startIndex = v
+	}
+	if v, err := strconv.Atoi(c.QueryParam("count")); err == nil && v >= 0 && v < scim.MaxResults {
+		count = v
+	}
+
+	var filter scim.Filter
+	if f := c.QueryParam("filter"); f != "" {
+		var err error
+		if filter, err = scim.ParseFilter(f); err != nil {
+			return writeSCIMError(c, scim.NewError(http.StatusBadRequest, scim.ErrorTypeInvalidFilter, err.Error()))
+		}
+	}
+
+	switch r := resources.(type) {
+	case []scim.User:
+		matched, err := filterResources(filter, r)
+		if err != nil {
+			return h.handleError(c, "failed to filter users", err)
+		}
+		return writeSCIM(c, http.StatusOK, scim.NewListResponse(matched, startIndex, count))
+	case []scim.Group:
+		matched, err := filterResources(filter, r)
+		if err != nil {
+			return h.handleError(c, "failed to filter groups", err)
+		}
+		return writeSCIM(c, http.StatusOK, scim.NewListResponse(matched, startIndex, count))
+	}
+	return h.handleError(c, "unsupported resource type", errors.New("unsupported resource type"))
+}
+
+func filterResources[T any](filter scim.Filter, resources []T) ([]T, error) {
+	if filter == nil {
+		return resources, nil
+	}
+	matched := []T{}
+	for _, r := range resources {
+		ok, err := scim.MatchResource(filter, r)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, r)
+		}
+	}
+	return matched, nil
+}
+
+// handleError writes the SCIM error response for the error, logging unexpected errors.
+func (h SCIMHandler) handleError(c echo.Context, msg string, err error) error {
+	var scimErr *scim.Error
+	switch {
+	case errors.As(err, &scimErr):
+		return writeSCIMError(c, scimErr)
+	case errs.IsOne(err, store.ErrUserNotFound, store.ErrRoleNotFound):
+		return writeSCIMError(c, scim.NewError(http.StatusNotFound, "", "Resource not found"))
+	case errors.Is(err, store.ErrProfileExists):
+		return writeSCIMError(c, scim.NewError(http.StatusConflict, scim.ErrorTypeUniqueness, "The user has been deleted from the company and must be restored by an administrator"))
+	case errors.Is(errs.CheckPgErr(err), errs.PgErrCodeUniqueViolation):
+		return writeSCIMError(c, scim.NewError(http.StatusConflict, scim.ErrorTypeUniqueness, "A resource with the same name already exists"))
+	}
+	requestLogger(c, h.Logger).Error(msg, "error", err)
+	return writeSCIMError(c, scim.NewError(http.StatusInternalServerError, "", ""))
+}
+
+// bindSCIM decodes the request body. Echo's binder is not used as it does not accept the SCIM content type.
+func bindSCIM(c echo.Context, v any) error {
+	if err := json.NewDecoder(c.Request().Body).Decode(v); err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorTypeInvalidSyntax, "The request body is not valid JSON")
+	}
+	return nil
+}
+
+func writeSCIM(c echo.Context, status int, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Blob(status, scim.ContentType, b)
+}
+
+func writeSCIMError(c echo.Context, err error) error {
+	var scimErr *scim.Error
+	if !errors.As(err, &scimErr) {
+		scimErr = scim.NewError(http.StatusInternalServerError, "", "")
+	}
+	return writeSCIM(c, scimErr.StatusCode(), scimErr)
+}
+
+// scimUserNames returns the first and last names of the user, falling back to splitting the display name.
+func scimUserNames(u scim.User) (string, string) {
+	if u.Name != nil && (u.Name.GivenName != "" || u.Name.FamilyName != "") {
+		return strings.TrimSpace(u.Name.GivenName), strings.TrimSpace(u.Name.FamilyName)
+	}
+	return namesFromMetadata(map[string]interface{}{"name": u.DisplayName})
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,341 @@
+package routes_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"advancely/internal/application"
+	"advancely/internal/auth"
+	"advancely/internal/model"
+	"advancely/internal/routes"
+	"advancely/internal/scim"
+	"advancely/internal/store"
+	"advancely/internal/tests"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSCIMHandler(db *sqlx.DB, authProvider *tests.FakeAuthProvider) routes.SCIMHandler {
+	return routes.SCIMHandler{
+		AuthProvider:     authProvider,
+		UserStore:        store.NewPostgresUserStore(db),
+		CompanyStore:     store.NewPostgresCompanyStore(db),
+		PermissionsStore: store.NewPostgresPermissionsStore(db),
+		SettingsStore:    store.NewPostgresCompanySettingsStore(db),
+		TokenStore:       store.NewPostgresSCIMTokenStore(db),
+		Config:           application.AppConfig{APIBaseURL: testAPIBaseURL},
+		Logger:           tests.NewDefaultLogger(),
+	}
+}
+
+// setUpSCIMCompany creates the company of a new admin user and its SCIM token, returning the token.
+// The company has verified the company-email.com domain.
+func setUpSCIMCompany(t *testing.T, db *sqlx.DB, authProvider *tests.FakeAuthProvider) (string, uuid.UUID) {
+	admin := tests.SignUpAdminUser(t, authProvider, db)
+	profile, err := store.NewPostgresUserStore(db).DefaultProfile(context.Background(), admin.ID)
+	require.NoError(t, err)
+
+	settingsStore := store.NewPostgresCompanySettingsStore(db)
+	_, err = settingsStore.AddAllowedEmailDomain(context.Background(), profile.CompanyID, "company-email.com")
+	require.NoError(t, err)
+	_, err = settingsStore.VerifyAllowedEmailDomain(context.Background(), profile.CompanyID, "company-email.com")
+	require.NoError(t, err)
+
+	plaintext, hash, prefix, err := auth.NewSCIMToken()
+	require.NoError(t, err)
+	token := model.SCIMToken{CompanyID: profile.CompanyID, TokenHash: hash, TokenPrefix: prefix}
+	require.NoError(t, store.NewPostgresSCIMTokenStore(db).SaveSCIMToken(context.Background(), &token))
+	return plaintext, profile.CompanyID
+}
+
+// serveSCIM runs the handler behind the SCIM authentication middleware.
+// The id parameter is set when not empty.
+func serveSCIM(
+	t *testing.T,
+	h routes.SCIMHandler,
+	handler echo.HandlerFunc,
+	method, target, token, id, body string,
+) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, scim.ContentType)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	c := tests.NewEchoInstance().NewContext(req, rec)
+	if id != "" {
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+	}
+	require.NoError(t, h.Authenticate(handler)(c))
+	return rec
+}
+
+func decodeSCIMResponse(t *testing.T, rec *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	require.Equal(t, scim.ContentType, rec.Header().Get(echo.HeaderContentType))
+	var resource map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resource))
+	return resource
+}
+
+// fakeSCIMTokenStore stores a single token in memory.
+type fakeSCIMTokenStore struct {
+	store.SCIMTokenStore
+	token model.SCIMToken
+}
+
+func (s *fakeSCIMTokenStore) SCIMTokenByHash(_ context.Context, tokenHash string) (model.SCIMToken, error) {
+	if tokenHash != s.token.TokenHash {
+		return model.SCIMToken{}, store.ErrSCIMTokenNotFound
+	}
+	return s.token, nil
+}
+
+func (s *fakeSCIMTokenStore) TouchSCIMToken(context.Context, uuid.UUID) error {
+	return nil
+}
+
+// fakeCompanyStore stores a single company in memory.
+type fakeCompanyStore struct {
+	store.CompanyStore
+	company model.Company
+}
+
+func (s *fakeCompanyStore) Company(_ context.Context, id uuid.UUID) (model.Company, error) {
+	if id != s.company.ID {
+		return model.Company{}, store.ErrCompanyNotFound
+	}
+	return s.company, nil
+}
+
+func TestSCIMAuthentication(t *testing.T) {
+	plaintext, hash, prefix, err := auth.NewSCIMToken()
+	require.NoError(t, err)
+	deletedPlaintext, deletedHash, deletedPrefix, err := auth.NewSCIMToken()
+	require.NoError(t, err)
+	company := model.Company{ID: uuid.New()}
+	h := routes.SCIMHandler{
+		CompanyStore: &fakeCompanyStore{company: company},
+		TokenStore:   &fakeSCIMTokenStore{token: model.SCIMToken{CompanyID: company.ID, TokenHash: hash, TokenPrefix: prefix}},
+		Logger:       tests.NewDefaultLogger(),
+	}
+	now := time.Now()
+	deleted := model.Company{ID: uuid.New(), DeletedAt: &now}
+	deletedHandler := routes.SCIMHandler{
+		CompanyStore: &fakeCompanyStore{company: deleted},
+		TokenStore:   &fakeSCIMTokenStore{token: model.SCIMToken{CompanyID: deleted.ID, TokenHash: deletedHash, TokenPrefix: deletedPrefix}},
+		Logger:       tests.NewDefaultLogger(),
+	}
+
+	testCases := []struct {
+		name           string
+		handler        routes.SCIMHandler
+		token          string
+		expectedStatus int
+	}{
+		{name: "no token", handler: h, token: "", expectedStatus: http.StatusUnauthorized},
+		{name: "personal access token", handler: h, token: auth.PersonalAccessTokenPrefix + "abc", expectedStatus: http.StatusUnauthorized},
+		{name: "unknown token", handler: h, token: auth.SCIMTokenPrefix + "abc", expectedStatus: http.StatusUnauthorized},
+		{name: "deleted company", handler: deletedHandler, token: deletedPlaintext, expectedStatus: http.StatusUnauthorized},
+		{name: "valid token", handler: h, token: plaintext, expectedStatus: http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serveSCIM(t, tc.handler, tc.handler.HandleServiceProviderConfig(), http.MethodGet, "/scim/v2/ServiceProviderConfig", tc.token, "", "")
+			require.Equal(t, tc.expectedStatus, rec.Code)
+			body := decodeSCIMResponse(t, rec)
+			if tc.expectedStatus != http.StatusOK {
+				require.Equal(t, []any{scim.SchemaError}, body["schemas"])
+				require.Equal(t, strconv.Itoa(tc.expectedStatus), body["status"])
+			}
+		})
+	}
+}
+
+func TestSCIMCreateAndFilterUsers(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	token, companyID := setUpSCIMCompany(t, db, authProvider)
+	h := newTestSCIMHandler(db, authProvider)
+
+	body := `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "Provisioned.User@company-email.com",
+		"externalId": "00u1",
+		"name": {"givenName": "Provisioned", "familyName": "User"},
+		"active": true
+	}`
+	rec := serveSCIM(t, h, h.HandleCreateUser(), http.MethodPost, "/scim/v2/Users", token, "", body)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	created := decodeSCIMResponse(t, rec)
+	tests.AssertSCIMResource(t, scim.UserSchema, created)
+	require.Equal(t, "provisioned.user@company-email.com", created["userName"])
+	require.Equal(t, "00u1", created["externalId"])
+	require.Equal(t, true, created["active"])
+	require.Equal(t, rec.Header().Get(echo.HeaderLocation), created["meta"].(map[string]any)["location"])
+
+	profile, err := store.NewPostgresUserStore(db).DefaultProfile(context.Background(), uuid.MustParse(created["id"].(string)))
+	require.NoError(t, err)
+	require.Equal(t, companyID, profile.CompanyID)
+
+	rec = serveSCIM(t, h, h.HandleCreateUser(), http.MethodPost, "/scim/v2/Users", token, "", body)
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Equal(t, scim.ErrorTypeUniqueness, decodeSCIMResponse(t, rec)["scimType"])
+
+	target := "/scim/v2/Users?filter=" + `userName+eq+"PROVISIONED.USER@company-email.com"`
+	rec = serveSCIM(t, h, h.HandleListUsers(), http.MethodGet, target, token, "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	list := decodeSCIMResponse(t, rec)
+	require.Equal(t, float64(1), list["totalResults"])
+	resources := list["Resources"].([]any)
+	require.Len(t, resources, 1)
+	tests.AssertSCIMResource(t, scim.UserSchema, resources[0].(map[string]any))
+
+	rec = serveSCIM(t, h, h.HandleListUsers(), http.MethodGet, "/scim/v2/Users?filter=userName+eq", token, "", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, scim.ErrorTypeInvalidFilter, decodeSCIMResponse(t, rec)["scimType"])
+}
+
+func TestSCIMCreateUserRequiresVerifiedDomain(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	token, companyID := setUpSCIMCompany(t, db, authProvider)
+	h := newTestSCIMHandler(db, authProvider)
+
+	ctx := context.Background()
+	userStore := store.NewPostgresUserStore(db)
+	create := func(email string) {
+		body := `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "` + email + `"}`
+		rec := serveSCIM(t, h, h.HandleCreateUser(), http.MethodPost, "/scim/v2/Users", token, "", body)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Equal(t, scim.ErrorTypeInvalidValue, decodeSCIMResponse(t, rec)["scimType"])
+	}
+
+	// Domains added to the company but not verified cannot be provisioned.
+	_, err := store.NewPostgresCompanySettingsStore(db).AddAllowedEmailDomain(ctx, companyID, "unverified-email.com")
+	require.NoError(t, err)
+	create("someone@unverified-email.com")
+	_, err = userStore.BaseUserByEmail(ctx, "someone@unverified-email.com")
+	require.ErrorIs(t, err, store.ErrUserNotFound)
+
+	// Users of other companies are not added to the company.
+	other := tests.CreateAuthUser(t, authProvider, db, "someone@other-company.com")
+	tests.CreateTestCompany(t, db, other.ID)
+	create(other.Email)
+	_, err = userStore.User(ctx, other.ID, companyID)
+	require.ErrorIs(t, err, store.ErrUserNotFound)
+}
+
+func TestSCIMPatchDeactivatesUser(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	token, _ := setUpSCIMCompany(t, db, authProvider)
+	h := newTestSCIMHandler(db, authProvider)
+
+	body := `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "leaver@company-email.com"}`
+	rec := serveSCIM(t, h, h.HandleCreateUser(), http.MethodPost, "/scim/v2/Users", token, "", body)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	id := decodeSCIMResponse(t, rec)["id"].(string)
+
+	patch := `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "Replace", "path": "active", "value": "False"}]
+	}`
+	rec = serveSCIM(t, h, h.HandlePatchUser(), http.MethodPatch, "/scim/v2/Users/"+id, token, id, patch)
+	require.Equal(t, http.StatusOK, rec.Code)
+	patched := decodeSCIMResponse(t, rec)
+	tests.AssertSCIMResource(t, scim.UserSchema, patched)
+	require.Equal(t, false, patched["active"])
+
+	profile, err := store.NewPostgresUserStore(db).DefaultProfile(context.Background(), uuid.MustParse(id))
+	require.NoError(t, err)
+	require.False(t, profile.Active())
+
+	patch = `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "userName", "value": "someone.else@company-email.com"}]
+	}`
+	rec = serveSCIM(t, h, h.HandlePatchUser(), http.MethodPatch, "/scim/v2/Users/"+id, token, id, patch)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, scim.ErrorTypeMutability, decodeSCIMResponse(t, rec)["scimType"])
+}
+
+func TestSCIMUserOfAnotherCompanyNotFound(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	token, _ := setUpSCIMCompany(t, db, authProvider)
+	h := newTestSCIMHandler(db, authProvider)
+
+	other := tests.CreateAdminUser(t, authProvider, db)
+	rec := serveSCIM(t, h, h.HandleGetUser(), http.MethodGet, "/scim/v2/Users/"+other.ID.String(), token, other.ID.String(), "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestSCIMGroupMembership(t *testing.T) {
+	db := tests.SetUpTestDatabase(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	token, companyID := setUpSCIMCompany(t, db, authProvider)
+	h := newTestSCIMHandler(db, authProvider)
+
+	var userIDs []string
+	for _, email := range []string{"first@company-email.com", "second@company-email.com"} {
+		body := `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "` + email + `"}`
+		rec := serveSCIM(t, h, h.HandleCreateUser(), http.MethodPost, "/scim/v2/Users", token, "", body)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		userIDs = append(userIDs, decodeSCIMResponse(t, rec)["id"].(string))
+	}
+
+	body := `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+		"displayName": "SCIM Engineering",
+		"members": [{"value": "` + userIDs[0] + `"}]
+	}`
+	rec := serveSCIM(t, h, h.HandleCreateGroup(), http.MethodPost, "/scim/v2/Groups", token, "", body)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	group := decodeSCIMResponse(t, rec)
+	tests.AssertSCIMResource(t, scim.GroupSchema, group)
+	groupID := group["id"].(string)
+
+	patch := `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "add", "path": "members", "value": [{"value": "` + userIDs[1] + `"}]},
+			{"op": "remove", "path": "members[value eq \"` + userIDs[0] + `\"]"}
+		]
+	}`
+	rec = serveSCIM(t, h, h.HandlePatchGroup(), http.MethodPatch, "/scim/v2/Groups/"+groupID, token, groupID, patch)
+	require.Equal(t, http.StatusOK, rec.Code)
+	group = decodeSCIMResponse(t, rec)
+	tests.AssertSCIMResource(t, scim.GroupSchema, group)
+	require.Len(t, group["members"], 1)
+	require.Equal(t, userIDs[1], group["members"].([]any)[0].(map[string]any)["value"])
+
+	roleID, err := strconv.Atoi(groupID)
+	require.NoError(t, err)
+	memberIDs, err := store.NewPostgresPermissionsStore(db).RoleUserIDs(context.Background(), roleID, companyID)
+	require.NoError(t, err)
+	require.Equal(t, []uuid.UUID{uuid.MustParse(userIDs[1])}, memberIDs)
+
+	unknown := `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "add", "path": "members", "value": [{"value": "` + uuid.NewString() + `"}]}]
+	}`
+	rec = serveSCIM(t, h, h.HandlePatchGroup(), http.MethodPatch, "/scim/v2/Groups/"+groupID, token, groupID, unknown)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, scim.ErrorTypeInvalidValue, decodeSCIMResponse(t, rec)["scimType"])
+
+	rec = serveSCIM(t, h, h.HandleDeleteGroup(), http.MethodDelete, "/scim/v2/Groups/"+groupID, token, groupID, "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+}
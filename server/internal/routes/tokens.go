@@ -0,0 +1,160 @@
+package routes
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"advancely/internal/auth"
+	"advancely/internal/model"
+	"advancely/internal/model/security"
+	"advancely/internal/store"
+	"advancely/internal/validation"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func NewPersonalAccessTokensHandler(
+	s *store.PostgresStore,
+	ensurePermissionFn EnsurePermissionFn,
+	logger *slog.Logger,
+) PersonalAccessTokensHandler {
+	return PersonalAccessTokensHandler{
+		TokenStore:       s.PersonalAccessTokenStore,
+		EnsurePermission: ensurePermissionFn,
+		Logger:           logger,
+	}
+}
+
+type PersonalAccessTokensHandler struct {
+	TokenStore       store.PersonalAccessTokenStore
+	EnsurePermission EnsurePermissionFn
+	Logger           *slog.Logger
+}
+
+func (h PersonalAccessTokensHandler) MakeRoutes(e *echo.Group) {
+	group := e.Group("/auth/tokens")
+	group.GET("", h.HandleListTokens())
+	group.POST("", h.HandleCreateToken())
+	group.DELETE("/:tokenId", h.HandleRevokeToken())
+}
+
+// ensureCanManageTokens ensures the user is logged in with a session that is not itself a personal access token.
+// This prevents a scoped token from being used to create a token with a wider scope.
+func ensureCanManageTokens(session auth.AuthenticatedSession) *echo.HTTPError {
+	if !session.LoggedIn {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	if session.IsPersonalAccessToken() {
+		return echo.NewHTTPError(http.StatusForbidden, "personal access tokens cannot be used to manage personal access tokens")
+	}
+	return nil
+}
+
+func (h PersonalAccessTokensHandler) HandleListTokens() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		session := auth.CurrentUser(c)
+		if err := ensureCanManageTokens(session); err != nil {
+			return err
+		}
+
+		tokens, err := h.TokenStore.PersonalAccessTokens(c.Request().Context(), session.User.ID)
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to list personal access tokens", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, tokens)
+	}
+}
+
+type CreatePersonalAccessTokenRequest struct {
+	Name          string `json:"name" validate:"required,max=100"`
+	ExpiresInDays int    `json:"expiresInDays" validate:"required,min=1,max=365"`
+	// Permissions optionally limits the token to a subset of the user's permissions.
+	Permissions []security.Permission `json:"permissions"`
+}
+
+type CreatePersonalAccessTokenResponse struct {
+	model.PersonalAccessToken
+	// Token is the plaintext token; it is only returned once, on creation.
+	Token string `json:"token"`
+}
+
+func (h PersonalAccessTokensHandler) HandleCreateToken() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		session := auth.CurrentUser(c)
+		if err := ensureCanManageTokens(session); err != nil {
+			return err
+		}
+
+		var req CreatePersonalAccessTokenRequest
+		if err := validation.BindAndValidate(c, &req); err != nil {
+			return err
+		}
+
+		// The user cannot create a token with permissions they do not currently hold.
+		var permissions []string
+		if req.Permissions != nil {
+			permissions = []string{}
+			for _, p := range req.Permissions {
+				if !p.Valid() {
+					return echo.NewHTTPError(http.StatusBadRequest, "unknown permission: "+p.String())
+				}
+				if err := h.EnsurePermission(c, p); err != nil {
+					return err
+				}
+				permissions = append(permissions, p.String())
+			}
+		}
+
+		token, hash, prefix, err := auth.NewPersonalAccessToken()
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to generate personal access token", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		created, err := h.TokenStore.CreatePersonalAccessToken(c.Request().Context(), model.CreatePersonalAccessToken{
+			UserID:      session.User.ID,
+			CompanyID:   session.Company.ID,
+			Name:        req.Name,
+			TokenHash:   hash,
+			TokenPrefix: prefix,
+			Permissions: permissions,
+			ExpiresAt:   time.Now().UTC().AddDate(0, 0, req.ExpiresInDays),
+		})
+		if err != nil {
+			requestLogger(c, h.Logger).Error("failed to create personal access token", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		return c.JSON(http.StatusCreated, CreatePersonalAccessTokenResponse{
+			PersonalAccessToken: created,
+			Token:               token,
+		})
+	}
+}
+
+func (h PersonalAccessTokensHandler) HandleRevokeToken() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		session := auth.CurrentUser(c)
+		if err := ensureCanManageTokens(session); err != nil {
+			return err
+		}
+
+		tokenID, err := uuid.Parse(c.Param("tokenId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "token ID is not valid")
+		}
+
+		if err := h.TokenStore.RevokePersonalAccessToken(c.Request().Context(), tokenID, session.User.ID); err != nil {
+			if errors.Is(err, store.ErrPersonalAccessTokenNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
+			}
+			requestLogger(c, h.Logger).Error("failed to revoke personal access token", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
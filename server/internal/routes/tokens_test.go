@@ -0,0 +1,168 @@
+package routes_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"advancely/internal/application"
+	"advancely/internal/auth"
+	mw "advancely/internal/middleware"
+	"advancely/internal/model/security"
+	"advancely/internal/routes"
+	"advancely/internal/store"
+	"advancely/internal/tests"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPersonalAccessTokensHandler(db *sqlx.DB, roleFetcher store.RoleFetcher) routes.PersonalAccessTokensHandler {
+	return routes.PersonalAccessTokensHandler{
+		TokenStore:       store.NewPostgresPersonalAccessTokenStore(db),
+		EnsurePermission: routes.EnsurePermissionsFnFactory(roleFetcher),
+		Logger:           tests.NewDefaultLogger(),
+	}
+}
+
+func TestCreatePersonalAccessTokenRejectedRequests(t *testing.T) {
+	testCases := []struct {
+		name           string
+		payload        routes.CreatePersonalAccessTokenRequest
+		saveSession    func(c echo.Context)
+		expectedStatus int
+	}{
+		{
+			name:           "not logged in",
+			payload:        routes.CreatePersonalAccessTokenRequest{Name: "ci", ExpiresInDays: 30},
+			saveSession:    func(c echo.Context) {},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:    "authenticated with a personal access token",
+			payload: routes.CreatePersonalAccessTokenRequest{Name: "ci", ExpiresInDays: 30},
+			saveSession: func(c echo.Context) {
+				session := auth.SessionCookie{
+					TokenType: auth.TokenTypePersonalAccessToken,
+					User:      &auth.SessionCookieUser{ID: uuid.New()},
+					Company:   &auth.SessionCookieCompany{ID: uuid.New()},
+				}
+				session.SaveInContext(c)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:    "expiry too long",
+			payload: routes.CreatePersonalAccessTokenRequest{Name: "ci", ExpiresInDays: 1000},
+			saveSession: func(c echo.Context) {
+				tests.SaveSessionInContext(c, uuid.New(), uuid.New())
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "unknown permission",
+			payload: routes.CreatePersonalAccessTokenRequest{
+				Name:          "ci",
+				ExpiresInDays: 30,
+				Permissions:   []security.Permission{"launch-rockets"},
+			},
+			saveSession: func(c echo.Context) {
+				tests.SaveSessionInContext(c, uuid.New(), uuid.New())
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "permission not held by the user",
+			payload: routes.CreatePersonalAccessTokenRequest{
+				Name:          "ci",
+				ExpiresInDays: 30,
+				Permissions:   []security.Permission{security.PermissionDeleteUser},
+			},
+			saveSession: func(c echo.Context) {
+				tests.SaveSessionInContext(c, uuid.New(), uuid.New())
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, rec := tests.NewRequestRecorder(t, http.MethodPost, "/auth/tokens", tc.payload)
+			tc.saveSession(c)
+
+			handler := newTestPersonalAccessTokensHandler(nil, tests.NewFakeRoleFetcher(security.PermissionCreateUser))
+			if err := handler.HandleCreateToken()(c); err != nil {
+				c.Error(err)
+			}
+			require.Equal(t, tc.expectedStatus, rec.Code)
+		})
+	}
+}
+
+func TestPersonalAccessTokenAuthenticatesWithLimitedScope(t *testing.T) {
+	db, user, companyId := setUpTestAdminUserAndCompany(t)
+
+	// Create the token
+	payload := routes.CreatePersonalAccessTokenRequest{
+		Name:          "integration",
+		ExpiresInDays: 30,
+		Permissions:   []security.Permission{security.PermissionCreateRole},
+	}
+	c, rec := tests.NewRequestRecorder(t, http.MethodPost, "/auth/tokens", payload)
+	tests.SaveSessionInContext(c, user.ID, companyId)
+
+	permissionsStore := store.NewPostgresPermissionsStore(db)
+	handler := newTestPersonalAccessTokensHandler(db, permissionsStore)
+	err := handler.HandleCreateToken()(c)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var created routes.CreatePersonalAccessTokenResponse
+	err = json.Unmarshal(rec.Body.Bytes(), &created)
+	require.NoError(t, err)
+	require.True(t, auth.IsPersonalAccessToken(created.Token))
+
+	// Only the hash of the token is stored
+	var storedHash string
+	err = db.Get(&storedHash, "select token_hash from security.personal_access_tokens where id = $1;", created.ID)
+	require.NoError(t, err)
+	require.Equal(t, auth.HashToken(created.Token), storedHash)
+
+	// Authenticate with the token
+	userMw := mw.NewUserMiddleware(
+		application.AppConfig{},
+		tests.NewFakeAuthProvider(),
+		auth.NewHMACVerifier([]byte(tests.FakeJWTSecret), "authenticated"),
+		store.NewPostgresUserStore(db),
+		store.NewPostgresPersonalAccessTokenStore(db),
+		newTestSessionPolicy(db),
+		tests.NewDefaultLogger(),
+	)
+
+	e := tests.NewEchoInstance()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+created.Token)
+	c = e.NewContext(req, httptest.NewRecorder())
+
+	var session auth.AuthenticatedSession
+	err = userMw.WithUserInContext(func(c echo.Context) error {
+		session = auth.CurrentUser(c)
+		return nil
+	})(c)
+	require.NoError(t, err)
+	require.True(t, session.LoggedIn)
+	require.Equal(t, user.ID, session.User.ID)
+
+	// The Admin role grants every permission, but the token is limited to its scope
+	ensurePermission := routes.EnsurePermissionsFnFactory(permissionsStore)
+	require.Nil(t, ensurePermission(c, security.PermissionCreateRole))
+	require.NotNil(t, ensurePermission(c, security.PermissionDeleteRole))
+
+	var lastUsedAt *string
+	err = db.Get(&lastUsedAt, "select last_used_at::text from security.personal_access_tokens where id = $1;", created.ID)
+	require.NoError(t, err)
+	require.NotNil(t, lastUsedAt)
+}
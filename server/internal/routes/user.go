@@ -1,28 +1,33 @@
 package routes
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"net/http"
+	"slices"
 
 	"advancely/internal/auth"
+	"advancely/internal/model"
+	"advancely/internal/model/security"
 	"advancely/internal/store"
 	"advancely/internal/validation"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/supabase-community/gotrue-go/types"
-	"github.com/supabase-community/supabase-go"
 )
 
 func NewUsersHandler(
 	s *store.PostgresStore,
-	sb *supabase.Client,
+	authProvider auth.Provider,
 	ensurePermissionFn EnsurePermissionFn,
 	logger *slog.Logger) UsersHandler {
 	return UsersHandler{
 		UserStore:        s.UserStore,
-		Supabase:         sb,
+		PermissionsStore: s.PermissionsStore,
+		SettingsStore:    s.CompanySettingsStore,
+		AuthProvider:     authProvider,
 		EnsurePermission: ensurePermissionFn,
 		Logger:           logger,
 	}
@@ -30,8 +35,10 @@ func NewUsersHandler(
 
 type UsersHandler struct {
 	UserStore        store.UserStore
+	PermissionsStore store.PermissionsStore
+	SettingsStore    store.CompanySettingsStore
 	EnsurePermission EnsurePermissionFn
-	Supabase         *supabase.Client
+	AuthProvider     auth.Provider
 	Logger           *slog.Logger
 }
 
@@ -40,37 +47,39 @@ func (h UsersHandler) MakeRoutes(e *echo.Group) {
 	group.GET("", h.HandleListUsers())
 	group.GET("/:userId", h.HandleGetUser())
 	group.POST("", h.HandleCreateNewUser())
+	group.DELETE("/:userId", h.HandleDeleteUser())
+	group.POST("/:userId/restore", h.HandleRestoreUser())
+	group.POST("/:userId/suspend", h.HandleSuspendUser())
+	group.POST("/:userId/reactivate", h.HandleReactivateUser())
 }
 
 func (h UsersHandler) HandleGetUser() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		session := auth.CurrentUser(c)
 		userID, err := uuid.Parse(c.Param("userId"))
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "user ID is not valid")
 		}
 
-		user, err := h.UserStore.User(userID)
+		user, err := h.UserStore.User(ctx, userID, session.Company.ID)
 		if err != nil {
 			if errors.Is(err, store.ErrUserNotFound) {
 				return echo.NewHTTPError(http.StatusNotFound, store.ErrUserNotFound)
 			}
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
-
-		if user.CompanyID != session.Company.ID {
-			return echo.NewHTTPError(http.StatusNotFound, store.ErrUserNotFound)
-		}
 		return c.JSON(http.StatusOK, user)
 	}
 }
 
 func (h UsersHandler) HandleListUsers() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		session := auth.CurrentUser(c)
-		users, err := h.UserStore.Users(session.Company.ID)
+		users, err := h.UserStore.Users(ctx, session.Company.ID)
 		if err != nil {
-			h.Logger.Error("error listing users", "error", err)
+			requestLogger(c, h.Logger).Error("error listing users", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 
@@ -83,58 +92,102 @@ type NewUserRequest struct {
 	FirstName string `json:"firstName" validation:"required"`
 	LastName  string `json:"lastName" validation:"required"`
 	Email     string `json:"email" validation:"required,email"`
+	// RoleIDs are the roles assigned to the user instead of the company's default roles, if set.
+	// An empty list invites the user without any role.
+	RoleIDs []int `json:"roleIds" validate:"max=10,dive,gt=0"`
 }
 
 // HandleCreateNewUser adds a user to the company and sends an invitation email.
-// A record is also added to the public.profiles table for the user.
+// A record is also added to the public.profiles table for the user, along with the requested roles
+// or the company's default roles. Users who are already members of another company are added to the company
+// without being sent an invitation, as they can log in and switch to it.
+// Choosing the roles of the user requires the permission to assign roles.
 func (h UsersHandler) HandleCreateNewUser() echo.HandlerFunc {
 	return func(c echo.Context) error {
+		ctx := c.Request().Context()
 		session := auth.CurrentUser(c)
+		if err := h.EnsurePermission(c, security.PermissionCreateUser); err != nil {
+			return err
+		}
 
 		var req NewUserRequest
 		if err := validation.BindAndValidate(c, &req); err != nil {
 			return err
 		}
 
-		exists, err := h.UserStore.Exists(req.Email)
-		if err != nil {
-			h.Logger.Error("error checking if user already exists", "error", err)
-			return echo.NewHTTPError(http.StatusInternalServerError)
-		}
-		if exists {
-			return echo.NewHTTPError(http.StatusConflict, "A user with the given email already exists")
+		roleIDs := req.RoleIDs
+		if roleIDs != nil {
+			if err := h.EnsurePermission(c, security.PermissionAssignUserRole); err != nil {
+				return err
+			}
+			if err := validateRoleIDs(ctx, h.PermissionsStore, session.Company.ID, "roleIds", roleIDs); err != nil {
+				var fieldErrs validation.Errors
+				if errors.As(err, &fieldErrs) {
+					return echo.NewHTTPError(http.StatusBadRequest, "The request is invalid").SetInternal(err)
+				}
+				requestLogger(c, h.Logger).Error("error getting roles", "error", err)
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
+		} else {
+			defaults, err := defaultRoleIDs(ctx, h.SettingsStore, session.Company.ID)
+			if err != nil {
+				requestLogger(c, h.Logger).Error("error getting default roles", "error", err)
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
+			roleIDs = defaults
 		}
 
-		err = h.Supabase.Auth.OTP(types.OTPRequest{
-			Email:      req.Email,
-			CreateUser: true,
-			Data: map[string]interface{}{
-				"created_by": map[string]string{
-					"id":    session.User.ID.String(),
-					"email": session.User.Email,
+		user, err := h.UserStore.BaseUserByEmail(ctx, req.Email)
+		if err == nil {
+			if _, err := h.UserStore.User(ctx, user.ID, session.Company.ID); !errors.Is(err, store.ErrUserNotFound) {
+				if err != nil {
+					requestLogger(c, h.Logger).Error("error checking if user is already a member", "error", err)
+					return echo.NewHTTPError(http.StatusInternalServerError)
+				}
+				return echo.NewHTTPError(http.StatusConflict, "A user with the given email already exists")
+			}
+		} else {
+			if !errors.Is(err, store.ErrUserNotFound) {
+				requestLogger(c, h.Logger).Error("error checking if user already exists", "error", err)
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
+
+			err = h.AuthProvider.SendOTP(c.Request().Context(), types.OTPRequest{
+				Email:      req.Email,
+				CreateUser: true,
+				Data: map[string]interface{}{
+					"created_by": map[string]string{
+						"id":    session.User.ID.String(),
+						"email": session.User.Email,
+					},
 				},
-			},
-		})
+			})
 
-		if err != nil {
-			h.Logger.Error("error creating user", "error", err)
-			return echo.NewHTTPError(http.StatusInternalServerError)
-		}
+			if err != nil {
+				requestLogger(c, h.Logger).Error("error creating user", "error", err)
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
 
-		user, err := h.UserStore.BaseUserByEmail(req.Email)
-		if err != nil {
-			h.Logger.Error("error fetching recently created user", "error", err)
-			return echo.NewHTTPError(http.StatusInternalServerError)
+			user, err = h.UserStore.BaseUserByEmail(ctx, req.Email)
+			if err != nil {
+				requestLogger(c, h.Logger).Error("error fetching recently created user", "error", err)
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
 		}
 
-		profile, err := h.UserStore.CreateProfile(store.CreateProfileRequest{
+		profile, err := h.UserStore.CreateProfile(ctx, store.CreateProfileRequest{
 			UserID:    user.ID,
 			CompanyID: session.Company.ID,
 			FirstName: req.FirstName,
 			LastName:  req.LastName,
+			RoleIDs:   roleIDs,
 		})
 
 		if err != nil {
+			if errors.Is(err, store.ErrProfileExists) {
+				return echo.NewHTTPError(http.StatusConflict, "The user has been deleted from the company and can be restored")
+			}
+			requestLogger(c, h.Logger).Error("error creating profile", "error", err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 
@@ -142,3 +195,123 @@ func (h UsersHandler) HandleCreateNewUser() echo.HandlerFunc {
 		return c.JSON(http.StatusCreated, profile)
 	}
 }
+
+// HandleDeleteUser removes the user from the company. The user keeps their account and roles
+// until the retention period has passed, and can be restored until then.
+func (h UsersHandler) HandleDeleteUser() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		session := auth.CurrentUser(c)
+		if err := h.EnsurePermission(c, security.PermissionDeleteUser); err != nil {
+			return err
+		}
+
+		userID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "user ID is not valid")
+		}
+		if userID == session.User.ID {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "You cannot delete yourself")
+		}
+
+		if err := h.UserStore.DeleteProfile(ctx, userID, session.Company.ID); err != nil {
+			if errors.Is(err, store.ErrUserNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
+			}
+			requestLogger(c, h.Logger).Error("error deleting user", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// HandleRestoreUser restores a user deleted from the company, along with the roles they had.
+func (h UsersHandler) HandleRestoreUser() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		session := auth.CurrentUser(c)
+		if err := h.EnsurePermission(c, security.PermissionDeleteUser); err != nil {
+			return err
+		}
+
+		userID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "user ID is not valid")
+		}
+
+		user, err := h.UserStore.RestoreProfile(ctx, userID, session.Company.ID)
+		if err != nil {
+			if errors.Is(err, store.ErrUserNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err)
+			}
+			if errors.Is(err, store.ErrUserNotDeleted) {
+				return echo.NewHTTPError(http.StatusConflict, err)
+			}
+			requestLogger(c, h.Logger).Error("error restoring user", "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, user)
+	}
+}
+
+// HandleSuspendUser blocks the user from accessing the company while keeping their profile and roles.
+// Their sessions are revoked unless they are an active member of another company.
+func (h UsersHandler) HandleSuspendUser() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return h.handleSetUserActive(c, false)
+	}
+}
+
+// HandleReactivateUser restores the access of a suspended user to the company.
+func (h UsersHandler) HandleReactivateUser() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return h.handleSetUserActive(c, true)
+	}
+}
+
+func (h UsersHandler) handleSetUserActive(c echo.Context, active bool) error {
+	ctx := c.Request().Context()
+	session := auth.CurrentUser(c)
+	if err := h.EnsurePermission(c, security.PermissionEditUser); err != nil {
+		return err
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "user ID is not valid")
+	}
+	if !active && userID == session.User.ID {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "You cannot suspend yourself")
+	}
+
+	if err := setUserActive(ctx, h.UserStore, h.AuthProvider, userID, session.Company.ID, active); err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err)
+		}
+		requestLogger(c, h.Logger).Error("error setting user active", "error", err, "active", active)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	user, err := h.UserStore.User(ctx, userID, session.Company.ID)
+	if err != nil {
+		requestLogger(c, h.Logger).Error("error getting user", "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// setUserActive suspends or reactivates the user in the company. Users are banned from the auth provider,
+// revoking their sessions, while they are not an active member of any company. The user stays suspended
+// or reactivated if banning them fails, so the change can be retried.
+func setUserActive(ctx context.Context, userStore store.UserStore, authProvider auth.Provider, id, companyID uuid.UUID, active bool) error {
+	if err := userStore.SetUserActive(ctx, id, companyID, active); err != nil {
+		return err
+	}
+
+	memberships, err := userStore.Memberships(ctx, id)
+	if err != nil {
+		return err
+	}
+	banned := !slices.ContainsFunc(memberships, model.Membership.Active)
+	return authProvider.SetUserBanned(ctx, id, banned)
+}
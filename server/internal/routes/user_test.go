@@ -1,18 +1,29 @@
 package routes_test
 
 import (
+	"advancely/internal/application"
+	"advancely/internal/auth"
+	mw "advancely/internal/middleware"
+	"advancely/internal/model"
 	"advancely/internal/model/security"
+	"advancely/internal/model/settings"
 	"advancely/internal/routes"
 	"advancely/internal/store"
 	"advancely/internal/tests"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/require"
-	"github.com/supabase-community/supabase-go"
 	"net/http"
+	"net/http/httptest"
+	"slices"
 	"testing"
 )
 
-func newTestUsersHandler(db *sqlx.DB, sb *supabase.Client, roleFetcher store.RoleFetcher) routes.UsersHandler {
+func newTestUsersHandler(db *sqlx.DB, authProvider auth.Provider, roleFetcher store.RoleFetcher) routes.UsersHandler {
 	permissionsStore := store.NewPostgresPermissionsStore(db)
 	var rf = roleFetcher
 	if rf == nil {
@@ -20,15 +31,17 @@ func newTestUsersHandler(db *sqlx.DB, sb *supabase.Client, roleFetcher store.Rol
 	}
 	return routes.UsersHandler{
 		UserStore:        store.NewPostgresUserStore(db),
+		PermissionsStore: permissionsStore,
+		SettingsStore:    store.NewPostgresCompanySettingsStore(db),
 		EnsurePermission: routes.EnsurePermissionsFnFactory(rf),
-		Supabase:         sb,
+		AuthProvider:     authProvider,
 		Logger:           tests.NewDefaultLogger(),
 	}
 }
 
 func TestHandleCreateNewUser(t *testing.T) {
 	db, user, companyId := setUpTestAdminUserAndCompany(t)
-	sb := tests.NewTestSupabaseClient(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
 
 	payload := map[string]string{
 		"firstName": "John",
@@ -38,7 +51,7 @@ func TestHandleCreateNewUser(t *testing.T) {
 
 	c, rec := tests.NewRequestRecorder(t, http.MethodPost, "/login", payload)
 	tests.SaveSessionInContext(c, user.ID, companyId)
-	handler := newTestUsersHandler(db, sb, tests.NewFakeRoleFetcher(security.PermissionCreateUser))
+	handler := newTestUsersHandler(db, authProvider, tests.NewFakeRoleFetcher(security.PermissionCreateUser))
 	err := handler.HandleCreateNewUser()(c)
 	if err != nil {
 		c.Error(err)
@@ -59,3 +72,225 @@ func TestHandleCreateNewUser(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, exists)
 }
+
+func TestHandleCreateNewUserAssignsRoles(t *testing.T) {
+	db, user, companyId := setUpTestAdminUserAndCompany(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	handler := newTestUsersHandler(db, authProvider, tests.NewFakeRoleFetcher(
+		security.PermissionCreateUser, security.PermissionAssignUserRole))
+	ctx := context.Background()
+
+	defaultRole, err := handler.PermissionsStore.CreateRole(ctx, model.CreateRole{CompanyID: companyId, Name: "Member"})
+	require.NoError(t, err)
+	otherRole, err := handler.PermissionsStore.CreateRole(ctx, model.CreateRole{CompanyID: companyId, Name: "Reviewer"})
+	require.NoError(t, err)
+
+	values, err := settings.Apply(settings.Values{}, map[string]json.RawMessage{
+		settings.DefaultRoleIDs.Key: json.RawMessage(fmt.Sprintf("[%d]", defaultRole.ID)),
+	})
+	require.NoError(t, err)
+	_, err = handler.SettingsStore.SaveSettings(ctx, companyId, values, nil, user.ID)
+	require.NoError(t, err)
+
+	invite := func(email string, roleIDs []int) error {
+		c, rec := tests.NewRequestRecorder(t, http.MethodPost, "/user", routes.NewUserRequest{
+			FirstName: "Jane",
+			LastName:  "Doe",
+			Email:     email,
+			RoleIDs:   roleIDs,
+		})
+		tests.SaveSessionInContext(c, user.ID, companyId)
+		if err := handler.HandleCreateNewUser()(c); err != nil {
+			return err
+		}
+		require.Equal(t, http.StatusCreated, rec.Code)
+		return nil
+	}
+	roleIDsOf := func(email string) []int {
+		var ids []int
+		stmt := `
+			select ur.role_id
+			from security.user_roles ur
+			join auth.users u on u.id = ur.user_id
+			where u.email = $1
+			order by ur.role_id;`
+		require.NoError(t, db.Select(&ids, stmt, email))
+		return ids
+	}
+
+	require.NoError(t, invite("default@advancelyexample.com", nil))
+	require.Equal(t, []int{defaultRole.ID}, roleIDsOf("default@advancelyexample.com"))
+
+	require.NoError(t, invite("explicit@advancelyexample.com", []int{otherRole.ID}))
+	require.Equal(t, []int{otherRole.ID}, roleIDsOf("explicit@advancelyexample.com"))
+
+	require.NoError(t, invite("none@advancelyexample.com", []int{}))
+	require.Empty(t, roleIDsOf("none@advancelyexample.com"))
+
+	err = invite("unknown@advancelyexample.com", []int{2147483647})
+	assertHTTPError(t, err, http.StatusBadRequest, "")
+}
+
+func TestHandleCreateNewUserRequiresPermissions(t *testing.T) {
+	db, user, companyId := setUpTestAdminUserAndCompany(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	roles, err := store.NewPostgresPermissionsStore(db).Roles(context.Background(), companyId)
+	require.NoError(t, err)
+	adminRole := roles[slices.IndexFunc(roles, func(r model.RoleWithPermissions) bool {
+		return r.IsSystemRole && r.Name == string(security.RoleAdmin)
+	})]
+
+	invite := func(roleFetcher store.RoleFetcher, email string, roleIDs []int) error {
+		c, _ := tests.NewRequestRecorder(t, http.MethodPost, "/user", routes.NewUserRequest{
+			FirstName: "Jane",
+			LastName:  "Doe",
+			Email:     email,
+			RoleIDs:   roleIDs,
+		})
+		tests.SaveSessionInContext(c, user.ID, companyId)
+		return newTestUsersHandler(db, authProvider, roleFetcher).HandleCreateNewUser()(c)
+	}
+
+	err = invite(tests.NewFakeRoleFetcher(), "noperm@advancelyexample.com", nil)
+	assertHTTPError(t, err, http.StatusForbidden, "")
+
+	// Members who can invite users but not assign roles cannot choose the roles of the new user.
+	err = invite(tests.NewFakeRoleFetcher(), "admin@advancelyexample.com", []int{adminRole.ID})
+	assertHTTPError(t, err, http.StatusForbidden, "")
+	err = invite(tests.NewFakeRoleFetcher(security.PermissionCreateUser), "admin@advancelyexample.com", []int{adminRole.ID})
+	assertHTTPError(t, err, http.StatusForbidden, "")
+
+	var exists bool
+	err = db.QueryRow(`select exists(select 1 from auth.users where email = 'admin@advancelyexample.com')`).Scan(&exists)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestHandleDeleteAndRestoreUser(t *testing.T) {
+	db, user, companyId := setUpTestAdminUserAndCompany(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	handler := newTestUsersHandler(db, authProvider, tests.NewFakeRoleFetcher(security.PermissionDeleteUser))
+	ctx := context.Background()
+
+	member := tests.CreateAuthUser(t, authProvider, db, "member@advancelyexample.com")
+	tests.AddAdminToCompany(t, db, member.ID, companyId)
+
+	call := func(h echo.HandlerFunc, method, userID string) (*httptest.ResponseRecorder, error) {
+		c, rec := tests.NewRequestRecorder(t, method, "/user/"+userID, nil)
+		tests.SaveSessionInContext(c, user.ID, companyId)
+		c.SetParamNames("userId")
+		c.SetParamValues(userID)
+		return rec, h(c)
+	}
+
+	_, err := call(handler.HandleRestoreUser(), http.MethodPost, member.ID.String())
+	assertHTTPError(t, err, http.StatusConflict, "")
+
+	_, err = call(handler.HandleDeleteUser(), http.MethodDelete, user.ID.String())
+	assertHTTPError(t, err, http.StatusUnprocessableEntity, "")
+
+	rec, err := call(handler.HandleDeleteUser(), http.MethodDelete, member.ID.String())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	// The deleted user is no longer a member of the company, but keeps their roles.
+	_, err = handler.UserStore.User(ctx, member.ID, companyId)
+	require.ErrorIs(t, err, store.ErrUserNotFound)
+	users, err := handler.UserStore.Users(ctx, companyId)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+
+	_, err = call(handler.HandleDeleteUser(), http.MethodDelete, member.ID.String())
+	assertHTTPError(t, err, http.StatusNotFound, "")
+
+	rec, err = call(handler.HandleRestoreUser(), http.MethodPost, member.ID.String())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	roles, err := handler.PermissionsStore.UserRoles(ctx, member.ID, companyId)
+	require.NoError(t, err)
+	require.Len(t, roles.Roles, 1)
+	require.Equal(t, security.RoleAdmin, roles.Roles[0].Role)
+
+	_, err = call(handler.HandleRestoreUser(), http.MethodPost, uuid.NewString())
+	assertHTTPError(t, err, http.StatusNotFound, "")
+}
+
+func TestHandleSuspendAndReactivateUser(t *testing.T) {
+	db, user, companyId := setUpTestAdminUserAndCompany(t)
+	authProvider := tests.NewFakeAuthProvider().WithDatabase(db)
+	handler := newTestUsersHandler(db, authProvider, tests.NewFakeRoleFetcher(security.PermissionEditUser))
+	ctx := context.Background()
+
+	member := tests.CreateAuthUser(t, authProvider, db, "suspended@advancelyexample.com")
+	tests.AddAdminToCompany(t, db, member.ID, companyId)
+	// Users who are active members of another company are not banned from the auth provider.
+	consultant := tests.CreateAuthUser(t, authProvider, db, "consultant@advancelyexample.com")
+	tests.CreateTestCompany(t, db, consultant.ID)
+	tests.AddAdminToCompany(t, db, consultant.ID, companyId)
+
+	tokens, err := authProvider.SignInWithEmailPassword(ctx, member.Email, tests.DefaultUserPassword)
+	require.NoError(t, err)
+	profile, err := handler.UserStore.User(ctx, member.ID, companyId)
+	require.NoError(t, err)
+	session := auth.NewSessionCookie(*tokens)
+	session.SetUser(profile)
+	session.Company = &auth.SessionCookieCompany{ID: companyId}
+
+	userMw := mw.NewUserMiddleware(
+		application.AppConfig{SessionSecret: testSessionSecret},
+		authProvider,
+		auth.NewHMACVerifier([]byte(tests.FakeJWTSecret), "authenticated"),
+		handler.UserStore,
+		store.NewPostgresPersonalAccessTokenStore(db),
+		newTestSessionPolicy(db),
+		tests.NewDefaultLogger(),
+	)
+	loggedIn := func() bool {
+		c, _ := tests.NewRequestRecorder(t, http.MethodGet, "/", nil)
+		tests.AddSessionCookie(t, c, session, testSessionSecret)
+		var loggedIn bool
+		require.NoError(t, userMw.WithUserInContext(func(c echo.Context) error {
+			loggedIn = auth.CurrentUser(c).LoggedIn
+			return nil
+		})(c))
+		return loggedIn
+	}
+	call := func(h echo.HandlerFunc, userID uuid.UUID) (*httptest.ResponseRecorder, error) {
+		c, rec := tests.NewRequestRecorder(t, http.MethodPost, "/user/"+userID.String(), nil)
+		tests.SaveSessionInContext(c, user.ID, companyId)
+		c.SetParamNames("userId")
+		c.SetParamValues(userID.String())
+		return rec, h(c)
+	}
+	require.True(t, loggedIn())
+
+	_, err = call(handler.HandleSuspendUser(), user.ID)
+	assertHTTPError(t, err, http.StatusUnprocessableEntity, "")
+
+	rec, err := call(handler.HandleSuspendUser(), member.ID)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// The session of the suspended user is rejected and can no longer be refreshed.
+	require.False(t, loggedIn())
+	_, err = authProvider.RefreshSession(ctx, tokens.RefreshToken)
+	require.Error(t, err)
+	_, err = authProvider.SignInWithEmailPassword(ctx, member.Email, tests.DefaultUserPassword)
+	require.Error(t, err)
+
+	_, err = call(handler.HandleSuspendUser(), consultant.ID)
+	require.NoError(t, err)
+	_, err = authProvider.SignInWithEmailPassword(ctx, consultant.Email, tests.DefaultUserPassword)
+	require.NoError(t, err)
+
+	rec, err = call(handler.HandleReactivateUser(), member.ID)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, loggedIn())
+	_, err = authProvider.SignInWithEmailPassword(ctx, member.Email, tests.DefaultUserPassword)
+	require.NoError(t, err)
+
+	_, err = call(handler.HandleSuspendUser(), uuid.New())
+	assertHTTPError(t, err, http.StatusNotFound, "")
+}
@@ -0,0 +1,393 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Filter is a parsed SCIM filter expression, as described in RFC 7644 section 3.4.2.2.
+// Filters are evaluated against the JSON representation of a resource.
+type Filter interface {
+	// Matches returns true if the resource, decoded from JSON, satisfies the filter.
+	Matches(resource map[string]any) bool
+}
+
+// caseExactAttributes are compared case-sensitively; all other string attributes are case-insensitive.
+var caseExactAttributes = map[string]bool{
+	"id":            true,
+	"externalid":    true,
+	"members.value": true,
+}
+
+// ParseFilter parses a filter such as `userName eq "jane@example.com" and active eq true`.
+// The logical operators and, or and not, grouping with parentheses, and all attribute operators are
+// supported. Complex attribute filters in brackets, such as `emails[type eq "work"]`, are not supported.
+func ParseFilter(filter string) (Filter, error) {
+	tokens, err := tokenizeFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidFilter, p.tokens[p.pos].text)
+	}
+	return f, nil
+}
+
+// MatchResource returns true if the resource satisfies the filter.
+// The resource is converted to its JSON representation to be evaluated.
+func MatchResource(f Filter, resource any) (bool, error) {
+	b, err := json.Marshal(resource)
+	if err != nil {
+		return false, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return false, err
+	}
+	return f.Matches(m), nil
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpenParen
+	tokenCloseParen
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+}
+
+func tokenizeFilter(filter string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(filter); {
+		ch := rune(filter[i])
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+		case ch == '(':
+			tokens = append(tokens, filterToken{kind: tokenOpenParen, text: "("})
+			i++
+		case ch == ')':
+			tokens = append(tokens, filterToken{kind: tokenCloseParen, text: ")"})
+			i++
+		case ch == '[' || ch == ']':
+			return nil, fmt.Errorf("%w: complex attribute filters are not supported", ErrInvalidFilter)
+		case ch == '"':
+			end := i + 1
+			for ; end < len(filter) && filter[end] != '"'; end++ {
+				if filter[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(filter) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidFilter)
+			}
+			var s string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &s); err != nil {
+				return nil, fmt.Errorf("%w: invalid string %s", ErrInvalidFilter, filter[i:end+1])
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: s})
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && !unicode.IsSpace(rune(filter[end])) && !strings.ContainsRune(`()[]"`, rune(filter[end])) {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: tokenWord, text: filter[i:end]})
+			i = end
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: empty filter", ErrInvalidFilter)
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) &&
+		p.tokens[p.pos].kind == tokenWord &&
+		strings.EqualFold(p.tokens[p.pos].text, keyword)
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseTerm() (Filter, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected end of filter", ErrInvalidFilter)
+	}
+
+	negate := false
+	if p.peekKeyword("not") {
+		negate = true
+		p.pos++
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenOpenParen {
+			return nil, fmt.Errorf("%w: not must be followed by a parenthesised expression", ErrInvalidFilter)
+		}
+	}
+
+	var f Filter
+	if p.tokens[p.pos].kind == tokenOpenParen {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenCloseParen {
+			return nil, fmt.Errorf("%w: missing closing parenthesis", ErrInvalidFilter)
+		}
+		p.pos++
+		f = inner
+	} else {
+		comparison, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		f = comparison
+	}
+
+	if negate {
+		return notFilter{f}, nil
+	}
+	return f, nil
+}
+
+func (p *filterParser) parseComparison() (Filter, error) {
+	attr := p.tokens[p.pos]
+	if attr.kind != tokenWord {
+		return nil, fmt.Errorf("%w: expected an attribute but got %q", ErrInvalidFilter, attr.text)
+	}
+	p.pos++
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenWord {
+		return nil, fmt.Errorf("%w: expected an operator after %q", ErrInvalidFilter, attr.text)
+	}
+	op := strings.ToLower(p.tokens[p.pos].text)
+	p.pos++
+
+	path := normalizeAttributePath(attr.text)
+	if op == "pr" {
+		return presentFilter{path: path}, nil
+	}
+	switch op {
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidFilter, op)
+	}
+
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("%w: expected a value after %q", ErrInvalidFilter, op)
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+
+	var value any
+	switch {
+	case token.kind == tokenString:
+		value = token.text
+	case token.kind == tokenWord && strings.EqualFold(token.text, "true"):
+		value = true
+	case token.kind == tokenWord && strings.EqualFold(token.text, "false"):
+		value = false
+	case token.kind == tokenWord && strings.EqualFold(token.text, "null"):
+		value = nil
+	case token.kind == tokenWord:
+		n, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid value %q", ErrInvalidFilter, token.text)
+		}
+		value = n
+	default:
+		return nil, fmt.Errorf("%w: expected a value after %q", ErrInvalidFilter, op)
+	}
+	return comparisonFilter{path: path, op: op, value: value, caseExact: caseExactAttributes[path]}, nil
+}
+
+// normalizeAttributePath lowercases the path and removes any schema URN prefix,
+// such as "urn:ietf:params:scim:schemas:core:2.0:User:userName".
+func normalizeAttributePath(path string) string {
+	path = strings.ToLower(path)
+	if strings.HasPrefix(path, "urn:") {
+		if i := strings.LastIndex(path, ":"); i != -1 {
+			path = path[i+1:]
+		}
+	}
+	return path
+}
+
+type andFilter struct{ left, right Filter }
+
+func (f andFilter) Matches(r map[string]any) bool { return f.left.Matches(r) && f.right.Matches(r) }
+
+type orFilter struct{ left, right Filter }
+
+func (f orFilter) Matches(r map[string]any) bool { return f.left.Matches(r) || f.right.Matches(r) }
+
+type notFilter struct{ inner Filter }
+
+func (f notFilter) Matches(r map[string]any) bool { return !f.inner.Matches(r) }
+
+type presentFilter struct{ path string }
+
+func (f presentFilter) Matches(r map[string]any) bool {
+	for _, v := range attributeValues(r, f.path) {
+		if s, ok := v.(string); !ok || s != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type comparisonFilter struct {
+	path      string
+	op        string
+	value     any
+	caseExact bool
+}
+
+func (f comparisonFilter) Matches(r map[string]any) bool {
+	values := attributeValues(r, f.path)
+	if f.value == nil {
+		// Comparing with null tests whether the attribute has no value.
+		switch f.op {
+		case "eq":
+			return len(values) == 0
+		case "ne":
+			return len(values) > 0
+		}
+		return false
+	}
+	if f.op == "ne" {
+		return !comparisonFilter{path: f.path, op: "eq", value: f.value, caseExact: f.caseExact}.Matches(r)
+	}
+	for _, v := range values {
+		if f.compare(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f comparisonFilter) compare(actual any) bool {
+	switch expected := f.value.(type) {
+	case bool:
+		b, ok := actual.(bool)
+		return ok && f.op == "eq" && b == expected
+	case float64:
+		n, ok := actual.(float64)
+		if !ok {
+			return false
+		}
+		return compareOrdered(f.op, n, expected)
+	case string:
+		s, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		if !f.caseExact {
+			s, expected = strings.ToLower(s), strings.ToLower(expected)
+		}
+		switch f.op {
+		case "co":
+			return strings.Contains(s, expected)
+		case "sw":
+			return strings.HasPrefix(s, expected)
+		case "ew":
+			return strings.HasSuffix(s, expected)
+		default:
+			return compareOrdered(f.op, s, expected)
+		}
+	}
+	return false
+}
+
+func compareOrdered[T float64 | string](op string, actual, expected T) bool {
+	switch op {
+	case "eq":
+		return actual == expected
+	case "gt":
+		return actual > expected
+	case "ge":
+		return actual >= expected
+	case "lt":
+		return actual < expected
+	case "le":
+		return actual <= expected
+	}
+	return false
+}
+
+// attributeValues returns the values at the dot separated path, which is matched case-insensitively.
+// Multi-valued attributes are flattened, so "emails.value" returns the value of every email.
+func attributeValues(resource map[string]any, path string) []any {
+	current := []any{resource}
+	for _, segment := range strings.Split(path, ".") {
+		var next []any
+		for _, v := range current {
+			m, ok := v.(map[string]any)
+			if !ok {
+				continue
+			}
+			for key, child := range m {
+				if !strings.EqualFold(key, segment) {
+					continue
+				}
+				if list, ok := child.([]any); ok {
+					next = append(next, list...)
+				} else if child != nil {
+					next = append(next, child)
+				}
+			}
+		}
+		current = next
+	}
+	return current
+}
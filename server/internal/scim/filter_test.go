@@ -0,0 +1,112 @@
+package scim
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilter(t *testing.T) {
+	user := map[string]any{
+		"id":         "2819c223",
+		"externalId": "ABC",
+		"userName":   "Jane@Example.com",
+		"active":     true,
+		"name":       map[string]any{"givenName": "Jane", "familyName": "Doe"},
+		"emails": []any{
+			map[string]any{"value": "jane@example.com", "type": "work"},
+			map[string]any{"value": "jane@home.org", "type": "home"},
+		},
+		"meta": map[string]any{"lastModified": "2024-05-01T10:00:00Z"},
+	}
+
+	testCases := []struct {
+		filter   string
+		expected bool
+	}{
+		{`userName eq "jane@example.com"`, true},
+		{`USERNAME Eq "JANE@EXAMPLE.COM"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "jane@example.com"`, true},
+		{`userName ne "jane@example.com"`, false},
+		{`userName sw "jane"`, true},
+		{`userName ew "example.com"`, true},
+		{`userName co "@"`, true},
+		{`externalId eq "ABC"`, true},
+		{`externalId eq "abc"`, false},
+		{`id eq "2819c223"`, true},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`name.familyName eq "doe"`, true},
+		{`emails.value eq "jane@home.org"`, true},
+		{`emails.type eq "other"`, false},
+		{`title pr`, false},
+		{`name pr`, true},
+		{`title eq null`, true},
+		{`meta.lastModified gt "2024-01-01T00:00:00Z"`, true},
+		{`meta.lastModified lt "2024-01-01T00:00:00Z"`, false},
+		{`userName eq "jane@example.com" and active eq false`, false},
+		{`userName eq "john@example.com" or active eq true`, true},
+		{`not (active eq true)`, false},
+		{`(userName eq "john@example.com" or externalId eq "ABC") and name.givenName sw "J"`, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.filter, func(t *testing.T) {
+			f, err := ParseFilter(tc.filter)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, f.Matches(user))
+		})
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	filters := []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName like "jane"`,
+		`userName eq "jane`,
+		`userName eq jane`,
+		`(userName eq "jane"`,
+		`userName eq "jane" and`,
+		`not active eq true`,
+		`emails[type eq "work"]`,
+		`userName eq "jane" extra`,
+	}
+	for _, filter := range filters {
+		t.Run(filter, func(t *testing.T) {
+			_, err := ParseFilter(filter)
+			require.True(t, errors.Is(err, ErrInvalidFilter), "expected invalid filter, got %v", err)
+		})
+	}
+}
+
+func TestMatchResource(t *testing.T) {
+	active := false
+	user := User{Schemas: []string{SchemaUser}, ID: "1", UserName: "jane@example.com", Active: &active}
+
+	f, err := ParseFilter(`userName eq "jane@example.com" and active eq false`)
+	require.NoError(t, err)
+
+	ok, err := MatchResource(f, user)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestNewListResponse(t *testing.T) {
+	resources := []int{1, 2, 3, 4, 5}
+
+	page := NewListResponse(resources, 2, 2)
+	require.Equal(t, 5, page.TotalResults)
+	require.Equal(t, 2, page.StartIndex)
+	require.Equal(t, 2, page.ItemsPerPage)
+	require.Equal(t, []any{2, 3}, page.Resources)
+
+	page = NewListResponse(resources, 0, -1)
+	require.Equal(t, 1, page.StartIndex)
+	require.Len(t, page.Resources, 5)
+
+	page = NewListResponse(resources, 10, 2)
+	require.Equal(t, 0, page.ItemsPerPage)
+	require.NotNil(t, page.Resources)
+}
@@ -0,0 +1,289 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PatchRequest is the body of a PATCH request, described in RFC 7644 section 3.5.2.
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is a single add, remove or replace operation.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+const (
+	patchOpAdd     = "add"
+	patchOpRemove  = "remove"
+	patchOpReplace = "replace"
+)
+
+// Validate checks the request has the PatchOp schema and that every operation is known.
+func (r PatchRequest) Validate() error {
+	hasSchema := false
+	for _, s := range r.Schemas {
+		hasSchema = hasSchema || s == SchemaPatchOp
+	}
+	if !hasSchema {
+		return NewError(http.StatusBadRequest, ErrorTypeInvalidSyntax, "The request must use the PatchOp schema")
+	}
+	if len(r.Operations) == 0 {
+		return NewError(http.StatusBadRequest, ErrorTypeInvalidSyntax, "The request must contain at least one operation")
+	}
+	for _, op := range r.Operations {
+		switch strings.ToLower(op.Op) {
+		case patchOpAdd, patchOpReplace:
+			if len(op.Value) == 0 {
+				return NewError(http.StatusBadRequest, ErrorTypeInvalidValue, fmt.Sprintf("The %s operation requires a value", op.Op))
+			}
+		case patchOpRemove:
+			if op.Path == "" {
+				return NewError(http.StatusBadRequest, ErrorTypeNoTarget, "The remove operation requires a path")
+			}
+		default:
+			return NewError(http.StatusBadRequest, ErrorTypeInvalidSyntax, fmt.Sprintf("Unknown operation %q", op.Op))
+		}
+	}
+	return nil
+}
+
+// ApplyUserPatch applies the operations to the user.
+// Operations without a path apply each attribute of the value object.
+func ApplyUserPatch(u *User, ops []PatchOperation) error {
+	for _, op := range ops {
+		op.Op = strings.ToLower(op.Op)
+		if op.Path == "" {
+			attributes, err := patchValueAttributes(op.Value)
+			if err != nil {
+				return err
+			}
+			for path, value := range attributes {
+				if err := applyUserAttribute(u, op.Op, path, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := applyUserAttribute(u, op.Op, op.Path, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emailsPathPattern matches paths to the user's email, such as `emails[type eq "work"].value`.
+var emailsPathPattern = regexp.MustCompile(`(?i)^emails(\[.*])?(\.value)?$`)
+
+func applyUserAttribute(u *User, op, path string, value json.RawMessage) error {
+	remove := op == patchOpRemove
+	path = normalizeAttributePath(path)
+
+	switch {
+	case path == "username":
+		if remove {
+			return NewError(http.StatusBadRequest, ErrorTypeMutability, "userName is required")
+		}
+		return unmarshalPatchValue(value, &u.UserName)
+	case path == "externalid":
+		if remove {
+			u.ExternalID = ""
+			return nil
+		}
+		return unmarshalPatchValue(value, &u.ExternalID)
+	case path == "displayname":
+		if remove {
+			u.DisplayName = ""
+			return nil
+		}
+		return unmarshalPatchValue(value, &u.DisplayName)
+	case path == "active":
+		active := true
+		if !remove {
+			b, err := patchBool(value)
+			if err != nil {
+				return err
+			}
+			active = b
+		}
+		u.Active = &active
+		return nil
+	case path == "name":
+		if remove {
+			u.Name = nil
+			return nil
+		}
+		var name Name
+		if err := unmarshalPatchValue(value, &name); err != nil {
+			return err
+		}
+		u.Name = &name
+		return nil
+	case strings.HasPrefix(path, "name."):
+		if u.Name == nil {
+			u.Name = &Name{}
+		}
+		var target *string
+		switch strings.TrimPrefix(path, "name.") {
+		case "givenname":
+			target = &u.Name.GivenName
+		case "familyname":
+			target = &u.Name.FamilyName
+		case "formatted":
+			target = &u.Name.Formatted
+		default:
+			return NewError(http.StatusBadRequest, ErrorTypeInvalidPath, fmt.Sprintf("Unknown attribute %q", path))
+		}
+		if remove {
+			*target = ""
+			return nil
+		}
+		return unmarshalPatchValue(value, target)
+	case emailsPathPattern.MatchString(path):
+		// Emails mirror the userName, which is the address the user logs in with, so changes are ignored.
+		return nil
+	}
+	return NewError(http.StatusBadRequest, ErrorTypeInvalidPath, fmt.Sprintf("Unknown attribute %q", path))
+}
+
+// membersPathPattern matches a path to a single member, such as `members[value eq "id"]`.
+var membersPathPattern = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+"([^"]*)"\s*]$`)
+
+// ApplyGroupPatch applies the operations to the group.
+// Operations without a path apply each attribute of the value object.
+func ApplyGroupPatch(g *Group, ops []PatchOperation) error {
+	for _, op := range ops {
+		op.Op = strings.ToLower(op.Op)
+		if op.Path == "" {
+			attributes, err := patchValueAttributes(op.Value)
+			if err != nil {
+				return err
+			}
+			for path, value := range attributes {
+				if err := applyGroupAttribute(g, op.Op, path, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := applyGroupAttribute(g, op.Op, op.Path, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyGroupAttribute(g *Group, op, path string, value json.RawMessage) error {
+	if match := membersPathPattern.FindStringSubmatch(path); match != nil {
+		if op != patchOpRemove {
+			return NewError(http.StatusBadRequest, ErrorTypeInvalidPath, "Members can only be removed by value filter")
+		}
+		g.Members = removeMembers(g.Members, []Member{{Value: match[1]}})
+		return nil
+	}
+
+	switch normalizeAttributePath(path) {
+	case "id":
+		// Some identity providers include the ID in the value of replace operations; it cannot be changed.
+		return nil
+	case "externalid":
+		if op == patchOpRemove {
+			g.ExternalID = ""
+			return nil
+		}
+		return unmarshalPatchValue(value, &g.ExternalID)
+	case "displayname":
+		if op == patchOpRemove {
+			return NewError(http.StatusBadRequest, ErrorTypeMutability, "displayName is required")
+		}
+		return unmarshalPatchValue(value, &g.DisplayName)
+	case "members":
+		var members []Member
+		if len(value) > 0 {
+			if err := unmarshalPatchValue(value, &members); err != nil {
+				return err
+			}
+		}
+		switch op {
+		case patchOpAdd:
+			g.Members = addMembers(g.Members, members)
+		case patchOpReplace:
+			g.Members = addMembers(nil, members)
+		case patchOpRemove:
+			// Without a value, every member is removed.
+			if len(value) == 0 {
+				g.Members = nil
+			} else {
+				g.Members = removeMembers(g.Members, members)
+			}
+		}
+		return nil
+	}
+	return NewError(http.StatusBadRequest, ErrorTypeInvalidPath, fmt.Sprintf("Unknown attribute %q", path))
+}
+
+func addMembers(members, add []Member) []Member {
+	for _, m := range add {
+		exists := false
+		for _, existing := range members {
+			exists = exists || existing.Value == m.Value
+		}
+		if !exists {
+			members = append(members, Member{Value: m.Value})
+		}
+	}
+	return members
+}
+
+func removeMembers(members, remove []Member) []Member {
+	var kept []Member
+	for _, m := range members {
+		removed := false
+		for _, r := range remove {
+			removed = removed || r.Value == m.Value
+		}
+		if !removed {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+func patchValueAttributes(value json.RawMessage) (map[string]json.RawMessage, error) {
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(value, &attributes); err != nil {
+		return nil, NewError(http.StatusBadRequest, ErrorTypeInvalidValue, "Operations without a path require an object value")
+	}
+	return attributes, nil
+}
+
+func unmarshalPatchValue(value json.RawMessage, target any) error {
+	if err := json.Unmarshal(value, target); err != nil {
+		return NewError(http.StatusBadRequest, ErrorTypeInvalidValue, fmt.Sprintf("Invalid value %s", value))
+	}
+	return nil
+}
+
+// patchBool parses a boolean value, which some identity providers send as the string "True" or "False".
+func patchBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b, nil
+		}
+	}
+	return false, NewError(http.StatusBadRequest, ErrorTypeInvalidValue, fmt.Sprintf("Invalid boolean %s", value))
+}
@@ -0,0 +1,157 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func parsePatchRequest(t *testing.T, body string) PatchRequest {
+	t.Helper()
+	var req PatchRequest
+	require.NoError(t, json.Unmarshal([]byte(body), &req))
+	return req
+}
+
+func requireSCIMError(t *testing.T, err error, status int, scimType string) {
+	t.Helper()
+	var scimErr *Error
+	require.ErrorAs(t, err, &scimErr)
+	require.Equal(t, status, scimErr.StatusCode())
+	require.Equal(t, scimType, scimErr.ScimType)
+}
+
+func TestPatchRequestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		scimType string
+	}{
+		{
+			name:     "missing schema",
+			body:     `{"Operations": [{"op": "replace", "path": "active", "value": false}]}`,
+			scimType: ErrorTypeInvalidSyntax,
+		},
+		{
+			name:     "no operations",
+			body:     `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": []}`,
+			scimType: ErrorTypeInvalidSyntax,
+		},
+		{
+			name:     "unknown operation",
+			body:     `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "move", "path": "active"}]}`,
+			scimType: ErrorTypeInvalidSyntax,
+		},
+		{
+			name:     "replace without value",
+			body:     `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "replace", "path": "active"}]}`,
+			scimType: ErrorTypeInvalidValue,
+		},
+		{
+			name:     "remove without path",
+			body:     `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "remove"}]}`,
+			scimType: ErrorTypeNoTarget,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := parsePatchRequest(t, tc.body).Validate()
+			requireSCIMError(t, err, http.StatusBadRequest, tc.scimType)
+		})
+	}
+}
+
+func TestApplyUserPatch(t *testing.T) {
+	active := true
+	user := User{
+		UserName: "jane@example.com",
+		Name:     &Name{GivenName: "Jane", FamilyName: "Doe"},
+		Active:   &active,
+	}
+
+	// Entra ID sends the active attribute as a string and replaces attributes without a path.
+	req := parsePatchRequest(t, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "Replace", "path": "active", "value": "False"},
+			{"op": "replace", "path": "name.familyName", "value": "Smith"},
+			{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "other@example.com"},
+			{"op": "add", "value": {"externalId": "ext-1", "displayName": "Jane Smith"}}
+		]
+	}`)
+	require.NoError(t, req.Validate())
+	require.NoError(t, ApplyUserPatch(&user, req.Operations))
+
+	require.False(t, *user.Active)
+	require.Equal(t, "Jane", user.Name.GivenName)
+	require.Equal(t, "Smith", user.Name.FamilyName)
+	require.Equal(t, "ext-1", user.ExternalID)
+	require.Equal(t, "Jane Smith", user.DisplayName)
+	require.Equal(t, "jane@example.com", user.UserName)
+}
+
+func TestApplyUserPatchErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		op       PatchOperation
+		scimType string
+	}{
+		{
+			name:     "unknown attribute",
+			op:       PatchOperation{Op: "replace", Path: "title", Value: json.RawMessage(`"CEO"`)},
+			scimType: ErrorTypeInvalidPath,
+		},
+		{
+			name:     "remove userName",
+			op:       PatchOperation{Op: "remove", Path: "userName"},
+			scimType: ErrorTypeMutability,
+		},
+		{
+			name:     "invalid active",
+			op:       PatchOperation{Op: "replace", Path: "active", Value: json.RawMessage(`"maybe"`)},
+			scimType: ErrorTypeInvalidValue,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := User{UserName: "jane@example.com"}
+			err := ApplyUserPatch(&user, []PatchOperation{tc.op})
+			requireSCIMError(t, err, http.StatusBadRequest, tc.scimType)
+		})
+	}
+}
+
+func TestApplyGroupPatch(t *testing.T) {
+	group := Group{
+		DisplayName: "Engineering",
+		Members:     []Member{{Value: "a"}, {Value: "b"}},
+	}
+
+	req := parsePatchRequest(t, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "add", "path": "members", "value": [{"value": "b"}, {"value": "c"}]},
+			{"op": "remove", "path": "members[value eq \"a\"]"},
+			{"op": "replace", "value": {"id": "1", "displayName": "Platform"}}
+		]
+	}`)
+	require.NoError(t, req.Validate())
+	require.NoError(t, ApplyGroupPatch(&group, req.Operations))
+
+	require.Equal(t, "Platform", group.DisplayName)
+	require.Equal(t, []Member{{Value: "b"}, {Value: "c"}}, group.Members)
+
+	err := ApplyGroupPatch(&group, []PatchOperation{
+		{Op: "remove", Path: "members", Value: json.RawMessage(`[{"value": "c"}]`)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []Member{{Value: "b"}}, group.Members)
+
+	require.NoError(t, ApplyGroupPatch(&group, []PatchOperation{{Op: "remove", Path: "members"}}))
+	require.Empty(t, group.Members)
+
+	err = ApplyGroupPatch(&group, []PatchOperation{{Op: "remove", Path: "displayName"}})
+	requireSCIMError(t, err, http.StatusBadRequest, ErrorTypeMutability)
+}
@@ -0,0 +1,220 @@
+package scim
+
+// Attribute describes an attribute of a resource schema, as defined in RFC 7643 section 7.
+type Attribute struct {
+	Name           string      `json:"name"`
+	Type           string      `json:"type"`
+	MultiValued    bool        `json:"multiValued"`
+	Description    string      `json:"description"`
+	Required       bool        `json:"required"`
+	CaseExact      bool        `json:"caseExact"`
+	Mutability     string      `json:"mutability"`
+	Returned       string      `json:"returned"`
+	Uniqueness     string      `json:"uniqueness"`
+	ReferenceTypes []string    `json:"referenceTypes,omitempty"`
+	SubAttributes  []Attribute `json:"subAttributes,omitempty"`
+}
+
+// Schema describes the attributes of a resource type that are supported by the API.
+// The common attributes id, externalId and meta are not part of any schema.
+type Schema struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Attributes  []Attribute `json:"attributes"`
+	Meta        *Meta       `json:"meta,omitempty"`
+}
+
+func stringAttribute(name, description string, required, caseExact bool, uniqueness string) Attribute {
+	return Attribute{
+		Name:        name,
+		Type:        "string",
+		Description: description,
+		Required:    required,
+		CaseExact:   caseExact,
+		Mutability:  "readWrite",
+		Returned:    "default",
+		Uniqueness:  uniqueness,
+	}
+}
+
+// UserSchema is the subset of the core User schema supported by the API.
+var UserSchema = Schema{
+	Schemas:     []string{SchemaSchema},
+	ID:          SchemaUser,
+	Name:        "User",
+	Description: "User Account",
+	Attributes: []Attribute{
+		stringAttribute("userName", "The user's email address, which they log in with.", true, false, "server"),
+		{
+			Name:        "name",
+			Type:        "complex",
+			Description: "The components of the user's name.",
+			Mutability:  "readWrite",
+			Returned:    "default",
+			Uniqueness:  "none",
+			SubAttributes: []Attribute{
+				stringAttribute("formatted", "The full name.", false, false, "none"),
+				stringAttribute("familyName", "The family name of the user.", false, false, "none"),
+				stringAttribute("givenName", "The given name of the user.", false, false, "none"),
+			},
+		},
+		stringAttribute("displayName", "The name of the user, suitable for display to end-users.", false, false, "none"),
+		{
+			Name:        "emails",
+			Type:        "complex",
+			MultiValued: true,
+			Description: "Email addresses for the user. The primary email is always the userName.",
+			Mutability:  "readOnly",
+			Returned:    "default",
+			Uniqueness:  "none",
+			SubAttributes: []Attribute{
+				stringAttribute("value", "Email address for the user.", false, false, "none"),
+				stringAttribute("type", "A label indicating the attribute's function.", false, false, "none"),
+				{
+					Name:        "primary",
+					Type:        "boolean",
+					Description: "Indicates the primary email address.",
+					Mutability:  "readOnly",
+					Returned:    "default",
+					Uniqueness:  "none",
+				},
+			},
+		},
+		{
+			Name:        "active",
+			Type:        "boolean",
+			Description: "The user's administrative status. Inactive users cannot log in.",
+			Mutability:  "readWrite",
+			Returned:    "default",
+			Uniqueness:  "none",
+		},
+	},
+}
+
+// GroupSchema is the subset of the core Group schema supported by the API.
+var GroupSchema = Schema{
+	Schemas:     []string{SchemaSchema},
+	ID:          SchemaGroup,
+	Name:        "Group",
+	Description: "Group, mapped to a custom role",
+	Attributes: []Attribute{
+		stringAttribute("displayName", "The name of the role.", true, false, "server"),
+		{
+			Name:        "members",
+			Type:        "complex",
+			MultiValued: true,
+			Description: "The users assigned the role.",
+			Mutability:  "readWrite",
+			Returned:    "default",
+			Uniqueness:  "none",
+			SubAttributes: []Attribute{
+				{
+					Name:        "value",
+					Type:        "string",
+					Description: "Identifier of the member user.",
+					Mutability:  "immutable",
+					Returned:    "default",
+					Uniqueness:  "none",
+				},
+				{
+					Name:           "$ref",
+					Type:           "reference",
+					ReferenceTypes: []string{"User"},
+					Description:    "The URI of the member user.",
+					Mutability:     "immutable",
+					Returned:       "default",
+					Uniqueness:     "none",
+				},
+				stringAttribute("display", "A human-readable name for the member.", false, false, "none"),
+			},
+		},
+	},
+}
+
+// ResourceType describes a resource endpoint.
+type ResourceType struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Endpoint    string   `json:"endpoint"`
+	Description string   `json:"description"`
+	Schema      string   `json:"schema"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// ResourceTypes returns the resource types supported by the API.
+func ResourceTypes() []ResourceType {
+	return []ResourceType{
+		{
+			Schemas:     []string{SchemaResourceType},
+			ID:          "User",
+			Name:        "User",
+			Endpoint:    "/Users",
+			Description: "User Account",
+			Schema:      SchemaUser,
+		},
+		{
+			Schemas:     []string{SchemaResourceType},
+			ID:          "Group",
+			Name:        "Group",
+			Endpoint:    "/Groups",
+			Description: "Group, mapped to a custom role",
+			Schema:      SchemaGroup,
+		},
+	}
+}
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type filterSupported struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type bulkSupported struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary"`
+}
+
+// ServiceProviderConfig describes the SCIM features supported by the API.
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulkSupported          `json:"bulk"`
+	Filter                filterSupported        `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+}
+
+// MaxResults is the maximum number of resources returned in a single list response.
+const MaxResults = 200
+
+// NewServiceProviderConfig returns the configuration of the API's SCIM features.
+func NewServiceProviderConfig() ServiceProviderConfig {
+	return ServiceProviderConfig{
+		Schemas: []string{SchemaServiceProviderConfig},
+		Patch:   supported{Supported: true},
+		Bulk:    bulkSupported{Supported: false},
+		Filter:  filterSupported{Supported: true, MaxResults: MaxResults},
+		AuthenticationSchemes: []authenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer Token",
+			Description: "Authentication with the company's SCIM token.",
+			Primary:     true,
+		}},
+	}
+}
@@ -0,0 +1,210 @@
+// Package scim implements the resources and protocol messages of SCIM 2.0 (RFC 7643 and RFC 7644),
+// which identity providers use to provision a company's users and groups.
+package scim
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"advancely/internal/model"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Error types returned in the scimType of an Error, defined in RFC 7644 section 3.12.
+const (
+	ErrorTypeInvalidFilter = "invalidFilter"
+	ErrorTypeInvalidSyntax = "invalidSyntax"
+	ErrorTypeInvalidPath   = "invalidPath"
+	ErrorTypeInvalidValue  = "invalidValue"
+	ErrorTypeMutability    = "mutability"
+	ErrorTypeNoTarget      = "noTarget"
+	ErrorTypeUniqueness    = "uniqueness"
+)
+
+// Error is a SCIM error response.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// NewError creates an error response with the HTTP status code. The scimType may be empty.
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// Error returns the detail of the error.
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return http.StatusText(e.StatusCode())
+	}
+	return e.Detail
+}
+
+// StatusCode returns the HTTP status code of the error.
+func (e *Error) StatusCode() int {
+	status, err := strconv.Atoi(e.Status)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// Meta contains the resource metadata common to all resources.
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+// Name contains the components of a user's name.
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+}
+
+// Email is an email address of a user.
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// User is a SCIM User resource. The userName is the user's email address.
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	Name        *Name    `json:"name,omitempty"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	// Active is nil if it was not provided in a request, in which case users are active.
+	Active *bool `json:"active,omitempty"`
+	Meta   *Meta `json:"meta,omitempty"`
+}
+
+// Member is a member of a group.
+type Member struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// Group is a SCIM Group resource, which is mapped to a company's custom role.
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// ListResponse is the response to a query for resources.
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// NewListResponse returns the page of resources starting at the 1-based startIndex.
+// A count less than zero returns all resources from the start index.
+func NewListResponse[T any](resources []T, startIndex, count int) ListResponse {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	page := []any{}
+	for i := startIndex - 1; i < len(resources) && (count < 0 || len(page) < count); i++ {
+		page = append(page, resources[i])
+	}
+	return ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+}
+
+// NewUser converts the profile to a User resource located at the given URL.
+func NewUser(u model.UserProfile, location string) User {
+	active := u.Active()
+	user := User{
+		Schemas:  []string{SchemaUser},
+		ID:       u.ID.String(),
+		UserName: u.Email,
+		Name: &Name{
+			Formatted:  strings.TrimSpace(u.FirstName + " " + u.LastName),
+			FamilyName: u.LastName,
+			GivenName:  u.FirstName,
+		},
+		DisplayName: strings.TrimSpace(u.FirstName + " " + u.LastName),
+		Emails:      []Email{{Value: u.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      &u.CreatedAt,
+			LastModified: lastModified(u.CreatedAt, u.UpdatedAt),
+			Location:     location,
+		},
+	}
+	if u.ExternalID != nil {
+		user.ExternalID = *u.ExternalID
+	}
+	return user
+}
+
+// NewGroup converts the role and the IDs of the users assigned to it to a Group resource located at the given URL.
+// The usersURL is the location of the Users endpoint, used to build references to the members.
+func NewGroup(r model.Role, memberIDs []string, location, usersURL string) Group {
+	group := Group{
+		Schemas:     []string{SchemaGroup},
+		ID:          strconv.Itoa(r.ID),
+		DisplayName: r.Name,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Location:     location,
+		},
+	}
+	for _, id := range memberIDs {
+		group.Members = append(group.Members, Member{
+			Value: id,
+			Ref:   fmt.Sprintf("%s/%s", usersURL, id),
+		})
+	}
+	return group
+}
+
+func lastModified(created time.Time, updated *time.Time) *time.Time {
+	if updated != nil {
+		return updated
+	}
+	return &created
+}
@@ -1,16 +1,25 @@
 package store
 
 import (
+	"advancely/internal/model"
+	"advancely/internal/model/settings"
 	"advancely/pkg/errs"
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
 var (
-	ErrDomainAlreadyExists = errors.New("domain already exists")
+	ErrDomainAlreadyExists            = errors.New("domain already exists")
+	ErrDomainNotFound                 = errors.New("domain not found")
+	ErrDomainVerifiedByAnotherCompany = errors.New("domain has been verified by another company")
+	ErrSAMLConfigNotFound             = errors.New("SAML configuration not found")
+	ErrSettingsVersionConflict        = errors.New("settings have been changed since they were read")
+	ErrSettingsVersionNotFound        = errors.New("settings version not found")
 )
 
 func NewPostgresCompanySettingsStore(db *sqlx.DB) *PostgresCompanySettingsStore {
@@ -23,17 +32,227 @@ type PostgresCompanySettingsStore struct {
 	*sqlx.DB
 }
 
+// allowedEmailDomainColumns are the columns of a model.AllowedEmailDomain.
+const allowedEmailDomainColumns = "id, company_id, domain, verification_token, verified_at, created_at, updated_at"
+
 func (s *PostgresCompanySettingsStore) AddAllowedEmailDomain(
 	ctx context.Context,
 	companyID uuid.UUID,
 	domain string,
-) error {
-	stmt := "insert into allowed_email_domains (company_id, domain) values ($1, $2);"
-	if _, err := s.ExecContext(ctx, stmt, companyID, domain); err != nil {
+) (model.AllowedEmailDomain, error) {
+	stmt := `
+		insert into allowed_email_domains (company_id, domain, verification_token)
+		values ($1, $2, replace(gen_random_uuid()::text, '-', ''))
+		returning ` + allowedEmailDomainColumns + ";"
+
+	var d model.AllowedEmailDomain
+	if err := s.GetContext(ctx, &d, stmt, companyID, domain); err != nil {
+		if pgErr := errs.CheckPgErr(err); errors.Is(pgErr, errs.PgErrCodeUniqueViolation) {
+			return model.AllowedEmailDomain{}, ErrDomainAlreadyExists
+		}
+		return model.AllowedEmailDomain{}, err
+	}
+	return d, nil
+}
+
+func (s *PostgresCompanySettingsStore) AllowedEmailDomains(
+	ctx context.Context,
+	companyID uuid.UUID,
+) ([]model.AllowedEmailDomain, error) {
+	stmt := "select " + allowedEmailDomainColumns + " from allowed_email_domains where company_id = $1 order by domain;"
+
+	domains := []model.AllowedEmailDomain{}
+	if err := s.SelectContext(ctx, &domains, stmt, companyID); err != nil {
+		return nil, fmt.Errorf("failed to get allowed email domains: %w", err)
+	}
+	return domains, nil
+}
+
+func (s *PostgresCompanySettingsStore) AllowedEmailDomain(
+	ctx context.Context,
+	companyID uuid.UUID,
+	domain string,
+) (model.AllowedEmailDomain, error) {
+	stmt := "select " + allowedEmailDomainColumns + " from allowed_email_domains where company_id = $1 and lower(domain) = lower($2);"
+
+	var d model.AllowedEmailDomain
+	if err := s.GetContext(ctx, &d, stmt, companyID, domain); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.AllowedEmailDomain{}, ErrDomainNotFound
+		}
+		return model.AllowedEmailDomain{}, fmt.Errorf("failed to get allowed email domain: %w", err)
+	}
+	return d, nil
+}
+
+func (s *PostgresCompanySettingsStore) VerifyAllowedEmailDomain(
+	ctx context.Context,
+	companyID uuid.UUID,
+	domain string,
+) (model.AllowedEmailDomain, error) {
+	stmt := `
+		update allowed_email_domains
+		set verified_at = coalesce(verified_at, now())
+		where company_id = $1 and lower(domain) = lower($2)
+		returning ` + allowedEmailDomainColumns + ";"
+
+	var d model.AllowedEmailDomain
+	if err := s.GetContext(ctx, &d, stmt, companyID, domain); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.AllowedEmailDomain{}, ErrDomainNotFound
+		}
 		if pgErr := errs.CheckPgErr(err); errors.Is(pgErr, errs.PgErrCodeUniqueViolation) {
-			return ErrDomainAlreadyExists
+			return model.AllowedEmailDomain{}, ErrDomainVerifiedByAnotherCompany
+		}
+		return model.AllowedEmailDomain{}, fmt.Errorf("failed to verify allowed email domain: %w", err)
+	}
+	return d, nil
+}
+
+func (s *PostgresCompanySettingsStore) CompanyIDByVerifiedEmailDomain(
+	ctx context.Context,
+	domain string,
+) (uuid.UUID, error) {
+	stmt := "select company_id from allowed_email_domains where lower(domain) = lower($1) and verified_at is not null;"
+
+	var id uuid.UUID
+	if err := s.GetContext(ctx, &id, stmt, domain); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrDomainNotFound
+		}
+		return uuid.Nil, fmt.Errorf("failed to get company by verified email domain: %w", err)
+	}
+	return id, nil
+}
+
+func (s *PostgresCompanySettingsStore) SAMLConfig(ctx context.Context, companyID uuid.UUID) (model.CompanySAMLConfig, error) {
+	var cfg model.CompanySAMLConfig
+	stmt := "select * from company_saml_configs where company_id = $1;"
+	if err := s.GetContext(ctx, &cfg, stmt, companyID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.CompanySAMLConfig{}, ErrSAMLConfigNotFound
 		}
-		return err
+		return model.CompanySAMLConfig{}, fmt.Errorf("failed to get SAML configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+func (s *PostgresCompanySettingsStore) SaveSAMLConfig(ctx context.Context, cfg *model.CompanySAMLConfig) error {
+	stmt := `
+		insert into company_saml_configs (
+			company_id, idp_entity_id, idp_sso_url, idp_certificate,
+			email_attribute, first_name_attribute, last_name_attribute, enforce_sso)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)
+		on conflict (company_id) do update
+		    set idp_entity_id = excluded.idp_entity_id,
+		        idp_sso_url = excluded.idp_sso_url,
+		        idp_certificate = excluded.idp_certificate,
+		        email_attribute = excluded.email_attribute,
+		        first_name_attribute = excluded.first_name_attribute,
+		        last_name_attribute = excluded.last_name_attribute,
+		        enforce_sso = excluded.enforce_sso
+		returning created_at, updated_at;`
+
+	err := s.QueryRowxContext(ctx, stmt,
+		cfg.CompanyID, cfg.IdPEntityID, cfg.IdPSSOURL, cfg.IdPCertificate,
+		cfg.EmailAttribute, cfg.FirstNameAttribute, cfg.LastNameAttribute, cfg.EnforceSSO,
+	).Scan(&cfg.CreatedAt, &cfg.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save SAML configuration: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresCompanySettingsStore) DeleteSAMLConfig(ctx context.Context, companyID uuid.UUID) error {
+	res, err := s.ExecContext(ctx, "delete from company_saml_configs where company_id = $1;", companyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete SAML configuration: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrSAMLConfigNotFound
 	}
 	return nil
 }
+
+func (s *PostgresCompanySettingsStore) Settings(ctx context.Context, companyID uuid.UUID) (model.CompanySettings, error) {
+	var cs model.CompanySettings
+	if err := s.GetContext(ctx, &cs, "select * from company_settings where company_id = $1;", companyID); err != nil {
+		// Companies without a row use the default of every setting.
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.CompanySettings{CompanyID: companyID, Settings: settings.Values{}}, nil
+		}
+		return model.CompanySettings{}, fmt.Errorf("failed to get company settings: %w", err)
+	}
+	return cs, nil
+}
+
+func (s *PostgresCompanySettingsStore) SaveSettings(
+	ctx context.Context,
+	companyID uuid.UUID,
+	values settings.Values,
+	baseVersion *int,
+	changedBy uuid.UUID,
+) (model.CompanySettings, error) {
+	tx, err := s.BeginTxx(ctx, nil)
+	if err != nil {
+		return model.CompanySettings{}, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+		insert into company_settings (company_id, settings, version)
+		values ($1, $2, 1)
+		on conflict (company_id) do update
+		    set settings = excluded.settings,
+		        version = company_settings.version + 1
+		    where $3::integer is null or company_settings.version = $3
+		returning *;`
+
+	var cs model.CompanySettings
+	if err := tx.GetContext(ctx, &cs, stmt, companyID, values, baseVersion); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.CompanySettings{}, ErrSettingsVersionConflict
+		}
+		return model.CompanySettings{}, fmt.Errorf("failed to save company settings: %w", err)
+	}
+	// A company saving its settings for the first time could not have read another version.
+	if baseVersion != nil && cs.Version != *baseVersion+1 {
+		return model.CompanySettings{}, ErrSettingsVersionConflict
+	}
+
+	stmt = `
+		insert into company_settings_versions (company_id, version, settings, changed_by)
+		values ($1, $2, $3, $4);`
+	if _, err := tx.ExecContext(ctx, stmt, companyID, cs.Version, cs.Settings, changedBy); err != nil {
+		return model.CompanySettings{}, fmt.Errorf("failed to record company settings version: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return model.CompanySettings{}, err
+	}
+	return cs, nil
+}
+
+func (s *PostgresCompanySettingsStore) SettingsVersions(ctx context.Context, companyID uuid.UUID) ([]model.CompanySettingsVersion, error) {
+	var versions []model.CompanySettingsVersion
+	stmt := "select * from company_settings_versions where company_id = $1 order by version desc;"
+	if err := s.SelectContext(ctx, &versions, stmt, companyID); err != nil {
+		return []model.CompanySettingsVersion{}, fmt.Errorf("failed to list company settings versions: %w", err)
+	}
+	return versions, nil
+}
+
+func (s *PostgresCompanySettingsStore) SettingsVersion(
+	ctx context.Context,
+	companyID uuid.UUID,
+	version int,
+) (model.CompanySettingsVersion, error) {
+	var v model.CompanySettingsVersion
+	stmt := "select * from company_settings_versions where company_id = $1 and version = $2;"
+	if err := s.GetContext(ctx, &v, stmt, companyID, version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.CompanySettingsVersion{}, ErrSettingsVersionNotFound
+		}
+		return model.CompanySettingsVersion{}, fmt.Errorf("failed to get company settings version: %w", err)
+	}
+	return v, nil
+}
@@ -2,14 +2,19 @@ package store
 
 import (
 	"advancely/internal/model"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"time"
 )
 
-var ErrCompanyNotFound = errors.New("company not found")
+var (
+	ErrCompanyNotFound   = errors.New("company not found")
+	ErrCompanyNotDeleted = errors.New("company has not been deleted")
+)
 
 func NewPostgresCompanyStore(db *sqlx.DB) *PostgresCompanyStore {
 	return &PostgresCompanyStore{
@@ -21,17 +26,20 @@ type PostgresCompanyStore struct {
 	*sqlx.DB
 }
 
-func (s *PostgresCompanyStore) Company(id uuid.UUID) (model.Company, error) {
+func (s *PostgresCompanyStore) Company(ctx context.Context, id uuid.UUID) (model.Company, error) {
 	var c model.Company
-	if err := s.Get(&c, "SELECT * FROM companies WHERE id = $1;", id); err != nil {
+	if err := s.GetContext(ctx, &c, "SELECT * FROM companies WHERE id = $1;", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Company{}, ErrCompanyNotFound
+		}
 		return model.Company{}, err
 	}
 	return c, nil
 }
 
-func (s *PostgresCompanyStore) CompanyByCreator(creatorID uuid.UUID) (model.Company, error) {
+func (s *PostgresCompanyStore) CompanyByCreator(ctx context.Context, creatorID uuid.UUID) (model.Company, error) {
 	var c model.Company
-	if err := s.Get(&c, "SELECT * FROM companies WHERE creator_id = $1;", creatorID); err != nil {
+	if err := s.GetContext(ctx, &c, "SELECT * FROM companies WHERE creator_id = $1;", creatorID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return model.Company{}, ErrCompanyNotFound
 		}
@@ -40,32 +48,96 @@ func (s *PostgresCompanyStore) CompanyByCreator(creatorID uuid.UUID) (model.Comp
 	return c, nil
 }
 
-func (s *PostgresCompanyStore) Companies() ([]model.Company, error) {
+func (s *PostgresCompanyStore) Companies(ctx context.Context) ([]model.Company, error) {
 	var cc []model.Company
-	if err := s.Get(&cc, "SELECT * FROM companies;"); err != nil {
+	if err := s.SelectContext(ctx, &cc, "SELECT * FROM companies;"); err != nil {
 		return []model.Company{}, err
 	}
 	return cc, nil
 }
 
-func (s *PostgresCompanyStore) CreateCompany(c *model.Company) error {
+func (s *PostgresCompanyStore) CreateCompany(ctx context.Context, c *model.Company) error {
 	query := `insert into companies (name, creator_id) values ($1, $2) returning *;`
-	if err := s.Get(c, query, c.Name, c.CreatorID); err != nil {
+	if err := s.GetContext(ctx, c, query, c.Name, c.CreatorID); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *PostgresCompanyStore) UpdateCompany(c *model.Company) error {
-	if err := s.Get(c, "update companies set name = $1 where id = $2 returning *;", c.Name, c.ID); err != nil {
+func (s *PostgresCompanyStore) UpdateCompany(ctx context.Context, c *model.Company) error {
+	if err := s.GetContext(ctx, c, "update companies set name = $1 where id = $2 returning *;", c.Name, c.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrCompanyNotFound
+		}
 		return fmt.Errorf("error updating company with id %s: %w", c.ID, err)
 	}
 	return nil
 }
 
-func (s *PostgresCompanyStore) DeleteCompany(id uuid.UUID) error {
-	if _, err := s.Exec("DELETE FROM companies WHERE id = $1;", id); err != nil {
+func (s *PostgresCompanyStore) DeleteCompany(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, "DELETE FROM companies WHERE id = $1;", id); err != nil {
 		return fmt.Errorf("error deleting company with id %s: %w", id, err)
 	}
 	return nil
 }
+
+func (s *PostgresCompanyStore) ScheduleCompanyDeletion(ctx context.Context, id uuid.UUID, purgeAfter time.Time) (model.Company, error) {
+	// A company already scheduled for deletion keeps its original purge time.
+	query := `
+		update companies
+		set deleted_at = coalesce(deleted_at, now()), purge_after = coalesce(purge_after, $2)
+		where id = $1
+		returning *;`
+
+	var c model.Company
+	if err := s.GetContext(ctx, &c, query, id, purgeAfter); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Company{}, ErrCompanyNotFound
+		}
+		return model.Company{}, fmt.Errorf("error scheduling deletion of company with id %s: %w", id, err)
+	}
+	return c, nil
+}
+
+func (s *PostgresCompanyStore) RestoreCompany(ctx context.Context, id uuid.UUID) (model.Company, error) {
+	query := `
+		update companies
+		set deleted_at = null, purge_after = null
+		where id = $1 and deleted_at is not null
+		returning *;`
+
+	var c model.Company
+	if err := s.GetContext(ctx, &c, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Company{}, ErrCompanyNotDeleted
+		}
+		return model.Company{}, fmt.Errorf("error restoring company with id %s: %w", id, err)
+	}
+	return c, nil
+}
+
+func (s *PostgresCompanyStore) CompaniesToPurge(ctx context.Context, now time.Time) ([]model.Company, error) {
+	var cc []model.Company
+	query := "select * from companies where purge_after <= $1 order by purge_after;"
+	if err := s.SelectContext(ctx, &cc, query, now); err != nil {
+		return []model.Company{}, fmt.Errorf("error listing companies to purge: %w", err)
+	}
+	return cc, nil
+}
+
+func (s *PostgresCompanyStore) CompanyUserIDs(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	stmt := `
+		select p.id
+		from public.profiles p
+		where p.company_id = $1
+		  and not exists (
+		      select 1 from public.profiles other
+		      where other.id = p.id and other.company_id <> p.company_id
+		  );`
+
+	if err := s.SelectContext(ctx, &ids, stmt, id); err != nil {
+		return []uuid.UUID{}, fmt.Errorf("error listing users of company with id %s: %w", id, err)
+	}
+	return ids, nil
+}
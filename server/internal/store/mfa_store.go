@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"advancely/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrMFAFactorNotFound        = errors.New("mfa factor not found")
+	ErrMFAFactorAlreadyVerified = errors.New("mfa factor already verified")
+	ErrMFACodeAlreadyUsed       = errors.New("mfa code has already been used")
+	ErrRecoveryCodeInvalid      = errors.New("recovery code is invalid or has already been used")
+)
+
+func NewPostgresMFAStore(db *sqlx.DB) *PostgresMFAStore {
+	return &PostgresMFAStore{
+		DB: db,
+	}
+}
+
+type PostgresMFAStore struct {
+	*sqlx.DB
+}
+
+func (s *PostgresMFAStore) MFAFactor(ctx context.Context, userID uuid.UUID) (model.MFAFactor, error) {
+	var f model.MFAFactor
+	if err := s.GetContext(ctx, &f, "select * from security.mfa_factors where user_id = $1;", userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.MFAFactor{}, ErrMFAFactorNotFound
+		}
+		return model.MFAFactor{}, fmt.Errorf("failed to get mfa factor: %w", err)
+	}
+	return f, nil
+}
+
+func (s *PostgresMFAStore) CreateMFAFactor(ctx context.Context, userID uuid.UUID, secret string) (model.MFAFactor, error) {
+	// Replace any existing unverified factor, but never a verified one.
+	stmt := `
+		insert into security.mfa_factors (user_id, secret)
+		values ($1, $2)
+		on conflict (user_id) do update
+		    set secret = excluded.secret, last_used_step = null, created_at = now()
+		    where security.mfa_factors.verified_at is null
+		returning *;`
+
+	var f model.MFAFactor
+	if err := s.GetContext(ctx, &f, stmt, userID, secret); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.MFAFactor{}, ErrMFAFactorAlreadyVerified
+		}
+		return model.MFAFactor{}, fmt.Errorf("failed to create mfa factor: %w", err)
+	}
+	return f, nil
+}
+
+func (s *PostgresMFAStore) VerifyMFAFactor(ctx context.Context, userID uuid.UUID, step int64) error {
+	stmt := `
+		update security.mfa_factors
+		set verified_at = now(), last_used_step = $2
+		where user_id = $1 and verified_at is null;`
+
+	res, err := s.ExecContext(ctx, stmt, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to verify mfa factor: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrMFAFactorNotFound
+	}
+	return nil
+}
+
+func (s *PostgresMFAStore) UseMFAFactorStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	stmt := `
+		update security.mfa_factors
+		set last_used_step = $2
+		where user_id = $1
+		  and verified_at is not null
+		  and (last_used_step is null or last_used_step < $2);`
+
+	res, err := s.ExecContext(ctx, stmt, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to update mfa factor: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrMFACodeAlreadyUsed
+	}
+	return nil
+}
+
+func (s *PostgresMFAStore) DeleteMFAFactor(ctx context.Context, userID uuid.UUID) error {
+	tx, err := s.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "delete from security.mfa_recovery_codes where user_id = $1;", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "delete from security.mfa_factors where user_id = $1;", userID); err != nil {
+		return fmt.Errorf("failed to delete mfa factor: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresMFAStore) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	tx, err := s.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "delete from security.mfa_recovery_codes where user_id = $1;", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	stmt := "insert into security.mfa_recovery_codes (user_id, code_hash) values ($1, $2);"
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, stmt, userID, hash); err != nil {
+			return fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresMFAStore) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error {
+	stmt := `
+		update security.mfa_recovery_codes
+		set used_at = now()
+		where user_id = $1 and code_hash = $2 and used_at is null;`
+
+	res, err := s.ExecContext(ctx, stmt, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
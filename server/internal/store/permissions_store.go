@@ -1,9 +1,11 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"advancely/internal/model"
 	"advancely/internal/model/security"
@@ -15,6 +17,7 @@ import (
 
 var (
 	ErrRoleNotFound           = errors.New("role not found")
+	ErrRoleNotDeleted         = errors.New("role has not been deleted")
 	ErrPermissionNotFount     = errors.New("permission not found")
 	ErrCannotDeleteSystemRole = errors.New("cannot delete system role")
 	ErrCannotUpdateSystemRole = errors.New("cannot update system role")
@@ -41,7 +44,7 @@ type rolePermission struct {
 	PermissionDesc sql.NullString `db:"permission_description"`
 }
 
-func (s *PostgresPermissionsStore) Role(id int, companyID *uuid.UUID) (model.RoleWithPermissions, error) {
+func (s *PostgresPermissionsStore) Role(ctx context.Context, id int, companyID *uuid.UUID) (model.RoleWithPermissions, error) {
 	stmt := `
 		select
 		  r.id, r.company_id, r.name, r.description, r.is_system_role,
@@ -50,10 +53,11 @@ func (s *PostgresPermissionsStore) Role(id int, companyID *uuid.UUID) (model.Rol
 		  left join security.role_permissions rp on r.id = rp.role_id
 		  left join security.permissions p on rp.permission_id = p.id
 		where r.id = $1
-		  and (r.company_id = $2 or r.is_system_role = true);`
+		  and (r.company_id = $2 or r.is_system_role = true)
+		  and r.deleted_at is null;`
 
 	var rpList []rolePermission
-	if err := s.Select(&rpList, stmt, id, companyID); err != nil {
+	if err := s.SelectContext(ctx, &rpList, stmt, id, companyID); err != nil {
 		return model.RoleWithPermissions{}, err
 	}
 
@@ -87,7 +91,7 @@ func (s *PostgresPermissionsStore) Role(id int, companyID *uuid.UUID) (model.Rol
 	return role, nil
 }
 
-func (s *PostgresPermissionsStore) Roles(companyID uuid.UUID) ([]model.RoleWithPermissions, error) {
+func (s *PostgresPermissionsStore) Roles(ctx context.Context, companyID uuid.UUID) ([]model.RoleWithPermissions, error) {
 	stmt := `
 		select
 		  r.id, r.company_id, r.name, r.description, r.is_system_role,
@@ -95,11 +99,12 @@ func (s *PostgresPermissionsStore) Roles(companyID uuid.UUID) ([]model.RoleWithP
 		from security.roles r
 		  left join security.role_permissions rp on r.id = rp.role_id
 		  left join security.permissions p on rp.permission_id = p.id
-		where company_id = $1 or is_system_role = true
+		where (r.company_id = $1 or r.is_system_role = true)
+		  and r.deleted_at is null
 		order by r.id, p.id;`
 
 	var rpList []rolePermission
-	if err := s.Select(&rpList, stmt, companyID); err != nil {
+	if err := s.SelectContext(ctx, &rpList, stmt, companyID); err != nil {
 		return []model.RoleWithPermissions{}, fmt.Errorf("failed to list roles for company ID %v: %w", companyID, err)
 	}
 
@@ -141,7 +146,7 @@ func (s *PostgresPermissionsStore) Roles(companyID uuid.UUID) ([]model.RoleWithP
 	return roles, nil
 }
 
-func (s *PostgresPermissionsStore) UserRoles(userID uuid.UUID) (security.UserRoleCollection, error) {
+func (s *PostgresPermissionsStore) UserRoles(ctx context.Context, userID, companyID uuid.UUID) (security.UserRoleCollection, error) {
 	collection := security.UserRoleCollection{
 		UserID: userID,
 		Roles:  []security.UserRole{},
@@ -156,7 +161,7 @@ func (s *PostgresPermissionsStore) UserRoles(userID uuid.UUID) (security.UserRol
 		join security.roles r on r.id = ur.role_id
 		join security.role_permissions rp on rp.role_id = r.id
 		join security.permissions p on p.id = rp.permission_id
-		where u.id = $1;`
+		where u.id = $1 and ur.company_id = $2 and r.deleted_at is null;`
 
 	var results []struct {
 		RoleID         int    `db:"role_id"`
@@ -164,7 +169,7 @@ func (s *PostgresPermissionsStore) UserRoles(userID uuid.UUID) (security.UserRol
 		PermissionID   int    `db:"permission_id"`
 		PermissionName string `db:"permission_name"`
 	}
-	if err := s.Select(&results, stmt, userID); err != nil {
+	if err := s.SelectContext(ctx, &results, stmt, userID, companyID); err != nil {
 		return collection, err
 	}
 
@@ -189,22 +194,22 @@ func (s *PostgresPermissionsStore) UserRoles(userID uuid.UUID) (security.UserRol
 	return collection, nil
 }
 
-func (s *PostgresPermissionsStore) CreateRole(r model.CreateRole) (model.Role, error) {
+func (s *PostgresPermissionsStore) CreateRole(ctx context.Context, r model.CreateRole) (model.Role, error) {
 	stmt := `
 		insert into security.roles (company_id, name, description)
 		values ($1, $2, $3)
 		returning id, company_id, name, description, is_system_role;`
 
 	var createdRole model.Role
-	if err := s.Get(&createdRole, stmt, r.CompanyID, r.Name, r.Description); err != nil {
+	if err := s.GetContext(ctx, &createdRole, stmt, r.CompanyID, r.Name, r.Description); err != nil {
 		return model.Role{}, fmt.Errorf("failed to create role: %w", err)
 	}
 
 	return createdRole, nil
 }
 
-func (s *PostgresPermissionsStore) UpdateRole(r *model.Role) error {
-	role, err := s.Role(r.ID, r.CompanyID)
+func (s *PostgresPermissionsStore) UpdateRole(ctx context.Context, r *model.Role) error {
+	role, err := s.Role(ctx, r.ID, r.CompanyID)
 	if err != nil {
 		return fmt.Errorf("failed to find role with ID %d: %w", r.ID, err)
 	}
@@ -218,16 +223,17 @@ func (s *PostgresPermissionsStore) UpdateRole(r *model.Role) error {
 		where id = $3
 		  and company_id = $4
 		  and is_system_role = false -- prevent updating of system roles
+		  and deleted_at is null
 		returning id, company_id, name, description, is_system_role;`
 
-	if err := s.Get(r, stmt, r.Name, r.Description, r.ID, r.CompanyID); err != nil {
+	if err := s.GetContext(ctx, r, stmt, r.Name, r.Description, r.ID, r.CompanyID); err != nil {
 		return fmt.Errorf("failed to update role: %w", err)
 	}
 	return nil
 }
 
-func (s *PostgresPermissionsStore) DeleteRole(id int, companyID uuid.UUID) error {
-	role, err := s.Role(id, &companyID)
+func (s *PostgresPermissionsStore) DeleteRole(ctx context.Context, id int, companyID uuid.UUID) error {
+	role, err := s.Role(ctx, id, &companyID)
 	if err != nil {
 		return fmt.Errorf("failed to find role with ID %d: %w", id, err)
 	}
@@ -235,14 +241,42 @@ func (s *PostgresPermissionsStore) DeleteRole(id int, companyID uuid.UUID) error
 		return ErrCannotDeleteSystemRole
 	}
 
-	stmt := "delete from security.roles where id = $1 and company_id = $2;"
-	if _, err := s.Exec(stmt, id, companyID); err != nil {
-		return err
+	stmt := "update security.roles set deleted_at = now() where id = $1 and company_id = $2 and deleted_at is null;"
+	if _, err := s.ExecContext(ctx, stmt, id, companyID); err != nil {
+		return fmt.Errorf("failed to delete role: %w", err)
 	}
 	return nil
 }
 
-func (s *PostgresPermissionsStore) Permission(id int) (model.Permission, error) {
+func (s *PostgresPermissionsStore) RestoreRole(ctx context.Context, id int, companyID uuid.UUID) (model.Role, error) {
+	stmt := `
+		update security.roles
+		set deleted_at = null
+		where id = $1 and company_id = $2 and deleted_at is not null
+		returning id, company_id, name, description, is_system_role;`
+
+	var role model.Role
+	if err := s.GetContext(ctx, &role, stmt, id, companyID); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return model.Role{}, fmt.Errorf("failed to restore role: %w", err)
+		}
+		if _, err := s.Role(ctx, id, &companyID); err != nil {
+			return model.Role{}, err
+		}
+		return model.Role{}, ErrRoleNotDeleted
+	}
+	return role, nil
+}
+
+func (s *PostgresPermissionsStore) PurgeRoles(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	res, err := s.ExecContext(ctx, "delete from security.roles where deleted_at <= $1;", deletedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge roles: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (s *PostgresPermissionsStore) Permission(ctx context.Context, id int) (model.Permission, error) {
 	stmt := `
 		select p.id, p.name, p.description,
 		       g.id as group_id,
@@ -262,7 +296,7 @@ func (s *PostgresPermissionsStore) Permission(id int) (model.Permission, error)
 		GroupDesc   string `db:"group_description"`
 	}
 
-	if err := s.Get(&result, stmt, id); err != nil {
+	if err := s.GetContext(ctx, &result, stmt, id); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return model.Permission{}, ErrPermissionNotFount
 		}
@@ -282,8 +316,8 @@ func (s *PostgresPermissionsStore) Permission(id int) (model.Permission, error)
 	return permission, nil
 }
 
-func (s *PostgresPermissionsStore) AssignPermissionToRole(roleID, permissionID int, companyID uuid.UUID) error {
-	role, err := s.Role(roleID, &companyID)
+func (s *PostgresPermissionsStore) AssignPermissionToRole(ctx context.Context, roleID, permissionID int, companyID uuid.UUID) error {
+	role, err := s.Role(ctx, roleID, &companyID)
 	if err != nil {
 		return err
 	}
@@ -291,12 +325,12 @@ func (s *PostgresPermissionsStore) AssignPermissionToRole(roleID, permissionID i
 		return ErrCannotUpdateSystemRole
 	}
 
-	if _, err = s.Permission(permissionID); err != nil {
+	if _, err = s.Permission(ctx, permissionID); err != nil {
 		return err
 	}
 
 	stmt := "insert into security.role_permissions (role_id, permission_id) values ($1, $2)"
-	if _, err := s.Exec(stmt, roleID, permissionID); err != nil {
+	if _, err := s.ExecContext(ctx, stmt, roleID, permissionID); err != nil {
 		// Check for unique_violation error, the relationship already exists.
 		if pge := errs.CheckPgErr(err); errors.Is(pge, errs.PgErrCodeUniqueViolation) {
 			return nil
@@ -307,8 +341,8 @@ func (s *PostgresPermissionsStore) AssignPermissionToRole(roleID, permissionID i
 	return nil
 }
 
-func (s *PostgresPermissionsStore) RemovePermissionFromRole(roleID, permissionID int, companyID uuid.UUID) error {
-	role, err := s.Role(roleID, &companyID)
+func (s *PostgresPermissionsStore) RemovePermissionFromRole(ctx context.Context, roleID, permissionID int, companyID uuid.UUID) error {
+	role, err := s.Role(ctx, roleID, &companyID)
 	if err != nil {
 		return err
 	}
@@ -317,42 +351,62 @@ func (s *PostgresPermissionsStore) RemovePermissionFromRole(roleID, permissionID
 	}
 
 	stmt := "delete from security.role_permissions where role_id = $1 and permission_id = $2"
-	if _, err := s.Exec(stmt, roleID, permissionID); err != nil {
+	if _, err := s.ExecContext(ctx, stmt, roleID, permissionID); err != nil {
 		return fmt.Errorf("failed to delete role permission: %w", err)
 	}
 	return nil
 }
 
-func (s *PostgresPermissionsStore) AssignRoleToUser(roleID int, userID, companyID uuid.UUID) error {
-	_, err := s.Role(roleID, &companyID)
+func (s *PostgresPermissionsStore) AssignRoleToUser(ctx context.Context, roleID int, userID, companyID uuid.UUID) error {
+	_, err := s.Role(ctx, roleID, &companyID)
 	if err != nil {
 		return err
 	}
 
-	stmt := "insert into security.user_roles (user_id, role_id) values ($1, $2);"
-	if _, err := s.Exec(stmt, userID, roleID); err != nil {
+	stmt := "insert into security.user_roles (user_id, company_id, role_id) values ($1, $2, $3);"
+	if _, err := s.ExecContext(ctx, stmt, userID, companyID, roleID); err != nil {
+		pgErr := errs.CheckPgErr(err)
 		// Check for postgres unique_violation, relationship already exists
-		if pgErr := errs.CheckPgErr(err); errors.Is(pgErr, errs.PgErrCodeUniqueViolation) {
+		if errors.Is(pgErr, errs.PgErrCodeUniqueViolation) {
 			return nil
 		}
+		// The user has no profile in the company
+		if errors.Is(pgErr, errs.PgErrCodeForeignKeyViolation) {
+			return ErrUserNotFound
+		}
 		return fmt.Errorf("failed to insert user role: %w", err)
 	}
 	return nil
 }
 
-func (s *PostgresPermissionsStore) AssignSystemRoleToUser(role security.Role, userID, companyID uuid.UUID) error {
+func (s *PostgresPermissionsStore) AssignSystemRoleToUser(ctx context.Context, role security.Role, userID, companyID uuid.UUID) error {
 	var roleId int
 	stmt := "select id from security.roles where name = $1 and is_system_role = true;"
-	if err := s.Get(&roleId, stmt, role); err != nil {
+	if err := s.GetContext(ctx, &roleId, stmt, role); err != nil {
 		return err
 	}
-	return s.AssignRoleToUser(roleId, userID, companyID)
+	return s.AssignRoleToUser(ctx, roleId, userID, companyID)
 }
 
-func (s *PostgresPermissionsStore) RemoveRoleFromUser(roleID int, userID, companyID uuid.UUID) error {
-	stmt := "delete from security.user_roles where user_id = $1 and role_id = $2;"
-	if _, err := s.Exec(stmt, userID, roleID); err != nil {
+func (s *PostgresPermissionsStore) RemoveRoleFromUser(ctx context.Context, roleID int, userID, companyID uuid.UUID) error {
+	stmt := "delete from security.user_roles where user_id = $1 and company_id = $2 and role_id = $3;"
+	if _, err := s.ExecContext(ctx, stmt, userID, companyID, roleID); err != nil {
 		return fmt.Errorf("failed to delete user role: %w", err)
 	}
 	return nil
 }
+
+func (s *PostgresPermissionsStore) RoleUserIDs(ctx context.Context, roleID int, companyID uuid.UUID) ([]uuid.UUID, error) {
+	stmt := `
+		select ur.user_id
+		from security.user_roles ur
+		join public.profiles p on p.id = ur.user_id and p.company_id = ur.company_id
+		where ur.role_id = $1 and ur.company_id = $2 and p.deleted_at is null
+		order by ur.created_at;`
+
+	userIDs := []uuid.UUID{}
+	if err := s.SelectContext(ctx, &userIDs, stmt, roleID, companyID); err != nil {
+		return []uuid.UUID{}, fmt.Errorf("failed to list users with role %d: %w", roleID, err)
+	}
+	return userIDs, nil
+}
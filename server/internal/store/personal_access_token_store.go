@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"advancely/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+
+func NewPostgresPersonalAccessTokenStore(db *sqlx.DB) *PostgresPersonalAccessTokenStore {
+	return &PostgresPersonalAccessTokenStore{
+		DB: db,
+	}
+}
+
+type PostgresPersonalAccessTokenStore struct {
+	*sqlx.DB
+}
+
+func (s *PostgresPersonalAccessTokenStore) CreatePersonalAccessToken(
+	ctx context.Context,
+	t model.CreatePersonalAccessToken,
+) (model.PersonalAccessToken, error) {
+	stmt := `
+		insert into security.personal_access_tokens
+		    (user_id, company_id, name, token_hash, token_prefix, permissions, expires_at)
+		values ($1, $2, $3, $4, $5, $6, $7)
+		returning *;`
+
+	var permissions pq.StringArray
+	if t.Permissions != nil {
+		permissions = t.Permissions
+	}
+
+	var token model.PersonalAccessToken
+	err := s.GetContext(ctx, &token, stmt,
+		t.UserID, t.CompanyID, t.Name, t.TokenHash, t.TokenPrefix, permissions, t.ExpiresAt)
+	if err != nil {
+		return model.PersonalAccessToken{}, fmt.Errorf("failed to create personal access token: %w", err)
+	}
+	return token, nil
+}
+
+func (s *PostgresPersonalAccessTokenStore) PersonalAccessTokens(
+	ctx context.Context,
+	userID uuid.UUID,
+) ([]model.PersonalAccessToken, error) {
+	stmt := `
+		select * from security.personal_access_tokens
+		where user_id = $1 and revoked_at is null
+		order by created_at desc;`
+
+	tokens := []model.PersonalAccessToken{}
+	if err := s.SelectContext(ctx, &tokens, stmt, userID); err != nil {
+		return []model.PersonalAccessToken{}, fmt.Errorf("failed to list personal access tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func (s *PostgresPersonalAccessTokenStore) PersonalAccessTokenByHash(
+	ctx context.Context,
+	tokenHash string,
+) (model.PersonalAccessToken, error) {
+	stmt := "select * from security.personal_access_tokens where token_hash = $1;"
+
+	var token model.PersonalAccessToken
+	if err := s.GetContext(ctx, &token, stmt, tokenHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.PersonalAccessToken{}, ErrPersonalAccessTokenNotFound
+		}
+		return model.PersonalAccessToken{}, fmt.Errorf("failed to get personal access token: %w", err)
+	}
+	return token, nil
+}
+
+func (s *PostgresPersonalAccessTokenStore) RevokePersonalAccessToken(ctx context.Context, id, userID uuid.UUID) error {
+	stmt := `
+		update security.personal_access_tokens
+		set revoked_at = now()
+		where id = $1 and user_id = $2 and revoked_at is null;`
+
+	res, err := s.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke personal access token: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+	return nil
+}
+
+func (s *PostgresPersonalAccessTokenStore) TouchPersonalAccessToken(ctx context.Context, id uuid.UUID) error {
+	stmt := "update security.personal_access_tokens set last_used_at = now() where id = $1;"
+	if _, err := s.ExecContext(ctx, stmt, id); err != nil {
+		return fmt.Errorf("failed to update personal access token last used time: %w", err)
+	}
+	return nil
+}
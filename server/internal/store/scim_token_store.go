@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"advancely/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrSCIMTokenNotFound = errors.New("SCIM token not found")
+
+func NewPostgresSCIMTokenStore(db *sqlx.DB) *PostgresSCIMTokenStore {
+	return &PostgresSCIMTokenStore{
+		DB: db,
+	}
+}
+
+type PostgresSCIMTokenStore struct {
+	*sqlx.DB
+}
+
+func (s *PostgresSCIMTokenStore) SCIMToken(ctx context.Context, companyID uuid.UUID) (model.SCIMToken, error) {
+	var token model.SCIMToken
+	if err := s.GetContext(ctx, &token, "select * from security.scim_tokens where company_id = $1;", companyID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.SCIMToken{}, ErrSCIMTokenNotFound
+		}
+		return model.SCIMToken{}, fmt.Errorf("failed to get SCIM token: %w", err)
+	}
+	return token, nil
+}
+
+func (s *PostgresSCIMTokenStore) SCIMTokenByHash(ctx context.Context, tokenHash string) (model.SCIMToken, error) {
+	var token model.SCIMToken
+	if err := s.GetContext(ctx, &token, "select * from security.scim_tokens where token_hash = $1;", tokenHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.SCIMToken{}, ErrSCIMTokenNotFound
+		}
+		return model.SCIMToken{}, fmt.Errorf("failed to get SCIM token: %w", err)
+	}
+	return token, nil
+}
+
+func (s *PostgresSCIMTokenStore) SaveSCIMToken(ctx context.Context, token *model.SCIMToken) error {
+	stmt := `
+		insert into security.scim_tokens (company_id, token_hash, token_prefix)
+		values ($1, $2, $3)
+		on conflict (company_id) do update
+		set token_hash = excluded.token_hash,
+		    token_prefix = excluded.token_prefix,
+		    last_used_at = null,
+		    created_at = now()
+		returning *;`
+
+	if err := s.GetContext(ctx, token, stmt, token.CompanyID, token.TokenHash, token.TokenPrefix); err != nil {
+		return fmt.Errorf("failed to save SCIM token: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresSCIMTokenStore) DeleteSCIMToken(ctx context.Context, companyID uuid.UUID) error {
+	res, err := s.ExecContext(ctx, "delete from security.scim_tokens where company_id = $1;", companyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete SCIM token: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrSCIMTokenNotFound
+	}
+	return nil
+}
+
+func (s *PostgresSCIMTokenStore) TouchSCIMToken(ctx context.Context, companyID uuid.UUID) error {
+	stmt := "update security.scim_tokens set last_used_at = now() where company_id = $1;"
+	if _, err := s.ExecContext(ctx, stmt, companyID); err != nil {
+		return fmt.Errorf("failed to touch SCIM token: %w", err)
+	}
+	return nil
+}
@@ -3,9 +3,11 @@ package store
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"advancely/internal/model"
 	"advancely/internal/model/security"
+	"advancely/internal/model/settings"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
@@ -13,7 +15,7 @@ import (
 )
 
 func NewPostgresStore(connectionString string) (*PostgresStore, error) {
-	db, err := sqlx.Open("postgres", connectionString)
+	db, err := openTracedDB(connectionString)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -21,18 +23,33 @@ func NewPostgresStore(connectionString string) (*PostgresStore, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 	return &PostgresStore{
-		UserStore:            NewPostgresUserStore(db),
-		CompanyStore:         NewPostgresCompanyStore(db),
-		CompanySettingsStore: NewPostgresCompanySettingsStore(db),
-		PermissionsStore:     NewPostgresPermissionsStore(db),
+		DB:                       db,
+		UserStore:                NewPostgresUserStore(db),
+		CompanyStore:             NewPostgresCompanyStore(db),
+		CompanySettingsStore:     NewPostgresCompanySettingsStore(db),
+		PermissionsStore:         NewPostgresPermissionsStore(db),
+		PersonalAccessTokenStore: NewPostgresPersonalAccessTokenStore(db),
+		MFAStore:                 NewPostgresMFAStore(db),
+		SCIMTokenStore:           NewPostgresSCIMTokenStore(db),
 	}, nil
 }
 
 type PostgresStore struct {
+	// DB is the connection pool shared by the stores.
+	DB *sqlx.DB
+
 	UserStore
 	CompanyStore
 	CompanySettingsStore
 	PermissionsStore
+	PersonalAccessTokenStore
+	MFAStore
+	SCIMTokenStore
+}
+
+// Close closes the connection pool, waiting for running queries to finish.
+func (s *PostgresStore) Close() error {
+	return s.DB.Close()
 }
 
 type Store interface {
@@ -40,43 +57,142 @@ type Store interface {
 	CompanyStore
 	CompanySettingsStore
 	PermissionsStore
+	PersonalAccessTokenStore
+	MFAStore
+	SCIMTokenStore
 }
 
 type UserStore interface {
-	// User returns the user associated with the given id.
-	User(id uuid.UUID) (model.UserProfile, error)
+	// User returns the profile of the user in the company. ErrUserNotFound is returned if the user
+	// is not a member of the company.
+	User(ctx context.Context, id, companyID uuid.UUID) (model.UserProfile, error)
+	// DefaultProfile returns the profile of the company the user logs in to: their oldest profile
+	// that has not been deactivated, preferring companies that have not been deleted,
+	// or their oldest profile if all of them have been deactivated.
+	DefaultProfile(ctx context.Context, id uuid.UUID) (model.UserProfile, error)
+	// Memberships returns the companies the user is a member of, oldest first.
+	Memberships(ctx context.Context, id uuid.UUID) ([]model.Membership, error)
 	// BaseUserByEmail returns the auth.users user associated with the given email.
-	BaseUserByEmail(email string) (model.User, error)
-	Exists(email string) (bool, error)
+	BaseUserByEmail(ctx context.Context, email string) (model.User, error)
+	Exists(ctx context.Context, email string) (bool, error)
 	// Users returns a slice of all users.
-	Users(companyID uuid.UUID) ([]model.UserProfile, error)
-	// CreateProfile creates a record in the profiles table.
-	CreateProfile(req CreateProfileRequest) (model.UserProfile, error)
-	UpdateUser(user *model.UserProfile) error
-	DeleteUser(id uuid.UUID) error
+	Users(ctx context.Context, companyID uuid.UUID) ([]model.UserProfile, error)
+	// CreateProfile creates a record in the profiles table and assigns the requested roles to the user.
+	// ErrProfileExists is returned if the user already has a profile in the company, which may have been deleted.
+	CreateProfile(ctx context.Context, req CreateProfileRequest) (model.UserProfile, error)
+	UpdateUser(ctx context.Context, user *model.UserProfile) error
+	// SetUserActive deactivates or reactivates the user in the company. Deactivated users keep their profile
+	// and roles but cannot log in to the company.
+	SetUserActive(ctx context.Context, id, companyID uuid.UUID, active bool) error
+	// DeleteProfile removes the user from the company. The profile and its roles are kept, excluded from
+	// every other query, until the user is restored or the profile is purged.
+	DeleteProfile(ctx context.Context, id, companyID uuid.UUID) error
+	// RestoreProfile restores the deleted profile of the user along with the roles they had.
+	// ErrUserNotDeleted is returned if the profile has not been deleted.
+	RestoreProfile(ctx context.Context, id, companyID uuid.UUID) (model.UserProfile, error)
+	// ProfilesToPurge returns the profiles deleted before the given time.
+	ProfilesToPurge(ctx context.Context, deletedBefore time.Time) ([]ProfileToPurge, error)
+	// PurgeProfile permanently deletes the deleted profile along with its roles.
+	PurgeProfile(ctx context.Context, id, companyID uuid.UUID) error
 }
 
 type CompanyStore interface {
 	// Company returns the company associated with the given id.
-	Company(id uuid.UUID) (model.Company, error)
+	Company(ctx context.Context, id uuid.UUID) (model.Company, error)
 	// CompanyByCreator returns the company created by the given creator user ID.
-	CompanyByCreator(creatorID uuid.UUID) (model.Company, error)
+	CompanyByCreator(ctx context.Context, creatorID uuid.UUID) (model.Company, error)
 	// Companies returns a slice of all companies.
-	Companies() ([]model.Company, error)
-	CreateCompany(c *model.Company) error
-	UpdateCompany(c *model.Company) error
-	DeleteCompany(id uuid.UUID) error
+	Companies(ctx context.Context) ([]model.Company, error)
+	CreateCompany(ctx context.Context, c *model.Company) error
+	UpdateCompany(ctx context.Context, c *model.Company) error
+	// DeleteCompany permanently deletes the company along with its profiles and settings.
+	DeleteCompany(ctx context.Context, id uuid.UUID) error
+	// ScheduleCompanyDeletion marks the company as deleted, to be purged after the given time.
+	ScheduleCompanyDeletion(ctx context.Context, id uuid.UUID, purgeAfter time.Time) (model.Company, error)
+	// RestoreCompany cancels the deletion of the company.
+	// ErrCompanyNotDeleted is returned if the company is not waiting to be purged.
+	RestoreCompany(ctx context.Context, id uuid.UUID) (model.Company, error)
+	// CompaniesToPurge returns the deleted companies whose grace period has ended by now.
+	CompaniesToPurge(ctx context.Context, now time.Time) ([]model.Company, error)
+	// CompanyUserIDs returns the IDs of the users with a profile in the company and in no other company,
+	// whose accounts are deleted along with the company.
+	CompanyUserIDs(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error)
 }
 
 type CompanySettingsStore interface {
-	// AddAllowedEmailDomain adds a new domain that can be used to auth for a company.
-	// Any other domains will be prevented from signing up with that company.
-	AddAllowedEmailDomain(ctx context.Context, companyID uuid.UUID, domain string) error
+	// AddAllowedEmailDomain adds a new domain that can be used to auth for a company, along with the token
+	// the company must publish to verify it. Any other domains will be prevented from signing up with that company.
+	AddAllowedEmailDomain(ctx context.Context, companyID uuid.UUID, domain string) (model.AllowedEmailDomain, error)
+	// AllowedEmailDomains returns the allowed email domains of the company.
+	AllowedEmailDomains(ctx context.Context, companyID uuid.UUID) ([]model.AllowedEmailDomain, error)
+	// AllowedEmailDomain returns the allowed email domain of the company. ErrDomainNotFound is returned if
+	// the company does not allow the domain.
+	AllowedEmailDomain(ctx context.Context, companyID uuid.UUID, domain string) (model.AllowedEmailDomain, error)
+	// VerifyAllowedEmailDomain marks the allowed email domain as verified, once the company has proven it owns it.
+	// ErrDomainVerifiedByAnotherCompany is returned if another company has already verified the domain.
+	VerifyAllowedEmailDomain(ctx context.Context, companyID uuid.UUID, domain string) (model.AllowedEmailDomain, error)
+	// CompanyIDByVerifiedEmailDomain returns the ID of the company that has verified the email domain.
+	// ErrDomainNotFound is returned if no company has verified it.
+	CompanyIDByVerifiedEmailDomain(ctx context.Context, domain string) (uuid.UUID, error)
+	// SAMLConfig returns the SAML identity provider configuration of the company.
+	// ErrSAMLConfigNotFound is returned if the company has not configured SAML.
+	SAMLConfig(ctx context.Context, companyID uuid.UUID) (model.CompanySAMLConfig, error)
+	// SaveSAMLConfig creates or replaces the SAML configuration of the company.
+	SaveSAMLConfig(ctx context.Context, cfg *model.CompanySAMLConfig) error
+	DeleteSAMLConfig(ctx context.Context, companyID uuid.UUID) error
+	// Settings returns the settings saved by the company, which are empty if it has never saved them.
+	Settings(ctx context.Context, companyID uuid.UUID) (model.CompanySettings, error)
+	// SaveSettings replaces the company's settings and records them as a new version.
+	// If baseVersion is not nil, ErrSettingsVersionConflict is returned if it is not the current version.
+	SaveSettings(
+		ctx context.Context,
+		companyID uuid.UUID,
+		values settings.Values,
+		baseVersion *int,
+		changedBy uuid.UUID,
+	) (model.CompanySettings, error)
+	// SettingsVersions returns every version of the company's settings, newest first.
+	SettingsVersions(ctx context.Context, companyID uuid.UUID) ([]model.CompanySettingsVersion, error)
+	// SettingsVersion returns the version of the company's settings.
+	// ErrSettingsVersionNotFound is returned if the company has no such version.
+	SettingsVersion(ctx context.Context, companyID uuid.UUID, version int) (model.CompanySettingsVersion, error)
+}
+
+type PersonalAccessTokenStore interface {
+	CreatePersonalAccessToken(ctx context.Context, t model.CreatePersonalAccessToken) (model.PersonalAccessToken, error)
+	// PersonalAccessTokens returns the tokens of the given user that have not been revoked.
+	PersonalAccessTokens(ctx context.Context, userID uuid.UUID) ([]model.PersonalAccessToken, error)
+	// PersonalAccessTokenByHash returns the token with the given hash, including revoked and expired tokens.
+	PersonalAccessTokenByHash(ctx context.Context, tokenHash string) (model.PersonalAccessToken, error)
+	// RevokePersonalAccessToken revokes the token if it belongs to the given user.
+	RevokePersonalAccessToken(ctx context.Context, id, userID uuid.UUID) error
+	// TouchPersonalAccessToken records that the token has just been used.
+	TouchPersonalAccessToken(ctx context.Context, id uuid.UUID) error
+}
+
+type MFAStore interface {
+	// MFAFactor returns the TOTP factor of the given user, which may not yet be verified.
+	MFAFactor(ctx context.Context, userID uuid.UUID) (model.MFAFactor, error)
+	// CreateMFAFactor creates an unverified factor, replacing any existing unverified factor.
+	// ErrMFAFactorAlreadyVerified is returned if the user already has a verified factor.
+	CreateMFAFactor(ctx context.Context, userID uuid.UUID, secret string) (model.MFAFactor, error)
+	// VerifyMFAFactor marks the user's factor as verified, recording the time step of the code used.
+	VerifyMFAFactor(ctx context.Context, userID uuid.UUID, step int64) error
+	// UseMFAFactorStep records the time step of a code used to log in.
+	// ErrMFACodeAlreadyUsed is returned if the step is not after the last step used.
+	UseMFAFactorStep(ctx context.Context, userID uuid.UUID, step int64) error
+	// DeleteMFAFactor removes the user's factor and recovery codes.
+	DeleteMFAFactor(ctx context.Context, userID uuid.UUID) error
+	// ReplaceRecoveryCodes replaces all the user's recovery codes with the given hashes.
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+	// UseRecoveryCode marks the recovery code as used.
+	// ErrRecoveryCodeInvalid is returned if the code does not exist or has already been used.
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error
 }
 
 type RoleFetcher interface {
-	// UserRoles gets the roles and permissions associated with the given user.
-	UserRoles(userID uuid.UUID) (security.UserRoleCollection, error)
+	// UserRoles gets the roles and permissions of the given user in the company.
+	UserRoles(ctx context.Context, userID, companyID uuid.UUID) (security.UserRoleCollection, error)
 }
 
 type PermissionsStore interface {
@@ -84,24 +200,46 @@ type PermissionsStore interface {
 
 	// Role returns the role associated with the given ID
 	// Passing nil for the companyID will allow searching for matching system roles
-	Role(id int, companyID *uuid.UUID) (model.RoleWithPermissions, error)
+	Role(ctx context.Context, id int, companyID *uuid.UUID) (model.RoleWithPermissions, error)
 	// Roles returns all roles (including system) for the given companyID
-	Roles(companyID uuid.UUID) ([]model.RoleWithPermissions, error)
-	CreateRole(r model.CreateRole) (model.Role, error)
-	UpdateRole(r *model.Role) error
-	DeleteRole(id int, companyID uuid.UUID) error
+	Roles(ctx context.Context, companyID uuid.UUID) ([]model.RoleWithPermissions, error)
+	CreateRole(ctx context.Context, r model.CreateRole) (model.Role, error)
+	UpdateRole(ctx context.Context, r *model.Role) error
+	// DeleteRole deletes the role of the company. The role is kept, along with its assignments to users,
+	// until it is restored or purged.
+	DeleteRole(ctx context.Context, id int, companyID uuid.UUID) error
+	// RestoreRole restores the deleted role, which is assigned again to the users it was assigned to.
+	// ErrRoleNotDeleted is returned if the role has not been deleted.
+	RestoreRole(ctx context.Context, id int, companyID uuid.UUID) (model.Role, error)
+	// PurgeRoles permanently deletes the roles deleted before the given time, returning how many were purged.
+	PurgeRoles(ctx context.Context, deletedBefore time.Time) (int64, error)
 	// AssignPermissionToRole associates a given permission with the given role.
 	// Users cannot associate any permissions with system roles.
-	AssignPermissionToRole(roleID, permissionID int, companyID uuid.UUID) error
+	AssignPermissionToRole(ctx context.Context, roleID, permissionID int, companyID uuid.UUID) error
 	// RemovePermissionFromRole removes the role - permission association.
 	// Users cannot remove a permission from a system role.
-	RemovePermissionFromRole(roleID, permissionID int, companyID uuid.UUID) error
-	// AssignRoleToUser assigns a role to a given user.
+	RemovePermissionFromRole(ctx context.Context, roleID, permissionID int, companyID uuid.UUID) error
+	// AssignRoleToUser assigns a role to a given user in the company.
 	// A success is returned if the role already exists for the user.
-	AssignRoleToUser(roleID int, userID, companyID uuid.UUID) error
+	// ErrUserNotFound is returned if the user is not a member of the company.
+	AssignRoleToUser(ctx context.Context, roleID int, userID, companyID uuid.UUID) error
 	// AssignSystemRoleToUser assigns the specified system role to a given user.
 	// A success is returned if the role already exists for the user.
-	AssignSystemRoleToUser(role security.Role, userID, companyID uuid.UUID) error
-	// RemoveRoleFromUser disassociates the given role from the user.
-	RemoveRoleFromUser(roleID int, userID, companyID uuid.UUID) error
+	AssignSystemRoleToUser(ctx context.Context, role security.Role, userID, companyID uuid.UUID) error
+	// RemoveRoleFromUser disassociates the given role from the user in the company.
+	RemoveRoleFromUser(ctx context.Context, roleID int, userID, companyID uuid.UUID) error
+	// RoleUserIDs returns the IDs of the company's users that have been assigned the role.
+	RoleUserIDs(ctx context.Context, roleID int, companyID uuid.UUID) ([]uuid.UUID, error)
+}
+
+type SCIMTokenStore interface {
+	// SCIMToken returns the SCIM token of the company.
+	SCIMToken(ctx context.Context, companyID uuid.UUID) (model.SCIMToken, error)
+	// SCIMTokenByHash returns the SCIM token with the given hash.
+	SCIMTokenByHash(ctx context.Context, tokenHash string) (model.SCIMToken, error)
+	// SaveSCIMToken creates the company's SCIM token, replacing any existing token.
+	SaveSCIMToken(ctx context.Context, token *model.SCIMToken) error
+	DeleteSCIMToken(ctx context.Context, companyID uuid.UUID) error
+	// TouchSCIMToken records that the company's token has just been used.
+	TouchSCIMToken(ctx context.Context, companyID uuid.UUID) error
 }
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/XSAM/otelsql"
+	"github.com/jmoiron/sqlx"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// openTracedDB opens the database with a driver creating a span for every query made with a context,
+// as a child of the span in the context. Spans are named after the statement, such as "select auth.users",
+// and record neither the query nor its parameters.
+func openTracedDB(connectionString string) (*sqlx.DB, error) {
+	db, err := otelsql.Open("postgres", connectionString,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanNameFormatter(func(_ context.Context, method otelsql.Method, query string) string {
+			if query == "" {
+				return string(method)
+			}
+			return statementName(query)
+		}),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableQuery:         true,
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitConnPrepare:      true,
+			OmitRows:             true,
+			RecordError: func(err error) bool {
+				return err != sql.ErrNoRows
+			},
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return sqlx.NewDb(db, "postgres"), nil
+}
+
+// statementName returns the operation of the query and the table it operates on, such as "insert public.profiles".
+func statementName(query string) string {
+	fields := strings.Fields(strings.ToLower(query))
+	if len(fields) == 0 {
+		return "query"
+	}
+
+	operation := strings.TrimSuffix(fields[0], ";")
+	var tableKeyword string
+	switch operation {
+	case "select", "with", "delete":
+		tableKeyword = "from"
+	case "insert":
+		tableKeyword = "into"
+	case "update":
+		if len(fields) > 1 {
+			return operation + " " + tableName(fields[1])
+		}
+		return operation
+	default:
+		return operation
+	}
+
+	for i, field := range fields[:len(fields)-1] {
+		if field == tableKeyword {
+			return operation + " " + tableName(fields[i+1])
+		}
+	}
+	return operation
+}
+
+// tableName strips the punctuation following a table name in a query, such as "companies;".
+func tableName(field string) string {
+	if i := strings.IndexAny(field, "(;,)"); i >= 0 {
+		return field[:i]
+	}
+	return field
+}
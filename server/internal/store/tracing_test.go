@@ -0,0 +1,26 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatementName(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{query: "SELECT * FROM companies WHERE id = $1;", expected: "select companies"},
+		{query: "select exists(select 1 from auth.users where email = $1);", expected: "select auth.users"},
+		{query: "\n\t\tinsert into public.profiles (id, company_id)\n\t\tvalues ($1, $2)", expected: "insert public.profiles"},
+		{query: "update companies set name = $1 where id = $2 returning *;", expected: "update companies"},
+		{query: "delete from security.roles where id = $1;", expected: "delete security.roles"},
+		{query: "select now();", expected: "select"},
+		{query: "begin", expected: "begin"},
+		{query: "  ", expected: "query"},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, statementName(tc.query), tc.query)
+	}
+}
@@ -2,14 +2,23 @@ package store
 
 import (
 	"advancely/internal/model"
+	"advancely/pkg/errs"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
-var ErrUserNotFound = errors.New("user not found")
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrUserNotDeleted = errors.New("user has not been deleted")
+	ErrProfileExists  = errors.New("user already has a profile in the company")
+)
 
 func NewPostgresUserStore(db *sqlx.DB) *PostgresUserStore {
 	return &PostgresUserStore{
@@ -21,18 +30,20 @@ type PostgresUserStore struct {
 	*sqlx.DB
 }
 
-func (s *PostgresUserStore) User(id uuid.UUID) (model.UserProfile, error) {
+// userProfileColumns are the columns of a model.UserProfile, selected from auth.users u joined with public.profiles p.
+const userProfileColumns = `
+	u.id, p.company_id, p.first_name, p.last_name,
+	u.email, p.is_admin, p.external_id, p.deactivated_at, p.deleted_at, p.created_at, p.updated_at`
+
+func (s *PostgresUserStore) User(ctx context.Context, id, companyID uuid.UUID) (model.UserProfile, error) {
 	var u model.UserProfile
 	query := `
-		select 
-		    u.id, p.company_id, p.first_name, p.last_name, 
-		    u.email, p.is_admin, p.created_at, p.updated_at 
+		select ` + userProfileColumns + `
 		from auth.users u
 		join public.profiles p on u.id = p.id
-		where u.id = $1
-		limit 1;`
+		where u.id = $1 and p.company_id = $2 and p.deleted_at is null;`
 
-	if err := s.Get(&u, query, id); err != nil {
+	if err := s.GetContext(ctx, &u, query, id, companyID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return model.UserProfile{}, ErrUserNotFound
 		}
@@ -41,7 +52,42 @@ func (s *PostgresUserStore) User(id uuid.UUID) (model.UserProfile, error) {
 	return u, nil
 }
 
-func (s *PostgresUserStore) BaseUserByEmail(email string) (model.User, error) {
+func (s *PostgresUserStore) DefaultProfile(ctx context.Context, id uuid.UUID) (model.UserProfile, error) {
+	var u model.UserProfile
+	query := `
+		select ` + userProfileColumns + `
+		from auth.users u
+		join public.profiles p on u.id = p.id
+		join public.companies c on c.id = p.company_id
+		where u.id = $1 and p.deleted_at is null
+		order by p.deactivated_at is not null, c.deleted_at is not null, p.created_at, p.company_id
+		limit 1;`
+
+	if err := s.GetContext(ctx, &u, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.UserProfile{}, ErrUserNotFound
+		}
+		return model.UserProfile{}, fmt.Errorf("error getting default profile of user: %w", err)
+	}
+	return u, nil
+}
+
+func (s *PostgresUserStore) Memberships(ctx context.Context, id uuid.UUID) ([]model.Membership, error) {
+	query := `
+		select c.id as company_id, c.name as company_name, p.deactivated_at, p.created_at
+		from public.profiles p
+		join public.companies c on c.id = p.company_id
+		where p.id = $1 and p.deleted_at is null
+		order by p.created_at, p.company_id;`
+
+	memberships := []model.Membership{}
+	if err := s.SelectContext(ctx, &memberships, query, id); err != nil {
+		return []model.Membership{}, fmt.Errorf("error listing memberships of user: %w", err)
+	}
+	return memberships, nil
+}
+
+func (s *PostgresUserStore) BaseUserByEmail(ctx context.Context, email string) (model.User, error) {
 	query := `
 		select id, aud, role, email, email_confirmed_at, invited_at,
 		       confirmation_sent_at, created_at, updated_at
@@ -50,7 +96,7 @@ func (s *PostgresUserStore) BaseUserByEmail(email string) (model.User, error) {
 		limit 1;`
 
 	var u model.User
-	if err := s.Get(&u, query, email); err != nil {
+	if err := s.GetContext(ctx, &u, query, email); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return model.User{}, ErrUserNotFound
 		}
@@ -60,69 +106,183 @@ func (s *PostgresUserStore) BaseUserByEmail(email string) (model.User, error) {
 	return u, nil
 }
 
-func (s *PostgresUserStore) Exists(email string) (bool, error) {
+func (s *PostgresUserStore) Exists(ctx context.Context, email string) (bool, error) {
 	var exists bool
 	stmt := "select exists(select 1 from auth.users where email = $1);"
-	if err := s.Get(&exists, stmt, email); err != nil {
+	if err := s.GetContext(ctx, &exists, stmt, email); err != nil {
 		return exists, err
 	}
 	return exists, nil
 }
 
-func (s *PostgresUserStore) Users(companyID uuid.UUID) ([]model.UserProfile, error) {
+func (s *PostgresUserStore) Users(ctx context.Context, companyID uuid.UUID) ([]model.UserProfile, error) {
 	var uu []model.UserProfile
 	query := `
-		select 
-		    u.id, p.company_id, p.first_name, p.last_name, 
-		    u.email, p.is_admin, p.created_at, p.updated_at 
+		select ` + userProfileColumns + `
 		from auth.users u
 		join public.profiles p on u.id = p.id
-		where p.company_id = $1;`
+		where p.company_id = $1 and p.deleted_at is null;`
 
-	if err := s.Select(&uu, query, companyID); err != nil {
+	if err := s.SelectContext(ctx, &uu, query, companyID); err != nil {
 		return []model.UserProfile{}, err
 	}
 	return uu, nil
 }
 
 type CreateProfileRequest struct {
-	UserID    uuid.UUID
-	CompanyID uuid.UUID
-	FirstName string
-	LastName  string
-	IsAdmin   bool
+	UserID     uuid.UUID
+	CompanyID  uuid.UUID
+	FirstName  string
+	LastName   string
+	IsAdmin    bool
+	ExternalID *string
+	// RoleIDs are the roles assigned to the user along with the profile. Roles that are neither roles of
+	// the company nor system roles, such as default roles deleted since they were configured, are skipped.
+	RoleIDs []int
 }
 
-func (s *PostgresUserStore) CreateProfile(req CreateProfileRequest) (model.UserProfile, error) {
+func (s *PostgresUserStore) CreateProfile(ctx context.Context, req CreateProfileRequest) (model.UserProfile, error) {
+	tx, err := s.BeginTxx(ctx, nil)
+	if err != nil {
+		return model.UserProfile{}, err
+	}
+	defer tx.Rollback()
+
 	query := `
-		insert into public.profiles (id, company_id, first_name, last_name, is_admin)
-		values ($1, $2, $3, $4, $5)
-		returning id, company_id, first_name, last_name, is_admin, created_at, updated_at;`
+		insert into public.profiles (id, company_id, first_name, last_name, is_admin, external_id)
+		values ($1, $2, $3, $4, $5, $6)
+		returning id, company_id, first_name, last_name, is_admin, external_id, deactivated_at, created_at, updated_at;`
 
 	var profile model.UserProfile
-	if err := s.Get(&profile, query, req.UserID, req.CompanyID, req.FirstName, req.LastName, req.IsAdmin); err != nil {
-		// TODO: Check if the profile already exists
+	err = tx.GetContext(ctx, &profile, query, req.UserID, req.CompanyID, req.FirstName, req.LastName, req.IsAdmin, req.ExternalID)
+	if err != nil {
+		if errors.Is(errs.CheckPgErr(err), errs.PgErrCodeUniqueViolation) {
+			return model.UserProfile{}, ErrProfileExists
+		}
 		return model.UserProfile{}, fmt.Errorf("error creating profile: %w", err)
 	}
+
+	if len(req.RoleIDs) > 0 {
+		stmt := `
+			insert into security.user_roles (user_id, company_id, role_id)
+			select $1, $3, r.id
+			from security.roles r
+			where r.id = any($2)
+			  and (r.company_id = $3 or r.is_system_role = true)
+			  and r.deleted_at is null
+			on conflict do nothing;`
+
+		if _, err := tx.ExecContext(ctx, stmt, req.UserID, pq.Array(req.RoleIDs), req.CompanyID); err != nil {
+			return model.UserProfile{}, fmt.Errorf("error assigning roles to profile: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.UserProfile{}, err
+	}
 	return profile, nil
 }
 
-func (s *PostgresUserStore) UpdateUser(user *model.UserProfile) error {
+func (s *PostgresUserStore) UpdateUser(ctx context.Context, user *model.UserProfile) error {
 	query := `
 		update public.profiles 
-		set first_name = $1, last_name = $2, is_admin = $3
-		where id = $4
+		set first_name = $1, last_name = $2, is_admin = $3, external_id = $4
+		where id = $5 and company_id = $6 and deleted_at is null
 		returning *;`
 
-	if err := s.Get(user, query, user.FirstName, user.LastName, user.IsAdmin, user.ID); err != nil {
+	if err := s.GetContext(ctx, user, query, user.FirstName, user.LastName, user.IsAdmin, user.ExternalID, user.ID, user.CompanyID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
 		return fmt.Errorf("error updating profile: %w", err)
 	}
 	return nil
 }
 
-func (s *PostgresUserStore) DeleteUser(id uuid.UUID) error {
-	if _, err := s.Exec("delete from auth.users where id = $1;", id); err != nil {
-		return fmt.Errorf("error deleting user: %w", err)
+func (s *PostgresUserStore) SetUserActive(ctx context.Context, id, companyID uuid.UUID, active bool) error {
+	stmt := `
+		update public.profiles
+		set deactivated_at = case when $1 then null else coalesce(deactivated_at, now()) end
+		where id = $2 and company_id = $3 and deleted_at is null;`
+
+	res, err := s.ExecContext(ctx, stmt, active, id, companyID)
+	if err != nil {
+		return fmt.Errorf("error setting user active: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *PostgresUserStore) DeleteProfile(ctx context.Context, id, companyID uuid.UUID) error {
+	stmt := `
+		update public.profiles
+		set deleted_at = now()
+		where id = $1 and company_id = $2 and deleted_at is null;`
+
+	res, err := s.ExecContext(ctx, stmt, id, companyID)
+	if err != nil {
+		return fmt.Errorf("error deleting profile: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *PostgresUserStore) RestoreProfile(ctx context.Context, id, companyID uuid.UUID) (model.UserProfile, error) {
+	stmt := `
+		update public.profiles
+		set deleted_at = null
+		where id = $1 and company_id = $2 and deleted_at is not null;`
+
+	res, err := s.ExecContext(ctx, stmt, id, companyID)
+	if err != nil {
+		return model.UserProfile{}, fmt.Errorf("error restoring profile: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		if _, err := s.User(ctx, id, companyID); err != nil {
+			return model.UserProfile{}, err
+		}
+		return model.UserProfile{}, ErrUserNotDeleted
+	}
+	return s.User(ctx, id, companyID)
+}
+
+// ProfileToPurge is a deleted profile whose retention period has ended.
+type ProfileToPurge struct {
+	UserID    uuid.UUID `db:"id"`
+	CompanyID uuid.UUID `db:"company_id"`
+	DeletedAt time.Time `db:"deleted_at"`
+	// LastProfile is true if the user has no profile in another company that is kept by the purge,
+	// so their account is purged too.
+	LastProfile bool `db:"last_profile"`
+}
+
+func (s *PostgresUserStore) ProfilesToPurge(ctx context.Context, deletedBefore time.Time) ([]ProfileToPurge, error) {
+	query := `
+		select p.id, p.company_id, p.deleted_at,
+		       not exists (
+		           select 1 from public.profiles other
+		           where other.id = p.id and other.company_id <> p.company_id
+		             and (other.deleted_at is null or other.deleted_at > $1)
+		       ) as last_profile
+		from public.profiles p
+		where p.deleted_at <= $1
+		order by p.deleted_at;`
+
+	profiles := []ProfileToPurge{}
+	if err := s.SelectContext(ctx, &profiles, query, deletedBefore); err != nil {
+		return []ProfileToPurge{}, fmt.Errorf("error listing profiles to purge: %w", err)
+	}
+	return profiles, nil
+}
+
+func (s *PostgresUserStore) PurgeProfile(ctx context.Context, id, companyID uuid.UUID) error {
+	stmt := "delete from public.profiles where id = $1 and company_id = $2 and deleted_at is not null;"
+	if _, err := s.ExecContext(ctx, stmt, id, companyID); err != nil {
+		return fmt.Errorf("error purging profile: %w", err)
 	}
 	return nil
 }
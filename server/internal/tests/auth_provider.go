@@ -0,0 +1,441 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"advancely/internal/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+// FakeJWTSecret is the HS256 secret used to sign access tokens issued by the FakeAuthProvider.
+const FakeJWTSecret = "fake-jwt-secret-for-testing-only"
+
+// FakeAuthProvider is an in-memory auth.Provider for use in tests that should not depend on Supabase.
+type FakeAuthProvider struct {
+	mu sync.Mutex
+	db *sqlx.DB
+
+	users         map[string]*fakeAuthUser
+	accessTokens  map[string]string
+	refreshTokens map[string]string
+	otps          map[string]string
+	oauthCodes    map[string]fakeOAuthCode
+
+	// PasswordResets contains the emails for which a password reset has been requested.
+	PasswordResets []string
+	// SentOTPs contains the requests for which a one-time password has been sent.
+	SentOTPs []types.OTPRequest
+	// DeletedUsers contains the IDs of the users that have been deleted.
+	DeletedUsers []uuid.UUID
+}
+
+type fakeAuthUser struct {
+	user     types.User
+	password string
+}
+
+type fakeOAuthCode struct {
+	email         string
+	codeChallenge string
+}
+
+func NewFakeAuthProvider() *FakeAuthProvider {
+	return &FakeAuthProvider{
+		users:         make(map[string]*fakeAuthUser),
+		accessTokens:  make(map[string]string),
+		refreshTokens: make(map[string]string),
+		otps:          make(map[string]string),
+		oauthCodes:    make(map[string]fakeOAuthCode),
+	}
+}
+
+// WithDatabase mirrors users created through SignUp and SendOTP into the auth.users table,
+// as Supabase would, so that stores querying auth.users can find them.
+func (p *FakeAuthProvider) WithDatabase(db *sqlx.DB) *FakeAuthProvider {
+	p.db = db
+	return p
+}
+
+// AddUser registers an existing user with the given password.
+func (p *FakeAuthProvider) AddUser(user types.User, password string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.users[user.Email] = &fakeAuthUser{user: user, password: password}
+}
+
+// SetOTP sets the one-time password expected by VerifyOTP for the given email.
+func (p *FakeAuthProvider) SetOTP(email, otp string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.otps[email] = otp
+}
+
+// AddOAuthCode returns an authorization code for the user registered with the given email,
+// as if they had logged in with an OAuth provider during a flow started with the code challenge.
+func (p *FakeAuthProvider) AddOAuthCode(email, codeChallenge string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code := uuid.NewString()
+	p.oauthCodes[code] = fakeOAuthCode{email: email, codeChallenge: codeChallenge}
+	return code
+}
+
+// NewSession creates a session for the user registered with the given email.
+func (p *FakeAuthProvider) NewSession(email string) *types.Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.newSession(p.users[email].user)
+}
+
+func (p *FakeAuthProvider) SignInWithEmailPassword(_ context.Context, email, password string) (*types.Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u, ok := p.users[email]
+	if !ok || u.password != password {
+		return nil, errInvalidCredentials()
+	}
+	if u.user.BannedUntil != nil {
+		return nil, errUserBanned()
+	}
+	return p.newSession(u.user), nil
+}
+
+func (p *FakeAuthProvider) SignUp(_ context.Context, req types.SignupRequest) (*types.User, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.users[req.Email]; exists {
+		return nil, &auth.Error{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    "user_already_exists",
+			Message: "User already registered",
+		}
+	}
+
+	now := time.Now().UTC()
+	u := types.User{
+		ID:           uuid.New(),
+		Aud:          "authenticated",
+		Role:         "authenticated",
+		Email:        req.Email,
+		UserMetadata: req.Data,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := p.createUser(u, req.Password); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (p *FakeAuthProvider) SendOTP(_ context.Context, req types.OTPRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.users[req.Email]; !exists {
+		if !req.CreateUser {
+			return errUserNotFound()
+		}
+		now := time.Now().UTC()
+		u := types.User{
+			ID:           uuid.New(),
+			Aud:          "authenticated",
+			Role:         "authenticated",
+			Email:        req.Email,
+			UserMetadata: req.Data,
+			InvitedAt:    &now,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		if err := p.createUser(u, ""); err != nil {
+			return err
+		}
+	}
+	p.SentOTPs = append(p.SentOTPs, req)
+	return nil
+}
+
+func (p *FakeAuthProvider) VerifyOTP(_ context.Context, req types.VerifyForUserRequest) (*types.Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	otp, ok := p.otps[req.Email]
+	if !ok || otp != req.Token {
+		return nil, &auth.Error{
+			Status:  http.StatusForbidden,
+			Code:    auth.ErrorCodeOTPExpired,
+			Message: "Token has expired or is invalid",
+		}
+	}
+	delete(p.otps, req.Email)
+	return p.newSession(p.users[req.Email].user), nil
+}
+
+func (p *FakeAuthProvider) RefreshSession(_ context.Context, refreshToken string) (*types.Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	email, ok := p.refreshTokens[refreshToken]
+	if !ok {
+		return nil, &auth.Error{
+			Status:  http.StatusBadRequest,
+			Code:    "refresh_token_not_found",
+			Message: "Invalid Refresh Token: Refresh Token Not Found",
+		}
+	}
+	delete(p.refreshTokens, refreshToken)
+	return p.newSession(p.users[email].user), nil
+}
+
+func (p *FakeAuthProvider) Logout(_ context.Context, accessToken string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.accessTokens, accessToken)
+	return nil
+}
+
+func (p *FakeAuthProvider) ResetPasswordForEmail(_ context.Context, email, _ string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Supabase does not reveal whether the email is registered, so neither does the fake.
+	if _, exists := p.users[email]; exists {
+		p.PasswordResets = append(p.PasswordResets, email)
+	}
+	return nil
+}
+
+func (p *FakeAuthProvider) UpdatePassword(_ context.Context, accessToken, password string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	email, ok := p.accessTokens[accessToken]
+	if !ok {
+		return errInvalidToken()
+	}
+	u := p.users[email]
+	if u.password == password {
+		return &auth.Error{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    auth.ErrorCodeSamePassword,
+			Message: "New password should be different from the old password.",
+		}
+	}
+	u.password = password
+	return nil
+}
+
+func (p *FakeAuthProvider) GetUser(_ context.Context, accessToken string) (*types.User, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	email, ok := p.accessTokens[accessToken]
+	if !ok {
+		return nil, errInvalidToken()
+	}
+	u := p.users[email].user
+	return &u, nil
+}
+
+func (p *FakeAuthProvider) OAuthURL(provider types.Provider, redirectTo, codeChallenge string) string {
+	q := url.Values{}
+	q.Set("provider", string(provider))
+	q.Set("redirect_to", redirectTo)
+	q.Set("code_challenge", codeChallenge)
+	return "https://auth.fake/authorize?" + q.Encode()
+}
+
+func (p *FakeAuthProvider) ExchangeCodeForSession(_ context.Context, code, codeVerifier string) (*types.Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	oauthCode, ok := p.oauthCodes[code]
+	if !ok || oauthCode.codeChallenge != auth.PKCEChallenge(codeVerifier) {
+		return nil, &auth.Error{
+			Status:  http.StatusBadRequest,
+			Code:    "bad_code_verifier",
+			Message: "code challenge does not match previously saved code verifier",
+		}
+	}
+	delete(p.oauthCodes, code)
+	return p.newSession(p.users[oauthCode.email].user), nil
+}
+
+func (p *FakeAuthProvider) SignInWithVerifiedEmail(
+	_ context.Context,
+	email string,
+	metadata map[string]interface{},
+) (*types.Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.users[email]; !exists {
+		u := NewAuthUser(email)
+		u.UserMetadata = metadata
+		if err := p.createUser(u, ""); err != nil {
+			return nil, err
+		}
+	}
+	return p.newSession(p.users[email].user), nil
+}
+
+func (p *FakeAuthProvider) CreateUser(
+	_ context.Context,
+	email string,
+	metadata map[string]interface{},
+) (*types.User, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.users[email]; exists {
+		return nil, &auth.Error{Status: http.StatusUnprocessableEntity, Code: "email_exists", Message: "User already registered"}
+	}
+	u := NewAuthUser(email)
+	u.UserMetadata = metadata
+	if err := p.createUser(u, ""); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (p *FakeAuthProvider) DeleteUser(_ context.Context, id uuid.UUID) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for email, u := range p.users {
+		if u.user.ID != id {
+			continue
+		}
+		if p.db != nil {
+			if _, err := p.db.Exec("delete from auth.users where id = $1;", id); err != nil {
+				return err
+			}
+		}
+		delete(p.users, email)
+		p.DeletedUsers = append(p.DeletedUsers, id)
+		return nil
+	}
+	return errUserNotFound()
+}
+
+// SetUserBanned bans the user, revoking their sessions, or lifts their ban.
+func (p *FakeAuthProvider) SetUserBanned(_ context.Context, id uuid.UUID, banned bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for email, u := range p.users {
+		if u.user.ID != id {
+			continue
+		}
+		if !banned {
+			u.user.BannedUntil = nil
+			return nil
+		}
+		until := time.Now().Add(100 * 365 * 24 * time.Hour)
+		u.user.BannedUntil = &until
+		for token, tokenEmail := range p.accessTokens {
+			if tokenEmail == email {
+				delete(p.accessTokens, token)
+			}
+		}
+		for token, tokenEmail := range p.refreshTokens {
+			if tokenEmail == email {
+				delete(p.refreshTokens, token)
+			}
+		}
+		return nil
+	}
+	return errUserNotFound()
+}
+
+// createUser registers the user, inserting them into auth.users if a database is configured.
+// The caller must hold the lock.
+func (p *FakeAuthProvider) createUser(u types.User, password string) error {
+	if p.db != nil {
+		if err := insertAuthUser(p.db, u); err != nil {
+			return err
+		}
+	}
+	p.users[u.Email] = &fakeAuthUser{user: u, password: password}
+	return nil
+}
+
+// newSession creates and records a new session for the user. The caller must hold the lock.
+// The access token is an HS256 JWT signed with FakeJWTSecret.
+func (p *FakeAuthProvider) newSession(u types.User) *types.Session {
+	expiresAt := time.Now().Add(time.Hour)
+	accessToken := NewAccessToken(u.ID.String(), u.Email, expiresAt)
+	refreshToken := uuid.NewString()
+	p.accessTokens[accessToken] = u.Email
+	p.refreshTokens[refreshToken] = u.Email
+
+	return &types.Session{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "bearer",
+		ExpiresIn:    3600,
+		ExpiresAt:    expiresAt.Unix(),
+		User:         u,
+	}
+}
+
+// NewAccessToken creates an access token for the given subject signed with FakeJWTSecret.
+func NewAccessToken(subject, email string, expiresAt time.Time) string {
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{"authenticated"},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
+		},
+		Email: email,
+		Role:  "authenticated",
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(FakeJWTSecret))
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
+
+func errInvalidCredentials() error {
+	return &auth.Error{
+		Status:  http.StatusBadRequest,
+		Code:    auth.ErrorCodeInvalidCredentials,
+		Message: "Invalid login credentials",
+	}
+}
+
+func errUserNotFound() error {
+	return &auth.Error{
+		Status:  http.StatusNotFound,
+		Code:    auth.ErrorCodeUserNotFound,
+		Message: "User not found",
+	}
+}
+
+func errUserBanned() error {
+	return &auth.Error{
+		Status:  http.StatusBadRequest,
+		Code:    "user_banned",
+		Message: "User is banned",
+	}
+}
+
+func errInvalidToken() error {
+	return &auth.Error{
+		Status:  http.StatusUnauthorized,
+		Code:    "bad_jwt",
+		Message: "invalid JWT",
+	}
+}
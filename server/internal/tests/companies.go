@@ -7,10 +7,28 @@ import (
 	"testing"
 )
 
+// CreateTestCompany creates a company created by the user, who is added to it as an Admin.
 func CreateTestCompany(t *testing.T, db *sqlx.DB, userId uuid.UUID) uuid.UUID {
 	stmt := "insert into companies (name, creator_id) values ('test-company', $1) returning id;"
 	var companyId uuid.UUID
 	err := db.Get(&companyId, stmt, userId)
 	require.NoError(t, err)
+
+	AddAdminToCompany(t, db, userId, companyId)
 	return companyId
 }
+
+// AddAdminToCompany creates the user's profile in the company and assigns them the Admin role in it.
+func AddAdminToCompany(t *testing.T, db *sqlx.DB, userId, companyId uuid.UUID) {
+	stmt := "insert into profiles (id, company_id, first_name, last_name) values ($1, $2, 'Joe', 'Blogs');"
+	_, err := db.Exec(stmt, userId, companyId)
+	require.NoError(t, err)
+
+	var adminRoleId int
+	err = db.Get(&adminRoleId, "select id from security.roles where name = 'Admin' limit 1;")
+	require.NoError(t, err)
+	_, err = db.Exec(
+		"insert into security.user_roles (user_id, company_id, role_id) values ($1, $2, $3);",
+		userId, companyId, adminRoleId)
+	require.NoError(t, err)
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"html"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"advancely/internal/model"
+
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// FakeIdentityProvider is a SAML identity provider served by an httptest server, standing in for
+// a company's identity provider in tests. Every authentication request is answered with an
+// assertion for the user set with SetUser.
+type FakeIdentityProvider struct {
+	IdP         *saml.IdentityProvider
+	server      *httptest.Server
+	certificate *x509.Certificate
+
+	mu               sync.Mutex
+	serviceProviders map[string]*saml.EntityDescriptor
+	session          *saml.Session
+}
+
+// NewFakeIdentityProvider starts an identity provider, which is stopped when the test finishes.
+func NewFakeIdentityProvider(t *testing.T) *FakeIdentityProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake-idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	p := &FakeIdentityProvider{
+		certificate:      certificate,
+		serviceProviders: make(map[string]*saml.EntityDescriptor),
+	}
+	p.IdP = &saml.IdentityProvider{
+		Key:                     key,
+		Certificate:             certificate,
+		ServiceProviderProvider: p,
+		SessionProvider:         p,
+	}
+	// The identity provider's handler routes on its URLs, which are only known once the server has started.
+	var handler http.Handler
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(p.server.Close)
+
+	metadataURL, _ := url.Parse(p.server.URL + "/metadata")
+	ssoURL, _ := url.Parse(p.server.URL + "/sso")
+	p.IdP.MetadataURL = *metadataURL
+	p.IdP.SSOURL = *ssoURL
+	handler = p.IdP.Handler()
+	return p
+}
+
+// Config returns a SAML configuration for the company pointing at this identity provider.
+func (p *FakeIdentityProvider) Config(companyID uuid.UUID) model.CompanySAMLConfig {
+	return model.CompanySAMLConfig{
+		CompanyID:          companyID,
+		IdPEntityID:        p.IdP.MetadataURL.String(),
+		IdPSSOURL:          p.IdP.SSOURL.String(),
+		IdPCertificate:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.certificate.Raw})),
+		EmailAttribute:     "email",
+		FirstNameAttribute: "firstName",
+		LastNameAttribute:  "lastName",
+	}
+}
+
+// MetadataXML returns the identity provider's metadata document.
+func (p *FakeIdentityProvider) MetadataXML(t *testing.T) []byte {
+	t.Helper()
+	res, err := http.Get(p.IdP.MetadataURL.String())
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return body
+}
+
+// RegisterServiceProvider trusts the service provider, as an administrator would when adding an application.
+func (p *FakeIdentityProvider) RegisterServiceProvider(metadata *saml.EntityDescriptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.serviceProviders[metadata.EntityID] = metadata
+}
+
+// SetUser sets the user the identity provider asserts in its responses.
+func (p *FakeIdentityProvider) SetUser(email, firstName, lastName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.session = &saml.Session{
+		ID:           uuid.NewString(),
+		CreateTime:   time.Now(),
+		ExpireTime:   time.Now().Add(time.Hour),
+		Index:        uuid.NewString(),
+		NameID:       email,
+		NameIDFormat: string(saml.EmailAddressNameIDFormat),
+		CustomAttributes: []saml.Attribute{
+			{Name: "email", Values: []saml.AttributeValue{{Type: "xs:string", Value: email}}},
+			{Name: "firstName", Values: []saml.AttributeValue{{Type: "xs:string", Value: firstName}}},
+			{Name: "lastName", Values: []saml.AttributeValue{{Type: "xs:string", Value: lastName}}},
+		},
+	}
+}
+
+var samlFormInputPattern = regexp.MustCompile(`name="(SAMLResponse|RelayState)" value="([^"]*)"`)
+
+// Login follows the redirect to the identity provider's SSO URL and returns the form
+// the identity provider would have the browser POST to the service provider's ACS.
+func (p *FakeIdentityProvider) Login(t *testing.T, redirectURL string) url.Values {
+	t.Helper()
+	res, err := http.Get(redirectURL)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	form := url.Values{}
+	for _, match := range samlFormInputPattern.FindAllSubmatch(body, -1) {
+		form.Set(string(match[1]), html.UnescapeString(string(match[2])))
+	}
+	require.NotEmpty(t, form.Get("SAMLResponse"), "identity provider did not return a SAML response")
+	return form
+}
+
+// GetServiceProvider implements saml.ServiceProviderProvider.
+func (p *FakeIdentityProvider) GetServiceProvider(_ *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	metadata, ok := p.serviceProviders[serviceProviderID]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return metadata, nil
+}
+
+// GetSession implements saml.SessionProvider.
+func (p *FakeIdentityProvider) GetSession(w http.ResponseWriter, _ *http.Request, _ *saml.IdpAuthnRequest) *saml.Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.session == nil {
+		http.Error(w, "no user", http.StatusForbidden)
+		return nil
+	}
+	return p.session
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"advancely/internal/scim"
+
+	"github.com/stretchr/testify/require"
+)
+
+// scimCommonAttributes are the attributes every resource may have in addition to its schema's attributes.
+var scimCommonAttributes = map[string]bool{"schemas": true, "id": true, "externalid": true, "meta": true}
+
+// AssertSCIMResource validates the resource, decoded from a JSON response, against the schema:
+// the resource must declare the schema, have every required attribute, and only have attributes of
+// the schema with values of the declared types.
+func AssertSCIMResource(t *testing.T, schema scim.Schema, resource map[string]any) {
+	t.Helper()
+
+	schemas, ok := resource["schemas"].([]any)
+	require.True(t, ok, "resource has no schemas")
+	require.Contains(t, schemas, schema.ID)
+
+	id, ok := resource["id"].(string)
+	require.True(t, ok && id != "", "resource has no id")
+
+	meta, ok := resource["meta"].(map[string]any)
+	require.True(t, ok, "resource has no meta")
+	require.Equal(t, schema.Name, meta["resourceType"])
+
+	for _, problem := range scimAttributeProblems("", schema.Attributes, resource, scimCommonAttributes) {
+		t.Error(problem)
+	}
+}
+
+func scimAttributeProblems(prefix string, attributes []scim.Attribute, value map[string]any, allowed map[string]bool) []string {
+	var problems []string
+	byName := make(map[string]scim.Attribute, len(attributes))
+	for _, a := range attributes {
+		byName[strings.ToLower(a.Name)] = a
+		if _, ok := value[a.Name]; a.Required && !ok {
+			problems = append(problems, fmt.Sprintf("required attribute %s%s is missing", prefix, a.Name))
+		}
+	}
+
+	for name, v := range value {
+		attribute, ok := byName[strings.ToLower(name)]
+		if !ok {
+			if !allowed[strings.ToLower(name)] {
+				problems = append(problems, fmt.Sprintf("attribute %s%s is not in the schema", prefix, name))
+			}
+			continue
+		}
+		if attribute.Name != name {
+			problems = append(problems, fmt.Sprintf("attribute %s%s should be named %s", prefix, name, attribute.Name))
+		}
+
+		values := []any{v}
+		if attribute.MultiValued {
+			list, ok := v.([]any)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("attribute %s%s must be multi-valued", prefix, name))
+				continue
+			}
+			values = list
+		}
+		for _, item := range values {
+			problems = append(problems, scimValueProblems(prefix+name, attribute, item)...)
+		}
+	}
+	return problems
+}
+
+func scimValueProblems(path string, attribute scim.Attribute, value any) []string {
+	valid := true
+	switch attribute.Type {
+	case "string", "reference", "binary":
+		_, valid = value.(string)
+	case "boolean":
+		_, valid = value.(bool)
+	case "integer", "decimal":
+		_, valid = value.(float64)
+	case "dateTime":
+		s, ok := value.(string)
+		_, err := time.Parse(time.RFC3339, s)
+		valid = ok && err == nil
+	case "complex":
+		m, ok := value.(map[string]any)
+		if !ok {
+			valid = false
+			break
+		}
+		return scimAttributeProblems(path+".", attribute.SubAttributes, m, nil)
+	}
+	if !valid {
+		return []string{fmt.Sprintf("attribute %s must be of type %s but is %v", path, attribute.Type, value)}
+	}
+	return nil
+}
@@ -2,12 +2,15 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"log/slog"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
 
+	"advancely/internal/application"
 	"advancely/internal/auth"
 	"advancely/internal/model/security"
 	"advancely/internal/validation"
@@ -43,6 +46,19 @@ func SaveSessionInContext(c echo.Context, userID, companyID uuid.UUID) {
 	session.SaveInContext(c)
 }
 
+// AddSessionCookie encodes the session into a cookie and adds it to the request on the echo context,
+// as if the session had been set on a previous response.
+func AddSessionCookie(t *testing.T, c echo.Context, session *auth.SessionCookie, secret string) {
+	e := NewEchoInstance()
+	rec := httptest.NewRecorder()
+	setter := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	require.NoError(t, session.SetCookie(setter, secret, application.EnvironmentDevelopment))
+
+	for _, cookie := range rec.Result().Cookies() {
+		c.Request().AddCookie(cookie)
+	}
+}
+
 // NewRequestRecorder creates a test HTTP request and recorder, returning the associated echo context and response.
 // The body should be a struct representing the request for the handler.
 func NewRequestRecorder(t *testing.T, method, url string, body interface{}) (echo.Context, *httptest.ResponseRecorder) {
@@ -73,7 +89,7 @@ func (f *FakeRoleFetcher) WithAdminRole() *FakeRoleFetcher {
 	return f
 }
 
-func (f *FakeRoleFetcher) UserRoles(userID uuid.UUID) (security.UserRoleCollection, error) {
+func (f *FakeRoleFetcher) UserRoles(_ context.Context, userID, _ uuid.UUID) (security.UserRoleCollection, error) {
 	roleName := security.Role("test-role")
 	if f.UseAdminRole {
 		roleName = security.RoleAdmin
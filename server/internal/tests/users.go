@@ -2,41 +2,61 @@ package tests
 
 import (
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 	"github.com/supabase-community/gotrue-go/types"
-	"github.com/supabase-community/supabase-go"
 )
 
-const DefaultUserPassword = "password"
+const (
+	DefaultUserEmail    = "user@company-email.com"
+	DefaultUserPassword = "password"
+)
 
-// CreateAdminUser creates a user and associates them with the Admin role.
-// This method does not to a complete signup. The profiles and companies table is not affected.
-func CreateAdminUser(t *testing.T, sb *supabase.Client, db *sqlx.DB) types.User {
-	resp, err := sb.Auth.Signup(types.SignupRequest{
-		Email:    "user@company-email.com",
-		Password: DefaultUserPassword,
-	})
-	require.NoError(t, err)
+// NewAuthUser returns a new confirmed user with the given email.
+func NewAuthUser(email string) types.User {
+	now := time.Now().UTC()
+	return types.User{
+		ID:               uuid.New(),
+		Aud:              "authenticated",
+		Role:             "authenticated",
+		Email:            email,
+		EmailConfirmedAt: &now,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+}
 
-	// Add the admin role
-	var adminRoleId int
-	err = db.Get(&adminRoleId, "select id from security.roles where name = 'Admin' limit 1;")
-	require.NoError(t, err)
-	_, err = db.Exec(
-		"insert into security.user_roles (user_id, role_id) values ($1, $2);",
-		resp.User.ID, adminRoleId)
+// CreateAuthUser inserts a confirmed user into the auth.users table and registers them with the fake auth provider.
+func CreateAuthUser(t *testing.T, provider *FakeAuthProvider, db *sqlx.DB, email string) types.User {
+	user := NewAuthUser(email)
+	err := insertAuthUser(db, user)
 	require.NoError(t, err)
 
-	return resp.User
+	provider.AddUser(user, DefaultUserPassword)
+	return user
+}
+
+func insertAuthUser(db *sqlx.DB, u types.User) error {
+	stmt := `
+		insert into auth.users (id, aud, role, email, email_confirmed_at, invited_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8);`
+	_, err := db.Exec(stmt, u.ID, u.Aud, u.Role, u.Email, u.EmailConfirmedAt, u.InvitedAt, u.CreatedAt, u.UpdatedAt)
+	return err
+}
+
+// CreateAdminUser creates the user who signs up a company. They become its Admin when the company is created
+// with CreateTestCompany. This method does not to a complete signup. The profiles and companies table is not affected.
+func CreateAdminUser(t *testing.T, provider *FakeAuthProvider, db *sqlx.DB) types.User {
+	return CreateAuthUser(t, provider, db, DefaultUserEmail)
 }
 
 // SignUpAdminUser simulates a completely signed up Admin user.
 // The user is created with the Admin role and associate profile and company records.
-func SignUpAdminUser(t *testing.T, sb *supabase.Client, db *sqlx.DB) types.User {
-	user := CreateAdminUser(t, sb, db)
+func SignUpAdminUser(t *testing.T, provider *FakeAuthProvider, db *sqlx.DB) types.User {
+	user := CreateAdminUser(t, provider, db)
 
 	// Create company
 	var companyID uuid.UUID
@@ -44,10 +64,8 @@ func SignUpAdminUser(t *testing.T, sb *supabase.Client, db *sqlx.DB) types.User
 	err := db.Get(&companyID, stmt, "test-company", user.ID)
 	require.NoError(t, err)
 
-	// Create profile
-	stmt = "insert into profiles (id, company_id, first_name, last_name) values ($1, $2, $3, $4);"
-	_, err = db.Exec(stmt, user.ID, companyID, "Joe", "Blogs")
-	require.NoError(t, err)
+	// Create profile with the Admin role
+	AddAdminToCompany(t, db, user.ID, companyID)
 
 	return user
 }
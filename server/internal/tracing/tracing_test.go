@@ -0,0 +1,73 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"advancely/internal/application"
+	"advancely/internal/auth"
+	"advancely/internal/tracing"
+	"advancely/pkg/sbext"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase-community/supabase-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newRecordingTracer returns a tracer provider recording the spans it ends in the returned recorder.
+func newRecordingTracer(t *testing.T) (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+	})
+	return provider, recorder
+}
+
+func TestSetupWithoutExporter(t *testing.T) {
+	shutdown, err := tracing.Setup(context.Background(), application.TracingConfig{
+		Exporter: application.TracingExporterNone,
+	}, application.EnvironmentDevelopment)
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+}
+
+func TestAuthProviderRequestsAreChildSpans(t *testing.T) {
+	provider, recorder := newRecordingTracer(t)
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": uuid.New(), "email": "user@company-email.com"})
+	}))
+	defer server.Close()
+
+	config := application.SupabaseConfig{URL: server.URL, PublicKey: "public-key"}
+	client, err := supabase.NewClient(config.URL, config.PublicKey, nil)
+	require.NoError(t, err)
+	authProvider := auth.NewSupabaseProvider(sbext.NewSupabaseExtended(client, config), "service-role-secret").
+		WithTransport(tracing.NewTransport(http.DefaultTransport))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "POST /api/v1/auth/signup")
+	_, err = authProvider.GetUser(ctx, "access-token")
+	require.NoError(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	clientSpan := spans[0]
+	require.Equal(t, "GET /auth/v1/user", clientSpan.Name())
+	require.Equal(t, parent.SpanContext().SpanID(), clientSpan.Parent().SpanID())
+	require.Contains(t, traceparent, parent.SpanContext().TraceID().String())
+}
@@ -1,25 +1,45 @@
 package validation
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 	"net"
 	"net/http"
+	"reflect"
 	"regexp"
+	"strings"
 )
 
 var (
 	ErrInvalidDomain = errors.New("invalid domain")
 	ErrUnknownDomain = errors.New("unknown domain")
+	// ErrDomainNotVerified is returned when the domain has no TXT record with its verification token.
+	ErrDomainNotVerified = errors.New("domain verification record not found")
 )
 
+// DomainVerificationPrefix prefixes the verification token in the TXT record proving a company owns a domain.
+const DomainVerificationPrefix = "advancely-domain-verification="
+
 type CustomValidator struct {
 	validator *validator.Validate
 }
 
 func NewCustomValidator() *CustomValidator {
-	return &CustomValidator{validator: validator.New()}
+	v := validator.New()
+	v.RegisterTagNameFunc(jsonFieldName)
+	return &CustomValidator{validator: v}
+}
+
+// jsonFieldName names fields by their JSON key, so validation errors refer to the fields of the request body.
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
 }
 
 func (cv *CustomValidator) Validate(i interface{}) error {
@@ -27,17 +47,98 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 }
 
 // BindAndValidate attempts to bind the form to the given struct and validates the result.
-// Returns an HTTP error if binding or validation fails.
+// Returns an HTTP error if binding or validation fails, with the validation errors as its internal error.
 func BindAndValidate(c echo.Context, i interface{}) *echo.HTTPError {
 	if err := c.Bind(i); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest)
+		return echo.NewHTTPError(http.StatusBadRequest, "The request body is malformed").SetInternal(err)
 	}
 	if err := c.Validate(i); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "The request is invalid").SetInternal(err)
 	}
 	return nil
 }
 
+// FieldError describes why a field of a request is invalid.
+type FieldError struct {
+	// Field is the path of the field in the request body, such as "email".
+	Field string `json:"field"`
+	// Code is the validation rule the field failed, such as "required" or "email".
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors are field errors found without the validator, such as when validating values that are not bound
+// to a struct.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fe := range e {
+		messages = append(messages, fmt.Sprintf("%s: %s", fe.Field, fe.Message))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// FieldErrors returns the field errors of a validation error in the err tree,
+// or nil if there is none.
+func FieldErrors(err error) []FieldError {
+	var errs Errors
+	if errors.As(err, &errs) {
+		return errs
+	}
+
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil
+	}
+
+	fieldErrs := make([]FieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		// The namespace starts with the name of the request struct, which is not part of the body.
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		fieldErrs = append(fieldErrs, FieldError{
+			Field:   field,
+			Code:    fe.Tag(),
+			Message: fieldErrorMessage(fe),
+		})
+	}
+	return fieldErrs
+}
+
+func fieldErrorMessage(fe validator.FieldError) string {
+	unit := ""
+	switch fe.Kind() {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		unit = " items"
+	}
+
+	switch fe.Tag() {
+	case "required", "required_with", "required_without", "required_if", "required_unless":
+		return "This field is required"
+	case "email":
+		return "Must be a valid email address"
+	case "url", "http_url":
+		return "Must be a valid URL"
+	case "uuid", "uuid4":
+		return "Must be a valid UUID"
+	case "oneof":
+		return fmt.Sprintf("Must be one of: %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "min", "gte":
+		return fmt.Sprintf("Must be at least %s%s", fe.Param(), unit)
+	case "max", "lte":
+		return fmt.Sprintf("Must be at most %s%s", fe.Param(), unit)
+	case "len":
+		return fmt.Sprintf("Must be exactly %s%s", fe.Param(), unit)
+	case "gt":
+		return fmt.Sprintf("Must be more than %s%s", fe.Param(), unit)
+	case "lt":
+		return fmt.Sprintf("Must be less than %s%s", fe.Param(), unit)
+	}
+	return fmt.Sprintf("Failed the %s validation", fe.Tag())
+}
+
 func ValidateDomain(domain string) error {
 	var domainRegex = regexp.MustCompile(`^([a-zA-Z0-9-]+\.)+[a-zA-Z]{2,}$`)
 	if !domainRegex.MatchString(domain) {
@@ -49,3 +150,25 @@ func ValidateDomain(domain string) error {
 	}
 	return nil
 }
+
+// LookupTXTFn returns the TXT records of the domain name, like net.Resolver.LookupTXT.
+type LookupTXTFn func(ctx context.Context, name string) ([]string, error)
+
+// VerifyDomainOwnership returns nil if the domain has a TXT record with the verification token,
+// proving the company that was given the token controls the domain's DNS.
+func VerifyDomainOwnership(ctx context.Context, lookupTXT LookupTXTFn, domain, token string) error {
+	records, err := lookupTXT(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return ErrDomainNotVerified
+		}
+		return fmt.Errorf("failed to look up TXT records of %s: %w", domain, err)
+	}
+	for _, record := range records {
+		if strings.TrimSpace(record) == DomainVerificationPrefix+token {
+			return nil
+		}
+	}
+	return ErrDomainNotVerified
+}
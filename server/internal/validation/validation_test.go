@@ -1,6 +1,9 @@
 package validation
 
 import (
+	"context"
+	"errors"
+	"net"
 	"testing"
 )
 
@@ -26,3 +29,37 @@ func TestValidateDomain(t *testing.T) {
 		}
 	}
 }
+
+func TestVerifyDomainOwnership(t *testing.T) {
+	lookupTXT := func(_ context.Context, name string) ([]string, error) {
+		switch name {
+		case "verified.com":
+			return []string{"v=spf1 -all", " " + DomainVerificationPrefix + "token "}, nil
+		case "unverified.com":
+			return []string{DomainVerificationPrefix + "other-token"}, nil
+		case "timeout.com":
+			return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	tests := []struct {
+		domain      string
+		expectedErr error
+	}{
+		{"verified.com", nil},
+		{"unverified.com", ErrDomainNotVerified},
+		{"unknown.com", ErrDomainNotVerified},
+	}
+	for _, test := range tests {
+		err := VerifyDomainOwnership(context.Background(), lookupTXT, test.domain, "token")
+		if err != test.expectedErr {
+			t.Errorf("VerifyDomainOwnership(%q) = %v; want %v", test.domain, err, test.expectedErr)
+		}
+	}
+
+	err := VerifyDomainOwnership(context.Background(), lookupTXT, "timeout.com", "token")
+	if err == nil || errors.Is(err, ErrDomainNotVerified) {
+		t.Errorf("VerifyDomainOwnership(%q) = %v; want the lookup error", "timeout.com", err)
+	}
+}
@@ -11,8 +11,9 @@ import (
 type PgErr string
 
 const (
-	PgErrNone                PgErr = "-"
-	PgErrCodeUniqueViolation PgErr = "unique_violation"
+	PgErrNone                    PgErr = "-"
+	PgErrCodeUniqueViolation     PgErr = "unique_violation"
+	PgErrCodeForeignKeyViolation PgErr = "foreign_key_violation"
 )
 
 // Error implements the built-in error interface
@@ -47,6 +48,8 @@ func stringToPgErr(code string) (PgErr, bool) {
 		return PgErrNone, false
 	case "unique_violation":
 		return PgErrCodeUniqueViolation, true
+	case "foreign_key_violation":
+		return PgErrCodeForeignKeyViolation, true
 	default:
 		return PgErr(code), false
 	}
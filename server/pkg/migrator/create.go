@@ -0,0 +1,44 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// timestampFormat is the format of the versions of created migrations, which sort after the sequential versions.
+const timestampFormat = "20060102150405"
+
+var ErrInvalidName = errors.New("migration names may only contain letters, digits and underscores")
+
+var migrationNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Create creates an empty up and down migration named after the time in the directory,
+// such as 20241019120000_create_table_invoices.up.sql, returning the paths of the files.
+// Spaces and hyphens in the name are replaced with underscores.
+func Create(dir, name string, now time.Time) ([]string, error) {
+	name = strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(strings.TrimSpace(name)))
+	if !migrationNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	version := now.UTC().Format(timestampFormat)
+	var paths []string
+	for _, direction := range []MigrationDirection{MigrationDirectionUp, MigrationDirectionDown} {
+		path := filepath.Join(dir, fmt.Sprintf("%s_%s.%s.sql", version, name, direction))
+		// Existing migrations are never overwritten.
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return paths, fmt.Errorf("could not create migration: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return paths, fmt.Errorf("could not create migration: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
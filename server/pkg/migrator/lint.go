@@ -0,0 +1,217 @@
+package migrator
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Rules of the migration linter. A migration can ignore rules it has been reviewed against with a comment
+// such as "-- lint:ignore drop-column,index-not-concurrent".
+const (
+	// RuleMissingDown reports an up migration without a down migration, or the reverse.
+	RuleMissingDown = "missing-down"
+	// RuleTrailingNewline reports a file that does not end with a newline.
+	RuleTrailingNewline = "trailing-newline"
+	// RuleIndexNotConcurrent reports an index created on an existing table without CONCURRENTLY,
+	// which blocks writes to the table while the index is built.
+	RuleIndexNotConcurrent = "index-not-concurrent"
+	// RuleDropColumn reports a column dropped by an up migration. Its data cannot be restored by the down
+	// migration, so it should first be backfilled elsewhere and no longer read by the deployed API.
+	RuleDropColumn = "drop-column"
+	// RuleNotNullWithoutDefault reports a NOT NULL column added to an existing table without a default,
+	// which fails on tables with rows unless they are backfilled first.
+	RuleNotNullWithoutDefault = "not-null-without-default"
+	// RuleMixedSchemaQualification reports a file referring to some tables of the public schema as public.name
+	// and others without the schema.
+	RuleMixedSchemaQualification = "mixed-schema-qualification"
+)
+
+// Finding is a problem found in a migration file.
+type Finding struct {
+	File    string
+	Rule    string
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.File, f.Rule, f.Message)
+}
+
+var (
+	migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+	ignorePattern        = regexp.MustCompile(`--\s*lint:ignore\s+([a-z\-, ]+)`)
+
+	dollarQuotedPattern  = regexp.MustCompile(`(?s)\$([a-z_]*)\$.*?\$([a-z_]*)\$`)
+	lineCommentPattern   = regexp.MustCompile(`--[^\n]*`)
+	stringLiteralPattern = regexp.MustCompile(`'(?:[^']|'')*'`)
+	quotedIdentPattern   = regexp.MustCompile(`"(?:[^"]|"")*"`)
+	whitespacePattern    = regexp.MustCompile(`\s+`)
+
+	tableName = `((?:[a-z_][a-z0-9_]*\.)?[a-z_][a-z0-9_]*)`
+
+	createTablePattern     = regexp.MustCompile(`^create (?:unlogged )?table (?:if not exists )?` + tableName)
+	createIndexPattern     = regexp.MustCompile(`^create (?:unique )?index (concurrently )?.*? on (?:only )?` + tableName)
+	alterTablePattern      = regexp.MustCompile(`^alter table (?:if exists )?(?:only )?` + tableName + ` (.*)$`)
+	dropColumnPattern      = regexp.MustCompile(`\bdrop (?:column )?(?:if exists )?([a-z_][a-z0-9_]*)`)
+	addColumnPattern       = regexp.MustCompile(`\badd (?:column )?(?:if not exists )?([a-z_][a-z0-9_]*) ([^,]*)`)
+	tableReferencePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\b(?:table|references|into|from|join|truncate)(?: if (?:not )?exists)?(?: only)? ` + tableName + `\b`),
+		regexp.MustCompile(`^update (?:only )?` + tableName + `\b`),
+		// Triggers, policies and indexes are created on a table.
+		regexp.MustCompile(`\b(?:before|after|instead of) [a-z, ]+? on ` + tableName + `\b`),
+		regexp.MustCompile(`\b(?:policy|trigger) (?:if exists )?\S+ on ` + tableName + `\b`),
+		regexp.MustCompile(`\bindex (?:concurrently )?(?:if not exists )?\S+ on (?:only )?` + tableName + `\b`),
+	}
+)
+
+// Lint checks the migrations at the root of fsys, returning the findings sorted by file.
+func Lint(fsys fs.FS) ([]Finding, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+
+	var findings []Finding
+	files := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		files[entry.Name()] = true
+	}
+
+	for name := range files {
+		match := migrationFilePattern.FindStringSubmatch(name)
+		if match == nil {
+			findings = append(findings, Finding{File: name, Rule: RuleMissingDown, Message: "file name is not <version>_<name>.<up|down>.sql"})
+			continue
+		}
+		other := "down"
+		if match[3] == "down" {
+			other = "up"
+		}
+		if !files[fmt.Sprintf("%s_%s.%s.sql", match[1], match[2], other)] {
+			findings = append(findings, Finding{File: name, Rule: RuleMissingDown, Message: fmt.Sprintf("missing %s migration", other)})
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("could not read migration %s: %w", name, err)
+		}
+		findings = append(findings, lintFile(name, string(data), MigrationDirection(match[3]))...)
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		return findings[i].Rule < findings[j].Rule
+	})
+	return findings, nil
+}
+
+func lintFile(name, sql string, direction MigrationDirection) []Finding {
+	ignored := make(map[string]bool)
+	for _, match := range ignorePattern.FindAllStringSubmatch(sql, -1) {
+		for _, rule := range strings.Split(match[1], ",") {
+			ignored[strings.TrimSpace(rule)] = true
+		}
+	}
+
+	var findings []Finding
+	report := func(rule, format string, args ...any) {
+		if !ignored[rule] {
+			findings = append(findings, Finding{File: name, Rule: rule, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	if sql != "" && !strings.HasSuffix(sql, "\n") {
+		report(RuleTrailingNewline, "file does not end with a newline")
+	}
+
+	statements := splitStatements(sql)
+
+	// Tables created by the migration are empty, so indexes and columns can be added to them safely.
+	created := make(map[string]bool)
+	for _, stmt := range statements {
+		if match := createTablePattern.FindStringSubmatch(stmt); match != nil {
+			created[unqualified(match[1])] = true
+		}
+	}
+
+	qualified := make(map[string]bool)
+	unqualifiedNames := make(map[string]bool)
+	for _, stmt := range statements {
+		if match := createIndexPattern.FindStringSubmatch(stmt); match != nil && match[1] == "" && !created[unqualified(match[2])] {
+			report(RuleIndexNotConcurrent, "index on %s is not created concurrently", match[2])
+		}
+
+		if match := alterTablePattern.FindStringSubmatch(stmt); match != nil {
+			table, actions := match[1], match[2]
+			for _, action := range strings.Split(actions, ",") {
+				action = strings.TrimSpace(action)
+				if direction == MigrationDirectionUp && strings.HasPrefix(action, "drop ") && !strings.HasPrefix(action, "drop constraint") &&
+					!strings.HasPrefix(action, "drop default") && !strings.HasPrefix(action, "drop not null") {
+					if column := dropColumnPattern.FindStringSubmatch(action); column != nil {
+						report(RuleDropColumn, "column %s.%s is dropped", table, column[1])
+					}
+				}
+				if column := addColumnPattern.FindStringSubmatch(action); column != nil && !strings.HasPrefix(action, "add constraint") &&
+					strings.Contains(column[2], "not null") && !strings.Contains(column[2], "default") && !created[unqualified(table)] {
+					report(RuleNotNullWithoutDefault, "column %s.%s is not null without a default", table, column[1])
+				}
+			}
+		}
+
+		for _, pattern := range tableReferencePatterns {
+			for _, match := range pattern.FindAllStringSubmatch(stmt, -1) {
+				switch {
+				case strings.HasPrefix(match[1], "public."):
+					qualified[match[1]] = true
+				case !strings.Contains(match[1], "."):
+					unqualifiedNames[match[1]] = true
+				}
+			}
+		}
+	}
+
+	if len(qualified) > 0 && len(unqualifiedNames) > 0 {
+		names := make([]string, 0, len(unqualifiedNames))
+		for name := range unqualifiedNames {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		report(RuleMixedSchemaQualification, "tables are referred to as public.name and without a schema: %s", strings.Join(names, ", "))
+	}
+	return findings
+}
+
+// splitStatements returns the statements of the SQL in lowercase with single spaces,
+// without comments, string literals, quoted identifiers and function bodies.
+func splitStatements(sql string) []string {
+	sql = strings.ToLower(sql)
+	sql = dollarQuotedPattern.ReplaceAllString(sql, "''")
+	sql = lineCommentPattern.ReplaceAllString(sql, "")
+	sql = stringLiteralPattern.ReplaceAllString(sql, "''")
+	sql = quotedIdentPattern.ReplaceAllString(sql, "ident")
+	// Parentheses are padded so names before them, such as references companies(id), are matched.
+	sql = strings.NewReplacer("(", " ( ", ")", " ) ").Replace(sql)
+	sql = whitespacePattern.ReplaceAllString(sql, " ")
+
+	var statements []string
+	for _, stmt := range strings.Split(sql, ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt != "" {
+			statements = append(statements, stmt)
+		}
+	}
+	return statements
+}
+
+func unqualified(table string) string {
+	return strings.TrimPrefix(table, "public.")
+}
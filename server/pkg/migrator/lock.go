@@ -0,0 +1,73 @@
+package migrator
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultLockKey is the key of the advisory lock held while migrating,
+	// so replicas of the API starting together migrate the database one at a time.
+	DefaultLockKey int64 = 7_061_825
+	// DefaultLockTimeout is how long to wait for another replica to finish migrating.
+	DefaultLockTimeout = 5 * time.Minute
+)
+
+var ErrLockTimeout = errors.New("timed out waiting for the migration lock")
+
+// WithLock sets the key of the advisory lock held while migrating and how long to wait for it.
+func (m *PostgresMigrator) WithLock(key int64, timeout time.Duration) *PostgresMigrator {
+	m.LockKey = key
+	m.LockTimeout = timeout
+	return m
+}
+
+// lock acquires the migration lock on its own connection, waiting up to the lock timeout,
+// and returns the function releasing it.
+func (m *PostgresMigrator) lock(ctx context.Context) (func(), error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to database: %w", err)
+	}
+
+	start := time.Now()
+	m.Logger.Info("waiting for migration lock", "key", m.LockKey, "timeout", m.LockTimeout)
+	lockCtx, cancel := context.WithTimeout(ctx, m.LockTimeout)
+	defer cancel()
+	// The query is cancelled when the context is done, which stops waiting for the lock.
+	if _, err := conn.ExecContext(lockCtx, "select pg_advisory_lock($1);", m.LockKey); err != nil {
+		_ = conn.Close()
+		if errors.Is(lockCtx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %s", ErrLockTimeout, m.LockTimeout)
+		}
+		return nil, fmt.Errorf("could not acquire migration lock: %w", err)
+	}
+	acquired := time.Now()
+	m.Logger.Info("acquired migration lock", "wait", acquired.Sub(start))
+
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), "select pg_advisory_unlock($1);", m.LockKey); err != nil {
+			m.Logger.Error("failed to release migration lock", "error", err)
+			// Discarding the connection ends its session, which releases the lock.
+			_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		_ = conn.Close()
+		m.Logger.Info("released migration lock", "held", time.Since(acquired))
+	}, nil
+}
+
+// upToDate returns true if the database has been migrated to the newest migration.
+func (m *PostgresMigrator) upToDate(ctx context.Context) (bool, int, error) {
+	version, dirty, err := DatabaseVersion(ctx, m.DB)
+	if err != nil {
+		return false, 0, err
+	}
+	latest, err := LatestVersion(m.Source)
+	if err != nil {
+		return false, 0, err
+	}
+	return !dirty && version == int(latest), version, nil
+}
@@ -1,19 +1,25 @@
 package migrator
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/lib/pq"
 )
 
-var DefaultMigrationPath = "file://cmd/migrate/migrations"
 var ErrUnknownDirection = errors.New("unknown direction expected up or down")
+var ErrNoMigrations = errors.New("no migrations found")
 
 type MigrationDirection string
 
@@ -34,24 +40,85 @@ func NewMigrationDirection(direction string) MigrationDirection {
 	}
 }
 
+// Source is where migrations are read from: FS if set, such as the migrations embedded in the binary,
+// and otherwise the source URL at Path, such as file://cmd/migrate/migrations.
+type Source struct {
+	FS   fs.FS
+	Path string
+}
+
+// FSSource returns the source of the migrations at the root of fsys.
+func FSSource(fsys fs.FS) Source {
+	return Source{FS: fsys}
+}
+
+// PathSource returns the source of the migrations at the URL, which is relative to the working directory
+// for file:// URLs.
+func PathSource(path string) Source {
+	return Source{Path: path}
+}
+
+// Open opens the source driver, which the caller must close.
+func (s Source) Open() (source.Driver, error) {
+	if s.FS != nil {
+		return iofs.New(s.FS, ".")
+	}
+	return source.Open(s.Path)
+}
+
+func (s Source) String() string {
+	if s.FS != nil {
+		return "embedded"
+	}
+	return s.Path
+}
+
 type Migrator interface {
+	// Migrate applies every pending migration, or reverts every migration.
 	Migrate(direction MigrationDirection) error
+	// Run migrates the database to the target.
+	Run(target Target) error
+	// Plan returns the migrations Run would run, without changing the database.
+	Plan(target Target) ([]Step, error)
+	// Force sets the version of the database and clears the dirty flag, without running migrations.
+	Force(version int) error
+	Status() (Status, error)
 }
 
-func NewPostgresMigrator(db *sql.DB, dbName, migrationPath string) *PostgresMigrator {
+var ErrDirty = errors.New("database is dirty")
+
+// Status is the migration state of the database.
+type Status struct {
+	// Version is the version of the database, or NilVersion if no migration has been applied.
+	Version int
+	// Dirty is true if the last migration failed, which must be fixed manually before forcing the version.
+	Dirty bool
+	// Latest is the version of the newest migration.
+	Latest uint
+	// Pending are the migrations not yet applied, which are unknown while the database is dirty.
+	Pending []Step
+}
+
+func NewPostgresMigrator(db *sql.DB, dbName string, src Source) *PostgresMigrator {
 	return &PostgresMigrator{
-		DB:            db,
-		DBName:        dbName,
-		MigrationPath: migrationPath,
-		Logger:        slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		DB:          db,
+		DBName:      dbName,
+		Source:      src,
+		Logger:      slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		LockKey:     DefaultLockKey,
+		LockTimeout: DefaultLockTimeout,
 	}
 }
 
 type PostgresMigrator struct {
-	DB            *sql.DB
-	DBName        string
-	MigrationPath string
-	Logger        *slog.Logger
+	DB     *sql.DB
+	DBName string
+	Source Source
+	Logger *slog.Logger
+	// LockKey is the key of the advisory lock held while migrating.
+	LockKey int64
+	// LockTimeout is how long to wait for the lock before failing.
+	LockTimeout time.Duration
 }
 
 func (m *PostgresMigrator) WithLogger(logger *slog.Logger) *PostgresMigrator {
@@ -59,40 +126,216 @@ func (m *PostgresMigrator) WithLogger(logger *slog.Logger) *PostgresMigrator {
 	return m
 }
 
-func (m *PostgresMigrator) Migrate(direction MigrationDirection) error {
-	driver, err := postgres.WithInstance(m.DB, &postgres.Config{})
+// instance returns a migrate instance using its own connection of the pool, which is released by closing it.
+func (m *PostgresMigrator) instance() (*migrate.Migrate, error) {
+	ctx := context.Background()
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to database: %w", err)
+	}
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
 	if err != nil {
-		return fmt.Errorf("could not create database driver: %w", err)
+		_ = conn.Close()
+		return nil, fmt.Errorf("could not create database driver: %w", err)
 	}
 
-	mig, err := migrate.NewWithDatabaseInstance(m.MigrationPath, m.DBName, driver)
+	src, err := m.Source.Open()
 	if err != nil {
-		return fmt.Errorf("could not create migration instance: %w", err)
+		_ = driver.Close()
+		return nil, fmt.Errorf("could not open migration source: %w", err)
 	}
 
-	m.Logger.Info("starting database migration", "direction", direction)
+	mig, err := migrate.NewWithInstance(m.Source.String(), src, m.DBName, driver)
+	if err != nil {
+		_ = src.Close()
+		_ = driver.Close()
+		return nil, fmt.Errorf("could not create migration instance: %w", err)
+	}
+	return mig, nil
+}
 
+func (m *PostgresMigrator) Migrate(direction MigrationDirection) error {
 	switch direction {
 	case MigrationDirectionUp:
-		if err := mig.Up(); err != nil {
-			if errors.Is(err, migrate.ErrNoChange) {
-				m.Logger.Info("nothing to migrate")
-				return nil
-			}
-			return fmt.Errorf("could not run migration: %w", err)
-		}
+		return m.Run(Up(0))
 	case MigrationDirectionDown:
-		if err := mig.Down(); err != nil {
-			if errors.Is(err, migrate.ErrNoChange) {
-				m.Logger.Info("nothing to migrate")
-				return nil
-			}
-			return fmt.Errorf("could not run migration: %w", err)
-		}
+		return m.Run(Down(0))
 	default:
 		return fmt.Errorf("%w got %s", ErrUnknownDirection, direction)
 	}
+}
+
+// Run migrates the database to the target while holding the migration lock. When migrating up to the newest
+// migration, nothing is run if the database is already up to date, such as when another replica migrated it
+// while waiting for the lock.
+func (m *PostgresMigrator) Run(target Target) error {
+	ctx := context.Background()
+	if target.upAll() {
+		upToDate, version, err := m.upToDate(ctx)
+		if err != nil {
+			return err
+		}
+		if upToDate {
+			m.Logger.Info("database is up to date, skipping migration", "version", version)
+			return nil
+		}
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if target.upAll() {
+		upToDate, version, err := m.upToDate(ctx)
+		if err != nil {
+			return err
+		}
+		if upToDate {
+			m.Logger.Info("database was migrated while waiting for the lock", "version", version)
+			return nil
+		}
+	}
+
+	mig, err := m.instance()
+	if err != nil {
+		return err
+	}
+	defer mig.Close()
+
+	m.Logger.Info("starting database migration", "target", target.String(), "source", m.Source.String())
+
+	switch {
+	case target.toVersion:
+		err = mig.Migrate(target.version)
+	case target.direction == MigrationDirectionUp && target.steps > 0:
+		err = mig.Steps(target.steps)
+	case target.direction == MigrationDirectionUp:
+		err = mig.Up()
+	case target.direction == MigrationDirectionDown && target.steps > 0:
+		err = mig.Steps(-target.steps)
+	case target.direction == MigrationDirectionDown:
+		err = mig.Down()
+	default:
+		return fmt.Errorf("%w got %s", ErrUnknownDirection, target.direction)
+	}
+	if errors.Is(err, migrate.ErrNoChange) {
+		m.Logger.Info("nothing to migrate")
+		return nil
+	}
+	var dirty migrate.ErrDirty
+	if errors.As(err, &dirty) {
+		return fmt.Errorf("%w at version %d: fix the failed migration and force the version", ErrDirty, dirty.Version)
+	}
+	if err != nil {
+		// Steps returns ErrShortLimit when fewer migrations than requested remain, after running them.
+		var short migrate.ErrShortLimit
+		if errors.As(err, &short) {
+			m.Logger.Warn("fewer migrations than requested", "missing", short.Short)
+		} else {
+			return fmt.Errorf("could not run migration: %w", err)
+		}
+	}
 
 	m.Logger.Info("database migration complete")
 	return nil
 }
+
+func (m *PostgresMigrator) Plan(target Target) ([]Step, error) {
+	version, dirty, err := DatabaseVersion(context.Background(), m.DB)
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		return nil, fmt.Errorf("%w at version %d: fix the failed migration and force the version", ErrDirty, version)
+	}
+	return Plan(m.Source, version, target)
+}
+
+func (m *PostgresMigrator) Force(version int) error {
+	unlock, err := m.lock(context.Background())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	mig, err := m.instance()
+	if err != nil {
+		return err
+	}
+	defer mig.Close()
+
+	if err := mig.Force(version); err != nil {
+		return fmt.Errorf("could not force version: %w", err)
+	}
+	m.Logger.Info("forced database version", "version", version)
+	return nil
+}
+
+func (m *PostgresMigrator) Status() (Status, error) {
+	version, dirty, err := DatabaseVersion(context.Background(), m.DB)
+	if err != nil {
+		return Status{}, err
+	}
+	latest, err := LatestVersion(m.Source)
+	if err != nil {
+		return Status{}, err
+	}
+
+	status := Status{Version: version, Dirty: dirty, Latest: latest}
+	if !dirty {
+		status.Pending, err = Plan(m.Source, version, Up(0))
+		if err != nil {
+			return Status{}, err
+		}
+	}
+	return status, nil
+}
+
+// LatestVersion returns the version of the newest migration of the source.
+func LatestVersion(s Source) (uint, error) {
+	src, err := s.Open()
+	if err != nil {
+		return 0, fmt.Errorf("could not open migration source: %w", err)
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, ErrNoMigrations
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not read first migration: %w", err)
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("could not read migration after version %d: %w", version, err)
+		}
+		version = next
+	}
+}
+
+// DatabaseVersion returns the migration version of the database, or NilVersion if no migration has been applied,
+// and whether the last migration failed, leaving it dirty. Unlike the migrate instance, it does not create the
+// migrations table if it is missing.
+func DatabaseVersion(ctx context.Context, db *sql.DB) (int, bool, error) {
+	var version int
+	var dirty bool
+	err := db.QueryRowContext(ctx, "select version, dirty from schema_migrations limit 1;").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NilVersion, false, nil
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "undefined_table" {
+		return NilVersion, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("could not get database version: %w", err)
+	}
+	return version, dirty, nil
+}
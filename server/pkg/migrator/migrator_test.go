@@ -0,0 +1,171 @@
+package migrator_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"advancely/cmd/migrate/migrations"
+	"advancely/pkg/migrator"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatestVersion(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1_create_users.up.sql", "1_create_users.down.sql", "12_add_roles.up.sql", "3_add_companies.up.sql"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("select 1;"), 0o600))
+	}
+
+	version, err := migrator.LatestVersion(migrator.PathSource("file://" + dir))
+	require.NoError(t, err)
+	require.Equal(t, uint(12), version)
+}
+
+func TestLatestVersionWithoutMigrations(t *testing.T) {
+	_, err := migrator.LatestVersion(migrator.PathSource("file://" + t.TempDir()))
+	require.ErrorIs(t, err, migrator.ErrNoMigrations)
+}
+
+func TestLatestVersionFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0_create_users.up.sql":    {Data: []byte("select 1;")},
+		"0_create_users.down.sql":  {Data: []byte("select 1;")},
+		"4_add_companies.up.sql":   {Data: []byte("select 1;")},
+		"4_add_companies.down.sql": {Data: []byte("select 1;")},
+	}
+
+	version, err := migrator.LatestVersion(migrator.FSSource(fsys))
+	require.NoError(t, err)
+	require.Equal(t, uint(4), version)
+}
+
+func TestLatestVersionOfEmbeddedMigrations(t *testing.T) {
+	embedded, err := migrator.LatestVersion(migrator.FSSource(migrations.FS))
+	require.NoError(t, err)
+
+	// The embedded migrations are the files of the migrations directory.
+	fromPath, err := migrator.LatestVersion(migrator.PathSource("file://../../cmd/migrate/migrations"))
+	require.NoError(t, err)
+	require.Equal(t, fromPath, embedded)
+}
+
+func newTestSource() migrator.Source {
+	fsys := fstest.MapFS{}
+	for _, name := range []string{"1_create_users", "2_add_companies", "5_add_roles"} {
+		fsys[name+".up.sql"] = &fstest.MapFile{Data: []byte("-- up " + name)}
+		fsys[name+".down.sql"] = &fstest.MapFile{Data: []byte("-- down " + name)}
+	}
+	return migrator.FSSource(fsys)
+}
+
+// stepNames returns the file names of the steps.
+func stepNames(steps []migrator.Step) []string {
+	names := make([]string, 0, len(steps))
+	for _, step := range steps {
+		names = append(names, step.Name())
+	}
+	return names
+}
+
+func TestPlan(t *testing.T) {
+	src := newTestSource()
+
+	for _, tc := range []struct {
+		name     string
+		version  int
+		target   migrator.Target
+		expected []string
+	}{
+		{"up all from nil", migrator.NilVersion, migrator.Up(0), []string{"1_create_users.up.sql", "2_add_companies.up.sql", "5_add_roles.up.sql"}},
+		{"up steps", 1, migrator.Up(1), []string{"2_add_companies.up.sql"}},
+		{"up more steps than pending", 2, migrator.Up(3), []string{"5_add_roles.up.sql"}},
+		{"up at latest", 5, migrator.Up(0), []string{}},
+		{"down steps", 5, migrator.Down(2), []string{"5_add_roles.down.sql", "2_add_companies.down.sql"}},
+		{"down all", 2, migrator.Down(0), []string{"2_add_companies.down.sql", "1_create_users.down.sql"}},
+		{"down from nil", migrator.NilVersion, migrator.Down(1), []string{}},
+		{"goto up", 1, migrator.To(5), []string{"2_add_companies.up.sql", "5_add_roles.up.sql"}},
+		{"goto down", 5, migrator.To(1), []string{"5_add_roles.down.sql", "2_add_companies.down.sql"}},
+		{"goto current", 2, migrator.To(2), []string{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			steps, err := migrator.Plan(src, tc.version, tc.target)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, stepNames(steps))
+		})
+	}
+
+	steps, err := migrator.Plan(src, 1, migrator.Up(1))
+	require.NoError(t, err)
+	require.Equal(t, "-- up 2_add_companies", steps[0].SQL)
+
+	_, err = migrator.Plan(src, 1, migrator.To(3))
+	require.Error(t, err, "the target version must exist")
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 10, 19, 12, 30, 0, 0, time.UTC)
+
+	paths, err := migrator.Create(dir, "Create table-invoices", now)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "20241019123000_create_table_invoices.up.sql"),
+		filepath.Join(dir, "20241019123000_create_table_invoices.down.sql"),
+	}, paths)
+
+	version, err := migrator.LatestVersion(migrator.PathSource("file://" + dir))
+	require.NoError(t, err)
+	require.Equal(t, uint(20241019123000), version)
+
+	_, err = migrator.Create(dir, "create_table_invoices", now)
+	require.ErrorIs(t, err, os.ErrExist)
+
+	_, err = migrator.Create(dir, "drop; table", now)
+	require.ErrorIs(t, err, migrator.ErrInvalidName)
+}
+
+func TestLint(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1_create_invoices.up.sql": {Data: []byte(`create table if not exists public.invoices (
+    id serial primary key,
+    total numeric not null
+);
+create index idx_invoices_total on public.invoices (total);
+`)},
+		"1_create_invoices.down.sql": {Data: []byte("drop table if exists invoices;")},
+		"2_index_profiles.up.sql": {Data: []byte(`create index idx_profiles_name on public.profiles (first_name);
+create index concurrently idx_profiles_last_name on public.profiles (last_name);
+alter table public.profiles
+    add column title text not null,
+    add column nickname text not null default '',
+    drop column if exists is_admin;
+select 1 from profiles;
+`)},
+		"2_index_profiles.down.sql": {Data: []byte(`-- lint:ignore not-null-without-default
+alter table public.profiles add column is_admin boolean not null;
+`)},
+		"3_drop_profile_titles.up.sql": {Data: []byte(`-- Titles are no longer read, and were copied to public.profile_titles.
+-- lint:ignore drop-column
+alter table public.profiles drop column title;
+`)},
+	}
+
+	findings, err := migrator.Lint(fsys)
+	require.NoError(t, err)
+
+	var actual []string
+	for _, finding := range findings {
+		actual = append(actual, finding.String())
+	}
+	require.Equal(t, []string{
+		"1_create_invoices.down.sql: trailing-newline: file does not end with a newline",
+		"2_index_profiles.up.sql: drop-column: column public.profiles.is_admin is dropped",
+		"2_index_profiles.up.sql: index-not-concurrent: index on public.profiles is not created concurrently",
+		"2_index_profiles.up.sql: mixed-schema-qualification: tables are referred to as public.name and without a schema: profiles",
+		"2_index_profiles.up.sql: not-null-without-default: column public.profiles.title is not null without a default",
+		"3_drop_profile_titles.up.sql: missing-down: missing down migration",
+	}, actual)
+}
@@ -0,0 +1,176 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// NilVersion is the version of a database no migration has been applied to.
+const NilVersion = -1
+
+// Target is the version a migration moves the database to.
+type Target struct {
+	direction MigrationDirection
+	steps     int
+	version   uint
+	toVersion bool
+}
+
+// Up targets the next n migrations, or every pending migration if n is not positive.
+func Up(n int) Target {
+	return Target{direction: MigrationDirectionUp, steps: n}
+}
+
+// Down targets reverting the last n migrations, or every migration if n is not positive.
+func Down(n int) Target {
+	return Target{direction: MigrationDirectionDown, steps: n}
+}
+
+// To targets the version, migrating up or down to it.
+func To(version uint) Target {
+	return Target{version: version, toVersion: true}
+}
+
+func (t Target) String() string {
+	switch {
+	case t.toVersion:
+		return fmt.Sprintf("version %d", t.version)
+	case t.steps > 0:
+		return fmt.Sprintf("%s %d", t.direction, t.steps)
+	default:
+		return fmt.Sprintf("%s all", t.direction)
+	}
+}
+
+// upAll returns true if the target is the newest migration.
+func (t Target) upAll() bool {
+	return !t.toVersion && t.direction == MigrationDirectionUp && t.steps <= 0
+}
+
+// Step is a migration run to reach a target.
+type Step struct {
+	Version    uint
+	Identifier string
+	Direction  MigrationDirection
+	SQL        string
+}
+
+// Name returns the name of the migration file, such as 1_create_table_companies.up.sql.
+func (s Step) Name() string {
+	return fmt.Sprintf("%d_%s.%s.sql", s.Version, s.Identifier, s.Direction)
+}
+
+// Plan returns the steps migrating a database at the version, or NilVersion, to the target,
+// in the order they run.
+func Plan(s Source, version int, target Target) ([]Step, error) {
+	src, err := s.Open()
+	if err != nil {
+		return nil, fmt.Errorf("could not open migration source: %w", err)
+	}
+	defer src.Close()
+
+	var versions []uint
+	var direction MigrationDirection
+	switch {
+	case target.toVersion:
+		// The target must exist, otherwise migrating up would apply every pending migration.
+		r, _, readErr := src.ReadUp(target.version)
+		if readErr != nil {
+			return nil, fmt.Errorf("could not find migration %d: %w", target.version, readErr)
+		}
+		_ = r.Close()
+
+		if version < int(target.version) {
+			direction = MigrationDirectionUp
+			versions, err = versionsUp(src, version, func(v uint, _ int) bool { return v <= target.version })
+		} else {
+			direction = MigrationDirectionDown
+			versions, err = versionsDown(src, version, func(v uint, _ int) bool { return v > target.version })
+		}
+	case target.direction == MigrationDirectionUp:
+		direction = MigrationDirectionUp
+		versions, err = versionsUp(src, version, func(_ uint, n int) bool { return target.steps <= 0 || n < target.steps })
+	case target.direction == MigrationDirectionDown:
+		direction = MigrationDirectionDown
+		versions, err = versionsDown(src, version, func(_ uint, n int) bool { return target.steps <= 0 || n < target.steps })
+	default:
+		return nil, fmt.Errorf("%w got %s", ErrUnknownDirection, target.direction)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]Step, 0, len(versions))
+	for _, v := range versions {
+		step, err := readStep(src, v, direction)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// versionsUp returns the versions after the version, while next returns true for the version and the number
+// of versions before it.
+func versionsUp(src source.Driver, version int, next func(v uint, n int) bool) ([]uint, error) {
+	var v uint
+	var err error
+	if version == NilVersion {
+		v, err = src.First()
+	} else {
+		v, err = src.Next(uint(version))
+	}
+
+	var versions []uint
+	for err == nil && next(v, len(versions)) {
+		versions = append(versions, v)
+		v, err = src.Next(v)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+	return versions, nil
+}
+
+// versionsDown returns the version and those before it, while next returns true for the version and the number
+// of versions after it.
+func versionsDown(src source.Driver, version int, next func(v uint, n int) bool) ([]uint, error) {
+	if version == NilVersion {
+		return nil, nil
+	}
+
+	v := uint(version)
+	var versions []uint
+	var err error
+	for err == nil && next(v, len(versions)) {
+		versions = append(versions, v)
+		v, err = src.Prev(v)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+	return versions, nil
+}
+
+func readStep(src source.Driver, version uint, direction MigrationDirection) (Step, error) {
+	read := src.ReadUp
+	if direction == MigrationDirectionDown {
+		read = src.ReadDown
+	}
+	r, identifier, err := read(version)
+	if err != nil {
+		return Step{}, fmt.Errorf("could not read %s migration %d: %w", direction, version, err)
+	}
+	defer r.Close()
+
+	sql, err := io.ReadAll(r)
+	if err != nil {
+		return Step{}, fmt.Errorf("could not read %s migration %d: %w", direction, version, err)
+	}
+	return Step{Version: version, Identifier: identifier, Direction: direction, SQL: string(sql)}, nil
+}
@@ -0,0 +1,91 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// snapshotQueries describe the objects of the schemas given as $1, one object per row.
+var snapshotQueries = []string{
+	`select format('schema %s', n.nspname)
+	from pg_namespace n
+	where n.nspname = any($1)`,
+
+	`select format('relation %s.%s kind=%s rls=%s', n.nspname, c.relname, c.relkind, c.relrowsecurity)
+	from pg_class c
+	join pg_namespace n on n.oid = c.relnamespace
+	where n.nspname = any($1) and c.relkind in ('r', 'p', 'v', 'm', 'S', 'f')`,
+
+	`select format('column %s.%s.%s %s not_null=%s default=%s', n.nspname, c.relname, a.attname,
+		format_type(a.atttypid, a.atttypmod), a.attnotnull, coalesce(pg_get_expr(d.adbin, d.adrelid), ''))
+	from pg_attribute a
+	join pg_class c on c.oid = a.attrelid
+	join pg_namespace n on n.oid = c.relnamespace
+	left join pg_attrdef d on d.adrelid = a.attrelid and d.adnum = a.attnum
+	where n.nspname = any($1) and c.relkind in ('r', 'p', 'v', 'm', 'f') and a.attnum > 0 and not a.attisdropped`,
+
+	`select format('constraint %s.%s %s %s', n.nspname, c.relname, con.conname, pg_get_constraintdef(con.oid))
+	from pg_constraint con
+	join pg_class c on c.oid = con.conrelid
+	join pg_namespace n on n.oid = c.relnamespace
+	where n.nspname = any($1)`,
+
+	`select format('index %s', pg_get_indexdef(i.indexrelid))
+	from pg_index i
+	join pg_class c on c.oid = i.indexrelid
+	join pg_namespace n on n.oid = c.relnamespace
+	where n.nspname = any($1)`,
+
+	`select format('trigger %s', pg_get_triggerdef(t.oid))
+	from pg_trigger t
+	join pg_class c on c.oid = t.tgrelid
+	join pg_namespace n on n.oid = c.relnamespace
+	where n.nspname = any($1) and not t.tgisinternal`,
+
+	`select format('policy %s.%s %s cmd=%s permissive=%s roles=%s using=%s check=%s', n.nspname, c.relname, p.polname,
+		p.polcmd, p.polpermissive, p.polroles::regrole[]::text,
+		coalesce(pg_get_expr(p.polqual, p.polrelid), ''), coalesce(pg_get_expr(p.polwithcheck, p.polrelid), ''))
+	from pg_policy p
+	join pg_class c on c.oid = p.polrelid
+	join pg_namespace n on n.oid = c.relnamespace
+	where n.nspname = any($1)`,
+
+	`select format('function %s', pg_get_functiondef(p.oid))
+	from pg_proc p
+	join pg_namespace n on n.oid = p.pronamespace
+	where n.nspname = any($1) and p.prokind in ('f', 'p')`,
+}
+
+// Snapshot describes the schemas from the system catalogs, one sorted line per schema, relation, column,
+// constraint, index, trigger, policy and function, so the schemas can be compared before and after migrating.
+// The migrations table is excluded.
+func Snapshot(ctx context.Context, db *sql.DB, schemas ...string) ([]string, error) {
+	var snapshot []string
+	for _, query := range snapshotQueries {
+		rows, err := db.QueryContext(ctx, query, pq.Array(schemas))
+		if err != nil {
+			return nil, fmt.Errorf("could not snapshot schema: %w", err)
+		}
+		for rows.Next() {
+			var line string
+			if err := rows.Scan(&line); err != nil {
+				_ = rows.Close()
+				return nil, fmt.Errorf("could not snapshot schema: %w", err)
+			}
+			if !strings.Contains(line, "schema_migrations") {
+				snapshot = append(snapshot, line)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("could not snapshot schema: %w", err)
+		}
+		_ = rows.Close()
+	}
+	sort.Strings(snapshot)
+	return snapshot, nil
+}
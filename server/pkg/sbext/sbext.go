@@ -7,14 +7,17 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 
 	"advancely/internal/application"
 	"github.com/supabase-community/supabase-go"
 )
 
 const (
-	authPath    string = "/auth/v1"
-	recoverPath string = "/recover"
+	authPath      string = "/auth/v1"
+	recoverPath   string = "/recover"
+	authorizePath string = "/authorize"
+	healthPath    string = "/health"
 )
 
 func NewSupabaseExtended(client *supabase.Client, config application.SupabaseConfig) *SupabaseExtended {
@@ -44,6 +47,12 @@ type SupabaseExtended struct {
 	Extensions *Extensions
 }
 
+// WithHTTPClient sets the client used for the requests made by the extensions, such as one recording metrics.
+func (s *SupabaseExtended) WithHTTPClient(client *http.Client) *SupabaseExtended {
+	s.Extensions.httpClient = client
+	return s
+}
+
 // ResetPasswordForEmail sends a password recovery link to the given e-mail address.
 func (c *Extensions) ResetPasswordForEmail(ctx context.Context, email, redirectTo string) error {
 	b, err := json.Marshal(map[string]string{
@@ -78,6 +87,40 @@ func (c *Extensions) ResetPasswordForEmail(ctx context.Context, email, redirectT
 	return nil
 }
 
+// AuthHealth returns an error if the auth server is not healthy.
+func (c *Extensions) AuthHealth(ctx context.Context) error {
+	r, err := c.newRequest(ctx, authPath+healthPath, http.MethodGet, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !statusCodeIsSuccess(resp.StatusCode) {
+		return fmt.Errorf("response status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// AuthorizeURL returns the URL of the authorize endpoint for a PKCE login with the given OAuth provider.
+// Unlike the gotrue-go Authorize method, no request is made and the redirect_to URL can be specified;
+// the auth server redirects back to redirectTo with a code to be exchanged with the code verifier.
+func (c *Extensions) AuthorizeURL(provider, scopes, redirectTo, codeChallenge string) string {
+	q := url.Values{}
+	q.Set("provider", provider)
+	q.Set("redirect_to", redirectTo)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "s256")
+	if scopes != "" {
+		q.Set("scopes", scopes)
+	}
+	return c.baseURL + authPath + authorizePath + "?" + q.Encode()
+}
+
 func (c *Extensions) newRequest(ctx context.Context, path string, method string, body io.Reader) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
 	if err != nil {
@@ -0,0 +1,78 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA1 test secret from RFC 6238 Appendix B.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestGenerateCode(t *testing.T) {
+	// The RFC test vectors use 8 digits; the last 6 digits are the 6-digit code.
+	testCases := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tc := range testCases {
+		code, err := GenerateCode(rfcSecret, time.Unix(tc.unix, 0))
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, code, "time %d", tc.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := GenerateCode(rfcSecret, now)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		code      string
+		at        time.Time
+		expectErr error
+	}{
+		{"current period", code, now, nil},
+		{"previous period within skew", code, now.Add(Period * time.Second), nil},
+		{"outside skew", code, now.Add(3 * Period * time.Second), ErrInvalidCode},
+		{"wrong code", "000000", now, ErrInvalidCode},
+		{"wrong length", "12345", now, ErrInvalidCode},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			step, err := Validate(rfcSecret, tc.code, tc.at)
+			if tc.expectErr != nil {
+				require.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, Step(now), step)
+		})
+	}
+}
+
+func TestValidateWithInvalidSecret(t *testing.T) {
+	_, err := Validate("not base32!", "123456", time.Now())
+	require.ErrorIs(t, err, ErrInvalidSecret)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+
+	code, err := GenerateCode(secret, time.Now())
+	require.NoError(t, err)
+	_, err = Validate(secret, code, time.Now())
+	require.NoError(t, err)
+}